@@ -0,0 +1,700 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/audit"
+	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/domain"
+	"github.com/ArmanurRahman/booking/internal/export"
+	"github.com/ArmanurRahman/booking/internal/importer"
+	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/ArmanurRahman/booking/internal/repository"
+	"github.com/ArmanurRahman/booking/internal/repository/dbrepo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//ownerBlock is the restriction id of a room blocked by the owner
+const ownerBlock = 2
+
+//commands are the operational subcommands of the web binary, run as "web user create -email ..."
+var commands = map[string]func(c *command, args []string) error{
+	"user create":            userCreate,
+	"user set-password":      userSetPassword,
+	"room add":               roomAdd,
+	"reservation list":       reservationList,
+	"reservation export":     reservationExport,
+	"reservation import":     reservationImport,
+	"reservation set-status": reservationSetStatus,
+	"reservation purge":      reservationPurge,
+	"restriction block":      restrictionBlock,
+	"seed demo":              seedDemo,
+}
+
+//openRepo connects the commands to the database, tests replace it with the test repository
+var openRepo = func() (repository.DatabaseRepo, func(), error) {
+	db, err := connectDB()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot connect to database: %w", err)
+	}
+	return dbrepo.NewRepo(db, &app), func() { db.SQL.Close() }, nil
+}
+
+//command holds the flags, input and output shared by the subcommands
+type command struct {
+	name  string
+	flags *flag.FlagSet
+	json  bool
+	in    io.Reader
+	out   io.Writer
+	ctx   context.Context
+	repo  repository.DatabaseRepo
+	close func()
+}
+
+//runCommand runs the subcommand named by the first two args and reports whether there was one
+func runCommand(args []string, in io.Reader, out io.Writer) (bool, error) {
+	if len(args) < 2 {
+		return false, nil
+	}
+	name := args[0] + " " + args[1]
+	run, ok := commands[name]
+	if !ok {
+		return false, nil
+	}
+
+	c := &command{
+		name:  name,
+		flags: flag.NewFlagSet(name, flag.ContinueOnError),
+		in:    in,
+		out:   out,
+		ctx:   audit.NewContext(context.Background(), audit.Actor{Name: "cli:" + os.Getenv("USER")}),
+	}
+	c.flags.BoolVar(&c.json, "json", false, "print JSON instead of text")
+
+	err := run(c, args[2:])
+	if err == flag.ErrHelp {
+		err = nil
+	}
+	return true, err
+}
+
+//commandNames lists the subcommands for the usage message
+func commandNames() string {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+//open parses the flags of the command together with the config flags and connects to the database
+func (c *command) open(args []string) error {
+	err := config.LoadFlags(&app, c.flags, args)
+	if err != nil {
+		return err
+	}
+
+	c.repo, c.close, err = openRepo()
+	return err
+}
+
+//print writes v as JSON with -json and the text otherwise
+func (c *command) print(v interface{}, text func(w io.Writer)) error {
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	text(w)
+	return w.Flush()
+}
+
+//password returns the password given as a flag, or reads it from the first line of the input
+func (c *command) password(flagValue string) (string, error) {
+	password := flagValue
+	if password == "" {
+		line, err := bufio.NewReader(c.in).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if len(password) < 8 {
+		return "", errors.New("the password must be at least 8 characters")
+	}
+	return password, nil
+}
+
+type userRecord struct {
+	ID          int    `json:"id"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	Email       string `json:"email"`
+	AccessLevel int    `json:"access_level"`
+}
+
+func userCreate(c *command, args []string) error {
+	firstName := c.flags.String("first-name", "", "first name of the user")
+	lastName := c.flags.String("last-name", "", "last name of the user")
+	email := c.flags.String("email", "", "email the user logs in with")
+	passwordFlag := c.flags.String("password", "", "password of the user, read from stdin when empty")
+	accessLevel := c.flags.Int("access-level", 1, "access level of the user")
+
+	if err := c.open(args); err != nil {
+		return err
+	}
+	defer c.close()
+
+	if *email == "" || *firstName == "" || *lastName == "" {
+		return errors.New("-email, -first-name and -last-name are required")
+	}
+
+	_, err := c.repo.GetUserByEmail(c.ctx, *email)
+	if err == nil {
+		return fmt.Errorf("user %s already exists", *email)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	password, err := c.password(*passwordFlag)
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	user := models.User{
+		FirstName:   *firstName,
+		LastName:    *lastName,
+		Email:       *email,
+		Password:    string(hash),
+		AccessLevel: *accessLevel,
+	}
+	user.ID, err = c.repo.InsertUser(c.ctx, user)
+	if err != nil {
+		return err
+	}
+
+	record := userRecord{ID: user.ID, FirstName: user.FirstName, LastName: user.LastName, Email: user.Email, AccessLevel: user.AccessLevel}
+	return c.print(record, func(w io.Writer) {
+		fmt.Fprintf(w, "created user %d\t%s\n", record.ID, record.Email)
+	})
+}
+
+func userSetPassword(c *command, args []string) error {
+	email := c.flags.String("email", "", "email of the user")
+	passwordFlag := c.flags.String("password", "", "new password, read from stdin when empty")
+
+	if err := c.open(args); err != nil {
+		return err
+	}
+	defer c.close()
+
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	user, err := c.repo.GetUserByEmail(c.ctx, *email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no user with email %s", *email)
+	} else if err != nil {
+		return err
+	}
+
+	password, err := c.password(*passwordFlag)
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	err = c.repo.UpdateUserPassword(c.ctx, user.ID, string(hash))
+	if err != nil {
+		return err
+	}
+
+	record := userRecord{ID: user.ID, FirstName: user.FirstName, LastName: user.LastName, Email: user.Email, AccessLevel: user.AccessLevel}
+	return c.print(record, func(w io.Writer) {
+		fmt.Fprintf(w, "password changed for user %d\t%s\n", record.ID, record.Email)
+	})
+}
+
+type roomRecord struct {
+	ID           int    `json:"id"`
+	RoomName     string `json:"room_name"`
+	MaxOccupancy int    `json:"max_occupancy"`
+	Beds         string `json:"beds"`
+}
+
+func roomAdd(c *command, args []string) error {
+	name := c.flags.String("name", "", "name of the room")
+	sleeps := c.flags.Int("sleeps", 2, "most guests the room sleeps")
+	beds := c.flags.String("beds", "", "beds of the room, like \"1 queen bed, 2 single beds\"")
+
+	if err := c.open(args); err != nil {
+		return err
+	}
+	defer c.close()
+
+	if *name == "" {
+		return errors.New("-name is required")
+	}
+	if *sleeps < 1 {
+		return errors.New("-sleeps must be at least 1")
+	}
+
+	id, err := c.repo.InsertRoom(c.ctx, models.Room{RoomName: *name, MaxOccupancy: *sleeps, Beds: *beds})
+	if err != nil {
+		return err
+	}
+
+	record := roomRecord{ID: id, RoomName: *name, MaxOccupancy: *sleeps, Beds: *beds}
+	return c.print(record, func(w io.Writer) {
+		fmt.Fprintf(w, "created room %d\t%s\tsleeps %d\n", record.ID, record.RoomName, record.MaxOccupancy)
+	})
+}
+
+//reservationRecord is a reservation as listed and exported by the commands
+type reservationRecord struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	Room      string `json:"room"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Status    string `json:"status"`
+}
+
+func newReservationRecord(r models.Reservation) reservationRecord {
+	return reservationRecord{
+		ID:        r.ID,
+		FirstName: r.FirstName,
+		LastName:  r.LastName,
+		Email:     r.Email,
+		Phone:     r.Phone,
+		Room:      r.Room.RoomName,
+		StartDate: r.StartDate.Format("2006-01-02"),
+		EndDate:   r.EndDate.Format("2006-01-02"),
+		Status:    string(r.Status),
+	}
+}
+
+//statusFlags adds the -status and -new flags that filter the reservations by status
+func (c *command) statusFlags(verb string) func() ([]domain.Status, error) {
+	status := c.flags.String("status", "", verb+" only the reservations in these comma separated statuses")
+	onlyNew := c.flags.Bool("new", false, verb+" only the pending reservations, same as -status pending")
+
+	return func() ([]domain.Status, error) {
+		if *onlyNew {
+			return []domain.Status{domain.Pending}, nil
+		}
+		return domain.ParseStatuses(*status)
+	}
+}
+
+//loadReservations returns the reservations in any of the statuses, or every reservation without statuses
+func (c *command) loadReservations(statuses []domain.Status) ([]reservationRecord, error) {
+	reservations, err := c.repo.ReservationsByStatus(c.ctx, statuses...)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []reservationRecord{}
+	for _, r := range reservations {
+		records = append(records, newReservationRecord(r))
+	}
+	return records, nil
+}
+
+func reservationList(c *command, args []string) error {
+	statuses := c.statusFlags("list")
+
+	if err := c.open(args); err != nil {
+		return err
+	}
+	defer c.close()
+
+	filter, err := statuses()
+	if err != nil {
+		return err
+	}
+
+	records, err := c.loadReservations(filter)
+	if err != nil {
+		return err
+	}
+
+	return c.print(records, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tNAME\tEMAIL\tROOM\tARRIVAL\tDEPARTURE\tSTATUS")
+		for _, r := range records {
+			fmt.Fprintf(w, "%d\t%s %s\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.FirstName, r.LastName, r.Email, r.Room, r.StartDate, r.EndDate, r.Status)
+		}
+	})
+}
+
+func reservationSetStatus(c *command, args []string) error {
+	id := c.flags.Int("id", 0, "id of the reservation")
+	status := c.flags.String("status", "", "status to move the reservation to")
+
+	if err := c.open(args); err != nil {
+		return err
+	}
+	defer c.close()
+
+	to, err := domain.ParseStatus(*status)
+	if err != nil {
+		return err
+	}
+
+	err = c.repo.UpdateReservationStatus(c.ctx, *id, to, 0)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no reservation with id %d", *id)
+	} else if err != nil {
+		return err
+	}
+
+	record := reservationRecord{ID: *id, Status: string(to)}
+	return c.print(record, func(w io.Writer) {
+		fmt.Fprintf(w, "reservation %d is %s\n", *id, to)
+	})
+}
+
+func reservationPurge(c *command, args []string) error {
+	olderThan := c.flags.Duration("older-than", 0, "purge the reservations deleted longer ago than this, reservations.trash_retention when zero")
+
+	if err := c.open(args); err != nil {
+		return err
+	}
+	defer c.close()
+
+	if *olderThan <= 0 {
+		*olderThan = app.Reservations.TrashRetention
+	}
+
+	n, err := c.repo.PurgeDeletedReservations(c.ctx, time.Now().Add(-*olderThan))
+	if err != nil {
+		return err
+	}
+
+	record := struct {
+		Purged int `json:"purged"`
+	}{n}
+	return c.print(record, func(w io.Writer) {
+		fmt.Fprintf(w, "purged %d deleted reservations\n", n)
+	})
+}
+
+func reservationExport(c *command, args []string) error {
+	statuses := c.statusFlags("export")
+	output := c.flags.String("o", "", "file to write, stdout when empty")
+	format := c.flags.String("format", "csv", "csv or json")
+
+	if err := c.open(args); err != nil {
+		return err
+	}
+	defer c.close()
+
+	if c.json {
+		*format = "json"
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("unknown format %q, use csv or json", *format)
+	}
+
+	filter, err := statuses()
+	if err != nil {
+		return err
+	}
+
+	records, err := c.loadReservations(filter)
+	if err != nil {
+		return err
+	}
+
+	w := c.out
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "first_name", "last_name", "email", "phone", "room", "start_date", "end_date", "status"})
+	for _, r := range records {
+		cw.Write([]string{
+			strconv.Itoa(r.ID), r.FirstName, r.LastName, r.Email, r.Phone, r.Room, r.StartDate, r.EndDate, r.Status,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type importRecord struct {
+	Rows     int                `json:"rows"`
+	Valid    int                `json:"valid"`
+	Imported []int              `json:"imported"`
+	DryRun   bool               `json:"dry_run"`
+	Problems []importer.Problem `json:"problems"`
+}
+
+func reservationImport(c *command, args []string) error {
+	input := c.flags.String("i", "", "CSV file to import, stdin when empty")
+	mapFlag := c.flags.String("map", "", "comma separated field=column pairs, a field is read from the column of its name when not mapped")
+	dryRun := c.flags.Bool("dry-run", false, "only check the rows, import nothing")
+	report := c.flags.String("report", "", "file to write the problems to, Excel when it ends in .xlsx and CSV otherwise")
+
+	if err := c.open(args); err != nil {
+		return err
+	}
+	defer c.close()
+
+	mapping, err := importer.ParseMapping(*mapFlag)
+	if err != nil {
+		return err
+	}
+
+	r := c.in
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	imp := &importer.Importer{DB: c.repo, Mapping: mapping}
+	result, err := imp.Check(c.ctx, r)
+	if err != nil {
+		return err
+	}
+
+	if *report != "" {
+		if err := writeImportReport(*report, result.Problems); err != nil {
+			return err
+		}
+	}
+
+	if !*dryRun && result.Valid() {
+		if err := imp.Import(c.ctx, result); err != nil {
+			return err
+		}
+	}
+
+	record := importRecord{
+		Rows:     result.Rows,
+		Valid:    len(result.Reservations),
+		Imported: append([]int{}, result.IDs...),
+		DryRun:   *dryRun,
+		Problems: append([]importer.Problem{}, result.Problems...),
+	}
+	err = c.print(record, func(w io.Writer) {
+		for _, p := range record.Problems {
+			fmt.Fprintf(w, "line %d\t%s\t%s\n", p.Line, p.Field, p.Message)
+		}
+		switch {
+		case !result.Valid():
+			fmt.Fprintf(w, "%d of %d rows have problems, nothing was imported\n", result.Invalid(), record.Rows)
+		case record.DryRun:
+			fmt.Fprintf(w, "%d rows can be imported\n", record.Valid)
+		default:
+			fmt.Fprintf(w, "imported %d reservations\n", len(record.Imported))
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if !result.Valid() {
+		return importer.ErrInvalidRows
+	}
+	return nil
+}
+
+//writeImportReport writes the problems of an import to the file, in the format of its extension
+func writeImportReport(path string, problems []importer.Problem) error {
+	format := export.CSV
+	if strings.HasSuffix(strings.ToLower(path), ".xlsx") {
+		format = export.XLSX
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	out, err := export.New(format, f, "import-errors")
+	if err != nil {
+		return err
+	}
+	err = importer.WriteReport(out, problems)
+	if err != nil {
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+type blockRecord struct {
+	RoomID    int    `json:"room_id"`
+	Room      string `json:"room"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+func restrictionBlock(c *command, args []string) error {
+	roomID := c.flags.Int("room", 0, "id of the room to block")
+	start := c.flags.String("start", "", "first blocked day, 2006-01-02")
+	end := c.flags.String("end", "", "last blocked day, 2006-01-02")
+
+	if err := c.open(args); err != nil {
+		return err
+	}
+	defer c.close()
+
+	startDate, err := time.Parse("2006-01-02", *start)
+	if err != nil {
+		return fmt.Errorf("invalid -start %q, use 2006-01-02", *start)
+	}
+	endDate, err := time.Parse("2006-01-02", *end)
+	if err != nil {
+		return fmt.Errorf("invalid -end %q, use 2006-01-02", *end)
+	}
+	if endDate.Before(startDate) {
+		return errors.New("-end is before -start")
+	}
+
+	room, err := c.repo.GetRoomByID(c.ctx, *roomID)
+	if err != nil {
+		return fmt.Errorf("no room with id %d", *roomID)
+	}
+
+	err = c.repo.InsetIntoRoomRestriction(c.ctx, models.RoomRestriction{
+		StartDate:     startDate,
+		EndDate:       endDate,
+		RoomID:        *roomID,
+		RestrictionID: ownerBlock,
+	})
+	if err != nil {
+		return err
+	}
+
+	record := blockRecord{RoomID: *roomID, Room: room.RoomName, StartDate: *start, EndDate: *end}
+	return c.print(record, func(w io.Writer) {
+		fmt.Fprintf(w, "blocked room %d\t%s\tfrom %s to %s\n", record.RoomID, record.Room, record.StartDate, record.EndDate)
+	})
+}
+
+type seedRecord struct {
+	Admin        string `json:"admin"`
+	AdminCreated bool   `json:"admin_created"`
+	Reservations []int  `json:"reservations"`
+}
+
+//demoGuests are the guests of the demo reservations
+var demoGuests = []models.Reservation{
+	{FirstName: "John", LastName: "Smith", Email: "john@smith.com", Phone: "555-0100", RoomID: 1, Adults: 2},
+	{FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com", Phone: "555-0101", RoomID: 2, Adults: 2, Children: 2},
+	{FirstName: "Max", LastName: "Mustermann", Email: "max@example.com", Phone: "555-0102", RoomID: 1, Adults: 1},
+}
+
+func seedDemo(c *command, args []string) error {
+	email := c.flags.String("admin-email", "admin@admin.com", "email of the demo admin")
+	password := c.flags.String("admin-password", "password", "password of the demo admin")
+
+	if err := c.open(args); err != nil {
+		return err
+	}
+	defer c.close()
+
+	record := seedRecord{Admin: *email, Reservations: []int{}}
+
+	_, err := c.repo.GetUserByEmail(c.ctx, *email)
+	if errors.Is(err, sql.ErrNoRows) {
+		hash, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		_, err = c.repo.InsertUser(c.ctx, models.User{
+			FirstName:   "Admin",
+			LastName:    "User",
+			Email:       *email,
+			Password:    string(hash),
+			AccessLevel: 3,
+		})
+		if err != nil {
+			return err
+		}
+		record.AdminCreated = true
+	} else if err != nil {
+		return err
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	for i, guest := range demoGuests {
+		guest.StartDate = today.AddDate(0, 0, 7*(i+1))
+		guest.EndDate = guest.StartDate.AddDate(0, 0, 2)
+
+		id, err := c.repo.InsertReservation(c.ctx, guest)
+		if err != nil {
+			return err
+		}
+		err = c.repo.InsetIntoRoomRestriction(c.ctx, models.RoomRestriction{
+			StartDate:     guest.StartDate,
+			EndDate:       guest.EndDate,
+			RoomID:        guest.RoomID,
+			ResevationID:  id,
+			RestrictionID: 1,
+		})
+		if err != nil {
+			return err
+		}
+		record.Reservations = append(record.Reservations, id)
+	}
+
+	return c.print(record, func(w io.Writer) {
+		if record.AdminCreated {
+			fmt.Fprintf(w, "created admin\t%s\n", record.Admin)
+		} else {
+			fmt.Fprintf(w, "admin exists\t%s\n", record.Admin)
+		}
+		fmt.Fprintf(w, "created reservations\t%d\n", len(record.Reservations))
+	})
+}
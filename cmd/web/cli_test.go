@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/audit"
+	"github.com/ArmanurRahman/booking/internal/importer"
+	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/ArmanurRahman/booking/internal/repository"
+	"github.com/ArmanurRahman/booking/internal/repository/dbrepo"
+)
+
+//newTestRepo makes the commands use an in-memory repository holding the admin user
+func newTestRepo(t *testing.T) *dbrepo.MemoryDBRepo {
+	repo := dbrepo.NewMemoryRepo(&app)
+	_, err := repo.InsertUser(context.Background(), models.User{
+		FirstName:   "Admin",
+		LastName:    "User",
+		Email:       "admin@admin.com",
+		AccessLevel: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	open := openRepo
+	t.Cleanup(func() { openRepo = open })
+	openRepo = func() (repository.DatabaseRepo, func(), error) {
+		return repo, func() {}, nil
+	}
+	return repo
+}
+
+//runTestCommand runs a subcommand against the repository of newTestRepo
+func runTestCommand(t *testing.T, stdin string, args ...string) (string, error) {
+	var out bytes.Buffer
+	ok, err := runCommand(args, strings.NewReader(stdin), &out)
+	if !ok {
+		t.Fatalf("%v is not a command", args)
+	}
+	return out.String(), err
+}
+
+func TestRunCommand_Unknown(t *testing.T) {
+	if ok, _ := runCommand([]string{"user", "delete"}, nil, nil); ok {
+		t.Error("user delete should not be a command")
+	}
+	if ok, _ := runCommand([]string{"-production"}, nil, nil); ok {
+		t.Error("flags should not be a command")
+	}
+}
+
+func TestUserCreate(t *testing.T) {
+	newTestRepo(t)
+
+	out, err := runTestCommand(t, "secret-password\n", "user", "create", "-json",
+		"-email", "new@admin.com", "-first-name", "New", "-last-name", "Admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var user userRecord
+	if err := json.Unmarshal([]byte(out), &user); err != nil {
+		t.Fatalf("output is not JSON: %v (%s)", err, out)
+	}
+	if user.ID != 2 || user.Email != "new@admin.com" {
+		t.Errorf("unexpected user %+v", user)
+	}
+}
+
+func TestUserCreate_Errors(t *testing.T) {
+	newTestRepo(t)
+
+	var tests = []struct {
+		name  string
+		stdin string
+		args  []string
+	}{
+		{"existing-user", "secret-password", []string{"-email", "admin@admin.com", "-first-name", "A", "-last-name", "B"}},
+		{"missing-name", "secret-password", []string{"-email", "new@admin.com"}},
+		{"short-password", "short", []string{"-email", "new@admin.com", "-first-name", "A", "-last-name", "B"}},
+	}
+
+	for _, e := range tests {
+		args := append([]string{"user", "create"}, e.args...)
+		if _, err := runTestCommand(t, e.stdin, args...); err == nil {
+			t.Errorf("for %s, expected an error", e.name)
+		}
+	}
+}
+
+func TestUserSetPassword(t *testing.T) {
+	newTestRepo(t)
+
+	out, err := runTestCommand(t, "", "user", "set-password", "-email", "admin@admin.com", "-password", "new-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "password changed for user 1") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	if _, err = runTestCommand(t, "", "user", "set-password", "-email", "nobody@admin.com", "-password", "new-password"); err == nil {
+		t.Error("expected an error for an unknown user")
+	}
+}
+
+func TestRoomAdd(t *testing.T) {
+	newTestRepo(t)
+
+	out, err := runTestCommand(t, "", "room", "add", "-name", "Colonel's Cabin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "created room 3") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	out, err = runTestCommand(t, "", "room", "add", "-name", "Barracks", "-sleeps", "8", "-beds", "4 bunk beds", "-json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record roomRecord
+	if err := json.Unmarshal([]byte(out), &record); err != nil || record.ID != 4 || record.MaxOccupancy != 8 || record.Beds != "4 bunk beds" {
+		t.Errorf("unexpected room %q (%v)", out, err)
+	}
+
+	if _, err := runTestCommand(t, "", "room", "add", "-name", "Closet", "-sleeps", "0"); err == nil {
+		t.Error("expected an error for a room that sleeps nobody")
+	}
+}
+
+func TestRunCommand_AuditActor(t *testing.T) {
+	repo := newTestRepo(t)
+	openRepo = func() (repository.DatabaseRepo, func(), error) {
+		return dbrepo.NewAuditedRepo(repo), func() {}, nil
+	}
+	t.Setenv("USER", "alice")
+
+	if _, err := runTestCommand(t, "", "room", "add", "-name", "Sergeant's Shed"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := repo.AuditEntries(context.Background(), models.AuditFilter{Action: audit.RoomCreate})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected the room to be audited, got %+v (%v)", entries, err)
+	}
+	if entries[0].Actor != "cli:alice" || entries[0].UserID != 0 {
+		t.Errorf("expected the change to be made by cli:alice, got %+v", entries[0])
+	}
+}
+
+func TestReservationList(t *testing.T) {
+	newTestRepo(t)
+
+	out, err := runTestCommand(t, "", "reservation", "list", "-json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(out) != "[]" {
+		t.Errorf("expected an empty JSON list, got %q", out)
+	}
+
+	out, err = runTestCommand(t, "", "reservation", "list", "-new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "ID") {
+		t.Errorf("expected a table header, got %q", out)
+	}
+}
+
+func TestReservationSetStatus(t *testing.T) {
+	repo := newTestRepo(t)
+	id, err := repo.InsertReservation(context.Background(), models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runTestCommand(t, "", "reservation", "set-status", "-id", strconv.Itoa(id), "-status", "confirmed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "is confirmed") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	out, err = runTestCommand(t, "", "reservation", "list", "-status", "confirmed", "-json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []reservationRecord
+	if err := json.Unmarshal([]byte(out), &records); err != nil {
+		t.Fatalf("output is not JSON: %v (%s)", err, out)
+	}
+	if len(records) != 1 || records[0].ID != id || records[0].Status != "confirmed" {
+		t.Errorf("unexpected confirmed reservations %+v", records)
+	}
+
+	var tests = []struct {
+		name string
+		args []string
+	}{
+		{"invalid-transition", []string{"-id", strconv.Itoa(id), "-status", "pending"}},
+		{"unknown-status", []string{"-id", strconv.Itoa(id), "-status", "processed"}},
+		{"missing-reservation", []string{"-id", "1000", "-status", "confirmed"}},
+	}
+	for _, e := range tests {
+		args := append([]string{"reservation", "set-status"}, e.args...)
+		if _, err := runTestCommand(t, "", args...); err == nil {
+			t.Errorf("for %s, expected an error", e.name)
+		}
+	}
+}
+
+func TestReservationPurge(t *testing.T) {
+	repo := newTestRepo(t)
+	id, err := repo.InsertReservation(context.Background(), models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteReservationById(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runTestCommand(t, "", "reservation", "purge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "purged 0 deleted reservations") {
+		t.Errorf("expected a reservation deleted just now to be kept, got %q", out)
+	}
+
+	out, err = runTestCommand(t, "", "reservation", "purge", "-older-than", "1ns", "-json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record struct {
+		Purged int `json:"purged"`
+	}
+	if err := json.Unmarshal([]byte(out), &record); err != nil || record.Purged != 1 {
+		t.Errorf("expected 1 purged reservation, got %q (%v)", out, err)
+	}
+}
+
+func TestReservationExport(t *testing.T) {
+	newTestRepo(t)
+
+	out, err := runTestCommand(t, "", "reservation", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "id,first_name,last_name,email") {
+		t.Errorf("expected a CSV header, got %q", out)
+	}
+
+	if _, err = runTestCommand(t, "", "reservation", "export", "-format", "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestReservationImport(t *testing.T) {
+	repo := newTestRepo(t)
+	file := "Name,Surname,email,room,start_date,end_date\n" +
+		"Jane,Doe,jane@doe.com,1,2050-02-01,2050-02-03\n" +
+		"Jane,Doe,jane@doe.com,2,2050-02-01,2050-02-03\n"
+
+	out, err := runTestCommand(t, file, "reservation", "import", "-dry-run", "-map", "first_name=Name,last_name=Surname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "2 rows can be imported") {
+		t.Errorf("unexpected output %q", out)
+	}
+	if count, _ := repo.CountReservationsByStatus(context.Background()); count != 0 {
+		t.Errorf("expected a dry run to import nothing, got %d reservations", count)
+	}
+
+	out, err = runTestCommand(t, file, "reservation", "import", "-json", "-map", "first_name=Name,last_name=Surname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record importRecord
+	if err := json.Unmarshal([]byte(out), &record); err != nil {
+		t.Fatalf("output is not JSON: %v (%s)", err, out)
+	}
+	if record.Rows != 2 || len(record.Imported) != 2 {
+		t.Errorf("unexpected import %+v", record)
+	}
+
+	report := filepath.Join(t.TempDir(), "errors.csv")
+	out, err = runTestCommand(t, file, "reservation", "import", "-map", "first_name=Name,last_name=Surname", "-report", report)
+	if !errors.Is(err, importer.ErrInvalidRows) || !strings.Contains(out, "2 of 2 rows have problems") {
+		t.Errorf("expected the rows to clash with the import, got %q (%v)", out, err)
+	}
+	written, err := os.ReadFile(report)
+	if err != nil || !strings.HasPrefix(string(written), "line,field,problem\n2,room,") {
+		t.Errorf("unexpected report %q (%v)", written, err)
+	}
+	if count, _ := repo.CountReservationsByStatus(context.Background()); count != 2 {
+		t.Errorf("expected the clashing import to insert nothing, got %d reservations", count)
+	}
+
+	if _, err = runTestCommand(t, file, "reservation", "import", "-map", "nickname=Name"); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+	if _, err = runTestCommand(t, file, "reservation", "import"); err == nil {
+		t.Error("expected an error for a missing column")
+	}
+}
+
+func TestRestrictionBlock(t *testing.T) {
+	repo := newTestRepo(t)
+
+	out, err := runTestCommand(t, "", "restriction", "block", "-room", "1", "-start", "2050-01-01", "-end", "2050-01-05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "blocked room 1") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	start := time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC)
+	available, err := repo.SearchAvailabilityByDatesByRoomId(context.Background(), start, start.AddDate(0, 0, 1), 1)
+	if err != nil || available {
+		t.Errorf("room 1 is still available after the block (%v)", err)
+	}
+
+	var tests = []struct {
+		name string
+		args []string
+	}{
+		{"bad-date", []string{"-room", "1", "-start", "01/01/2050", "-end", "2050-01-05"}},
+		{"end-before-start", []string{"-room", "1", "-start", "2050-01-05", "-end", "2050-01-01"}},
+		{"unknown-room", []string{"-room", "100", "-start", "2050-01-01", "-end", "2050-01-05"}},
+	}
+	for _, e := range tests {
+		args := append([]string{"restriction", "block"}, e.args...)
+		if _, err := runTestCommand(t, "", args...); err == nil {
+			t.Errorf("for %s, expected an error", e.name)
+		}
+	}
+}
+
+func TestSeedDemo(t *testing.T) {
+	repo := newTestRepo(t)
+
+	out, err := runTestCommand(t, "", "seed", "demo", "-json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seed seedRecord
+	if err := json.Unmarshal([]byte(out), &seed); err != nil {
+		t.Fatalf("output is not JSON: %v (%s)", err, out)
+	}
+	if seed.AdminCreated {
+		t.Error("the demo admin already exists and should not be created again")
+	}
+	if len(seed.Reservations) != len(demoGuests) {
+		t.Errorf("expected %d reservations, got %d", len(demoGuests), len(seed.Reservations))
+	}
+
+	//a failing reservation stops the seed with an error
+	defer repo.FailOn("InsertReservation", errors.New("some error"))()
+	if _, err := runTestCommand(t, "", "seed", "demo"); err == nil {
+		t.Error("expected the seed to fail when a reservation cannot be stored")
+	}
+}
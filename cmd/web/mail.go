@@ -1,36 +1,69 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/ArmanurRahman/booking/internal/metrics"
 	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/ArmanurRahman/booking/internal/tracing"
 	mail "github.com/xhit/go-simple-mail/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
+//mailQueueSize is how many emails can wait for the mail worker before senders block
+const mailQueueSize = 100
+
+//mailWorkers tracks the goroutines sending the queued emails
+var mailWorkers sync.WaitGroup
+
+//sendMail sends one email, tests replace it to avoid a real SMTP server
+var sendMail = sendMag
+
+//listenForMail sends queued emails until the mail channel is closed
 func listenForMail() {
+	mailWorkers.Add(1)
 	go func() {
-		for {
-			msg := <-app.MailChan
-			sendMag(msg)
+		defer mailWorkers.Done()
+		for msg := range app.MailChan {
+			if err := sendMail(msg); err != nil && msg.Failed != nil {
+				msg.Failed()
+			}
 		}
 	}()
 }
 
-func sendMag(m models.MailData) {
+func sendMag(m models.MailData) error {
+	ctx, span := tracing.Start(context.Background(), "mail.send",
+		attribute.String("mail.template", m.Template),
+		attribute.String("mail.subject", m.Subject),
+	)
+	defer span.End()
+
 	server := mail.NewSMTPClient()
-	server.Host = "localhost"
-	server.Port = 1025
+	server.Host = app.Mail.Host
+	server.Port = app.Mail.Port
+	server.Username = app.Mail.Username
+	server.Password = app.Mail.Password
 	server.KeepAlive = false
 	server.ConnectTimeout = 10 * time.Second
 	server.SendTimeout = 10 * time.Second
 
+	logger := app.Logger.With("to", m.To, "subject", m.Subject)
+
+	_, connectSpan := tracing.Start(ctx, "smtp.connect", attribute.String("server.address", server.Host))
 	client, err := server.Connect()
+	tracing.End(connectSpan, err)
 	if err != nil {
-		errorLog.Println(err)
+		logger.Error("cannot connect to mail server", "error", err)
+		span.SetStatus(codes.Error, "cannot connect to mail server")
+		metrics.MailSent.WithLabelValues("failure").Inc()
+		return err
 	}
 
 	email := mail.NewMSG()
@@ -41,18 +74,24 @@ func sendMag(m models.MailData) {
 	} else {
 		data, err := ioutil.ReadFile(fmt.Sprintf("./email-templates/%s", m.Template))
 		if err != nil {
-			app.ErrorLog.Println(err)
+			logger.Error("cannot read email template", "template", m.Template, "error", err)
 		}
 
 		mailTemplate := string(data)
 		msgToSend := strings.Replace(mailTemplate, "[%body%]", m.Content, 1)
 		email.SetBody(mail.TextHTML, msgToSend)
 	}
+	_, sendSpan := tracing.Start(ctx, "smtp.send")
 	err = email.Send(client)
+	tracing.End(sendSpan, err)
 	if err != nil {
-		log.Println(err)
-	} else {
-		log.Println("Email sent!")
+		logger.Error("cannot send email", "error", err)
+		span.SetStatus(codes.Error, "cannot send email")
+		metrics.MailSent.WithLabelValues("failure").Inc()
+		return err
 	}
 
+	logger.Info("email sent")
+	metrics.MailSent.WithLabelValues("success").Inc()
+	return nil
 }
@@ -1,82 +1,159 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"time"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/ArmanurRahman/booking/internal/config"
 	"github.com/ArmanurRahman/booking/internal/drivers"
+	"github.com/ArmanurRahman/booking/internal/events"
 	"github.com/ArmanurRahman/booking/internal/handlers"
 	"github.com/ArmanurRahman/booking/internal/helpers"
+	"github.com/ArmanurRahman/booking/internal/importer"
+	"github.com/ArmanurRahman/booking/internal/logging"
+	"github.com/ArmanurRahman/booking/internal/metrics"
 	"github.com/ArmanurRahman/booking/internal/models"
 	"github.com/ArmanurRahman/booking/internal/render"
+	"github.com/ArmanurRahman/booking/internal/tracing"
 
 	"github.com/alexedwards/scs/v2"
 )
 
-const port = ":8080"
-
 var app config.AppConfig
 var session *scs.SessionManager
 
-var infoLog *log.Logger
-var errorLog *log.Logger
-
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	db, err := run()
+	if ok, err := runCommand(os.Args[1:], os.Stdin, os.Stdout); ok {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	} else if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		fmt.Fprintf(os.Stderr, "unknown command %q, commands are: migrate, %s\n", strings.Join(os.Args[1:], " "), commandNames())
+		os.Exit(2)
+	}
+
+	err := config.Load(&app, os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		log.Fatal(err)
+	}
 
+	if app.PrintConfig {
+		config.Print(os.Stdout, &app)
+		return
+	}
+
+	err = app.Validate()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.SQL.Close()
 
-	defer close(app.MailChan)
+	db, err := run()
+
+	if err != nil {
+		app.Logger.Error("cannot start", "error", err)
+		os.Exit(1)
+	}
+
+	traces, err := tracing.Setup(context.Background(), &app, os.Stdout)
+	if err != nil {
+		app.Logger.Error("cannot start tracing", "error", err)
+		os.Exit(1)
+	}
+	app.Logger.Info("tracing", "exporter", app.Tracing.Exporter)
+
 	listenForMail()
 
-	fmt.Println("stating mail listener")
+	app.Logger.Info("starting mail listener")
+
+	var sched *scheduler
+	if app.Scheduler.Enabled {
+		notifier := &guestNotifier{App: &app, DB: handlers.Repo.DB}
+		purger := &trashPurger{App: &app, DB: handlers.Repo.DB}
+		sched = newScheduler(append(notifier.jobs(), purger.job())...)
+		sched.start()
+		app.Logger.Info("starting scheduler", "interval", app.Scheduler.Interval, "trash_retention", app.Reservations.TrashRetention)
+	}
+
+	app.Logger.Info("starting web server", "addr", app.Addr())
 
-	fmt.Println("Starting listining to port ", port)
-	//_ = http.ListenAndServe(port, nil)
+	ln, err := net.Listen("tcp", app.Addr())
+	if err != nil {
+		app.Logger.Error("cannot listen", "addr", app.Addr(), "error", err)
+		os.Exit(1)
+	}
 
-	srv := &http.Server{
-		Addr:    port,
-		Handler: routes(&app),
+	srv := &server{
+		http: &http.Server{
+			Handler: routes(&app),
+		},
+		scheduler: sched,
+		notifier:  handlers.Repo.Staff,
+		db:        db,
+		traces:    traces,
+		timeout:   app.ShutdownTimeout,
 	}
+	//end the live admin streams so they do not hold up the shutdown
+	srv.http.RegisterOnShutdown(app.Events.Close)
 
-	err = srv.ListenAndServe()
+	//stop on ctrl+c and on the SIGTERM sent during deploys
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	err = srv.run(ctx, ln)
 
 	if err != nil {
-		log.Fatal(err)
+		app.Logger.Error("shutdown failed", "error", err)
+		os.Exit(1)
 	}
 }
 
 func run() (*drivers.DB, error) {
 	//what am i put in session
 	gob.Register(models.Reservation{})
+	gob.Register([]models.Reservation{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
+	gob.Register([]importer.Problem{})
 
 	//initiate mail chan
-	mailChan := make(chan models.MailData)
+	mailChan := make(chan models.MailData, mailQueueSize)
 	app.MailChan = mailChan
 
-	//change this value to true in production
-	app.IsProduction = false
+	app.Events = events.NewBus()
 
-	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	app.Logger = logging.New(os.Stdout, app.IsProduction, app.LogLevel)
+	slog.SetDefault(app.Logger)
 
 	session = scs.New()
-	session.Lifetime = 24 * time.Hour
+	session.Lifetime = app.SessionLifetime
 	session.Cookie.Persist = true
 	session.Cookie.SameSite = http.SameSiteLaxMode
 	session.Cookie.Secure = app.IsProduction
@@ -85,21 +162,40 @@ func run() (*drivers.DB, error) {
 
 	//connect to database
 
-	db, err := drivers.ConnectSQL("host=localhost port=5432 dbname=booking user=postgres password=mubeen")
+	db, err := connectDB()
 	if err != nil {
-		log.Fatal("Cannot connect to DB. Dying...")
+		return nil, fmt.Errorf("cannot connect to database: %w", err)
+	}
+	if db.Driver == "sqlite" {
+		app.Logger.Info("connected to database", "driver", db.Driver, "path", app.DB.Path)
+	} else {
+		app.Logger.Info("connected to database", "driver", db.Driver, "host", app.DB.Host, "name", app.DB.Name)
 	}
-	log.Println("connected to database")
+
+	if app.DB.Migrate {
+		m, err := newMigrator(db)
+		if err != nil {
+			return nil, err
+		}
+		applied, err := m.Up(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("cannot migrate the database: %w", err)
+		}
+		for _, mig := range applied {
+			app.Logger.Info("applied migration", "version", mig.Version, "name", mig.Name)
+		}
+	}
+
+	metrics.RegisterDB(db.SQL)
+	metrics.RegisterMailQueue(app.MailChan)
 
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
-		log.Fatal("cannot create template cache")
-		return nil, err
+		return nil, fmt.Errorf("cannot create template cache: %w", err)
 	}
 
 	app.TemplateCache = tc
 
-	app.UseCache = false
 	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
 
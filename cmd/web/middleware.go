@@ -1,10 +1,25 @@
 package main
 
 import (
+	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/ArmanurRahman/booking/internal/audit"
 	"github.com/ArmanurRahman/booking/internal/helpers"
+	"github.com/ArmanurRahman/booking/internal/logging"
+	"github.com/ArmanurRahman/booking/internal/metrics"
+	"github.com/ArmanurRahman/booking/internal/tracing"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/justinas/nosurf"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 /*
@@ -34,6 +49,25 @@ func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
 
+//SessionRead loads the session without saving it. LoadAndSave buffers the whole response to
+//save the session before it, so the streamed responses use this instead and must not change
+//the session.
+func SessionRead(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if cookie, err := r.Cookie(session.Cookie.Name); err == nil {
+			token = cookie.Value
+		}
+
+		ctx, err := session.Load(r.Context(), token)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !helpers.IsAuthinticate(r) {
@@ -44,3 +78,137 @@ func Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+//GuestAuth lets only guests logged in to their account through
+func GuestAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !helpers.IsGuest(r) {
+			session.Put(r.Context(), "error", "Log in first")
+			http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//NoGuests keeps guests logged in to their account out of the staff pages
+func NoGuests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if helpers.IsGuest(r) {
+			helpers.ClientError(w, r, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//AuditActor stores the logged in user and the client address in the context, for the audit log
+func AuditActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		ctx := audit.NewContext(r.Context(), audit.Actor{
+			UserID: session.GetInt(r.Context(), "user_id"),
+			IP:     ip,
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+//Metrics records the count and latency of every request by chi route pattern
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		//the pattern is only known once chi has routed the request
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		metrics.HTTPRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		metrics.HTTPDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+//RequestLogger attaches the request logger to the context and logs every request once it is served
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &logging.Request{
+			ID:     middleware.GetReqID(r.Context()),
+			Start:  time.Now(),
+			UserID: session.GetInt(r.Context(), "user_id"),
+		}
+		w.Header().Set("X-Request-Id", req.ID)
+
+		ctx := logging.NewContext(r.Context(), app.Logger, req)
+		r = r.WithContext(ctx)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		logging.FromContext(ctx).Log(ctx, level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+		)
+	})
+}
+
+//Tracing starts a server span for every request, continuing the trace of the caller if there is one
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			route := rctx.RoutePattern()
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
@@ -3,7 +3,18 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/ArmanurRahman/booking/internal/audit"
+	"github.com/ArmanurRahman/booking/internal/helpers"
+	"github.com/ArmanurRahman/booking/internal/metrics"
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -31,3 +42,123 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
 	}
 }
+
+func TestAuditActor(t *testing.T) {
+	old := session
+	t.Cleanup(func() { session = old })
+	session = scs.New()
+
+	var actor audit.Actor
+	h := SessionLoad(AuditActor(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = audit.ActorFrom(r.Context())
+	})))
+
+	req := httptest.NewRequest("POST", "/admin/reservation/all/1", nil)
+	req.RemoteAddr = "192.0.2.1:51234"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if actor.IP != "192.0.2.1" || actor.UserID != 0 {
+		t.Errorf("expected an anonymous actor from 192.0.2.1, got %+v", actor)
+	}
+}
+
+//guestSession runs h with a new session that holds the values put by the first handler
+func guestSession(t *testing.T, put map[string]interface{}, h http.Handler) *httptest.ResponseRecorder {
+	old, oldApp := session, app.Session
+	t.Cleanup(func() { session, app.Session = old, oldApp })
+	session = scs.New()
+	app.Session = session
+	helpers.NewHelpers(&app)
+
+	fill := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range put {
+			session.Put(r.Context(), k, v)
+		}
+		h.ServeHTTP(w, r)
+	})
+
+	rr := httptest.NewRecorder()
+	SessionLoad(fill).ServeHTTP(rr, httptest.NewRequest("GET", "/admin/dashboard", nil))
+	return rr
+}
+
+func TestGuestAuth(t *testing.T) {
+	var myH myHandler
+
+	rr := guestSession(t, nil, GuestAuth(&myH))
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/account/login" {
+		t.Errorf("expected a redirect to the guest login, got %d to %s", rr.Code, rr.Header().Get("Location"))
+	}
+
+	rr = guestSession(t, map[string]interface{}{"user_id": 1}, GuestAuth(&myH))
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected staff to be sent to the guest login, got %d", rr.Code)
+	}
+
+	rr = guestSession(t, map[string]interface{}{"guest_account_id": 1}, GuestAuth(&myH))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected a guest to get through, got %d", rr.Code)
+	}
+}
+
+func TestNoGuests(t *testing.T) {
+	var myH myHandler
+
+	rr := guestSession(t, map[string]interface{}{"guest_account_id": 1}, NoGuests(&myH))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected %d for a guest, got %d", http.StatusForbidden, rr.Code)
+	}
+
+	rr = guestSession(t, map[string]interface{}{"user_id": 1}, NoGuests(&myH))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected staff to get through, got %d", rr.Code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(Metrics)
+	mux.Get("/choose-room/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusSeeOther)
+	})
+
+	counter := metrics.HTTPRequests.WithLabelValues("GET", "/choose-room/{id}", "303")
+	before := testutil.ToFloat64(counter)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/choose-room/1", nil)
+	mux.ServeHTTP(rr, req)
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/choose-room/2", nil))
+
+	if got := testutil.ToFloat64(counter) - before; got != 2 {
+		t.Errorf("expected 2 requests counted under the route pattern, got %v", got)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	mux := chi.NewRouter()
+	mux.Use(Tracing)
+	mux.Get("/choose-room/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/choose-room/1", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Name != "GET /choose-room/{id}" {
+		t.Errorf("span named %q, wanted the route pattern", spans[0].Name)
+	}
+	if spans[0].Status.Code.String() != "Error" {
+		t.Errorf("expected a 500 to mark the span as an error, got %s", spans[0].Status.Code)
+	}
+}
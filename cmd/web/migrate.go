@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/drivers"
+	"github.com/ArmanurRahman/booking/internal/migrate"
+	"github.com/ArmanurRahman/booking/migrations"
+)
+
+const migrateUsage = "usage: web migrate up | down [n] | status [config flags]"
+
+//runMigrate runs the migrate subcommand, the config flags follow the action
+func runMigrate(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	action, args := args[0], args[1:]
+
+	steps := 1
+	if action == "down" && len(args) > 0 && args[0][0] != '-' {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return fmt.Errorf("down takes a positive number of migrations, got %q", args[0])
+		}
+		steps, args = n, args[1:]
+	}
+
+	err := config.Load(&app, args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return fmt.Errorf("cannot connect to database: %w", err)
+	}
+	defer db.SQL.Close()
+
+	m, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch action {
+	case "up":
+		applied, err := m.Up(ctx)
+		printMigrations(out, "applied", applied)
+		return err
+	case "down":
+		reverted, err := m.Down(ctx, steps)
+		printMigrations(out, "reverted", reverted)
+		return err
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printStatus(out, statuses)
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+//connectDB opens the database chosen by db.driver
+func connectDB() (*drivers.DB, error) {
+	if app.DB.Driver == "sqlite" {
+		return drivers.ConnectSQLite(app.DB.Path)
+	}
+	return drivers.ConnectSQL(app.DB.DSN())
+}
+
+//newMigrator creates a migrator with the migrations of the driver db was opened with
+func newMigrator(db *drivers.DB) (*migrate.Migrator, error) {
+	fsys, err := migrations.For(db.Driver)
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(db.SQL, db.Driver, fsys)
+}
+
+func printMigrations(out io.Writer, verb string, done []migrate.Migration) {
+	if len(done) == 0 {
+		fmt.Fprintln(out, "nothing to do")
+		return
+	}
+	for _, m := range done {
+		fmt.Fprintf(out, "%s %d_%s\n", verb, m.Version, m.Name)
+	}
+}
+
+func printStatus(out io.Writer, statuses []migrate.Status) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.Applied() {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	w.Flush()
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestRunMigrate_Usage(t *testing.T) {
+	var tests = []struct {
+		name string
+		args []string
+	}{
+		{"no-action", nil},
+		{"bad-steps", []string{"down", "zero"}},
+		{"negative-steps", []string{"down", "0"}},
+	}
+
+	for _, e := range tests {
+		if err := runMigrate(e.args, ioutil.Discard); err == nil {
+			t.Errorf("for %s, expected an error", e.name)
+		}
+	}
+}
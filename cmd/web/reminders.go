@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/ArmanurRahman/booking/internal/repository"
+)
+
+const (
+	notificationPreArrival = "pre-arrival"
+	notificationCheckOut   = "check-out"
+	notificationFollowUp   = "follow-up"
+)
+
+//followUpCatchUpDays is how many days late a follow-up is still sent, when the jobs did not run on time
+const followUpCatchUpDays = 7
+
+//guestNotifier builds the scheduled emails sent to guests around their stay
+type guestNotifier struct {
+	App *config.AppConfig
+	DB  repository.DatabaseRepo
+}
+
+//jobs returns the scheduler jobs that send the guest notifications
+func (g *guestNotifier) jobs() []job {
+	interval := g.App.Scheduler.Interval
+	return []job{
+		{name: notificationPreArrival, interval: interval, run: g.sendPreArrivalReminders},
+		{name: notificationCheckOut, interval: interval, run: g.sendCheckOutInstructions},
+		{name: notificationFollowUp, interval: interval, run: g.sendFollowUps},
+	}
+}
+
+//sendPreArrivalReminders emails guests arriving in ReminderDaysBefore days or less
+func (g *guestNotifier) sendPreArrivalReminders(ctx context.Context, now time.Time) {
+	today := dateOf(now)
+
+	reservations, err := g.DB.ReservationsByStartDate(ctx, today, today.AddDate(0, 0, g.App.Scheduler.ReminderDaysBefore))
+	if err != nil {
+		g.App.Logger.Error("cannot load arrivals", "job", notificationPreArrival, "error", err)
+		return
+	}
+
+	for _, res := range reservations {
+		content := fmt.Sprintf(`
+			<strong>Your stay is coming up</strong><br>
+			Dear: %s, <br>
+			This is a reminder of your reservation in the %s from %s to %s. We look forward to welcoming you.
+		`, res.FirstName, res.Room.RoomName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"))
+
+		g.notify(ctx, res, notificationPreArrival, "Upcoming Reservation Reminder", content)
+	}
+}
+
+//sendCheckOutInstructions emails guests departing today
+func (g *guestNotifier) sendCheckOutInstructions(ctx context.Context, now time.Time) {
+	today := dateOf(now)
+
+	reservations, err := g.DB.ReservationsByEndDate(ctx, today, today)
+	if err != nil {
+		g.App.Logger.Error("cannot load departures", "job", notificationCheckOut, "error", err)
+		return
+	}
+
+	for _, res := range reservations {
+		content := fmt.Sprintf(`
+			<strong>Check-out Instructions</strong><br>
+			Dear: %s, <br>
+			Today is your check-out day. Please leave the key of the %s at the front desk before 11:00.
+		`, res.FirstName, res.Room.RoomName)
+
+		g.notify(ctx, res, notificationCheckOut, "Check-out Instructions", content)
+	}
+}
+
+//sendFollowUps thanks guests that left FollowUpDaysAfter days ago, or up to followUpCatchUpDays before, and asks for a review
+func (g *guestNotifier) sendFollowUps(ctx context.Context, now time.Time) {
+	departure := dateOf(now).AddDate(0, 0, -g.App.Scheduler.FollowUpDaysAfter)
+
+	reservations, err := g.DB.ReservationsByEndDate(ctx, departure.AddDate(0, 0, -followUpCatchUpDays), departure)
+	if err != nil {
+		g.App.Logger.Error("cannot load departures", "job", notificationFollowUp, "error", err)
+		return
+	}
+
+	for _, res := range reservations {
+		content := fmt.Sprintf(`
+			<strong>Thank you for staying with us</strong><br>
+			Dear: %s, <br>
+			We hope you enjoyed your stay in the %s. We would be grateful if you took a minute to review your stay.
+		`, res.FirstName, res.Room.RoomName)
+
+		g.notify(ctx, res, notificationFollowUp, "Thank You for Your Stay", content)
+	}
+}
+
+//notify queues an email unless the notification was already sent for the reservation
+//or the guest cancelled or did not show up. The claim is released when the email cannot
+//be queued or sent, so that the next run tries again
+func (g *guestNotifier) notify(ctx context.Context, res models.Reservation, kind, subject, content string) {
+	if !res.Status.Stays() {
+		return
+	}
+
+	claimed, err := g.DB.ClaimNotification(ctx, res.ID, kind)
+	if err != nil {
+		g.App.Logger.Error("cannot claim notification", "job", kind, "reservation_id", res.ID, "error", err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	msg := models.MailData{
+		To:       res.Email,
+		From:     g.App.Mail.From,
+		Subject:  subject,
+		Content:  content,
+		Template: "basic.html",
+		Failed: func() {
+			g.release(context.Background(), res.ID, kind)
+		},
+	}
+
+	select {
+	case g.App.MailChan <- msg:
+	default:
+		g.App.Logger.Warn("mail queue is full", "job", kind, "reservation_id", res.ID)
+		g.release(ctx, res.ID, kind)
+	}
+}
+
+//release forgets a claimed notification so that the next run sends it again
+func (g *guestNotifier) release(ctx context.Context, reservationID int, kind string) {
+	if err := g.DB.ReleaseNotification(ctx, reservationID, kind); err != nil {
+		g.App.Logger.Error("cannot release notification", "job", kind, "reservation_id", reservationID, "error", err)
+	}
+}
+
+//dateOf truncates a time to midnight UTC, the way dates are stored in the database
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
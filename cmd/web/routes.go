@@ -5,6 +5,7 @@ import (
 
 	"github.com/ArmanurRahman/booking/internal/config"
 	"github.com/ArmanurRahman/booking/internal/handlers"
+	"github.com/ArmanurRahman/booking/internal/metrics"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -12,10 +13,40 @@ import (
 func routes(app *config.AppConfig) http.Handler {
 	mux := chi.NewRouter()
 
+	mux.Use(middleware.RequestID)
+	mux.Use(Tracing)
 	mux.Use(middleware.Recoverer)
+	mux.Use(Metrics)
 	//mux.Use(WriteToConsole)
 	mux.Use(NoSurf)
-	mux.Use(SessionLoad)
+
+	//the streamed responses are sent as they are written, without saving the session
+	mux.Group(func(mux chi.Router) {
+		mux.Use(SessionRead)
+		mux.Use(RequestLogger)
+		mux.Use(Auth)
+		mux.Use(NoGuests)
+		mux.Get("/admin/events", handlers.Repo.AdminEvents)
+		mux.Get("/admin/export/reservations.{format}", handlers.Repo.AdminExportReservations)
+		mux.Get("/admin/export/occupancy.{format}", handlers.Repo.AdminExportOccupancy)
+		mux.Get("/admin/export/restrictions.{format}", handlers.Repo.AdminExportRestrictions)
+	})
+
+	mux.Group(func(mux chi.Router) {
+		mux.Use(SessionLoad)
+		mux.Use(RequestLogger)
+		mux.Use(AuditActor)
+		pages(mux)
+	})
+	return mux
+}
+
+//pages registers the routes served with the session loaded and saved
+func pages(mux chi.Router) {
+	mux.Get("/healthz", handlers.Repo.Healthz)
+	mux.Get("/readyz", handlers.Repo.Readyz)
+	mux.Get("/version", handlers.Repo.Version)
+	mux.Handle("/metrics", metrics.Handler())
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
@@ -30,25 +61,60 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
 	mux.Get("/book-room", handlers.Repo.BookRoom)
+	mux.Get("/cart", handlers.Repo.Cart)
+	mux.Post("/cart", handlers.Repo.AddToCart)
+	mux.Post("/cart/checkout", handlers.Repo.PostCart)
+	mux.Post("/cart/{n}/remove", handlers.Repo.RemoveFromCart)
+	mux.Get("/booking-summary", handlers.Repo.BookingSummary)
 	mux.Get("/user/login", handlers.Repo.UserLogin)
 	mux.Post("/user/login", handlers.Repo.PostUserLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
+	mux.Get("/account/signup", handlers.Repo.AccountSignup)
+	mux.Post("/account/signup", handlers.Repo.PostAccountSignup)
+	mux.Get("/account/verify", handlers.Repo.AccountVerify)
+	mux.Get("/account/login", handlers.Repo.AccountLogin)
+	mux.Post("/account/login", handlers.Repo.PostAccountLogin)
+	mux.Get("/account/logout", handlers.Repo.AccountLogout)
 
 	//handle static file
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
+	mux.Route("/account/bookings", func(mux chi.Router) {
+		mux.Use(GuestAuth)
+		mux.Get("/", handlers.Repo.MyBookings)
+		mux.Post("/{id}/cancel", handlers.Repo.CancelMyBooking)
+		mux.Post("/{id}/dates", handlers.Repo.ChangeMyBooking)
+		mux.Post("/group/{id}/cancel", handlers.Repo.CancelMyGroupBooking)
+	})
+
 	mux.Route("/admin", func(mux chi.Router) {
-		//mux.Use(Auth)
+		mux.Use(Auth)
+		mux.Use(NoGuests)
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+		mux.Get("/analytics/occupancy", handlers.Repo.AdminOccupancy)
+		mux.Get("/analytics/bookings", handlers.Repo.AdminBookings)
 		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+		mux.Get("/reservations-deleted", handlers.Repo.AdminDeletedReservations)
+		mux.Post("/reservations-deleted/{id}/restore", handlers.Repo.AdminRestoreReservation)
+		mux.Get("/guests", handlers.Repo.AdminGuests)
+		mux.Get("/guests/{id}", handlers.Repo.AdminShowGuest)
+		mux.Post("/guests/{id}", handlers.Repo.AdminPostGuest)
+		mux.Post("/guests/{id}/merge", handlers.Repo.AdminMergeGuest)
+		mux.Get("/import", handlers.Repo.AdminImport)
+		mux.Post("/import", handlers.Repo.AdminPostImport)
+		mux.Get("/import/report.{format}", handlers.Repo.AdminImportReport)
 		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalender)
 		mux.Get("/reservation/{src}/{id}", handlers.Repo.AdminShowReservation)
 		mux.Post("/reservation/{src}/{id}", handlers.Repo.AdminPostReservation)
-		mux.Get("/process-reservation/{src}/{id}", handlers.Repo.AdminApproveReservation)
-		mux.Get("/delete-reservation/{src}/{id}", handlers.Repo.AdminDeleteReservation)
+		mux.Post("/reservation/{src}/{id}/status", handlers.Repo.AdminReservationStatus)
+		mux.Post("/reservation/{src}/{id}/delete", handlers.Repo.AdminDeleteReservation)
+		mux.Post("/reservation/{src}/{id}/cancel-booking", handlers.Repo.AdminCancelBooking)
+		mux.Get("/audit", handlers.Repo.AdminAudit)
+		mux.Get("/audit/export", handlers.Repo.AdminAuditExport)
+		mux.Get("/notifications", handlers.Repo.AdminNotifications)
+		mux.Post("/notifications/read-all", handlers.Repo.AdminReadAllNotifications)
+		mux.Post("/notifications/{id}/read", handlers.Repo.AdminReadNotification)
 	})
-	return mux
-
 }
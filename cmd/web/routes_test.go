@@ -1,10 +1,25 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/events"
+	"github.com/ArmanurRahman/booking/internal/handlers"
+	"github.com/ArmanurRahman/booking/internal/helpers"
+	"github.com/ArmanurRahman/booking/internal/logging"
+	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/ArmanurRahman/booking/internal/repository"
+	"github.com/ArmanurRahman/booking/internal/repository/dbrepo"
+	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -20,3 +35,114 @@ func TestRoutes(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not *chi.Mux, type is %T", v))
 	}
 }
+
+//setupRoutes returns the routes of the app with a new session and an in-memory repository
+func setupRoutes(t *testing.T) http.Handler {
+	old, oldApp, oldLogger, oldEvents, oldRepo := session, app.Session, app.Logger, app.Events, handlers.Repo
+	t.Cleanup(func() {
+		session, app.Session, app.Logger, app.Events = old, oldApp, oldLogger, oldEvents
+		handlers.NewHandlers(oldRepo)
+	})
+	session = scs.New()
+	app.Session = session
+	app.Logger = logging.New(io.Discard, false, "info")
+	app.Events = events.NewBus()
+	helpers.NewHelpers(&app)
+	handlers.NewHandlers(handlers.NewTestRepo(&app, dbrepo.NewMemoryRepo(&app)))
+	return routes(&app)
+}
+
+//staffCookie returns the cookie of a session with a staff user logged in
+func staffCookie(t *testing.T) *http.Cookie {
+	ctx, err := session.Load(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Put(ctx, "user_id", 1)
+	token, _, err := session.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: session.Cookie.Name, Value: token}
+}
+
+func TestRoutes_AdminNeedsLogin(t *testing.T) {
+	mux := setupRoutes(t)
+
+	for _, url := range []string{"/admin/dashboard", "/admin/reservations-all", "/admin/reservation/all/1", "/admin/events", "/admin/export/reservations.csv"} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/login" {
+			t.Errorf("for %s, expected a redirect to the login, got %d to %s", url, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRoutes_AdminEventsStream(t *testing.T) {
+	srv := httptest.NewServer(setupRoutes(t))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/admin/events", nil)
+	req.AddCookie(staffCookie(t))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	//the handler is still running, so the first event was flushed as it was written
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "event: reservation\n" {
+		t.Errorf("expected the first event before the stream ends, got %q (%v)", line, err)
+	}
+}
+
+//blockedExportRepo reads many reservations for the export, then waits to be released
+type blockedExportRepo struct {
+	repository.DatabaseRepo
+	release chan struct{}
+}
+
+func (m *blockedExportRepo) EachReservation(ctx context.Context, q models.ReservationQuery, fn func(models.Reservation) error) error {
+	for i := 1; i <= 1000; i++ {
+		if err := fn(models.Reservation{ID: i, FirstName: "Guest", Email: "guest@example.com"}); err != nil {
+			return err
+		}
+	}
+	select {
+	case <-m.release:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func TestRoutes_ExportStream(t *testing.T) {
+	setupRoutes(t)
+	repo := &blockedExportRepo{DatabaseRepo: dbrepo.NewMemoryRepo(&app), release: make(chan struct{})}
+	handlers.NewHandlers(&handlers.Repository{App: &app, DB: repo})
+	srv := httptest.NewServer(routes(&app))
+	defer srv.Close()
+	defer close(repo.release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/admin/export/reservations.csv", nil)
+	req.AddCookie(staffCookie(t))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected the export to start before every row is read, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "id,first_name") {
+		t.Errorf("expected the first rows before the export ends, got %q (%v)", line, err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//job is a background task run by the scheduler
+type job struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context, now time.Time)
+}
+
+//scheduler runs jobs on their intervals until it is stopped
+type scheduler struct {
+	jobs []job
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newScheduler(jobs ...job) *scheduler {
+	return &scheduler{
+		jobs: jobs,
+		quit: make(chan struct{}),
+	}
+}
+
+//start runs every job once and then again on each tick of its interval
+func (s *scheduler) start() {
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go func(j job) {
+			defer s.wg.Done()
+
+			ticker := time.NewTicker(j.interval)
+			defer ticker.Stop()
+
+			s.runJob(j, time.Now())
+			for {
+				select {
+				case now := <-ticker.C:
+					s.runJob(j, now)
+				case <-s.quit:
+					return
+				}
+			}
+		}(j)
+	}
+}
+
+//stop signals every job to finish and waits for the running ones
+func (s *scheduler) stop() {
+	close(s.quit)
+	s.wg.Wait()
+}
+
+//runJob runs one job in its own trace, recovering from a panic so the job runs again on the next tick
+func (s *scheduler) runJob(j job, now time.Time) {
+	ctx, span := tracing.Start(context.Background(), "job", attribute.String("job.name", j.name))
+	defer span.End()
+	defer func() {
+		if r := recover(); r != nil {
+			app.Logger.Error("job panicked", "job", j.name, "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	j.run(ctx, now)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/logging"
+	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/ArmanurRahman/booking/internal/repository/dbrepo"
+)
+
+func TestScheduler(t *testing.T) {
+	var runs int32
+
+	s := newScheduler(job{
+		name:     "count",
+		interval: 10 * time.Millisecond,
+		run: func(ctx context.Context, now time.Time) {
+			atomic.AddInt32(&runs, 1)
+		},
+	})
+	s.start()
+	time.Sleep(55 * time.Millisecond)
+	s.stop()
+
+	n := atomic.LoadInt32(&runs)
+	if n < 2 {
+		t.Errorf("expected job to run at least twice, ran %d times", n)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if atomic.LoadInt32(&runs) != n {
+		t.Error("job kept running after the scheduler was stopped")
+	}
+}
+
+func TestSchedulerRecoversFromPanic(t *testing.T) {
+	app.Logger = logging.New(os.Stdout, false, "info")
+
+	var runs int32
+	s := newScheduler(job{
+		name:     "panic",
+		interval: 10 * time.Millisecond,
+		run: func(ctx context.Context, now time.Time) {
+			atomic.AddInt32(&runs, 1)
+			panic("boom")
+		},
+	})
+	s.start()
+	time.Sleep(35 * time.Millisecond)
+	s.stop()
+
+	if atomic.LoadInt32(&runs) < 2 {
+		t.Error("job did not run again after a panic")
+	}
+}
+
+func TestGuestNotifier_PreArrivalReminders(t *testing.T) {
+	testApp := config.AppConfig{
+		Logger:    logging.New(os.Stdout, false, "info"),
+		MailChan:  make(chan models.MailData, 10),
+		Scheduler: config.SchedulerConfig{ReminderDaysBefore: 3},
+	}
+	repo := dbrepo.NewMemoryRepo(&testApp)
+	notifier := &guestNotifier{App: &testApp, DB: repo}
+
+	now := time.Date(2021, 7, 20, 15, 30, 0, 0, time.UTC)
+	for _, start := range []time.Time{dateOf(now).AddDate(0, 0, 3), dateOf(now).AddDate(0, 0, 4)} {
+		_, err := repo.InsertReservation(context.Background(), models.Reservation{
+			FirstName: "John",
+			LastName:  "Smith",
+			Email:     "john@smith.com",
+			StartDate: start,
+			EndDate:   start.AddDate(0, 0, 2),
+			RoomID:    1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notifier.sendPreArrivalReminders(context.Background(), now)
+
+	if len(testApp.MailChan) != 1 {
+		t.Fatalf("expected 1 reminder, got %d", len(testApp.MailChan))
+	}
+
+	msg := <-testApp.MailChan
+	if msg.To != "john@smith.com" {
+		t.Errorf("reminder sent to %s, wanted john@smith.com", msg.To)
+	}
+	if msg.Subject != "Upcoming Reservation Reminder" {
+		t.Errorf("unexpected subject %q", msg.Subject)
+	}
+}
+
+func TestGuestNotifier_CatchesUpMissedDays(t *testing.T) {
+	testApp := config.AppConfig{
+		Logger:    logging.New(os.Stdout, false, "info"),
+		MailChan:  make(chan models.MailData, 10),
+		Scheduler: config.SchedulerConfig{ReminderDaysBefore: 3, FollowUpDaysAfter: 1},
+	}
+	repo := dbrepo.NewMemoryRepo(&testApp)
+	notifier := &guestNotifier{App: &testApp, DB: repo}
+
+	//the process was down when these reminders were due
+	now := time.Date(2021, 7, 20, 15, 30, 0, 0, time.UTC)
+	arrival := dateOf(now).AddDate(0, 0, 1)
+	if _, err := repo.InsertReservation(context.Background(), models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: arrival,
+		EndDate:   arrival.AddDate(0, 0, 2),
+		RoomID:    1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	departure := dateOf(now).AddDate(0, 0, -3)
+	if _, err := repo.InsertReservation(context.Background(), models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane@doe.com",
+		StartDate: departure.AddDate(0, 0, -2),
+		EndDate:   departure,
+		RoomID:    2,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	notifier.sendPreArrivalReminders(context.Background(), now)
+	notifier.sendFollowUps(context.Background(), now)
+
+	if len(testApp.MailChan) != 2 {
+		t.Fatalf("expected the late reminder and follow-up, got %d emails", len(testApp.MailChan))
+	}
+	if msg := <-testApp.MailChan; msg.To != "john@smith.com" {
+		t.Errorf("reminder sent to %s, wanted john@smith.com", msg.To)
+	}
+	if msg := <-testApp.MailChan; msg.To != "jane@doe.com" {
+		t.Errorf("follow-up sent to %s, wanted jane@doe.com", msg.To)
+	}
+
+	notifier.sendPreArrivalReminders(context.Background(), now.Add(time.Hour))
+	if len(testApp.MailChan) != 0 {
+		t.Error("reminder was sent again by the next run")
+	}
+}
+
+func TestGuestNotifier_ReleasesUnsentNotifications(t *testing.T) {
+	testApp := config.AppConfig{
+		Logger:   logging.New(os.Stdout, false, "info"),
+		MailChan: make(chan models.MailData, 1),
+	}
+	repo := dbrepo.NewMemoryRepo(&testApp)
+	notifier := &guestNotifier{App: &testApp, DB: repo}
+	res := models.Reservation{ID: 1, Email: "a@b.com"}
+
+	//the queue is full, the reminder is left for the next run
+	testApp.MailChan <- models.MailData{To: "other@b.com"}
+	notifier.notify(context.Background(), res, notificationPreArrival, "subject", "content")
+	<-testApp.MailChan
+
+	notifier.notify(context.Background(), res, notificationPreArrival, "subject", "content")
+	if len(testApp.MailChan) != 1 {
+		t.Fatal("reminder was not sent after the queue was full")
+	}
+
+	//the mail server failed, the reminder is left for the next run
+	msg := <-testApp.MailChan
+	msg.Failed()
+
+	notifier.notify(context.Background(), res, notificationPreArrival, "subject", "content")
+	if len(testApp.MailChan) != 1 {
+		t.Error("reminder was not sent after the mail server failed")
+	}
+}
+
+func TestGuestNotifier_SkipsClaimedNotifications(t *testing.T) {
+	testApp := config.AppConfig{
+		Logger:   logging.New(os.Stdout, false, "info"),
+		MailChan: make(chan models.MailData, 10),
+	}
+	repo := dbrepo.NewMemoryRepo(&testApp)
+	notifier := &guestNotifier{App: &testApp, DB: repo}
+
+	//another instance already sent the follow up
+	if _, err := repo.ClaimNotification(context.Background(), 1, notificationFollowUp); err != nil {
+		t.Fatal(err)
+	}
+	notifier.notify(context.Background(), models.Reservation{ID: 1, Email: "a@b.com"}, notificationFollowUp, "subject", "content")
+
+	if len(testApp.MailChan) != 0 {
+		t.Error("notification was sent twice")
+	}
+}
+
+func TestTrashPurger(t *testing.T) {
+	testApp := config.AppConfig{
+		Logger:       logging.New(os.Stdout, false, "info"),
+		Reservations: config.ReservationConfig{TrashRetention: time.Hour},
+	}
+	repo := dbrepo.NewMemoryRepo(&testApp)
+	purger := &trashPurger{App: &testApp, DB: repo}
+
+	id, err := repo.InsertReservation(context.Background(), models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteReservationById(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+
+	purger.purge(context.Background(), time.Now())
+	if deleted, _ := repo.DeletedReservations(context.Background()); len(deleted) != 1 {
+		t.Fatalf("expected the reservation to stay in the trash for the retention, got %d", len(deleted))
+	}
+
+	purger.purge(context.Background(), time.Now().Add(2*time.Hour))
+	if deleted, _ := repo.DeletedReservations(context.Background()); len(deleted) != 0 {
+		t.Errorf("expected the reservation to be purged after the retention, got %d", len(deleted))
+	}
+}
+
+func TestDateOf(t *testing.T) {
+	d := dateOf(time.Date(2021, 7, 20, 23, 59, 0, 0, time.UTC))
+
+	if !d.Equal(time.Date(2021, 7, 20, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("dateOf returned %s", d)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/drivers"
+)
+
+//server runs the web server and shuts it down together with the
+//background workers that depend on it
+type server struct {
+	http      *http.Server
+	scheduler *scheduler
+	notifier  interface{ Wait() }
+	db        *drivers.DB
+	traces    func(context.Context) error
+	timeout   time.Duration
+}
+
+//run serves requests on ln until ctx is cancelled and then shuts down
+func (s *server) run(ctx context.Context, ln net.Listener) error {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.http.Serve(ln)
+	}()
+
+	select {
+	case err := <-errChan:
+		if err != http.ErrServerClosed {
+			return err
+		}
+	case <-ctx.Done():
+	}
+
+	return s.shutdown()
+}
+
+//shutdown stops accepting connections, waits for in-flight requests, stops the
+//scheduler, drains the mail queue, closes the database pool and flushes the
+//pending spans, all within the timeout. When requests or workers are still
+//running at the deadline, the mail queue and the pool are left open for them;
+//a mail worker still sending may release its notification in the database.
+func (s *server) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	app.Logger.Info("shutting down, waiting for in-flight requests")
+	err := s.http.Shutdown(ctx)
+	if err != nil {
+		app.Logger.Error("http shutdown failed, closing the open connections", "error", err)
+		_ = s.http.Close()
+	}
+
+	if s.scheduler != nil {
+		if stopErr := wait(ctx, s.scheduler.stop); stopErr != nil {
+			app.Logger.Error("scheduler not stopped", "error", stopErr)
+			err = errors.Join(err, stopErr)
+		}
+	}
+	if s.notifier != nil {
+		if notifyErr := wait(ctx, s.notifier.Wait); notifyErr != nil {
+			app.Logger.Error("notifications not sent", "error", notifyErr)
+			err = errors.Join(err, notifyErr)
+		}
+	}
+
+	//a handler or job still running may send mail or query, closing under it would panic or fail
+	if err != nil {
+		return err
+	}
+
+	app.Logger.Info("draining the mail queue")
+	close(app.MailChan)
+	mailErr := wait(ctx, mailWorkers.Wait)
+	if mailErr != nil {
+		app.Logger.Error("mail queue not drained, leaving the database open", "error", mailErr)
+	} else if s.db != nil {
+		if dbErr := s.db.SQL.Close(); dbErr != nil {
+			app.Logger.Error("cannot close database", "error", dbErr)
+		}
+	}
+
+	if s.traces != nil {
+		if traceErr := s.traces(ctx); traceErr != nil {
+			app.Logger.Error("cannot flush traces", "error", traceErr)
+		}
+	}
+
+	if mailErr != nil {
+		return mailErr
+	}
+	app.Logger.Info("shutdown complete")
+	return nil
+}
+
+//wait runs fn and returns once it finishes or ctx is done
+func wait(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.New("timed out")
+	}
+}
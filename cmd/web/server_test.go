@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/drivers"
+	"github.com/ArmanurRahman/booking/internal/logging"
+	"github.com/ArmanurRahman/booking/internal/models"
+)
+
+//setupShutdownTest starts the mail worker with a fake sender and returns the sent emails and the log
+func setupShutdownTest(t *testing.T) (*[]models.MailData, *sync.Mutex, *bytes.Buffer) {
+	var logs bytes.Buffer
+	app.Logger = logging.New(&logs, false, "info")
+
+	var mu sync.Mutex
+	var sent []models.MailData
+	sendMail = func(m models.MailData) error {
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		sent = append(sent, m)
+		mu.Unlock()
+		return nil
+	}
+	t.Cleanup(func() { sendMail = sendMag })
+
+	app.MailChan = make(chan models.MailData, mailQueueSize)
+	listenForMail()
+
+	return &sent, &mu, &logs
+}
+
+func TestServer_GracefulShutdown(t *testing.T) {
+	sent, mu, logs := setupShutdownTest(t)
+
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		app.MailChan <- models.MailData{To: "guest@test.com"}
+		app.MailChan <- models.MailData{To: "staff@test.com"}
+		w.Write([]byte("done"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	srv := &server{
+		http:    &http.Server{Handler: mux},
+		timeout: 5 * time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- srv.run(ctx, ln)
+	}()
+
+	type result struct {
+		status int
+		err    error
+	}
+	inFlight := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			inFlight <- result{err: err}
+			return
+		}
+		resp.Body.Close()
+		inFlight <- result{status: resp.StatusCode}
+	}()
+
+	<-started
+	cancel()
+
+	res := <-inFlight
+	if res.err != nil || res.status != http.StatusOK {
+		t.Errorf("in-flight request was not completed: status %d, err %v", res.status, res.err)
+	}
+
+	if err = <-runErr; err != nil {
+		t.Errorf("shutdown returned an error: %v", err)
+	}
+
+	mu.Lock()
+	if len(*sent) != 2 {
+		t.Errorf("expected the 2 queued emails to be sent before exit, sent %d", len(*sent))
+	}
+	mu.Unlock()
+
+	if _, err = http.Get("http://" + addr + "/slow"); err == nil {
+		t.Error("server still accepts connections after shutdown")
+	}
+
+	out := logs.String()
+	steps := []string{"waiting for in-flight requests", "draining the mail queue", "shutdown complete"}
+	last := -1
+	for _, step := range steps {
+		i := strings.Index(out, step)
+		if i < 0 || i < last {
+			t.Fatalf("shutdown step %q missing or out of order in log:\n%s", step, out)
+		}
+		last = i
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	setupShutdownTest(t)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stuck", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &server{
+		http:    &http.Server{Handler: mux},
+		timeout: 50 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- srv.run(ctx, ln)
+	}()
+
+	go http.Get("http://" + ln.Addr().String() + "/stuck")
+	<-started
+	cancel()
+
+	select {
+	case err = <-runErr:
+		if err == nil {
+			t.Error("expected an error when requests do not finish in time")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown did not respect the timeout")
+	}
+
+	//the request that is still running can queue its mail
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Errorf("expected the mail queue to stay open, got %v", r)
+			}
+		}()
+		app.MailChan <- models.MailData{To: "late@test.com"}
+	}()
+}
+
+func TestServer_ShutdownStuckJob(t *testing.T) {
+	setupShutdownTest(t)
+
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	sched := newScheduler(job{name: "stuck", interval: time.Hour, run: func(ctx context.Context, now time.Time) {
+		close(started)
+		<-release
+	}})
+	sched.start()
+	<-started
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &server{
+		http:      &http.Server{Handler: http.NewServeMux()},
+		scheduler: sched,
+		timeout:   50 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.run(ctx, ln)
+	}()
+
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Error("expected an error when a job does not stop in time")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown waited for the job past the timeout")
+	}
+}
+
+func TestServer_ShutdownStuckMail(t *testing.T) {
+	setupShutdownTest(t)
+
+	release := make(chan struct{})
+	defer close(release)
+	sending := make(chan struct{})
+	sendMail = func(m models.MailData) error {
+		close(sending)
+		<-release
+		return nil
+	}
+	app.MailChan <- models.MailData{To: "slow@test.com"}
+	<-sending
+
+	db, err := drivers.ConnectSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SQL.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &server{
+		http:    &http.Server{Handler: http.NewServeMux()},
+		db:      db,
+		timeout: 50 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = srv.run(ctx, ln); err == nil {
+		t.Error("expected an error when the mail queue is not drained in time")
+	}
+
+	//the worker still sending can release its notification
+	if err = db.SQL.Ping(); err != nil {
+		t.Errorf("expected the database to stay open, got %v", err)
+	}
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"log"
 	"net/http"
 	"os"
 	"testing"
+
+	"github.com/ArmanurRahman/booking/internal/config"
 )
 
 func TestMain(m *testing.M) {
+	err := config.Load(&app, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	os.Exit(m.Run())
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/repository"
+)
+
+const jobPurgeTrash = "purge-trash"
+
+//trashPurger permanently removes the reservations kept in the trash longer than the retention
+type trashPurger struct {
+	App *config.AppConfig
+	DB  repository.DatabaseRepo
+}
+
+//job returns the scheduler job that empties the trash
+func (p *trashPurger) job() job {
+	return job{name: jobPurgeTrash, interval: p.App.Scheduler.Interval, run: p.purge}
+}
+
+func (p *trashPurger) purge(ctx context.Context, now time.Time) {
+	n, err := p.DB.PurgeDeletedReservations(ctx, now.Add(-p.App.Reservations.TrashRetention))
+	if err != nil {
+		p.App.Logger.Error("cannot purge deleted reservations", "job", jobPurgeTrash, "error", err)
+		return
+	}
+	if n > 0 {
+		p.App.Logger.Info("purged deleted reservations", "job", jobPurgeTrash, "count", n)
+	}
+}
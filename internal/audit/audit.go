@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+)
+
+//The actions recorded in the audit log
+const (
+	ReservationCreate  = "reservation.create"
+	ReservationUpdate  = "reservation.update"
+	ReservationStatus  = "reservation.status"
+	ReservationDelete  = "reservation.delete"
+	ReservationRestore = "reservation.restore"
+	ReservationPurge   = "reservation.purge"
+	ReservationImport  = "reservation.import"
+	ReservationDates   = "reservation.dates"
+	BookingCreate      = "booking.create"
+	BookingCancel      = "booking.cancel"
+	RestrictionCreate  = "restriction.create"
+	RoomCreate         = "room.create"
+	GuestUpdate        = "guest.update"
+	GuestMerge         = "guest.merge"
+	AccountCreate      = "account.create"
+	UserCreate         = "user.create"
+	UserUpdate         = "user.update"
+	UserPassword       = "user.password"
+)
+
+//Actions lists every action, for the filters of the audit viewer
+var Actions = []string{
+	ReservationCreate,
+	ReservationUpdate,
+	ReservationStatus,
+	ReservationDelete,
+	ReservationRestore,
+	ReservationPurge,
+	ReservationImport,
+	ReservationDates,
+	BookingCreate,
+	BookingCancel,
+	RestrictionCreate,
+	RoomCreate,
+	GuestUpdate,
+	GuestMerge,
+	AccountCreate,
+	UserCreate,
+	UserUpdate,
+	UserPassword,
+}
+
+//Actor is who made a change and from where. UserID is zero and IP empty for
+//changes made from the command line or by a background job, Name then says who made them.
+type Actor struct {
+	UserID int
+	IP     string
+	Name   string
+}
+
+type contextKey struct{}
+
+//NewContext returns a copy of ctx carrying the actor of the request
+func NewContext(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, contextKey{}, actor)
+}
+
+//ActorFrom returns the actor stored in ctx, the zero actor outside of a request
+func ActorFrom(ctx context.Context) Actor {
+	actor, _ := ctx.Value(contextKey{}).(Actor)
+	return actor
+}
+
+//Change is the value of a field before and after a change
+type Change struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+//Diff returns the fields that differ between before and after as a JSON object of
+//field to change. A nil before is a creation and a nil after a deletion.
+func Diff(before, after map[string]interface{}) string {
+	fields := make(map[string]bool)
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+
+	changes := make(map[string]Change)
+	for k := range fields {
+		b, a := before[k], after[k]
+		if reflect.DeepEqual(b, a) {
+			continue
+		}
+		changes[k] = Change{Before: b, After: a}
+	}
+
+	out, err := json.Marshal(changes)
+	if err != nil {
+		return "{}"
+	}
+	return string(out)
+}
+
+//Parse decodes a diff made by Diff, it returns no changes for an invalid diff
+func Parse(diff string) map[string]Change {
+	changes := make(map[string]Change)
+	if err := json.Unmarshal([]byte(diff), &changes); err != nil {
+		return map[string]Change{}
+	}
+	return changes
+}
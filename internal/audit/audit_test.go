@@ -0,0 +1,49 @@
+package audit
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	var tests = []struct {
+		name   string
+		before map[string]interface{}
+		after  map[string]interface{}
+		want   string
+	}{
+		{"update", map[string]interface{}{"email": "a@b.com", "phone": "1"}, map[string]interface{}{"email": "c@d.com", "phone": "1"}, `{"email":{"before":"a@b.com","after":"c@d.com"}}`},
+		{"create", nil, map[string]interface{}{"room_name": "Suite"}, `{"room_name":{"after":"Suite"}}`},
+		{"delete", map[string]interface{}{"room_name": "Suite"}, nil, `{"room_name":{"before":"Suite"}}`},
+		{"unchanged", map[string]interface{}{"phone": "1"}, map[string]interface{}{"phone": "1"}, `{}`},
+		{"zero values", map[string]interface{}{"access_level": 0}, map[string]interface{}{"access_level": 3}, `{"access_level":{"before":0,"after":3}}`},
+	}
+
+	for _, e := range tests {
+		if got := Diff(e.before, e.after); got != e.want {
+			t.Errorf("for %s, got %s, wanted %s", e.name, got, e.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	changes := Parse(`{"email":{"before":"a@b.com","after":"c@d.com"}}`)
+	if len(changes) != 1 || changes["email"].Before != "a@b.com" || changes["email"].After != "c@d.com" {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+
+	if changes := Parse("not json"); len(changes) != 0 {
+		t.Errorf("expected no changes for an invalid diff, got %+v", changes)
+	}
+}
+
+func TestActorFrom(t *testing.T) {
+	if actor := ActorFrom(context.Background()); actor.UserID != 0 || actor.IP != "" {
+		t.Errorf("expected the zero actor outside of a request, got %+v", actor)
+	}
+
+	ctx := NewContext(context.Background(), Actor{UserID: 7, IP: "10.0.0.1"})
+	if actor := ActorFrom(ctx); actor.UserID != 7 || actor.IP != "10.0.0.1" {
+		t.Errorf("unexpected actor %+v", actor)
+	}
+}
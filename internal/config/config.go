@@ -1,19 +1,102 @@
 package config
 
 import (
+	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
+	"time"
 
+	"github.com/ArmanurRahman/booking/internal/events"
 	"github.com/ArmanurRahman/booking/internal/models"
 	"github.com/alexedwards/scs/v2"
 )
 
 type AppConfig struct {
-	UseCache      bool
-	TemplateCache map[string]*template.Template
-	IsProduction  bool
-	Session       *scs.SessionManager
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	MailChan      chan models.MailData
+	Port            int
+	PublicURL       string
+	ShutdownTimeout time.Duration
+	HealthTimeout   time.Duration
+	UseCache        bool
+	TemplateCache   map[string]*template.Template
+	IsProduction    bool
+	SessionLifetime time.Duration
+	Session         *scs.SessionManager
+	Logger          *slog.Logger
+	LogLevel        string
+	MailChan        chan models.MailData
+	DB              DBConfig
+	Mail            MailConfig
+	Scheduler       SchedulerConfig
+	Reservations    ReservationConfig
+	Notifications   NotificationConfig
+	Tracing         TracingConfig
+	Events          *events.Bus
+	ConfigFile      string
+	PrintConfig     bool
+}
+
+//DBConfig holds the database connection settings
+type DBConfig struct {
+	Driver       string
+	Path         string
+	Host         string
+	Port         int
+	Name         string
+	User         string
+	Password     string
+	SSLMode      string
+	QueryTimeout time.Duration
+	//ExportTimeout bounds the queries streaming an export, which read many more rows
+	ExportTimeout time.Duration
+	Migrate       bool
+}
+
+//DSN returns the connection string for the database
+func (d DBConfig) DSN() string {
+	dsn := fmt.Sprintf("host=%s port=%d dbname=%s user=%s sslmode=%s", d.Host, d.Port, d.Name, d.User, d.SSLMode)
+	if d.Password != "" {
+		dsn = fmt.Sprintf("%s password=%s", dsn, d.Password)
+	}
+	return dsn
+}
+
+//MailConfig holds the SMTP server settings
+type MailConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+//SchedulerConfig holds the settings of the background guest notification jobs
+type SchedulerConfig struct {
+	Enabled            bool
+	Interval           time.Duration
+	ReminderDaysBefore int
+	FollowUpDaysAfter  int
+}
+
+//ReservationConfig holds how long deleted reservations stay in the trash before they are purged
+type ReservationConfig struct {
+	TrashRetention time.Duration
+}
+
+//NotificationConfig holds the channels used to tell staff about new reservations
+type NotificationConfig struct {
+	StaffEmails []string
+	WebhookURL  string
+	InApp       bool
+}
+
+//TracingConfig holds where the OpenTelemetry spans are exported
+type TracingConfig struct {
+	Exporter string
+	Endpoint string
+	Insecure bool
+}
+
+//Addr returns the address the web server listens on
+func (a *AppConfig) Addr() string {
+	return fmt.Sprintf(":%d", a.Port)
 }
@@ -0,0 +1,375 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+//EnvPrefix is prepended to a setting key to get its environment variable,
+//db.password is read from BOOKING_DB_PASSWORD
+const EnvPrefix = "BOOKING_"
+
+//setting is a single configuration value that can come from a flag,
+//an environment variable or the config file
+type setting struct {
+	key    string
+	def    string
+	usage  string
+	secret bool
+	isBool bool
+	set    func(string) error
+	get    func() string
+}
+
+//rawFlag keeps the raw text of a flag so it can be applied after the
+//config file and the environment have been read
+type rawFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *rawFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *rawFlag) Set(v string) error {
+	f.value = v
+	return nil
+}
+
+func (f *rawFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+//settings binds every configurable value to its field in the app config
+func settings(a *AppConfig) []setting {
+	return []setting{
+		intSetting("http.port", "8080", "port the web server listens on", &a.Port),
+		stringSetting("http.public_url", "http://localhost:8080", "address of the site in the links of emails", &a.PublicURL),
+		durationSetting("http.shutdown_timeout", "30s", "how long to wait for requests and queued emails on shutdown", &a.ShutdownTimeout),
+		durationSetting("health.timeout", "2s", "timeout of each readiness check", &a.HealthTimeout),
+		stringSetting("log.level", "info", "minimum log level: debug, info, warn or error", &a.LogLevel),
+		boolSetting("production", "false", "run in production mode", &a.IsProduction),
+		boolSetting("cache", "false", "use the template cache", &a.UseCache),
+		durationSetting("session.lifetime", "24h", "lifetime of a login session", &a.SessionLifetime),
+
+		stringSetting("db.driver", "postgres", "database driver, postgres or sqlite", &a.DB.Driver),
+		stringSetting("db.path", "booking.db", "sqlite database file", &a.DB.Path),
+		stringSetting("db.host", "localhost", "database host", &a.DB.Host),
+		intSetting("db.port", "5432", "database port", &a.DB.Port),
+		stringSetting("db.name", "booking", "database name", &a.DB.Name),
+		stringSetting("db.user", "postgres", "database user", &a.DB.User),
+		secret(stringSetting("db.password", "", "database password", &a.DB.Password)),
+		stringSetting("db.sslmode", "disable", "database ssl mode", &a.DB.SSLMode),
+		durationSetting("db.query_timeout", "3s", "longest a single query may run before it is cancelled", &a.DB.QueryTimeout),
+		durationSetting("db.export_timeout", "5m", "longest the query streaming an export may run", &a.DB.ExportTimeout),
+		boolSetting("db.migrate", "true", "apply the pending migrations on start", &a.DB.Migrate),
+
+		stringSetting("mail.host", "localhost", "SMTP host", &a.Mail.Host),
+		intSetting("mail.port", "1025", "SMTP port", &a.Mail.Port),
+		stringSetting("mail.username", "", "SMTP username", &a.Mail.Username),
+		secret(stringSetting("mail.password", "", "SMTP password", &a.Mail.Password)),
+		stringSetting("mail.from", "mubeen@test.com", "sender address of outgoing emails", &a.Mail.From),
+
+		boolSetting("scheduler.enabled", "true", "run the guest notification jobs", &a.Scheduler.Enabled),
+		durationSetting("scheduler.interval", "1h", "how often the guest notification jobs run", &a.Scheduler.Interval),
+		intSetting("scheduler.reminder_days", "3", "days before arrival the reminder is sent", &a.Scheduler.ReminderDaysBefore),
+		intSetting("scheduler.followup_days", "1", "days after departure the thank-you email is sent", &a.Scheduler.FollowUpDaysAfter),
+
+		durationSetting("reservations.trash_retention", "720h", "how long deleted reservations can be restored before they are purged", &a.Reservations.TrashRetention),
+
+		listSetting("notifications.staff_emails", "", "comma separated staff addresses notified of new reservations", &a.Notifications.StaffEmails),
+		secret(stringSetting("notifications.webhook_url", "", "chat webhook notified of new reservations", &a.Notifications.WebhookURL)),
+		boolSetting("notifications.in_app", "true", "show new reservations in the admin notification bell", &a.Notifications.InApp),
+
+		stringSetting("tracing.exporter", "none", "where spans are exported: none, stdout or otlp", &a.Tracing.Exporter),
+		stringSetting("tracing.endpoint", "", "OTLP/HTTP collector host:port, defaults to OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318", &a.Tracing.Endpoint),
+		boolSetting("tracing.insecure", "false", "send spans to the OTLP collector over plain HTTP", &a.Tracing.Insecure),
+	}
+}
+
+//Load fills the app config from, in order of precedence, command-line flags,
+//environment variables, an optional YAML config file and the defaults
+func Load(a *AppConfig, args []string) error {
+	return LoadFlags(a, flag.NewFlagSet("booking", flag.ContinueOnError), args)
+}
+
+//LoadFlags is Load for commands that parse flags of their own, it adds the
+//config flags to fs before parsing args
+func LoadFlags(a *AppConfig, fs *flag.FlagSet, args []string) error {
+	all := settings(a)
+
+	fs.StringVar(&a.ConfigFile, "config", os.Getenv(EnvPrefix+"CONFIG"), "path to a YAML config file")
+	fs.BoolVar(&a.PrintConfig, "print-config", false, "print the effective config and exit")
+
+	flags := make(map[string]*rawFlag)
+	for _, s := range all {
+		flags[s.key] = &rawFlag{value: s.def, isBool: s.isBool}
+		fs.Var(flags[s.key], s.key, s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	values := make(map[string]string)
+	for _, s := range all {
+		values[s.key] = s.def
+	}
+
+	if a.ConfigFile != "" {
+		fromFile, err := readFile(a.ConfigFile)
+		if err != nil {
+			return err
+		}
+		for k, v := range fromFile {
+			if _, ok := values[k]; !ok {
+				return fmt.Errorf("%s: unknown setting %s", a.ConfigFile, k)
+			}
+			values[k] = v
+		}
+	}
+
+	for _, s := range all {
+		if v, ok := os.LookupEnv(envName(s.key)); ok {
+			values[s.key] = v
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if p, ok := flags[f.Name]; ok {
+			values[f.Name] = p.value
+		}
+	})
+
+	for _, s := range all {
+		if err := s.set(values[s.key]); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", values[s.key], s.key, err)
+		}
+	}
+	return nil
+}
+
+//Validate checks that the required settings are present and sensible
+func (a *AppConfig) Validate() error {
+	var problems []string
+
+	if a.Port <= 0 || a.Port > 65535 {
+		problems = append(problems, "http.port must be between 1 and 65535")
+	}
+	if a.ShutdownTimeout <= 0 {
+		problems = append(problems, "http.shutdown_timeout must be positive")
+	}
+	if a.HealthTimeout <= 0 {
+		problems = append(problems, "health.timeout must be positive")
+	}
+	if a.SessionLifetime <= 0 {
+		problems = append(problems, "session.lifetime must be positive")
+	}
+	if a.DB.QueryTimeout <= 0 {
+		problems = append(problems, "db.query_timeout must be positive")
+	}
+	if a.DB.ExportTimeout <= 0 {
+		problems = append(problems, "db.export_timeout must be positive")
+	}
+	switch a.DB.Driver {
+	case "postgres":
+		if a.DB.Host == "" || a.DB.Name == "" || a.DB.User == "" {
+			problems = append(problems, "db.host, db.name and db.user are required")
+		}
+		if a.IsProduction && a.DB.Password == "" {
+			problems = append(problems, "db.password is required in production")
+		}
+	case "sqlite":
+		if a.DB.Path == "" {
+			problems = append(problems, "db.path is required")
+		}
+	default:
+		problems = append(problems, "db.driver must be postgres or sqlite")
+	}
+	if a.Mail.Host == "" || a.Mail.Port <= 0 {
+		problems = append(problems, "mail.host and mail.port are required")
+	}
+	if a.Mail.From == "" {
+		problems = append(problems, "mail.from is required")
+	}
+	if a.Scheduler.Enabled && a.Scheduler.Interval <= 0 {
+		problems = append(problems, "scheduler.interval must be positive")
+	}
+	if a.Reservations.TrashRetention <= 0 {
+		problems = append(problems, "reservations.trash_retention must be positive")
+	}
+	switch a.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		problems = append(problems, "tracing.exporter must be none, stdout or otlp")
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+//Print writes the effective config with the secrets redacted
+func Print(w io.Writer, a *AppConfig) {
+	all := settings(a)
+	sort.Slice(all, func(i, j int) bool { return all[i].key < all[j].key })
+
+	if a.ConfigFile != "" {
+		fmt.Fprintf(w, "# config file: %s\n", a.ConfigFile)
+	}
+	for _, s := range all {
+		v := s.get()
+		if s.secret && v != "" {
+			v = "******"
+		}
+		fmt.Fprintf(w, "%s = %s\n", s.key, v)
+	}
+}
+
+//envName returns the environment variable of a setting key
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+//readFile reads a YAML config file into flat, dot separated keys
+func readFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]interface{}
+	if err = yaml.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	values := make(map[string]string)
+	flatten("", tree, values)
+	return values, nil
+}
+
+func flatten(prefix string, tree map[string]interface{}, values map[string]string) {
+	for k, v := range tree {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flatten(key, v, values)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+func secret(s setting) setting {
+	s.secret = true
+	return s
+}
+
+func stringSetting(key, def, usage string, p *string) setting {
+	return setting{
+		key:   key,
+		def:   def,
+		usage: usage,
+		set: func(v string) error {
+			*p = v
+			return nil
+		},
+		get: func() string { return *p },
+	}
+}
+
+func intSetting(key, def, usage string, p *int) setting {
+	return setting{
+		key:   key,
+		def:   def,
+		usage: usage,
+		set: func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return err
+			}
+			*p = n
+			return nil
+		},
+		get: func() string { return strconv.Itoa(*p) },
+	}
+}
+
+func boolSetting(key, def, usage string, p *bool) setting {
+	return setting{
+		key:    key,
+		def:    def,
+		usage:  usage,
+		isBool: true,
+		set: func(v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			*p = b
+			return nil
+		},
+		get: func() string { return strconv.FormatBool(*p) },
+	}
+}
+
+func durationSetting(key, def, usage string, p *time.Duration) setting {
+	return setting{
+		key:   key,
+		def:   def,
+		usage: usage,
+		set: func(v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			*p = d
+			return nil
+		},
+		get: func() string { return p.String() },
+	}
+}
+
+func listSetting(key, def, usage string, p *[]string) setting {
+	return setting{
+		key:   key,
+		def:   def,
+		usage: usage,
+		set: func(v string) error {
+			var items []string
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			*p = items
+			return nil
+		},
+		get: func() string { return strings.Join(*p, ",") },
+	}
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad_Defaults(t *testing.T) {
+	var a AppConfig
+
+	err := Load(&a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Port != 8080 || a.Addr() != ":8080" {
+		t.Errorf("expected default port 8080, got %d", a.Port)
+	}
+	if a.SessionLifetime != 24*time.Hour {
+		t.Errorf("expected default session lifetime of 24h, got %s", a.SessionLifetime)
+	}
+	if a.IsProduction || a.UseCache {
+		t.Error("production and cache should be off by default")
+	}
+	if a.Mail.Host != "localhost" || a.Mail.Port != 1025 {
+		t.Errorf("unexpected default mail server %s:%d", a.Mail.Host, a.Mail.Port)
+	}
+	if a.Reservations.TrashRetention != 30*24*time.Hour {
+		t.Errorf("expected deleted reservations to be kept 30 days, got %s", a.Reservations.TrashRetention)
+	}
+	if err = a.Validate(); err != nil {
+		t.Errorf("defaults should be valid, got %s", err)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "booking.yml")
+	yml := `
+http:
+  port: 9000
+db:
+  host: db.internal
+  name: from_file
+  password: file-secret
+notifications:
+  staff_emails:
+    - front@test.com
+    - owner@test.com
+`
+	if err = ioutil.WriteFile(file, []byte(yml), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("BOOKING_DB_NAME", "from_env")
+	os.Setenv("BOOKING_HTTP_PORT", "9100")
+	defer os.Unsetenv("BOOKING_DB_NAME")
+	defer os.Unsetenv("BOOKING_HTTP_PORT")
+
+	var a AppConfig
+	err = Load(&a, []string{"-config", file, "-http.port", "9200"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Port != 9200 {
+		t.Errorf("flag should win over env and file, got port %d", a.Port)
+	}
+	if a.DB.Name != "from_env" {
+		t.Errorf("env should win over file, got db name %s", a.DB.Name)
+	}
+	if a.DB.Host != "db.internal" || a.DB.Password != "file-secret" {
+		t.Errorf("file values were not applied, got %+v", a.DB)
+	}
+	if a.DB.User != "postgres" {
+		t.Errorf("default should be kept when nothing overrides it, got %s", a.DB.User)
+	}
+	if len(a.Notifications.StaffEmails) != 2 || a.Notifications.StaffEmails[1] != "owner@test.com" {
+		t.Errorf("unexpected staff emails %v", a.Notifications.StaffEmails)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	var a AppConfig
+
+	if err := Load(&a, []string{"-http.port", "abc"}); err == nil {
+		t.Error("expected an error for an invalid port")
+	}
+	if err := Load(&a, []string{"-config", "./does-not-exist.yml"}); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+	if err := Load(&a, []string{"-unknown"}); err == nil {
+		t.Error("expected an error for an unknown flag")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var a AppConfig
+	if err := Load(&a, []string{"-production", "-db.host", "", "-tracing.exporter", "jaeger", "-reservations.trash_retention", "0s"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := a.Validate()
+	if err == nil {
+		t.Fatal("expected validation to fail")
+	}
+	if !strings.Contains(err.Error(), "db.host") || !strings.Contains(err.Error(), "db.password") || !strings.Contains(err.Error(), "tracing.exporter") ||
+		!strings.Contains(err.Error(), "reservations.trash_retention") {
+		t.Errorf("validation error does not name the missing settings: %s", err)
+	}
+}
+
+func TestValidate_SQLite(t *testing.T) {
+	var a AppConfig
+	if err := Load(&a, []string{"-production", "-db.driver", "sqlite", "-db.host", ""}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Validate(); err != nil {
+		t.Errorf("sqlite needs neither db.host nor db.password: %s", err)
+	}
+
+	a.DB.Path = ""
+	if err := a.Validate(); err == nil || !strings.Contains(err.Error(), "db.path") {
+		t.Errorf("expected db.path to be required, got %v", err)
+	}
+
+	a.DB.Driver = "mysql"
+	if err := a.Validate(); err == nil || !strings.Contains(err.Error(), "db.driver") {
+		t.Errorf("expected an unknown driver to be rejected, got %v", err)
+	}
+}
+
+func TestPrint_RedactsSecrets(t *testing.T) {
+	var a AppConfig
+	if err := Load(&a, []string{"-db.password", "hunter2", "-mail.host", "smtp.test.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	Print(&buf, &a)
+	out := buf.String()
+
+	if strings.Contains(out, "hunter2") {
+		t.Error("printed config contains the database password")
+	}
+	if !strings.Contains(out, "db.password = ******") {
+		t.Error("db.password is not shown as redacted")
+	}
+	if !strings.Contains(out, "mail.host = smtp.test.com") {
+		t.Error("printed config is missing mail.host")
+	}
+	if !strings.Contains(out, "mail.password = \n") {
+		t.Error("empty secrets should be shown as empty")
+	}
+}
+
+func TestDBConfig_DSN(t *testing.T) {
+	d := DBConfig{Host: "localhost", Port: 5432, Name: "booking", User: "postgres", SSLMode: "disable"}
+
+	if d.DSN() != "host=localhost port=5432 dbname=booking user=postgres sslmode=disable" {
+		t.Errorf("unexpected dsn %s", d.DSN())
+	}
+
+	d.Password = "secret"
+	if !strings.HasSuffix(d.DSN(), " password=secret") {
+		t.Errorf("password missing from dsn %s", d.DSN())
+	}
+}
@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//The tags staff put on guests most, any other tag is allowed too
+const (
+	TagVIP       = "vip"
+	TagDoNotRent = "do-not-rent"
+)
+
+//Tags lists the tags offered on the guest page
+var Tags = []string{TagVIP, TagDoNotRent}
+
+//minPhoneDigits is the fewest digits a phone number needs to match guests by,
+//shorter numbers are extensions or typos
+const minPhoneDigits = 7
+
+//EmailKey is the email a guest is matched by, trimmed and in lower case
+func EmailKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+//PhoneKey is the phone number a guest is matched by, its digits only. It is
+//empty for a number too short to tell guests apart.
+func PhoneKey(phone string) string {
+	key := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	if len(key) < minPhoneDigits {
+		return ""
+	}
+	return key
+}
+
+//ParseTags returns the tags of a comma separated list in lower case, with the
+//spaces in a tag replaced by dashes, sorted and without duplicates
+func ParseTags(s string) []string {
+	seen := make(map[string]bool)
+	tags := []string{}
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.Join(strings.FieldsFunc(strings.ToLower(tag), unicode.IsSpace), "-")
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEmailKey(t *testing.T) {
+	if key := EmailKey("  John.Smith@Example.COM "); key != "john.smith@example.com" {
+		t.Errorf("unexpected key %q", key)
+	}
+}
+
+func TestPhoneKey(t *testing.T) {
+	var tests = []struct {
+		phone string
+		key   string
+	}{
+		{"+1 (555) 010-0100", "15550100100"},
+		{"555.0100.12", "555010012"},
+		{"555-01", ""},
+		{"", ""},
+	}
+
+	for _, e := range tests {
+		if key := PhoneKey(e.phone); key != e.key {
+			t.Errorf("for %q, expected %q but got %q", e.phone, e.key, key)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags := ParseTags(" VIP, do not  rent,,vip,Late Arrival ")
+	if strings.Join(tags, ",") != "do-not-rent,late-arrival,vip" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	if tags := ParseTags(""); tags == nil || len(tags) != 0 {
+		t.Errorf("expected no tags, got %#v", tags)
+	}
+}
@@ -0,0 +1,103 @@
+package domain
+
+import "sort"
+
+//MaxPartySize is the most guests a search or a reservation is made for
+const MaxPartySize = 20
+
+//MaxCombinedRooms is the most rooms a suggested combination is made of
+const MaxCombinedRooms = 3
+
+//MaxSuggestions is the most combinations suggested for one search
+const MaxSuggestions = 5
+
+//Combinations returns the ways to fit a party of adults and children in at most MaxCombinedRooms
+//of the rooms that sleep the capacities, as indexes into capacities. A combination holds no
+//room the party fits without, and no more rooms than adults, as SplitParty puts an adult in
+//each room. The fewest rooms come first, then the fewest spare beds, and no more than
+//MaxSuggestions are returned.
+func Combinations(capacities []int, adults, children int) [][]int {
+	party := adults + children
+	maxRooms := min(MaxCombinedRooms, adults)
+
+	var found [][]int
+	var combine func(start int, picked []int, beds int)
+	combine = func(start int, picked []int, beds int) {
+		if beds >= party {
+			//a combination without its smallest room must not fit the party
+			smallest := capacities[picked[0]]
+			for _, i := range picked {
+				if capacities[i] < smallest {
+					smallest = capacities[i]
+				}
+			}
+			if beds-smallest < party {
+				found = append(found, append([]int(nil), picked...))
+			}
+			return
+		}
+		if len(picked) == maxRooms {
+			return
+		}
+		for i := start; i < len(capacities); i++ {
+			if capacities[i] > 0 {
+				combine(i+1, append(picked, i), beds+capacities[i])
+			}
+		}
+	}
+	if adults > 0 {
+		combine(0, nil, 0)
+	}
+
+	spare := func(c []int) int {
+		beds := 0
+		for _, i := range c {
+			beds += capacities[i]
+		}
+		return beds - party
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if len(found[i]) != len(found[j]) {
+			return len(found[i]) < len(found[j])
+		}
+		return spare(found[i]) < spare(found[j])
+	})
+
+	if len(found) > MaxSuggestions {
+		found = found[:MaxSuggestions]
+	}
+	return found
+}
+
+//SplitParty spreads a party over rooms that sleep the capacities, one adult in each room
+//first and then filling the rooms in order, adults before children. It returns false when
+//the party does not fit or there are fewer adults than rooms.
+func SplitParty(capacities []int, adults, children int) ([]int, []int, bool) {
+	if adults < len(capacities) {
+		return nil, nil, false
+	}
+
+	roomAdults := make([]int, len(capacities))
+	roomChildren := make([]int, len(capacities))
+	for i, beds := range capacities {
+		if beds < 1 {
+			return nil, nil, false
+		}
+		roomAdults[i] = 1
+	}
+	adults -= len(capacities)
+
+	for i, beds := range capacities {
+		n := min(adults, beds-roomAdults[i])
+		roomAdults[i] += n
+		adults -= n
+
+		n = min(children, beds-roomAdults[i])
+		roomChildren[i] = n
+		children -= n
+	}
+	if adults > 0 || children > 0 {
+		return nil, nil, false
+	}
+	return roomAdults, roomChildren, true
+}
@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCombinations(t *testing.T) {
+	var tests = []struct {
+		name       string
+		capacities []int
+		adults     int
+		children   int
+		expected   string
+	}{
+		{"single-room", []int{2, 4}, 3, 0, "[[1]]"},
+		{"two-rooms", []int{2, 4, 2}, 5, 0, "[[0 1] [1 2]]"},
+		{"fewest-spare-beds", []int{4, 2, 3}, 6, 0, "[[0 1] [0 2]]"},
+		{"three-rooms", []int{2, 2, 2}, 6, 0, "[[0 1 2]]"},
+		{"too-many-rooms", []int{1, 1, 1, 1}, 4, 0, "[]"},
+		{"too-small", []int{2, 4}, 7, 0, "[]"},
+		{"no-party", []int{2}, 0, 0, "[]"},
+		{"closed-room", []int{0, 2, 2}, 4, 0, "[[1 2]]"},
+		{"most-suggestions", []int{1, 1, 1, 1, 1, 1}, 2, 0, "[[0 1] [0 2] [0 3] [0 4] [0 5]]"},
+		{"adult-in-each-room", []int{2, 2, 2}, 2, 2, "[[0 1] [0 2] [1 2]]"},
+		{"too-few-adults", []int{2, 2, 2}, 1, 3, "[]"},
+		{"no-adults", []int{4}, 0, 2, "[]"},
+	}
+
+	for _, e := range tests {
+		found := Combinations(e.capacities, e.adults, e.children)
+		if got := fmt.Sprint(found); got != e.expected {
+			t.Errorf("for %s, expected %s but got %s", e.name, e.expected, got)
+		}
+		for _, c := range found {
+			capacities := make([]int, len(c))
+			for i, n := range c {
+				capacities[i] = e.capacities[n]
+			}
+			if _, _, ok := SplitParty(capacities, e.adults, e.children); !ok {
+				t.Errorf("for %s, cannot split the party over %v", e.name, c)
+			}
+		}
+	}
+}
+
+func TestSplitParty(t *testing.T) {
+	var tests = []struct {
+		name       string
+		capacities []int
+		adults     int
+		children   int
+		expected   string
+		ok         bool
+	}{
+		{"one-room", []int{4}, 2, 2, "[2] [2]", true},
+		{"adult-in-each-room", []int{4, 2}, 2, 3, "[1 1] [3 0]", true},
+		{"adults-first", []int{2, 4}, 4, 2, "[2 2] [0 2]", true},
+		{"too-many-children", []int{2, 2}, 2, 3, "", false},
+		{"too-few-adults", []int{2, 2}, 1, 2, "", false},
+		{"closed-room", []int{0, 4}, 2, 0, "", false},
+	}
+
+	for _, e := range tests {
+		adults, children, ok := SplitParty(e.capacities, e.adults, e.children)
+		if ok != e.ok {
+			t.Errorf("for %s, expected ok to be %v", e.name, e.ok)
+			continue
+		}
+		if got := fmt.Sprint(adults, " ", children); ok && got != e.expected {
+			t.Errorf("for %s, expected %s but got %s", e.name, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+//ErrRoomUnavailable is returned when the room of a reservation is taken on its dates
+var ErrRoomUnavailable = errors.New("room is not available on these dates")
+
+//Overlaps reports whether two stays share a night. A stay holds the nights from its start
+//up to, not including, its end, so a guest may arrive on the day another one leaves.
+func Overlaps(start, end, otherStart, otherEnd time.Time) bool {
+	return start.Before(otherEnd) && otherStart.Before(end)
+}
@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOverlaps(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2030, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	var tests = []struct {
+		name     string
+		start    time.Time
+		end      time.Time
+		overlaps bool
+	}{
+		{"before", day(1), day(5), false},
+		{"ending on the arrival day", day(7), day(10), false},
+		{"overlapping the start", day(9), day(11), true},
+		{"inside", day(10), day(11), true},
+		{"same", day(10), day(12), true},
+		{"around", day(8), day(14), true},
+		{"overlapping the end", day(11), day(13), true},
+		{"starting on the departure day", day(12), day(14), false},
+		{"after", day(13), day(15), false},
+	}
+
+	for _, e := range tests {
+		if got := Overlaps(e.start, e.end, day(10), day(12)); got != e.overlaps {
+			t.Errorf("%s: expected %v, got %v", e.name, e.overlaps, got)
+		}
+		if got := Overlaps(day(10), day(12), e.start, e.end); got != e.overlaps {
+			t.Errorf("%s swapped: expected %v, got %v", e.name, e.overlaps, got)
+		}
+	}
+}
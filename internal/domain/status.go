@@ -0,0 +1,109 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//Status is where a reservation is in its lifecycle
+type Status string
+
+//The statuses of a reservation. A reservation starts pending, staff confirm it,
+//and it ends checked out, cancelled or as a no-show.
+const (
+	Pending    Status = "pending"
+	Confirmed  Status = "confirmed"
+	CheckedIn  Status = "checked-in"
+	CheckedOut Status = "checked-out"
+	Cancelled  Status = "cancelled"
+	NoShow     Status = "no-show"
+)
+
+//Statuses lists every status in lifecycle order
+var Statuses = []Status{Pending, Confirmed, CheckedIn, CheckedOut, Cancelled, NoShow}
+
+//transitions are the statuses each status may move to
+var transitions = map[Status][]Status{
+	Pending:    {Confirmed, Cancelled},
+	Confirmed:  {CheckedIn, Cancelled, NoShow},
+	CheckedIn:  {CheckedOut},
+	CheckedOut: nil,
+	Cancelled:  nil,
+	NoShow:     nil,
+}
+
+//ErrInvalidTransition is returned for a status change the lifecycle does not allow
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+//ParseStatus returns the status named s
+func ParseStatus(s string) (Status, error) {
+	status := Status(strings.ToLower(strings.TrimSpace(s)))
+	if !status.Valid() {
+		return "", fmt.Errorf("unknown reservation status %q", s)
+	}
+	return status, nil
+}
+
+//ParseStatuses returns the statuses of a comma separated list, an empty list gives none
+func ParseStatuses(s string) ([]Status, error) {
+	var statuses []Status
+	for _, name := range strings.Split(s, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		status, err := ParseStatus(name)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+//Valid reports whether s is a known status
+func (s Status) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+//Label is the status as shown to staff
+func (s Status) Label() string {
+	switch s {
+	case CheckedIn:
+		return "Checked in"
+	case CheckedOut:
+		return "Checked out"
+	case NoShow:
+		return "No-show"
+	case "":
+		return ""
+	}
+	return strings.ToUpper(string(s[:1])) + string(s[1:])
+}
+
+//Next returns the statuses s may move to
+func (s Status) Next() []Status {
+	return transitions[s]
+}
+
+//Final reports whether the lifecycle of a reservation in s is over
+func (s Status) Final() bool {
+	return s.Valid() && len(transitions[s]) == 0
+}
+
+//Stays reports whether a guest with a reservation in s is expected or has stayed,
+//that is the reservation was neither cancelled nor missed
+func (s Status) Stays() bool {
+	return s != Cancelled && s != NoShow
+}
+
+//Transition checks that a reservation may move from one status to another
+func Transition(from, to Status) error {
+	for _, next := range transitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, from, to)
+}
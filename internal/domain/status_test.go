@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransition(t *testing.T) {
+	var tests = []struct {
+		from    Status
+		to      Status
+		allowed bool
+	}{
+		{Pending, Confirmed, true},
+		{Pending, Cancelled, true},
+		{Pending, CheckedIn, false},
+		{Confirmed, CheckedIn, true},
+		{Confirmed, NoShow, true},
+		{Confirmed, Cancelled, true},
+		{Confirmed, Pending, false},
+		{CheckedIn, CheckedOut, true},
+		{CheckedIn, Cancelled, false},
+		{CheckedOut, CheckedIn, false},
+		{Cancelled, Confirmed, false},
+		{NoShow, CheckedIn, false},
+		{Pending, Pending, false},
+		{"archived", Confirmed, false},
+	}
+
+	for _, e := range tests {
+		err := Transition(e.from, e.to)
+		if e.allowed && err != nil {
+			t.Errorf("%s to %s should be allowed, got %v", e.from, e.to, err)
+		}
+		if !e.allowed && !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("%s to %s should be refused, got %v", e.from, e.to, err)
+		}
+	}
+}
+
+func TestParseStatuses(t *testing.T) {
+	statuses, err := ParseStatuses("pending, Checked-In,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || statuses[0] != Pending || statuses[1] != CheckedIn {
+		t.Errorf("unexpected statuses %v", statuses)
+	}
+
+	if statuses, err := ParseStatuses(""); err != nil || len(statuses) != 0 {
+		t.Errorf("expected no statuses for an empty list, got %v (%v)", statuses, err)
+	}
+	if _, err := ParseStatuses("pending,processed"); err == nil {
+		t.Error("expected an error for an unknown status")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	for _, s := range Statuses {
+		if !s.Valid() {
+			t.Errorf("%s is not valid", s)
+		}
+		if s.Label() == "" {
+			t.Errorf("%s has no label", s)
+		}
+	}
+
+	if Status("processed").Valid() {
+		t.Error("processed should not be a status")
+	}
+	if Pending.Final() || !CheckedOut.Final() || !NoShow.Final() {
+		t.Error("only the end of the lifecycle should be final")
+	}
+	if CheckedIn.Label() != "Checked in" || Confirmed.Label() != "Confirmed" {
+		t.Errorf("unexpected labels %q and %q", CheckedIn.Label(), Confirmed.Label())
+	}
+	if Cancelled.Stays() || NoShow.Stays() || !CheckedOut.Stays() {
+		t.Error("only cancelled and missed reservations have no stay")
+	}
+}
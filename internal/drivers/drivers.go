@@ -2,16 +2,19 @@ package drivers
 
 import (
 	"database/sql"
+	"fmt"
 	"time"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	_ "modernc.org/sqlite"
 )
 
-//DB holds the database connection pool
+//DB holds the database connection pool and the driver it was opened with
 type DB struct {
-	SQL *sql.DB
+	SQL    *sql.DB
+	Driver string
 }
 
 var con = &DB{}
@@ -33,6 +36,7 @@ func ConnectSQL(dsn string) (*DB, error) {
 	d.SetConnMaxLifetime(maxDbLifeTime)
 
 	con.SQL = d
+	con.Driver = "postgres"
 
 	err = testDB(d)
 
@@ -43,6 +47,27 @@ func ConnectSQL(dsn string) (*DB, error) {
 	return con, nil
 }
 
+//ConnectSQLite opens the SQLite database file at path, creating it if needed.
+//SQLite allows one writer at a time, so the pool holds a single connection
+//and foreign keys, which SQLite leaves off by default, are turned on.
+func ConnectSQLite(path string) (*DB, error) {
+	d, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite", path))
+	if err != nil {
+		return nil, err
+	}
+
+	d.SetMaxOpenConns(1)
+	d.SetConnMaxLifetime(0)
+
+	err = testDB(d)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+
+	return &DB{SQL: d, Driver: "sqlite"}, nil
+}
+
 //testDB tries to ping database
 func testDB(d *sql.DB) error {
 	err := d.Ping()
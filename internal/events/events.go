@@ -0,0 +1,113 @@
+package events
+
+import "sync"
+
+//Event types published by the reservation handlers
+const (
+	ReservationCreated       = "reservation.created"
+	ReservationUpdated       = "reservation.updated"
+	ReservationStatusChanged = "reservation.status_changed"
+	ReservationDeleted       = "reservation.deleted"
+	ReservationRestored      = "reservation.restored"
+)
+
+//subscriberBuffer is how many events a subscriber may lag behind before it is dropped
+const subscriberBuffer = 16
+
+//Event describes a change to a reservation
+type Event struct {
+	Type          string `json:"type"`
+	ReservationID int    `json:"reservation_id"`
+	NewCount      int    `json:"new_count"`
+}
+
+//Bus is an in-process publish/subscribe hub that fans events out to every subscriber
+type Bus struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+//Subscription receives the events published on a bus on C.
+//C is closed when the subscription is closed or dropped for falling behind.
+type Subscription struct {
+	C   chan Event
+	bus *Bus
+}
+
+//NewBus creates an event bus without subscribers
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+//Subscribe registers a new subscriber, on a closed bus the subscription is closed right away
+func (b *Bus) Subscribe() *Subscription {
+	s := &Subscription{
+		C:   make(chan Event, subscriberBuffer),
+		bus: b,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(s.C)
+		return s
+	}
+	b.subs[s] = struct{}{}
+
+	return s
+}
+
+//Close drops every subscriber so long-lived streams end, used on shutdown
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subs {
+		b.remove(s)
+	}
+}
+
+//Close unsubscribes from the bus, it is safe to call more than once
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	s.bus.remove(s)
+}
+
+//Publish sends the event to every subscriber without blocking.
+//A subscriber whose buffer is full is considered gone and is dropped.
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subs {
+		select {
+		case s.C <- e:
+		default:
+			b.remove(s)
+		}
+	}
+}
+
+//Subscribers returns the number of active subscribers
+func (b *Bus) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subs)
+}
+
+//remove drops a subscriber, the caller must hold the lock
+func (b *Bus) remove(s *Subscription) {
+	if _, ok := b.subs[s]; !ok {
+		return
+	}
+	delete(b.subs, s)
+	close(s.C)
+}
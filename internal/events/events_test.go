@@ -0,0 +1,97 @@
+package events
+
+import "testing"
+
+func TestBus_FanOut(t *testing.T) {
+	bus := NewBus()
+
+	first := bus.Subscribe()
+	second := bus.Subscribe()
+	defer first.Close()
+	defer second.Close()
+
+	bus.Publish(Event{Type: ReservationCreated, ReservationID: 1, NewCount: 3})
+
+	for i, s := range []*Subscription{first, second} {
+		select {
+		case e := <-s.C:
+			if e.Type != ReservationCreated || e.ReservationID != 1 || e.NewCount != 3 {
+				t.Errorf("subscriber %d got unexpected event %+v", i, e)
+			}
+		default:
+			t.Errorf("subscriber %d did not receive the event", i)
+		}
+	}
+}
+
+func TestBus_Close(t *testing.T) {
+	bus := NewBus()
+
+	s := bus.Subscribe()
+	if bus.Subscribers() != 1 {
+		t.Fatalf("expected 1 subscriber, got %d", bus.Subscribers())
+	}
+
+	s.Close()
+	s.Close()
+
+	if bus.Subscribers() != 0 {
+		t.Errorf("expected no subscribers after close, got %d", bus.Subscribers())
+	}
+	if _, ok := <-s.C; ok {
+		t.Error("subscription channel still open after close")
+	}
+
+	//publishing with no subscribers must not block or panic
+	bus.Publish(Event{Type: ReservationDeleted})
+}
+
+func TestBus_DropsDisconnectedSubscribers(t *testing.T) {
+	bus := NewBus()
+
+	active := bus.Subscribe()
+	defer active.Close()
+	gone := bus.Subscribe()
+
+	//the gone subscriber never reads, so its buffer fills up
+	for i := 0; i <= subscriberBuffer; i++ {
+		bus.Publish(Event{Type: ReservationUpdated, ReservationID: i})
+		<-active.C
+	}
+
+	if bus.Subscribers() != 1 {
+		t.Errorf("expected the stalled subscriber to be dropped, have %d subscribers", bus.Subscribers())
+	}
+
+	n := 0
+	for range gone.C {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("dropped subscriber should keep its %d buffered events, got %d", subscriberBuffer, n)
+	}
+
+	bus.Publish(Event{Type: ReservationStatusChanged})
+	if e := <-active.C; e.Type != ReservationStatusChanged {
+		t.Errorf("active subscriber got %+v", e)
+	}
+}
+
+func TestBus_CloseEndsSubscriptions(t *testing.T) {
+	bus := NewBus()
+	s := bus.Subscribe()
+
+	bus.Close()
+
+	if _, ok := <-s.C; ok {
+		t.Error("subscription still open after the bus was closed")
+	}
+
+	late := bus.Subscribe()
+	if _, ok := <-late.C; ok {
+		t.Error("subscribing to a closed bus should return a closed subscription")
+	}
+	if bus.Subscribers() != 0 {
+		t.Errorf("closed bus has %d subscribers", bus.Subscribers())
+	}
+}
@@ -0,0 +1,95 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+//The formats a report can be exported in
+const (
+	CSV  = "csv"
+	XLSX = "xlsx"
+)
+
+//Formats lists every export format
+var Formats = []string{CSV, XLSX}
+
+//contentTypes are the content types of the formats
+var contentTypes = map[string]string{
+	CSV:  "text/csv",
+	XLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+//Writer writes a report one row at a time, so a report never has to be held in memory.
+//A cell is a string, an int or a float64. Close flushes what is left and ends the file.
+type Writer interface {
+	Write(row ...interface{}) error
+	Close() error
+}
+
+//New returns a writer of the format to w, sheet names the sheet of a workbook
+func New(format string, w io.Writer, sheet string) (Writer, error) {
+	switch format {
+	case CSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case XLSX:
+		return newXLSXWriter(w, sheet), nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+//ContentType returns the content type of the format
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+type csvWriter struct {
+	w    *csv.Writer
+	rows int
+}
+
+func (c *csvWriter) Write(row ...interface{}) error {
+	record := make([]string, len(row))
+	for i, cell := range row {
+		switch v := cell.(type) {
+		case string:
+			record[i] = escapeFormula(v)
+		case int:
+			record[i] = strconv.Itoa(v)
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			record[i] = escapeFormula(fmt.Sprint(v))
+		}
+	}
+	if err := c.w.Write(record); err != nil {
+		return err
+	}
+
+	//flush now and then so the rows stream out instead of piling up in the buffer
+	c.rows++
+	if c.rows%500 == 0 {
+		c.w.Flush()
+	}
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+//escapeFormula keeps a spreadsheet from running text as a formula, a guest could
+//otherwise put one in their name
+func escapeFormula(s string) string {
+	if s == "" {
+		return s
+	}
+	switch s[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,112 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := New(CSV, &buf, "ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Write("id", "name", "rate")
+	w.Write(7, "=HYPERLINK(\"http://evil\")", 0.25)
+	w.Write(8, "Smith, John", 1.0)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "id,name,rate\n7,\"'=HYPERLINK(\"\"http://evil\"\")\",0.25\n8,\"Smith, John\",1\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q but got %q", expected, buf.String())
+	}
+}
+
+//sheet is the part of a worksheet the tests read back
+type sheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R      string `xml:"r,attr"`
+			T      string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func TestXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := New(XLSX, &buf, "Reservations: 2050/01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Write("id", "name", "rate")
+	w.Write(7, "Smith & <Sons>", 0.25)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string]string)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(r)
+		parts[f.Name] = string(b)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("expected the workbook to have %s", name)
+		}
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `name="Reservations- 2050-01"`) {
+		t.Errorf("expected a sheet name Excel accepts, got %s", parts["xl/workbook.xml"])
+	}
+
+	var s sheet
+	if err := xml.Unmarshal([]byte(parts["xl/worksheets/sheet1.xml"]), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Rows) != 2 || len(s.Rows[1].Cells) != 3 {
+		t.Fatalf("expected 2 rows of 3 cells, got %+v", s.Rows)
+	}
+	cells := s.Rows[1].Cells
+	if cells[0].R != "A2" || cells[0].T != "" || cells[0].Value != "7" {
+		t.Errorf("expected a number in A2, got %+v", cells[0])
+	}
+	if cells[1].T != "inlineStr" || cells[1].Inline != "Smith & <Sons>" {
+		t.Errorf("expected the text in B2, got %+v", cells[1])
+	}
+	if cells[2].R != "C2" || cells[2].Value != "0.25" {
+		t.Errorf("expected a number in C2, got %+v", cells[2])
+	}
+}
+
+func TestColumn(t *testing.T) {
+	for i, expected := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if got := column(i); got != expected {
+			t.Errorf("for column %d, expected %s but got %s", i, expected, got)
+		}
+	}
+}
+
+func TestNew_UnknownFormat(t *testing.T) {
+	if _, err := New("pdf", io.Discard, ""); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
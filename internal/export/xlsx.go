@@ -0,0 +1,153 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//The parts of a workbook with a single sheet, the sheet itself is streamed
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`
+
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`
+
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`
+
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+//xlsxWriter writes a workbook of one sheet. The zip entries are written in order, so the
+//rows go out as they are written and only the current part is buffered.
+type xlsxWriter struct {
+	zip   *zip.Writer
+	sheet *bufio.Writer
+	rows  int
+	err   error
+}
+
+func newXLSXWriter(w io.Writer, name string) *xlsxWriter {
+	x := &xlsxWriter{zip: zip.NewWriter(w)}
+
+	x.part("[Content_Types].xml", xlsxContentTypes)
+	x.part("_rels/.rels", xlsxRels)
+	x.part("xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, xmlEscape(sheetName(name))))
+	x.part("xl/_rels/workbook.xml.rels", xlsxWorkbookRels)
+
+	if x.err == nil {
+		var sheet io.Writer
+		sheet, x.err = x.zip.Create("xl/worksheets/sheet1.xml")
+		if x.err == nil {
+			x.sheet = bufio.NewWriter(sheet)
+			_, x.err = x.sheet.WriteString(xlsxSheetStart)
+		}
+	}
+	return x
+}
+
+//part writes a whole zip entry
+func (x *xlsxWriter) part(name, content string) {
+	if x.err != nil {
+		return
+	}
+	var w io.Writer
+	if w, x.err = x.zip.Create(name); x.err == nil {
+		_, x.err = io.WriteString(w, content)
+	}
+}
+
+func (x *xlsxWriter) Write(row ...interface{}) error {
+	if x.err != nil {
+		return x.err
+	}
+	x.rows++
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<row r="%d">`, x.rows)
+	for i, cell := range row {
+		ref := column(i) + strconv.Itoa(x.rows)
+		switch v := cell.(type) {
+		case int:
+			fmt.Fprintf(&b, `<c r="%s"><v>%d</v></c>`, ref, v)
+		case float64:
+			fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, xmlEscape(fmt.Sprint(v)))
+		}
+	}
+	b.WriteString(`</row>`)
+
+	_, x.err = x.sheet.WriteString(b.String())
+	return x.err
+}
+
+func (x *xlsxWriter) Close() error {
+	if x.err != nil {
+		return x.err
+	}
+	if _, x.err = x.sheet.WriteString(xlsxSheetEnd); x.err != nil {
+		return x.err
+	}
+	if x.err = x.sheet.Flush(); x.err != nil {
+		return x.err
+	}
+	x.err = x.zip.Close()
+	return x.err
+}
+
+//column returns the letters of the i-th column, counting from 0
+func column(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+//sheetName returns a name Excel accepts for a sheet, at most 31 characters without []:*?/\
+func sheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '-'
+		}
+		return r
+	}, name)
+	if name == "" {
+		name = "Sheet1"
+	}
+	if r := []rune(name); len(r) > 31 {
+		name = string(r[:31])
+	}
+	return name
+}
+
+//xmlEscape escapes text for an element or attribute, characters XML cannot hold are replaced
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -3,6 +3,7 @@ package forms
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/asaskevich/govalidator"
 )
@@ -50,3 +51,19 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Not valid email")
 	}
 }
+
+//IntRange checks the field is a whole number from min to max and returns it, a blank
+//field is read as def
+func (f *Form) IntRange(field string, min, max, def int) int {
+	x := f.Get(field)
+	if x == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(x)
+	if err != nil || n < min || n > max {
+		f.Errors.Add(field, fmt.Sprintf("Enter a number from %d to %d", min, max))
+		return def
+	}
+	return n
+}
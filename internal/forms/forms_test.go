@@ -132,3 +132,25 @@ func TestForm_IsEmail(t *testing.T) {
 	}
 
 }
+
+func TestForm_IntRange(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("adults", "3")
+	postedData.Add("children", "-1")
+	postedData.Add("rooms", "two")
+	form := New(postedData)
+
+	if n := form.IntRange("adults", 1, 20, 1); n != 3 || !form.Valid() {
+		t.Errorf("expected 3 without an error, got %d", n)
+	}
+	if n := form.IntRange("pets", 0, 2, 0); n != 0 || !form.Valid() {
+		t.Errorf("expected a blank field to be read as the default, got %d", n)
+	}
+
+	if n := form.IntRange("children", 0, 20, 0); n != 0 || form.Errors.Get("children") == "" {
+		t.Errorf("expected an error for a number out of range, got %d", n)
+	}
+	if n := form.IntRange("rooms", 1, 3, 1); n != 1 || form.Errors.Get("rooms") == "" {
+		t.Errorf("expected an error for a field that is not a number, got %d", n)
+	}
+}
@@ -1,22 +1,43 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"html/template"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"strconv"
 	"time"
 
+	"github.com/ArmanurRahman/booking/internal/audit"
 	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/domain"
 	"github.com/ArmanurRahman/booking/internal/drivers"
+	"github.com/ArmanurRahman/booking/internal/events"
+	"github.com/ArmanurRahman/booking/internal/export"
 	"github.com/ArmanurRahman/booking/internal/forms"
+	"github.com/ArmanurRahman/booking/internal/health"
+	"github.com/ArmanurRahman/booking/internal/importer"
+	"github.com/ArmanurRahman/booking/internal/logging"
+	"github.com/ArmanurRahman/booking/internal/metrics"
+	"github.com/ArmanurRahman/booking/internal/notify"
 	"github.com/ArmanurRahman/booking/internal/repository"
 	"github.com/ArmanurRahman/booking/internal/repository/dbrepo"
+	"github.com/ArmanurRahman/booking/internal/version"
 
 	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/ArmanurRahman/booking/internal/helpers"
 
@@ -29,23 +50,37 @@ var Repo *Repository
 
 //Repository is the repository type
 type Repository struct {
-	App *config.AppConfig
-	DB  repository.DatabaseRepo
+	App    *config.AppConfig
+	DB     repository.DatabaseRepo
+	Staff  *notify.Dispatcher
+	Checks []health.Check
 }
 
 //NewRepo creates a new repository
 func NewRepo(a *config.AppConfig, db *drivers.DB) *Repository {
+	repo := dbrepo.NewRepo(db, a)
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewPostgresRepo(db.SQL, a),
+		App:   a,
+		DB:    repo,
+		Staff: notify.NewDispatcher(a, repo),
+		Checks: []health.Check{
+			health.Database(db.SQL, a.HealthTimeout),
+			health.Templates(a, a.HealthTimeout),
+			health.Dial("mail", net.JoinHostPort(a.Mail.Host, strconv.Itoa(a.Mail.Port)), a.HealthTimeout),
+		},
 	}
 }
 
-//NewRepo creates a new repository
-func NewTestRepo(a *config.AppConfig) *Repository {
+//NewTestRepo creates a repository for the tests backed by the in-memory db
+func NewTestRepo(a *config.AppConfig, db *dbrepo.MemoryDBRepo) *Repository {
+	repo := dbrepo.NewAuditedRepo(db)
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewTestDBRepo(a),
+		App:   a,
+		DB:    repo,
+		Staff: notify.NewDispatcher(a, repo),
+		Checks: []health.Check{
+			health.Templates(a, time.Second),
+		},
 	}
 }
 
@@ -93,7 +128,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	room, err := m.DB.GetRoomByID(r.Context(), res.RoomID)
 
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "cannot fint room")
@@ -101,7 +136,30 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res.Room.RoomName = room.RoomName
+	res.Room = room
+
+	//the party of the search, without the guests a room from a suggested combination does not sleep
+	if res.Adults == 0 {
+		res.Adults = 1
+	}
+	if !room.Fits(res.Guests()) {
+		res.Adults = min(res.Adults, max(room.MaxOccupancy, 1))
+		res.Children = max(room.MaxOccupancy-res.Adults, 0)
+	}
+
+	//a logged in guest books with the details of the account, for the guest of the account
+	if id := m.App.Session.GetInt(r.Context(), "guest_account_id"); id != 0 && res.GuestID == 0 {
+		account, err := m.DB.GetGuestAccount(r.Context(), id)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("cannot load guest account", "account_id", id, "error", err)
+		} else {
+			res.GuestID = account.GuestID
+			res.FirstName = account.Guest.FirstName
+			res.LastName = account.Guest.LastName
+			res.Email = account.Guest.Email
+			res.Phone = account.Guest.Phone
+		}
+	}
 
 	m.App.Session.Put(r.Context(), "reservation", res)
 
@@ -142,6 +200,14 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	reservation.LastName = r.Form.Get("last_name")
 	reservation.Phone = r.Form.Get("phone")
 	reservation.Email = r.Form.Get("email")
+
+	room, err := m.DB.GetRoomByID(r.Context(), reservation.RoomID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot find room")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	reservation.Room = room
 	//sd := r.Form.Get("start_date")
 	//sd := "2020-12-15"
 	//ed := r.Form.Get("end_date")
@@ -150,18 +216,18 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		layout := "2006-01-02"
 		startDate, err := time.Parse(layout, sd)
 		if err != nil {
-			helpers.ServerError(w, err)
+			helpers.ServerError(w, r, err)
 			return
 		}
 		endDate, err := time.Parse(layout, ed)
 		if err != nil {
-			helpers.ServerError(w, err)
+			helpers.ServerError(w, r, err)
 			return
 		}
 		//roomId, err := strconv.Atoi(r.Form.Get("room_id"))
 		roomId, err := strconv.Atoi("1")
 		if err != nil {
-			helpers.ServerError(w, err)
+			helpers.ServerError(w, r, err)
 			return
 		}
 		reservation := models.Reservation{
@@ -180,6 +246,10 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	form.Required("first_name", "last_name", "email")
 	form.MinLength("first_name", 3, r)
 	form.IsEmail("email")
+	reservation.Adults, reservation.Children = readParty(form)
+	if form.Valid() && !room.Fits(reservation.Guests()) {
+		form.Errors.Add("adults", fmt.Sprintf("The %s sleeps at most %d guests", room.RoomName, room.MaxOccupancy))
+	}
 
 	if !form.Valid() {
 		data := make(map[string]interface{})
@@ -191,27 +261,19 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newReservationId, err := m.DB.InsertReservation(reservation)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "cannot insert reservation into database")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	//the reservation and its room restriction are inserted together, once the room is checked
+	//to be still free, like the rooms of a booking
+	_, ids, err := m.DB.InsertBooking(r.Context(), []models.Reservation{reservation})
+	if errors.Is(err, domain.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The %s was just taken on these dates, please search again", room.RoomName))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
-	}
-
-	roomRestriction := models.RoomRestriction{
-
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ResevationID:  newReservationId,
-		RestrictionID: 1,
-	}
-	err = m.DB.InsetIntoRoomRestriction(roomRestriction)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "cannot insert room restriction")
+	} else if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot insert reservation into database")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	newReservationId := ids[0]
 
 	//send notifications to guest
 
@@ -223,7 +285,7 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 
 	msg := models.MailData{
 		To:       reservation.Email,
-		From:     "mubeen@test.com",
+		From:     m.App.Mail.From,
 		Subject:  "Reservation Confirmation",
 		Content:  htmlMessage,
 		Template: "basic.html",
@@ -231,46 +293,127 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 
 	m.App.MailChan <- msg
 
+	//send notifications to staff
+	m.Staff.Notify(r.Context(), models.Notification{
+		Title: "New reservation",
+		Body: fmt.Sprintf("%s %s booked the %s from %s to %s",
+			reservation.FirstName, reservation.LastName, reservation.Room.RoomName,
+			reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02")),
+		Link: fmt.Sprintf("/admin/reservation/new/%d", newReservationId),
+	})
+	m.publish(r.Context(), events.ReservationCreated, newReservationId)
+	metrics.ReservationsCreated.Inc()
+
 	m.App.Session.Put(r.Context(), "reservation", reservation)
 
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 }
 
 func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "search-availability.page.html", &models.TemplateData{})
+	render.Template(w, r, "search-availability.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+//readParty reads the adults and children of a form, a blank field is one adult and no children
+func readParty(form *forms.Form) (int, int) {
+	adults := form.IntRange("adults", 1, domain.MaxPartySize, 1)
+	children := form.IntRange("children", 0, domain.MaxPartySize, 0)
+	if form.Errors.Get("adults") == "" && form.Errors.Get("children") == "" && adults+children > domain.MaxPartySize {
+		form.Errors.Add("children", fmt.Sprintf("We take at most %d guests per booking", domain.MaxPartySize))
+	}
+	return adults, children
+}
+
+//fitParty returns the free rooms that sleep the whole party, and when there are none
+//the combinations of free rooms that sleep it together
+func fitParty(rooms []models.Room, adults, children int) ([]models.Room, [][]models.Room) {
+	var fit []models.Room
+	for _, room := range rooms {
+		if room.Fits(adults + children) {
+			fit = append(fit, room)
+		}
+	}
+	if len(fit) > 0 {
+		return fit, nil
+	}
+
+	capacities := make([]int, len(rooms))
+	for i, room := range rooms {
+		capacities[i] = room.MaxOccupancy
+	}
+	var combinations [][]models.Room
+	for _, c := range domain.Combinations(capacities, adults, children) {
+		combination := make([]models.Room, len(c))
+		for i, n := range c {
+			combination[i] = rooms[n]
+		}
+		combinations = append(combinations, combination)
+	}
+	return nil, combinations
 }
 
 func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
 	start := r.Form.Get("start")
 	end := r.Form.Get("end")
 
 	layout := "2006-01-02"
 	startDate, err := time.Parse(layout, start)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	endDate, err := time.Parse(layout, end)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	adults, children := readParty(form)
+	if !form.Valid() {
+		render.Template(w, r, "search-availability.page.html", &models.TemplateData{
+			Form: form,
+		})
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
-	if len(rooms) == 0 {
+	fit, combinations := fitParty(rooms, adults, children)
+	if len(fit) == 0 && len(combinations) == 0 {
 		//not available
+		metrics.AvailabilitySearches.WithLabelValues("empty").Inc()
 		m.App.Session.Put(r.Context(), "error", "No Availability")
 		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
 
 	data := make(map[string]interface{})
-	data["rooms"] = rooms
+	if len(fit) > 0 {
+		metrics.AvailabilitySearches.WithLabelValues("found").Inc()
+		data["rooms"] = fit
+	} else {
+		metrics.AvailabilitySearches.WithLabelValues("combined").Inc()
+		data["combinations"] = combinations
+	}
+	data["guests"] = adults + children
 
 	res := models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
+		Adults:    adults,
+		Children:  children,
 	}
 
 	m.App.Session.Put(r.Context(), "reservation", res)
@@ -288,6 +431,11 @@ type jsonResponse struct {
 }
 
 func (m *Repository) AvailabilityJson(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
 
 	sd := r.Form.Get("start")
 	ed := r.Form.Get("end")
@@ -297,10 +445,22 @@ func (m *Repository) AvailabilityJson(w http.ResponseWriter, r *http.Request) {
 	startDate, _ := time.Parse(layout, sd)
 	endDate, _ := time.Parse(layout, ed)
 
-	available, _ := m.DB.SearchAvailabilityByDatesByRoomId(startDate, endDate, roomId)
+	available, _ := m.DB.SearchAvailabilityByDatesByRoomId(r.Context(), startDate, endDate, roomId)
+
+	//a party too big for the room is told so, a search without guest counts is for one adult
+	message := ""
+	adults, children := readParty(forms.New(r.Form))
+	if available {
+		room, err := m.DB.GetRoomByID(r.Context(), roomId)
+		if err == nil && !room.Fits(adults+children) {
+			available = false
+			message = fmt.Sprintf("The %s sleeps at most %d guests", room.RoomName, room.MaxOccupancy)
+		}
+	}
+
 	resp := jsonResponse{
 		OK:        available,
-		Message:   "",
+		Message:   message,
 		RoomId:    strconv.Itoa(roomId),
 		StartDate: sd,
 		EndDate:   ed,
@@ -308,7 +468,7 @@ func (m *Repository) AvailabilityJson(w http.ResponseWriter, r *http.Request) {
 
 	out, err := json.MarshalIndent(resp, "", "     ")
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -319,7 +479,7 @@ func (m *Repository) AvailabilityJson(w http.ResponseWriter, r *http.Request) {
 func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
 	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
-		m.App.ErrorLog.Println("cannot get item from session")
+		logging.FromContext(r.Context()).Warn("cannot get reservation from session")
 		m.App.Session.Put(r.Context(), "error", "Cannot get reservation from session")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
@@ -342,13 +502,13 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 	roomId, err := strconv.Atoi(chi.URLParam(r, "id"))
 
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	res.RoomID = roomId
@@ -356,6 +516,278 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
 }
 
+//cart returns the rooms the visitor added to the booking, in the order they were added
+func (m *Repository) cart(r *http.Request) []models.Reservation {
+	cart, _ := m.App.Session.Get(r.Context(), "cart").([]models.Reservation)
+	return cart
+}
+
+//AddToCart adds the rooms posted from the search results to the booking, for the dates of
+//the search. The party of the search is spread over the rooms, an adult in each.
+func (m *Repository) AddToCart(w http.ResponseWriter, r *http.Request) {
+	search, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok || search.StartDate.IsZero() {
+		m.App.Session.Put(r.Context(), "error", "Search for the dates of your stay first")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	if len(r.Form["room_id"]) == 0 {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	var rooms []models.Room
+	var capacities []int
+	for _, v := range r.Form["room_id"] {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			helpers.ClientError(w, r, http.StatusBadRequest)
+			return
+		}
+		room, err := m.DB.GetRoomByID(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(w, r, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		rooms = append(rooms, room)
+		capacities = append(capacities, room.MaxOccupancy)
+	}
+
+	adults, children, ok := domain.SplitParty(capacities, max(search.Adults, 1), search.Children)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Your party does not fit in these rooms, with an adult in each")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	cart := m.cart(r)
+	for i, room := range rooms {
+		item := models.Reservation{
+			StartDate: search.StartDate,
+			EndDate:   search.EndDate,
+			RoomID:    room.ID,
+			Room:      room,
+			Adults:    adults[i],
+			Children:  children[i],
+		}
+
+		taken := false
+		for _, other := range cart {
+			if other.RoomID == item.RoomID && domain.Overlaps(item.StartDate, item.EndDate, other.StartDate, other.EndDate) {
+				taken = true
+			}
+		}
+		if !taken {
+			available, err := m.DB.SearchAvailabilityByDatesByRoomId(r.Context(), item.StartDate, item.EndDate, item.RoomID)
+			if err != nil {
+				helpers.ServerError(w, r, err)
+				return
+			}
+			taken = !available
+		}
+		if taken {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The %s is not free on these dates anymore", room.RoomName))
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+		cart = append(cart, item)
+	}
+
+	m.App.Session.Put(r.Context(), "cart", cart)
+	if len(rooms) == 1 {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("The %s was added to your booking", rooms[0].RoomName))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%d rooms were added to your booking", len(rooms)))
+	}
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+//Cart shows the rooms of the booking with the form to check it out
+func (m *Repository) Cart(w http.ResponseWriter, r *http.Request) {
+	cart := m.cart(r)
+
+	var contact models.Reservation
+	if id := m.App.Session.GetInt(r.Context(), "guest_account_id"); id != 0 {
+		account, err := m.DB.GetGuestAccount(r.Context(), id)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("cannot load guest account", "account_id", id, "error", err)
+		} else {
+			contact.FirstName = account.Guest.FirstName
+			contact.LastName = account.Guest.LastName
+			contact.Email = account.Guest.Email
+			contact.Phone = account.Guest.Phone
+		}
+	}
+
+	m.renderCart(w, r, cart, contact, forms.New(nil))
+}
+
+func (m *Repository) renderCart(w http.ResponseWriter, r *http.Request, cart []models.Reservation, contact models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["cart"] = cart
+	data["contact"] = contact
+	data["guests"] = models.Booking{Reservations: cart}.Guests()
+	render.Template(w, r, "cart.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+//RemoveFromCart takes a room out of the booking, by its position in the booking
+func (m *Repository) RemoveFromCart(w http.ResponseWriter, r *http.Request) {
+	cart := m.cart(r)
+	n, err := strconv.Atoi(chi.URLParam(r, "n"))
+	if err != nil || n < 0 || n >= len(cart) {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	room := cart[n].Room.RoomName
+	cart = append(cart[:n:n], cart[n+1:]...)
+	m.App.Session.Put(r.Context(), "cart", cart)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("The %s was removed from your booking", room))
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+//PostCart checks the booking out, every room of it is reserved or none is
+func (m *Repository) PostCart(w http.ResponseWriter, r *http.Request) {
+	cart := m.cart(r)
+	if len(cart) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Your booking has no rooms yet")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	contact := models.Reservation{
+		FirstName: r.Form.Get("first_name"),
+		LastName:  r.Form.Get("last_name"),
+		Email:     r.Form.Get("email"),
+		Phone:     r.Form.Get("phone"),
+	}
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3, r)
+	form.IsEmail("email")
+	if !form.Valid() {
+		m.renderCart(w, r, cart, contact, form)
+		return
+	}
+
+	//a logged in guest books for the guest of the account
+	if id := m.App.Session.GetInt(r.Context(), "guest_account_id"); id != 0 {
+		if account, err := m.DB.GetGuestAccount(r.Context(), id); err == nil {
+			contact.GuestID = account.GuestID
+		}
+	}
+
+	reservations := make([]models.Reservation, len(cart))
+	for i, item := range cart {
+		res := item
+		res.FirstName, res.LastName, res.Email, res.Phone = contact.FirstName, contact.LastName, contact.Email, contact.Phone
+		res.GuestID = contact.GuestID
+		reservations[i] = res
+	}
+
+	//the rooms are checked again as the booking is inserted, this tells which one was taken meanwhile
+	for _, res := range reservations {
+		available, err := m.DB.SearchAvailabilityByDatesByRoomId(r.Context(), res.StartDate, res.EndDate, res.RoomID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		if !available {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The %s is not free from %s to %s anymore, remove it to go on",
+				res.Room.RoomName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02")))
+			http.Redirect(w, r, "/cart", http.StatusSeeOther)
+			return
+		}
+	}
+
+	bookingID, ids, err := m.DB.InsertBooking(r.Context(), reservations)
+	if errors.Is(err, domain.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "A room of your booking was just taken, please check your booking")
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	//one confirmation for every room of the booking
+	var rooms strings.Builder
+	for _, res := range reservations {
+		fmt.Fprintf(&rooms, "%s from %s to %s, %d adults and %d children<br>",
+			template.HTMLEscapeString(res.Room.RoomName), res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"),
+			res.Adults, res.Children)
+	}
+	m.App.MailChan <- models.MailData{
+		To:      contact.Email,
+		From:    m.App.Mail.From,
+		Subject: "Booking Confirmation",
+		Content: fmt.Sprintf(`
+			<strong>Booking Confirmation</strong><br>
+			Dear: %s, <br>
+			This is confirm your booking %d of these rooms:<br>
+			%s
+		`, template.HTMLEscapeString(contact.FirstName), bookingID, rooms.String()),
+		Template: "basic.html",
+	}
+
+	m.Staff.Notify(r.Context(), models.Notification{
+		Title: "New booking",
+		Body: fmt.Sprintf("%s %s booked %d rooms from %s", contact.FirstName, contact.LastName, len(ids),
+			reservations[0].StartDate.Format("2006-01-02")),
+		Link: fmt.Sprintf("/admin/reservation/new/%d", ids[0]),
+	})
+	for _, id := range ids {
+		m.publish(r.Context(), events.ReservationCreated, id)
+	}
+	metrics.ReservationsCreated.Add(float64(len(ids)))
+
+	m.App.Session.Remove(r.Context(), "cart")
+	m.App.Session.Remove(r.Context(), "reservation")
+	m.App.Session.Put(r.Context(), "booking_id", bookingID)
+	http.Redirect(w, r, "/booking-summary", http.StatusSeeOther)
+}
+
+//BookingSummary shows the booking that was just checked out
+func (m *Repository) BookingSummary(w http.ResponseWriter, r *http.Request) {
+	id := m.App.Session.PopInt(r.Context(), "booking_id")
+	if id == 0 {
+		m.App.Session.Put(r.Context(), "error", "Cannot get booking from session")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	booking, err := m.DB.GetBooking(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["booking"] = booking
+	render.Template(w, r, "booking-summary.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
 func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	sd := r.URL.Query().Get("s")
 	ed := r.URL.Query().Get("e")
@@ -388,7 +820,7 @@ func (m *Repository) PostUserLogin(w http.ResponseWriter, r *http.Request) {
 
 	err := r.ParseForm()
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(r.Context()).Warn("cannot parse login form", "error", err)
 	}
 
 	email := r.Form.Get("email")
@@ -404,16 +836,20 @@ func (m *Repository) PostUserLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Info("login failed", "email", email, "error", err)
 
 		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		return
 	}
 
+	m.App.Session.Remove(r.Context(), "guest_account_id")
 	m.App.Session.Put(r.Context(), "user_id", id)
+	if req := logging.RequestFrom(r.Context()); req != nil {
+		req.UserID = id
+	}
 	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 
@@ -426,97 +862,1084 @@ func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
-func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-dashboard.page.html", &models.TemplateData{})
-}
+//verifyTokenLifetime is how long the link confirming the email of a guest account works
+const verifyTokenLifetime = 48 * time.Hour
 
-func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+//minPasswordLength is the shortest password of a guest account
+const minPasswordLength = 8
 
-	reservations, err := m.DB.AllReservations()
+//newVerifyToken returns a random token for the link confirming the email of a guest
+//account and the hash of it that is stored with the account
+func newVerifyToken() (string, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
 	if err != nil {
-		helpers.ServerError(w, err)
-		return
+		return "", "", err
 	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
 
-	data := make(map[string]interface{})
-
-	data["reservations"] = reservations
-
-	render.Template(w, r, "admin-all-reservations.page.html", &models.TemplateData{
-		Data: data,
-	})
+//hashToken returns the hash of a token as it is stored, so the table holds no working links
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.NewReservations()
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
+//sendVerification emails the link confirming the email of a guest account
+func (m *Repository) sendVerification(email, firstName, token string) {
+	link := fmt.Sprintf("%s/account/verify?token=%s", strings.TrimSuffix(m.App.PublicURL, "/"), url.QueryEscape(token))
+	htmlMessage := fmt.Sprintf(`
+			<strong>Confirm your email</strong><br>
+			Dear: %s, <br>
+			Follow <a href="%s">this link</a> within %d hours to confirm your email and log in to your bookings.
+		`, template.HTMLEscapeString(firstName), link, int(verifyTokenLifetime.Hours()))
+
+	m.App.MailChan <- models.MailData{
+		To:       email,
+		From:     m.App.Mail.From,
+		Subject:  "Confirm your email",
+		Content:  htmlMessage,
+		Template: "basic.html",
 	}
+}
 
+//AccountSignup renders the sign up form of guest accounts
+func (m *Repository) AccountSignup(w http.ResponseWriter, r *http.Request) {
 	data := make(map[string]interface{})
-
-	data["reservations"] = reservations
-	render.Template(w, r, "admin-new-reservations.page.html", &models.TemplateData{
+	data["guest"] = models.Guest{}
+	render.Template(w, r, "account-signup.page.html", &models.TemplateData{
+		Form: forms.New(nil),
 		Data: data,
 	})
 }
 
-func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
-	exploded := strings.Split(r.RequestURI, "/")
-	id, err := strconv.Atoi(exploded[4])
-
+//PostAccountSignup creates a guest account and emails the link confirming its email
+func (m *Repository) PostAccountSignup(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ClientError(w, r, http.StatusBadRequest)
 		return
 	}
 
-	src := exploded[3]
+	guest := models.Guest{
+		FirstName: strings.TrimSpace(r.Form.Get("first_name")),
+		LastName:  strings.TrimSpace(r.Form.Get("last_name")),
+		Email:     strings.TrimSpace(r.Form.Get("email")),
+		Phone:     strings.TrimSpace(r.Form.Get("phone")),
+	}
 
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "password")
+	form.MinLength("first_name", 3, r)
+	form.IsEmail("email")
+	form.MinLength("password", minPasswordLength, r)
+	if r.Form.Get("password") != r.Form.Get("password_confirm") {
+		form.Errors.Add("password_confirm", "The passwords do not match")
+	}
 
-	res, err := m.DB.GetReservationById(id)
+	renderForm := func() {
+		data := make(map[string]interface{})
+		data["guest"] = guest
+		render.Template(w, r, "account-signup.page.html", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
+	}
+	if !form.Valid() {
+		renderForm()
+		return
+	}
 
+	hash, err := bcrypt.GenerateFromPassword([]byte(r.Form.Get("password")), bcrypt.DefaultCost)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	token, tokenHash, err := newVerifyToken()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	data := make(map[string]interface{})
-	data["reservation"] = res
-	render.Template(w, r, "admin-show-reservation.page.html", &models.TemplateData{
-		StringMap: stringMap,
-		Data:      data,
-		Form:      forms.New(nil),
+	_, err = m.DB.InsertGuestAccount(r.Context(), models.GuestAccount{
+		Email:         guest.Email,
+		Password:      string(hash),
+		VerifyToken:   tokenHash,
+		VerifyExpires: time.Now().Add(verifyTokenLifetime),
+		Guest:         guest,
 	})
-}
-
-func (m *Repository) AdminPostReservation(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
-
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "cannot perse form")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	if errors.Is(err, dbrepo.ErrAccountExists) {
+		form.Errors.Add("email", "There is an account with this email already, log in instead")
+		renderForm()
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
 		return
 	}
-	exploded := strings.Split(r.RequestURI, "/")
-	id, err := strconv.Atoi(exploded[4])
 
-	if err != nil {
-		helpers.ServerError(w, err)
+	m.sendVerification(guest.Email, guest.FirstName, token)
+	m.App.Session.Put(r.Context(), "flash", "Check your email for the link confirming your account")
+	http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+}
+
+//AccountVerify confirms the email of a guest account with the token of the emailed link
+func (m *Repository) AccountVerify(w http.ResponseWriter, r *http.Request) {
+	_, err := m.DB.VerifyGuestAccount(r.Context(), hashToken(r.URL.Query().Get("token")))
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "The link is invalid or has expired, log in to get a new one")
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	src := exploded[3]
+	m.App.Session.Put(r.Context(), "flash", "Your email is confirmed, you can log in now")
+	http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+}
 
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
+//AccountLogin renders the login form of guest accounts
+func (m *Repository) AccountLogin(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "account-login.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
 
-	res, err := m.DB.GetReservationById(id)
+//PostAccountLogin logs a guest in to a verified account. An account that is not verified
+//yet gets a new link instead, in case the first one expired.
+func (m *Repository) PostAccountLogin(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.RenewToken(r.Context())
 
+	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "account-login.page.html", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	account, err := m.DB.AuthenticateGuest(r.Context(), r.Form.Get("email"), r.Form.Get("password"))
+	if err != nil {
+		logging.FromContext(r.Context()).Info("guest login failed", "email", r.Form.Get("email"), "error", err)
+
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	}
+
+	if !account.Verified() {
+		token, tokenHash, err := newVerifyToken()
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		err = m.DB.RenewGuestVerification(r.Context(), account.ID, tokenHash, time.Now().Add(verifyTokenLifetime))
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		m.sendVerification(account.Email, account.Guest.FirstName, token)
+
+		m.App.Session.Put(r.Context(), "warning", "Confirm your email first, we sent you a new link")
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	}
+
+	//a guest is never logged in as staff at the same time
+	m.App.Session.Remove(r.Context(), "user_id")
+	m.App.Session.Put(r.Context(), "guest_account_id", account.ID)
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/account/bookings", http.StatusSeeOther)
+}
+
+//AccountLogout logs a guest out
+func (m *Repository) AccountLogout(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+//currentAccount loads the account of the logged in guest, it answers the request itself when it cannot
+func (m *Repository) currentAccount(w http.ResponseWriter, r *http.Request) (models.GuestAccount, bool) {
+	id := m.App.Session.GetInt(r.Context(), "guest_account_id")
+	account, err := m.DB.GetGuestAccount(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Remove(r.Context(), "guest_account_id")
+		m.App.Session.Put(r.Context(), "error", "Log in first")
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return account, false
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return account, false
+	}
+	return account, true
+}
+
+//guestCanChange reports whether a guest may still cancel or move a reservation online,
+//that is it is pending or confirmed and the stay starts after today
+func guestCanChange(res models.Reservation, today time.Time) bool {
+	return (res.Status == domain.Pending || res.Status == domain.Confirmed) && res.StartDate.After(today)
+}
+
+//ownsReservation reports whether a reservation belongs to a guest account. Anonymous reservations
+//are linked to a guest by phone as well, which proves nothing about who made them, so the
+//reservation must also be made with the verified email of the account.
+func ownsReservation(account models.GuestAccount, res models.Reservation) bool {
+	return res.GuestID == account.GuestID && domain.EmailKey(res.Email) == domain.EmailKey(account.Email)
+}
+
+//today returns the date of today, like a date column
+func today() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+//MyBookings lists the upcoming and past reservations of the logged in guest
+func (m *Repository) MyBookings(w http.ResponseWriter, r *http.Request) {
+	account, ok := m.currentAccount(w, r)
+	if !ok {
+		return
+	}
+
+	reservations, err := m.DB.GuestReservations(r.Context(), account.GuestID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	//the reservations come latest first, the upcoming ones are listed soonest first
+	day := today()
+	var upcoming, past []models.Reservation
+	changeable := make(map[int]bool)
+	for _, res := range reservations {
+		if !ownsReservation(account, res) {
+			continue
+		}
+		if res.EndDate.Before(day) {
+			past = append(past, res)
+			continue
+		}
+		upcoming = append([]models.Reservation{res}, upcoming...)
+		changeable[res.ID] = guestCanChange(res, day)
+	}
+
+	//a booking of several rooms is cancelled as a whole from its first upcoming room
+	rooms := make(map[int]int)
+	for _, res := range upcoming {
+		if res.BookingID != 0 {
+			rooms[res.BookingID]++
+		}
+	}
+	groups := make(map[int]bool)
+	seen := make(map[int]bool)
+	for _, res := range upcoming {
+		if rooms[res.BookingID] > 1 && !seen[res.BookingID] {
+			groups[res.ID] = true
+			seen[res.BookingID] = true
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["account"] = account
+	data["upcoming"] = upcoming
+	data["past"] = past
+	data["changeable"] = changeable
+	data["groups"] = groups
+	render.Template(w, r, "my-bookings.page.html", &models.TemplateData{
+		StringMap: map[string]string{"tomorrow": day.AddDate(0, 0, 1).Format("2006-01-02")},
+		Data:      data,
+	})
+}
+
+//guestReservation loads the reservation in the route when it belongs to the logged in guest
+//and can still be changed online, it answers the request itself when it cannot
+func (m *Repository) guestReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	account, ok := m.currentAccount(w, r)
+	if !ok {
+		return models.Reservation{}, false
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return models.Reservation{}, false
+	}
+
+	//another guest's reservation is as good as missing
+	res, err := m.DB.GetReservationById(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !ownsReservation(account, res)) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return res, false
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return res, false
+	}
+
+	if !guestCanChange(res, today()) {
+		m.App.Session.Put(r.Context(), "error", "This booking cannot be changed online anymore, please contact us")
+		http.Redirect(w, r, "/account/bookings", http.StatusSeeOther)
+		return res, false
+	}
+	return res, true
+}
+
+//ownsBooking reports whether every reservation of a booking belongs to a guest account
+func ownsBooking(account models.GuestAccount, booking models.Booking) bool {
+	if len(booking.Reservations) == 0 {
+		return false
+	}
+	for _, res := range booking.Reservations {
+		if !ownsReservation(account, res) {
+			return false
+		}
+	}
+	return true
+}
+
+//CancelMyGroupBooking cancels every room of a booking of the logged in guest, when all of them
+//can still be changed online
+func (m *Repository) CancelMyGroupBooking(w http.ResponseWriter, r *http.Request) {
+	account, ok := m.currentAccount(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	//another guest's booking is as good as missing
+	booking, err := m.DB.GetBooking(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !ownsBooking(account, booking)) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	day := today()
+	for _, res := range booking.Reservations {
+		if res.Status != domain.Cancelled && !guestCanChange(res, day) {
+			m.App.Session.Put(r.Context(), "error", "This booking cannot be changed online anymore, please contact us")
+			http.Redirect(w, r, "/account/bookings", http.StatusSeeOther)
+			return
+		}
+	}
+
+	ids, err := m.DB.CancelBooking(r.Context(), id, 0)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	first := booking.Reservations[0]
+	m.Staff.Notify(r.Context(), models.Notification{
+		Title: "Booking cancelled",
+		Body: fmt.Sprintf("%s %s cancelled %d rooms from %s",
+			first.FirstName, first.LastName, len(ids), first.StartDate.Format("2006-01-02")),
+		Link: fmt.Sprintf("/admin/reservation/all/%d", first.ID),
+	})
+	for _, id := range ids {
+		m.publish(r.Context(), events.ReservationStatusChanged, id)
+	}
+	metrics.ReservationsCancelled.Add(float64(len(ids)))
+
+	m.App.Session.Put(r.Context(), "flash", "Your booking was cancelled")
+	http.Redirect(w, r, "/account/bookings", http.StatusSeeOther)
+}
+
+//CancelMyBooking cancels a reservation of the logged in guest and releases the room
+func (m *Repository) CancelMyBooking(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.UpdateReservationStatus(r.Context(), res.ID, domain.Cancelled, 0)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.Staff.Notify(r.Context(), models.Notification{
+		Title: "Reservation cancelled",
+		Body: fmt.Sprintf("%s %s cancelled the %s from %s to %s",
+			res.FirstName, res.LastName, res.Room.RoomName,
+			res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02")),
+		Link: fmt.Sprintf("/admin/reservation/all/%d", res.ID),
+	})
+	m.publish(r.Context(), events.ReservationStatusChanged, res.ID)
+	metrics.ReservationsCancelled.Inc()
+
+	m.App.Session.Put(r.Context(), "flash", "Your booking was cancelled")
+	http.Redirect(w, r, "/account/bookings", http.StatusSeeOther)
+}
+
+//ChangeMyBooking moves a reservation of the logged in guest to other dates, when the room is free on them
+func (m *Repository) ChangeMyBooking(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	fail := func(message string) {
+		m.App.Session.Put(r.Context(), "error", message)
+		http.Redirect(w, r, "/account/bookings", http.StatusSeeOther)
+	}
+
+	layout := "2006-01-02"
+	start, err := time.Parse(layout, r.FormValue("start_date"))
+	if err != nil {
+		fail("Choose the arrival date")
+		return
+	}
+	end, err := time.Parse(layout, r.FormValue("end_date"))
+	if err != nil {
+		fail("Choose the departure date")
+		return
+	}
+	if !end.After(start) {
+		fail("The departure must be after the arrival")
+		return
+	}
+	if !start.After(today()) {
+		fail("The arrival must be after today")
+		return
+	}
+
+	err = m.DB.ChangeReservationDates(r.Context(), res.ID, start, end)
+	if errors.Is(err, domain.ErrRoomUnavailable) {
+		fail("The room is not available on these dates")
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.Staff.Notify(r.Context(), models.Notification{
+		Title: "Reservation changed",
+		Body: fmt.Sprintf("%s %s moved the %s from %s to %s",
+			res.FirstName, res.LastName, res.Room.RoomName,
+			start.Format("2006-01-02"), end.Format("2006-01-02")),
+		Link: fmt.Sprintf("/admin/reservation/all/%d", res.ID),
+	})
+	m.publish(r.Context(), events.ReservationUpdated, res.ID)
+
+	m.App.Session.Put(r.Context(), "flash", "Your booking was changed")
+	http.Redirect(w, r, "/account/bookings", http.StatusSeeOther)
+}
+
+//analyticsMaxDays bounds the range of the analytics, a day per room is counted for occupancy
+const analyticsMaxDays = 731
+
+//analyticsRange reads the range and period of the analytics from the query string. The dates
+//are inclusive and default to the last 30 days, the returned to date is exclusive.
+func analyticsRange(r *http.Request) (time.Time, time.Time, string, error) {
+	v := r.URL.Query()
+
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, 0, -29)
+	period := models.PeriodDay
+
+	var err error
+	if s := v.Get("from"); s != "" {
+		if from, err = time.Parse("2006-01-02", s); err != nil {
+			return from, to, period, err
+		}
+	}
+	if s := v.Get("to"); s != "" {
+		if to, err = time.Parse("2006-01-02", s); err != nil {
+			return from, to, period, err
+		}
+	}
+	if s := v.Get("period"); s != "" {
+		known := false
+		for _, p := range models.Periods {
+			known = known || p == s
+		}
+		if !known {
+			return from, to, period, fmt.Errorf("unknown period %q", s)
+		}
+		period = s
+	}
+
+	to = to.AddDate(0, 0, 1)
+	if !from.Before(to) || to.Sub(from) > analyticsMaxDays*24*time.Hour {
+		return from, to, period, fmt.Errorf("invalid range from %s to %s", v.Get("from"), v.Get("to"))
+	}
+	return from, to, period, nil
+}
+
+//AdminDashboard shows the occupancy and booking analytics of the range in the query string,
+//the page loads the numbers from the analytics endpoints
+func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	from, to, period, err := analyticsRange(r)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	stringMap := map[string]string{
+		"from":   from.Format("2006-01-02"),
+		"to":     to.AddDate(0, 0, -1).Format("2006-01-02"),
+		"period": period,
+	}
+	query := url.Values{"from": {stringMap["from"]}, "to": {stringMap["to"]}, "period": {period}}.Encode()
+	for _, report := range []string{"occupancy", "restrictions"} {
+		for _, format := range export.Formats {
+			stringMap[report+"-"+format] = "/admin/export/" + report + "." + format + "?" + query
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["periods"] = models.Periods
+
+	render.Template(w, r, "admin-dashboard.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+//startExport checks the format in the route and starts the download of a report in it,
+//the file is named after the report and today
+func startExport(w http.ResponseWriter, r *http.Request, report string) (export.Writer, bool) {
+	format := chi.URLParam(r, "format")
+	out, err := export.New(format, w, report)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return nil, false
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s"`, report, time.Now().Format("2006-01-02"), format))
+	return out, true
+}
+
+//finishExport closes an export. The download has started, so a failure can only be logged
+//and the file is left unfinished for the client to notice.
+func finishExport(r *http.Request, report string, out export.Writer, err error) {
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("cannot write export", "report", report, "error", err)
+	}
+}
+
+//AdminExportReservations downloads the reservations filtered and sorted like the admin lists,
+//streamed as they are read
+func (m *Repository) AdminExportReservations(w http.ResponseWriter, r *http.Request) {
+	q, err := reservationQuery(r)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	q.After, q.Before = "", ""
+
+	out, ok := startExport(w, r, "reservations")
+	if !ok {
+		return
+	}
+
+	out.Write("id", "first_name", "last_name", "email", "phone", "room", "arrival", "departure", "nights", "adults", "children",
+		"status", "created_at")
+	err = m.DB.EachReservation(r.Context(), q, func(res models.Reservation) error {
+		return out.Write(
+			res.ID,
+			res.FirstName,
+			res.LastName,
+			res.Email,
+			res.Phone,
+			res.Room.RoomName,
+			res.StartDate.Format("2006-01-02"),
+			res.EndDate.Format("2006-01-02"),
+			int(res.EndDate.Sub(res.StartDate).Hours()/24),
+			res.Adults,
+			res.Children,
+			string(res.Status),
+			res.CreatedAt.Format("2006-01-02 15:04:05"),
+		)
+	})
+	finishExport(r, "reservations", out, err)
+}
+
+//AdminExportOccupancy downloads the occupancy of every room per period of the range in the
+//query string, like the dashboard shows it
+func (m *Repository) AdminExportOccupancy(w http.ResponseWriter, r *http.Request) {
+	from, to, period, err := analyticsRange(r)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	//the range is bounded, so the rows are few enough to read at once
+	occupancy, err := m.DB.Occupancy(r.Context(), from, to, period)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	out, ok := startExport(w, r, "occupancy")
+	if !ok {
+		return
+	}
+
+	out.Write("period", "room_id", "room", "nights", "booked", "rate")
+	for _, o := range occupancy {
+		if err = out.Write(o.Period.Format("2006-01-02"), o.RoomID, o.RoomName, o.Nights, o.Booked, rate(o.Booked, o.Nights)); err != nil {
+			break
+		}
+	}
+	finishExport(r, "occupancy", out, err)
+}
+
+//AdminExportRestrictions downloads the room restrictions of the range in the query string,
+//the stays and the owner blocks, streamed as they are read
+func (m *Repository) AdminExportRestrictions(w http.ResponseWriter, r *http.Request) {
+	from, to, _, err := analyticsRange(r)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	out, ok := startExport(w, r, "restrictions")
+	if !ok {
+		return
+	}
+
+	out.Write("id", "room_id", "room", "restriction", "start_date", "end_date", "reservation_id", "guest", "email")
+	err = m.DB.EachRoomRestriction(r.Context(), from, to, func(rr models.RoomRestriction) error {
+		guest := strings.TrimSpace(rr.Reservation.FirstName + " " + rr.Reservation.LastName)
+		return out.Write(
+			rr.ID,
+			rr.RoomID,
+			rr.Room.RoomName,
+			rr.Restriction.RestrictionName,
+			rr.StartDate.Format("2006-01-02"),
+			rr.EndDate.Format("2006-01-02"),
+			rr.ResevationID,
+			guest,
+			rr.Reservation.Email,
+		)
+	})
+	finishExport(r, "restrictions", out, err)
+}
+
+type occupancyResponse struct {
+	From   string          `json:"from"`
+	To     string          `json:"to"`
+	Period string          `json:"period"`
+	Labels []string        `json:"labels"`
+	Rooms  []roomOccupancy `json:"rooms"`
+	Rate   float64         `json:"rate"`
+}
+
+type roomOccupancy struct {
+	ID     int       `json:"id"`
+	Name   string    `json:"name"`
+	Nights []int     `json:"nights"`
+	Booked []int     `json:"booked"`
+	Rates  []float64 `json:"rates"`
+}
+
+//rate returns part of whole, zero when whole is
+func rate(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole)
+}
+
+//AdminOccupancy returns the occupancy rate of every room per period as json,
+//rate is the share of every night of every room booked in the range
+func (m *Repository) AdminOccupancy(w http.ResponseWriter, r *http.Request) {
+	from, to, period, err := analyticsRange(r)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	occupancy, err := m.DB.Occupancy(r.Context(), from, to, period)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	resp := occupancyResponse{
+		From:   from.Format("2006-01-02"),
+		To:     to.AddDate(0, 0, -1).Format("2006-01-02"),
+		Period: period,
+		Labels: []string{},
+		Rooms:  []roomOccupancy{},
+	}
+
+	//the rows come by period, then by room
+	rooms := make(map[int]int)
+	nights, booked := 0, 0
+	for _, o := range occupancy {
+		label := o.Period.Format("2006-01-02")
+		if len(resp.Labels) == 0 || resp.Labels[len(resp.Labels)-1] != label {
+			resp.Labels = append(resp.Labels, label)
+		}
+
+		i, ok := rooms[o.RoomID]
+		if !ok {
+			i = len(resp.Rooms)
+			rooms[o.RoomID] = i
+			resp.Rooms = append(resp.Rooms, roomOccupancy{ID: o.RoomID, Name: o.RoomName})
+		}
+		room := &resp.Rooms[i]
+		room.Nights = append(room.Nights, o.Nights)
+		room.Booked = append(room.Booked, o.Booked)
+		room.Rates = append(room.Rates, rate(o.Booked, o.Nights))
+
+		nights += o.Nights
+		booked += o.Booked
+	}
+	resp.Rate = rate(booked, nights)
+
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+type bookingsResponse struct {
+	From         string        `json:"from"`
+	To           string        `json:"to"`
+	Period       string        `json:"period"`
+	Labels       []string      `json:"labels"`
+	Reservations []int         `json:"reservations"`
+	Cancelled    []int         `json:"cancelled"`
+	Totals       bookingTotals `json:"totals"`
+}
+
+type bookingTotals struct {
+	Reservations     int     `json:"reservations"`
+	Cancelled        int     `json:"cancelled"`
+	CancellationRate float64 `json:"cancellation_rate"`
+	AverageStay      float64 `json:"average_stay"`
+	AverageLeadTime  float64 `json:"average_lead_time"`
+}
+
+//AdminBookings returns the reservations and cancellations arriving per period as json, with
+//the average length of stay in nights and lead time in days of the reservations not cancelled
+func (m *Repository) AdminBookings(w http.ResponseWriter, r *http.Request) {
+	from, to, period, err := analyticsRange(r)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	stats, err := m.DB.BookingStats(r.Context(), from, to, period)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	resp := bookingsResponse{
+		From:         from.Format("2006-01-02"),
+		To:           to.AddDate(0, 0, -1).Format("2006-01-02"),
+		Period:       period,
+		Labels:       []string{},
+		Reservations: []int{},
+		Cancelled:    []int{},
+	}
+
+	nights, leadDays := 0, 0
+	for _, s := range stats {
+		resp.Labels = append(resp.Labels, s.Period.Format("2006-01-02"))
+		resp.Reservations = append(resp.Reservations, s.Reservations)
+		resp.Cancelled = append(resp.Cancelled, s.Cancelled)
+
+		resp.Totals.Reservations += s.Reservations
+		resp.Totals.Cancelled += s.Cancelled
+		nights += s.Nights
+		leadDays += s.LeadDays
+	}
+	kept := resp.Totals.Reservations - resp.Totals.Cancelled
+	resp.Totals.CancellationRate = rate(resp.Totals.Cancelled, resp.Totals.Reservations)
+	resp.Totals.AverageStay = rate(nights, kept)
+	resp.Totals.AverageLeadTime = rate(leadDays, kept)
+
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+//reservationQuery reads the filters, sort and page of a reservation list from the query string
+func reservationQuery(r *http.Request) (models.ReservationQuery, error) {
+	v := r.URL.Query()
+	q := models.ReservationQuery{
+		Search: strings.TrimSpace(v.Get("q")),
+		Sort:   v.Get("sort"),
+		Desc:   v.Get("dir") == "desc",
+		After:  v.Get("after"),
+		Before: v.Get("before"),
+	}
+
+	var err error
+	if q.Statuses, err = domain.ParseStatuses(strings.Join(v["status"], ",")); err != nil {
+		return q, err
+	}
+	if q.Sort != "" {
+		known := false
+		for _, s := range models.ReservationSorts {
+			known = known || s == q.Sort
+		}
+		if !known {
+			return q, fmt.Errorf("unknown sort %q", q.Sort)
+		}
+	}
+	if s := v.Get("room"); s != "" {
+		if q.RoomID, err = strconv.Atoi(s); err != nil {
+			return q, err
+		}
+	}
+	if s := v.Get("size"); s != "" {
+		if q.Size, err = strconv.Atoi(s); err != nil || q.Size < 1 || q.Size > models.MaxPageSize {
+			return q, fmt.Errorf("invalid page size %q", s)
+		}
+	}
+	if s := v.Get("from"); s != "" {
+		if q.From, err = time.Parse("2006-01-02", s); err != nil {
+			return q, err
+		}
+	}
+	if s := v.Get("to"); s != "" {
+		if q.To, err = time.Parse("2006-01-02", s); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+//listURL returns the url of a list with the query string changed by set, an empty value
+//removes the parameter. The page cursors are dropped unless set again.
+func listURL(r *http.Request, set map[string]string) string {
+	v := r.URL.Query()
+	v.Del("after")
+	v.Del("before")
+	for key, value := range set {
+		if value == "" {
+			v.Del(key)
+		} else {
+			v.Set(key, value)
+		}
+	}
+	if len(v) == 0 {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + v.Encode()
+}
+
+//reservationList renders a page of the reservations in the query string on a list page,
+//statuses restrict a list that only ever shows some statuses
+func (m *Repository) reservationList(w http.ResponseWriter, r *http.Request, page string, statuses ...domain.Status) {
+	q, err := reservationQuery(r)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	filter := make(map[domain.Status]bool)
+	for _, s := range q.Statuses {
+		filter[s] = true
+	}
+	if len(statuses) > 0 {
+		q.Statuses = statuses
+	}
+
+	reservations, err := m.DB.ListReservations(r.Context(), q)
+	if errors.Is(err, dbrepo.ErrInvalidCursor) {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	sort := q.Sort
+	if sort == "" {
+		sort = models.SortByArrival
+	}
+	//the sorted column sorts the other way when clicked again
+	sortLinks := make(map[string]string)
+	for _, s := range models.ReservationSorts {
+		dir := ""
+		if s == sort && !q.Desc {
+			dir = "desc"
+		}
+		sortLinks[s] = listURL(r, map[string]string{"sort": s, "dir": dir})
+	}
+	sortMarks := map[string]string{sort: "\u25b2"}
+	if q.Desc {
+		sortMarks[sort] = "\u25bc"
+	}
+
+	stringMap := map[string]string{
+		"q":    q.Search,
+		"sort": sort,
+		"dir":  r.URL.Query().Get("dir"),
+		"size": r.URL.Query().Get("size"),
+		"from": r.URL.Query().Get("from"),
+		"to":   r.URL.Query().Get("to"),
+	}
+	if reservations.Next != "" {
+		stringMap["next"] = listURL(r, map[string]string{"after": reservations.Next})
+	}
+	if reservations.Prev != "" {
+		stringMap["prev"] = listURL(r, map[string]string{"before": reservations.Prev})
+	}
+
+	//the export has every reservation of the list, whatever the page
+	exportQuery := r.URL.Query()
+	for _, key := range []string{"after", "before", "size"} {
+		exportQuery.Del(key)
+	}
+	if len(statuses) > 0 {
+		exportQuery["status"] = statusNames(statuses)
+	}
+	for _, format := range export.Formats {
+		stringMap["export-"+format] = "/admin/export/reservations." + format + "?" + exportQuery.Encode()
+	}
+
+	data := make(map[string]interface{})
+
+	data["reservations"] = reservations.Reservations
+	data["statuses"] = domain.Statuses
+	data["filter"] = filter
+	data["rooms"] = rooms
+	data["room"] = q.RoomID
+	data["desc"] = q.Desc
+	data["sortLinks"] = sortLinks
+	data["sortMarks"] = sortMarks
+
+	render.Template(w, r, page, &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+//statusNames returns the names of the statuses for a query string
+func statusNames(statuses []domain.Status) []string {
+	names := make([]string, len(statuses))
+	for i, s := range statuses {
+		names[i] = string(s)
+	}
+	return names
+}
+
+//AdminAllReservations lists a page of the reservations, filtered, sorted and paged by the query string
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	m.reservationList(w, r, "admin-all-reservations.page.html")
+}
+
+//AdminNewReservations lists a page of the pending reservations
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	m.reservationList(w, r, "admin-new-reservations.page.html", domain.Pending)
+}
+
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[4])
+
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	src := exploded[3]
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	res, err := m.DB.GetReservationById(r.Context(), id)
+
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	history, err := m.DB.ReservationStatusHistory(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	var userIDs []int
+	for _, c := range history {
+		userIDs = append(userIDs, c.UserID)
+	}
+	changedBy := m.userNames(r.Context(), userIDs)
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["history"] = history
+	data["changedBy"] = changedBy
+	if res.GuestID != 0 {
+		guest, err := m.DB.GetGuestByID(r.Context(), res.GuestID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		data["guest"] = guest
+	}
+	if res.BookingID != 0 {
+		booking, err := m.DB.GetBooking(r.Context(), res.BookingID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		data["booking"] = booking
+	}
+	render.Template(w, r, "admin-show-reservation.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      forms.New(nil),
+	})
+}
+
+func (m *Repository) AdminPostReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "cannot perse form")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[4])
+
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	src := exploded[3]
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	res, err := m.DB.GetReservationById(r.Context(), id)
+
+	if err != nil {
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -525,45 +1948,752 @@ func (m *Repository) AdminPostReservation(w http.ResponseWriter, r *http.Request
 	res.Email = r.Form.Get("email")
 	res.Phone = r.Form.Get("phone")
 
-	err = m.DB.UpdateReservationById(res)
+	err = m.DB.UpdateReservationById(r.Context(), res)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.publish(r.Context(), events.ReservationUpdated, id)
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+}
+
+//AdminReservationStatus moves a reservation to the status posted by staff
+func (m *Repository) AdminReservationStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	src := chi.URLParam(r, "src")
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	to, err := domain.ParseStatus(r.Form.Get("status"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	err = m.DB.UpdateReservationStatus(r.Context(), id, to, userID)
+	if errors.Is(err, domain.ErrInvalidTransition) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("This reservation cannot be marked as %s", strings.ToLower(to.Label())))
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservation/%s/%d", src, id), http.StatusSeeOther)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.publish(r.Context(), events.ReservationStatusChanged, id)
+	metrics.ReservationStatusChanges.WithLabelValues(string(to)).Inc()
+	if to == domain.Cancelled {
+		metrics.ReservationsCancelled.Inc()
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation marked as %s", strings.ToLower(to.Label())))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+}
+
+//AdminCancelBooking cancels every room of the booking of a reservation that can still be cancelled
+func (m *Repository) AdminCancelBooking(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	src := chi.URLParam(r, "src")
+
+	res, err := m.DB.GetReservationById(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && res.BookingID == 0) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	ids, err := m.DB.CancelBooking(r.Context(), res.BookingID, userID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	for _, id := range ids {
+		m.publish(r.Context(), events.ReservationStatusChanged, id)
+	}
+	metrics.ReservationStatusChanges.WithLabelValues(string(domain.Cancelled)).Add(float64(len(ids)))
+	metrics.ReservationsCancelled.Add(float64(len(ids)))
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%d reservations of the booking cancelled", len(ids)))
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+}
+
+//AdminDeleteReservation moves a reservation to the trash, where it can be restored until it is purged
+func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	src := chi.URLParam(r, "src")
+
+	err = m.DB.DeleteReservationById(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.publish(r.Context(), events.ReservationDeleted, id)
+	metrics.ReservationsDeleted.Inc()
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation moved to the trash")
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+}
+
+//AdminDeletedReservations lists the reservations in the trash with the day each one is purged
+func (m *Repository) AdminDeletedReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.DeletedReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	purgeOn := make(map[int]time.Time)
+	for _, res := range reservations {
+		purgeOn[res.ID] = res.DeletedAt.Add(m.App.Reservations.TrashRetention)
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["purgeOn"] = purgeOn
+
+	render.Template(w, r, "admin-deleted-reservations.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+//AdminRestoreReservation takes a reservation out of the trash when its room is still free
+func (m *Repository) AdminRestoreReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.RestoreReservation(r.Context(), id)
+	if errors.Is(err, domain.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "The room was booked again on these dates, the reservation cannot be restored")
+		http.Redirect(w, r, "/admin/reservations-deleted", http.StatusSeeOther)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.publish(r.Context(), events.ReservationRestored, id)
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation restored")
+
+	http.Redirect(w, r, "/admin/reservations-deleted", http.StatusSeeOther)
+}
+
+//maxImportSize is the largest file the import page accepts
+const maxImportSize = 10 << 20
+
+//AdminImport shows the form to upload reservations from a CSV file
+func (m *Repository) AdminImport(w http.ResponseWriter, r *http.Request) {
+	m.renderImport(w, r, importer.Mapping{}, nil)
+}
+
+//renderImport shows the import form with the mapping and the outcome of the last check
+func (m *Repository) renderImport(w http.ResponseWriter, r *http.Request, mapping importer.Mapping, result *importer.Result) {
+	columns := make(map[string]string)
+	for field, column := range mapping {
+		columns[field] = column
+	}
+
+	data := make(map[string]interface{})
+	data["fields"] = importer.Fields
+	data["result"] = result
+	render.Template(w, r, "admin-import.page.html", &models.TemplateData{
+		StringMap: columns,
+		Data:      data,
+	})
+}
+
+//AdminPostImport checks the uploaded file and, unless it is a dry run, imports its
+//reservations when every row passed. The problems are kept for the error report.
+func (m *Repository) AdminPostImport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	err := r.ParseMultipartForm(maxImportSize)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	mapping := importer.Mapping{}
+	for _, field := range importer.Fields {
+		if column := strings.TrimSpace(r.Form.Get("map_" + field)); column != "" {
+			mapping[field] = column
+		}
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Choose a CSV file to import")
+		m.renderImport(w, r, mapping, nil)
+		return
+	}
+	defer file.Close()
+
+	imp := &importer.Importer{DB: m.DB, Mapping: mapping}
+	result, err := imp.Check(r.Context(), file)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Cannot read the file: %v", err))
+		m.renderImport(w, r, mapping, nil)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "import-problems", result.Problems)
+	if r.Form.Get("dry_run") != "" || !result.Valid() {
+		m.renderImport(w, r, mapping, result)
+		return
+	}
+
+	err = imp.Import(r.Context(), result)
+	if errors.Is(err, domain.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "A room was booked while the file was imported, nothing was imported")
+		m.renderImport(w, r, mapping, result)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("imported reservations", "count", len(result.IDs))
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Imported %d reservations", len(result.IDs)))
+	http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
+}
+
+//AdminImportReport downloads the problems of the last import check
+func (m *Repository) AdminImportReport(w http.ResponseWriter, r *http.Request) {
+	problems, ok := m.App.Session.Get(r.Context(), "import-problems").([]importer.Problem)
+	if !ok {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	out, ok := startExport(w, r, "import-errors")
+	if !ok {
+		return
+	}
+	finishExport(r, "import-errors", out, importer.WriteReport(out, problems))
+}
+
+//AdminGuests lists the guests, searched by name, email or phone number
+func (m *Repository) AdminGuests(w http.ResponseWriter, r *http.Request) {
+	search := strings.TrimSpace(r.URL.Query().Get("q"))
+	guests, err := m.DB.ListGuests(r.Context(), search)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["guests"] = guests
+	render.Template(w, r, "admin-guests.page.html", &models.TemplateData{
+		StringMap: map[string]string{"q": search},
+		Data:      data,
+	})
+}
+
+//guestFromRoute loads the guest with the id in the route, it answers the request itself when it cannot
+func (m *Repository) guestFromRoute(w http.ResponseWriter, r *http.Request) (models.Guest, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return models.Guest{}, false
+	}
+
+	guest, err := m.DB.GetGuestByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return guest, false
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return guest, false
+	}
+	return guest, true
+}
+
+//renderGuest shows the profile of a guest with the stays and the guests that look like duplicates
+func (m *Repository) renderGuest(w http.ResponseWriter, r *http.Request, guest models.Guest, form *forms.Form) {
+	stays, err := m.DB.GuestReservations(r.Context(), guest.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	duplicates, err := m.DB.DuplicateGuests(r.Context(), guest.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["guest"] = guest
+	data["stays"] = stays
+	data["duplicates"] = duplicates
+	data["tags"] = domain.Tags
+	render.Template(w, r, "admin-guest.page.html", &models.TemplateData{
+		StringMap: map[string]string{"tags": strings.Join(guest.Tags, ", ")},
+		Data:      data,
+		Form:      form,
+	})
+}
+
+//AdminShowGuest shows the profile of a guest
+func (m *Repository) AdminShowGuest(w http.ResponseWriter, r *http.Request) {
+	guest, ok := m.guestFromRoute(w, r)
+	if !ok {
+		return
+	}
+	m.renderGuest(w, r, guest, forms.New(nil))
+}
+
+//AdminPostGuest saves the details, notes and tags of a guest
+func (m *Repository) AdminPostGuest(w http.ResponseWriter, r *http.Request) {
+	guest, ok := m.guestFromRoute(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	guest.FirstName = strings.TrimSpace(r.Form.Get("first_name"))
+	guest.LastName = strings.TrimSpace(r.Form.Get("last_name"))
+	guest.Email = strings.TrimSpace(r.Form.Get("email"))
+	guest.Phone = strings.TrimSpace(r.Form.Get("phone"))
+	guest.Notes = strings.TrimSpace(r.Form.Get("notes"))
+	guest.Tags = domain.ParseTags(r.Form.Get("tags"))
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name")
+	if guest.Email != "" {
+		form.IsEmail("email")
+	}
+	if !form.Valid() {
+		m.renderGuest(w, r, guest, form)
+		return
+	}
+
+	err = m.DB.UpdateGuest(r.Context(), guest)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
+
 	m.App.Session.Put(r.Context(), "flash", "Changes saved")
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d", guest.ID), http.StatusSeeOther)
 }
 
-func (m *Repository) AdminApproveReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	src := chi.URLParam(r, "src")
+//AdminMergeGuest merges the posted duplicate into the guest, the duplicate is removed
+func (m *Repository) AdminMergeGuest(w http.ResponseWriter, r *http.Request) {
+	guest, ok := m.guestFromRoute(w, r)
+	if !ok {
+		return
+	}
 
-	err := m.DB.UpdateProcessedForReservation(1, id)
+	duplicate, err := strconv.Atoi(r.FormValue("duplicate"))
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.MergeGuests(r.Context(), guest.ID, duplicate)
+	if errors.Is(err, dbrepo.ErrSameGuest) {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Guest %d merged into this guest", duplicate))
+	http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d", guest.ID), http.StatusSeeOther)
+}
 
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+//userNames names the staff members with the given ids, zero is a change made from the command line
+func (m *Repository) userNames(ctx context.Context, ids []int) map[int]string {
+	names := map[int]string{0: "command line"}
+	for _, id := range ids {
+		if _, ok := names[id]; ok {
+			continue
+		}
+		user, err := m.DB.GetUserById(ctx, id)
+		if err != nil {
+			names[id] = fmt.Sprintf("user %d", id)
+			continue
+		}
+		names[id] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
+	}
+	return names
 }
 
-func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	src := chi.URLParam(r, "src")
+//auditViewLimit is how many entries the audit viewer shows, the export has them all
+const auditViewLimit = 200
+
+//auditRow is an audit entry with its changes decoded for the viewer
+type auditRow struct {
+	models.AuditEntry
+	Changes map[string]audit.Change
+}
+
+//auditFilter reads the audit filter from the query string
+func auditFilter(r *http.Request) (models.AuditFilter, error) {
+	q := r.URL.Query()
+	f := models.AuditFilter{
+		Action: q.Get("action"),
+		Entity: q.Get("entity"),
+	}
+
+	if f.Action != "" {
+		known := false
+		for _, a := range audit.Actions {
+			known = known || a == f.Action
+		}
+		if !known {
+			return f, fmt.Errorf("unknown action %q", f.Action)
+		}
+	}
+
+	var err error
+	if v := q.Get("user"); v != "" {
+		if f.UserID, err = strconv.Atoi(v); err != nil {
+			return f, err
+		}
+	}
+	if v := q.Get("entity_id"); v != "" {
+		if f.EntityID, err = strconv.Atoi(v); err != nil {
+			return f, err
+		}
+	}
+	if v := q.Get("from"); v != "" {
+		if f.From, err = time.Parse("2006-01-02", v); err != nil {
+			return f, err
+		}
+	}
+	//the to date is inclusive, the filter bound is not
+	if v := q.Get("to"); v != "" {
+		to, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return f, err
+		}
+		f.To = to.AddDate(0, 0, 1)
+	}
+	return f, nil
+}
+
+//AdminAudit shows the latest audit entries matching the filters in the query string
+func (m *Repository) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	filter.Limit = auditViewLimit
 
-	err := m.DB.DeleteReservationById(id)
+	entries, err := m.DB.AuditEntries(r.Context(), filter)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
+	var rows []auditRow
+	var userIDs []int
+	for _, e := range entries {
+		rows = append(rows, auditRow{AuditEntry: e, Changes: audit.Parse(e.Changes)})
+		userIDs = append(userIDs, e.UserID)
+	}
 
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	stringMap := make(map[string]string)
+	for _, key := range []string{"user", "action", "entity", "entity_id", "from", "to"} {
+		stringMap[key] = r.URL.Query().Get(key)
+	}
+	//the whole url is passed, a query string alone would be escaped by the template
+	stringMap["export"] = "/admin/audit/export?" + r.URL.RawQuery
+
+	data := make(map[string]interface{})
+	data["entries"] = rows
+	data["actions"] = audit.Actions
+	data["users"] = m.userNames(r.Context(), userIDs)
+	data["limit"] = auditViewLimit
+
+	render.Template(w, r, "admin-audit.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+//AdminAuditExport downloads every audit entry matching the filters in the query string as CSV
+func (m *Repository) AdminAuditExport(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := m.DB.AuditEntries(r.Context(), filter)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	var userIDs []int
+	for _, e := range entries {
+		userIDs = append(userIDs, e.UserID)
+	}
+	names := m.userNames(r.Context(), userIDs)
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "created_at", "user_id", "user", "ip", "action", "entity", "entity_id", "changes", "actor"})
+	for _, e := range entries {
+		cw.Write([]string{
+			strconv.Itoa(e.ID),
+			e.CreatedAt.Format(time.RFC3339),
+			strconv.Itoa(e.UserID),
+			names[e.UserID],
+			e.IP,
+			e.Action,
+			e.Entity,
+			strconv.Itoa(e.EntityID),
+			e.Changes,
+			e.Actor,
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		logging.FromContext(r.Context()).Error("cannot write audit export", "error", err)
+	}
 }
 
 func (m *Repository) AdminReservationsCalender(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "admin-reservations-calender.page.html", &models.TemplateData{})
 }
+
+type notificationsResponse struct {
+	Unread        int                    `json:"unread"`
+	Notifications []notificationResponse `json:"notifications"`
+}
+
+type notificationResponse struct {
+	ID        int    `json:"id"`
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+	Link      string `json:"link"`
+	Read      bool   `json:"read"`
+	CreatedAt string `json:"created_at"`
+}
+
+//AdminNotifications returns the latest staff notifications of the logged in user as json
+func (m *Repository) AdminNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+
+	notifications, err := m.DB.RecentNotifications(r.Context(), userID, 10)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	unread, err := m.DB.UnreadNotificationCount(r.Context(), userID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	resp := notificationsResponse{
+		Unread:        unread,
+		Notifications: []notificationResponse{},
+	}
+	for _, n := range notifications {
+		resp.Notifications = append(resp.Notifications, notificationResponse{
+			ID:        n.ID,
+			Title:     n.Title,
+			Body:      n.Body,
+			Link:      n.Link,
+			Read:      n.Read,
+			CreatedAt: n.CreatedAt.Format("2006-01-02 15:04"),
+		})
+	}
+
+	out, err := json.Marshal(resp)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+//AdminReadNotification marks a notification as read by the logged in user
+func (m *Repository) AdminReadNotification(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+
+	err = m.DB.MarkNotificationRead(r.Context(), id, userID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//AdminReadAllNotifications marks every notification as read by the logged in user
+func (m *Repository) AdminReadAllNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+
+	err := m.DB.MarkAllNotificationsRead(r.Context(), userID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//publish tells the live admin views that a reservation changed
+func (m *Repository) publish(ctx context.Context, eventType string, reservationID int) {
+	if m.App.Events == nil {
+		return
+	}
+
+	count, err := m.DB.CountReservationsByStatus(ctx, domain.Pending)
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot count new reservations", "error", err)
+		return
+	}
+
+	m.App.Events.Publish(events.Event{
+		Type:          eventType,
+		ReservationID: reservationID,
+		NewCount:      count,
+	})
+}
+
+//AdminEvents streams reservation events to the admin pages as server-sent events
+func (m *Repository) AdminEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		helpers.ServerError(w, r, errors.New("streaming is not supported"))
+		return
+	}
+
+	sub := m.App.Events.Subscribe()
+	defer sub.Close()
+
+	count, err := m.DB.CountReservationsByStatus(r.Context(), domain.Pending)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	writeEvent(w, events.Event{Type: "count", NewCount: count})
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				//dropped for falling behind, the browser will reconnect
+				return
+			}
+			writeEvent(w, e)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e events.Event) {
+	out, _ := json.Marshal(e)
+	fmt.Fprintf(w, "event: reservation\ndata: %s\n\n", out)
+}
+
+//Healthz reports that the process is alive
+func (m *Repository) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+//Readyz reports whether the app can serve requests, checking the database,
+//the template cache and the mail server
+func (m *Repository) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := health.Run(r.Context(), m.Checks)
+
+	status := http.StatusOK
+	if !report.OK() {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, r, status, report)
+}
+
+//Version returns the build version and commit
+func (m *Repository) Version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]string{
+		"version": version.Version,
+		"commit":  version.Commit,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	out, err := json.MarshalIndent(v, "", "     ")
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
@@ -1,16 +1,32 @@
 package handlers
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/ArmanurRahman/booking/internal/domain"
+	"github.com/ArmanurRahman/booking/internal/events"
+	"github.com/ArmanurRahman/booking/internal/health"
+	"github.com/ArmanurRahman/booking/internal/metrics"
 	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/ArmanurRahman/booking/internal/version"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 type postData struct {
@@ -202,33 +218,33 @@ func TestRepository_PostReservation(t *testing.T) {
 	reservation = models.Reservation{
 		StartDate: time.Now(),
 		EndDate:   time.Now(),
-		RoomID:    2,
+		RoomID:    1,
 	}
 
 	session.Put(ctx, "reservation", reservation)
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	handler = http.HandlerFunc(Repo.PostReservation)
+	restore := testDB.FailOn("InsertBooking", errors.New("some error"))
 	handler.ServeHTTP(rr, req)
+	restore()
 
 	if rr.Code != http.StatusTemporaryRedirect {
 		t.Errorf("Reservation handler return wrong response code for insetion reservation. Got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
 
-	//test for insetion restriction failure
-	reqBody = "first_name=mubeen"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=arman")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=arman@gmail.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=01012532")
+	//test for a room taken since it was chosen
+	start := time.Date(2067, 5, 10, 0, 0, 0, 0, time.UTC)
+	insertBooking(t, start, start.AddDate(0, 0, 2))
 	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
 	ctx = getCtx(req)
 	req = req.WithContext(ctx)
 	rr = httptest.NewRecorder()
 
 	reservation = models.Reservation{
-		StartDate: time.Now(),
-		EndDate:   time.Now(),
-		RoomID:    1000,
+		StartDate: start.AddDate(0, 0, 1),
+		EndDate:   start.AddDate(0, 0, 3),
+		RoomID:    1,
 	}
 
 	session.Put(ctx, "reservation", reservation)
@@ -237,8 +253,1716 @@ func TestRepository_PostReservation(t *testing.T) {
 	handler = http.HandlerFunc(Repo.PostReservation)
 	handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("Reservation handler return wrong response code for insetion restriction. Got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("Reservation handler return wrong response for a taken room. Got %d to %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if available, _ := testDB.SearchAvailabilityByDatesByRoomId(context.Background(), start.AddDate(0, 0, 2), start.AddDate(0, 0, 3), 1); !available {
+		t.Error("the reservation of a taken room was inserted")
+	}
+}
+
+func TestRepository_PostAvailability(t *testing.T) {
+	insertBooking(t, time.Date(2061, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2061, 3, 12, 0, 0, 0, 0, time.UTC))
+
+	var tests = []struct {
+		name               string
+		form               string
+		expectedStatusCode int
+		expectedRooms      []string
+		combined           bool
+	}{
+		{"one-adult", "start=2061-04-01&end=2061-04-03", http.StatusOK, []string{"General&#39;s Quarters", "Major&#39;s Suite"}, false},
+		{"family", "start=2061-04-01&end=2061-04-03&adults=2&children=1", http.StatusOK, []string{"Major&#39;s Suite"}, false},
+		{"room-taken", "start=2061-03-10&end=2061-03-12&adults=2", http.StatusOK, []string{"Major&#39;s Suite"}, false},
+		{"combined", "start=2061-04-01&end=2061-04-03&adults=4&children=2", http.StatusOK, []string{"General&#39;s Quarters", "Major&#39;s Suite"}, true},
+		{"too-big", "start=2061-03-10&end=2061-03-12&adults=4&children=2", http.StatusSeeOther, nil, false},
+		{"one-adult-per-room", "start=2061-04-01&end=2061-04-03&adults=1&children=5", http.StatusSeeOther, nil, false},
+		{"no-adults", "start=2061-04-01&end=2061-04-03&adults=0", http.StatusOK, nil, false},
+		{"too-many", "start=2061-04-01&end=2061-04-03&adults=15&children=10", http.StatusOK, nil, false},
+	}
+
+	for _, e := range tests {
+		rr, ctx := serveGuest(Repo.PostAvailability, "POST", "/search-availability", e.form, nil, nil)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		body := rr.Body.String()
+		for _, room := range e.expectedRooms {
+			if !strings.Contains(body, room) {
+				t.Errorf("for %s, expected %s to be offered", e.name, room)
+			}
+		}
+		if combined := strings.Contains(body, `id="combinations"`); combined != e.combined {
+			t.Errorf("for %s, expected combined to be %v", e.name, e.combined)
+		}
+		if e.expectedRooms == nil && e.expectedStatusCode == http.StatusOK && !strings.Contains(body, "Enter a number") && !strings.Contains(body, "at most") {
+			t.Errorf("for %s, expected the form to show the error", e.name)
+		}
+
+		if res, ok := session.Get(ctx, "reservation").(models.Reservation); ok && e.name == "family" && (res.Adults != 2 || res.Children != 1) {
+			t.Errorf("expected the party to be kept for the reservation, got %+v", res)
+		}
+	}
+}
+
+func TestRepository_AvailabilityJson(t *testing.T) {
+	var tests = []struct {
+		name      string
+		form      string
+		available bool
+	}{
+		{"no-party", "start=2062-01-01&end=2062-01-03&room_id=1", true},
+		{"fits", "start=2062-01-01&end=2062-01-03&room_id=1&adults=2", true},
+		{"too-big", "start=2062-01-01&end=2062-01-03&room_id=1&adults=2&children=1", false},
+	}
+
+	for _, e := range tests {
+		rr, _ := serveGuest(Repo.AvailabilityJson, "POST", "/search-availability-json", e.form, nil, nil)
+		var resp jsonResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.OK != e.available {
+			t.Errorf("for %s, expected available to be %v (%s)", e.name, e.available, resp.Message)
+		}
+	}
+}
+
+func TestRepository_ReservationParty(t *testing.T) {
+	//a room of a suggested combination is booked for the guests it sleeps
+	rr, ctx := serveGuest(Repo.Reservation, "GET", "/make-reservation", "", map[string]interface{}{
+		"reservation": models.Reservation{RoomID: 1, Adults: 4, Children: 2},
+	}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d but got %d", http.StatusOK, rr.Code)
+	}
+	res, _ := session.Get(ctx, "reservation").(models.Reservation)
+	if res.Adults != 2 || res.Children != 0 {
+		t.Errorf("expected the party to be cut to the room, got %d adults and %d children", res.Adults, res.Children)
+	}
+
+	var tests = []struct {
+		name               string
+		party              string
+		expectedStatusCode int
+	}{
+		{"too-big", "&adults=2&children=1", http.StatusOK},
+		{"invalid", "&adults=two", http.StatusOK},
+		{"fits", "&adults=1&children=1", http.StatusSeeOther},
+	}
+
+	for _, e := range tests {
+		start := time.Date(2062, 2, 1, 0, 0, 0, 0, time.UTC)
+		form := "first_name=Octavia&last_name=Guest&email=octavia@guest.com&phone=555" + e.party
+		rr, ctx := serveGuest(Repo.PostReservation, "POST", "/make-reservation", form, map[string]interface{}{
+			"reservation": models.Reservation{RoomID: 1, StartDate: start, EndDate: start.AddDate(0, 0, 2)},
+		}, nil)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedStatusCode == http.StatusSeeOther {
+			res, _ := session.Get(ctx, "reservation").(models.Reservation)
+			if res.Adults != 1 || res.Children != 1 {
+				t.Errorf("expected the party to be booked, got %d adults and %d children", res.Adults, res.Children)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminAllReservations(t *testing.T) {
+	var tests = []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+	}{
+		{"any-status", "", http.StatusOK},
+		{"one-status", "?status=pending", http.StatusOK},
+		{"many-statuses", "?status=pending&status=checked-in", http.StatusOK},
+		{"unknown-status", "?status=processed", http.StatusBadRequest},
+		{"filters", "?q=john+smith&room=1&from=2050-01-01&to=2050-12-31&size=50", http.StatusOK},
+		{"sorted", "?sort=last_name&dir=desc", http.StatusOK},
+		{"unknown-sort", "?sort=phone", http.StatusBadRequest},
+		{"invalid-size", "?size=1000", http.StatusBadRequest},
+		{"invalid-room", "?room=first", http.StatusBadRequest},
+		{"invalid-date", "?from=tomorrow", http.StatusBadRequest},
+		{"invalid-cursor", "?after=bogus", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reservations-all"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminAllReservations)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminReservationPages(t *testing.T) {
+	start := time.Date(2060, 3, 1, 0, 0, 0, 0, time.UTC)
+	var ids []int
+	for i := 0; i < 3; i++ {
+		ids = append(ids, insertBooking(t, start.AddDate(0, 0, i*3), start.AddDate(0, 0, i*3+1)))
+	}
+
+	get := func(url string) string {
+		req, _ := http.NewRequest("GET", url, nil)
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminAllReservations)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("for %s, expected %d but got %d", url, http.StatusOK, rr.Code)
+		}
+		return rr.Body.String()
+	}
+	link := regexp.MustCompile(`href="([^"]*(after|before)=[^"]*)"`)
+	pageLink := func(body, cursor string) string {
+		for _, m := range link.FindAllStringSubmatch(body, -1) {
+			if m[2] == cursor {
+				return html.UnescapeString(m[1])
+			}
+		}
+		return ""
+	}
+	shows := func(body string, id int) bool {
+		return strings.Contains(body, fmt.Sprintf("/admin/reservation/all/%d\"", id))
+	}
+
+	first := get("/admin/reservations-all?from=2060-03-01&to=2060-03-31&size=2")
+	if !shows(first, ids[0]) || !shows(first, ids[1]) || shows(first, ids[2]) {
+		t.Fatal("expected the first page to show the first two reservations")
+	}
+	if pageLink(first, "before") != "" {
+		t.Error("expected no previous page on the first page")
+	}
+
+	next := pageLink(first, "after")
+	if !strings.HasPrefix(next, "/admin/reservations-all?") || !strings.Contains(next, "size=2") {
+		t.Fatalf("expected a next page link keeping the filters, got %q", next)
+	}
+	second := get(next)
+	if shows(second, ids[0]) || !shows(second, ids[2]) || pageLink(second, "after") != "" {
+		t.Error("expected the second page to show only the last reservation")
+	}
+
+	prev := pageLink(second, "before")
+	if prev == "" {
+		t.Fatal("expected a previous page link on the second page")
+	}
+	if back := get(prev); !shows(back, ids[0]) || !shows(back, ids[1]) || shows(back, ids[2]) {
+		t.Error("expected the previous page to be the first page again")
+	}
+}
+
+func TestRepository_AdminNewReservations(t *testing.T) {
+	var tests = []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+	}{
+		{"first-page", "", http.StatusOK},
+		{"sorted", "?sort=departure&size=10", http.StatusOK},
+		{"invalid-cursor", "?before=bogus", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reservations-new"+e.query, nil)
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminNewReservations)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminReservationStatus(t *testing.T) {
+	id, err := testDB.InsertReservation(context.Background(), models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		StartDate: time.Now(),
+		EndDate:   time.Now().AddDate(0, 0, 2),
+		RoomID:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name               string
+		id                 string
+		status             string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"confirm", strconv.Itoa(id), "confirmed", http.StatusSeeOther, "/admin/reservations-new"},
+		{"invalid-transition", strconv.Itoa(id), "pending", http.StatusSeeOther, fmt.Sprintf("/admin/reservation/new/%d", id)},
+		{"unknown-status", strconv.Itoa(id), "processed", http.StatusBadRequest, ""},
+		{"invalid-id", "abc", "confirmed", http.StatusBadRequest, ""},
+		{"missing-reservation", strconv.Itoa(id + 1000), "confirmed", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/reservation/new/"+e.id+"/status", strings.NewReader("status="+e.status))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 1)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "new")
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReservationStatus)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("for %s, expected a redirect to %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+
+	history, err := testDB.ReservationStatusHistory(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].To != domain.Confirmed || history[0].UserID != 1 {
+		t.Errorf("expected one change to confirmed by user 1, got %+v", history)
+	}
+}
+
+//insertBooking inserts a reservation of room 1 with the restriction that blocks the room
+func insertBooking(t *testing.T, start, end time.Time) int {
+	ctx := context.Background()
+
+	id, err := testDB.InsertReservation(ctx, models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		StartDate: start,
+		EndDate:   end,
+		RoomID:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = testDB.InsetIntoRoomRestriction(ctx, models.RoomRestriction{
+		StartDate:     start,
+		EndDate:       end,
+		RoomID:        1,
+		ResevationID:  id,
+		RestrictionID: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+//postAdmin posts an empty form to an admin handler with the chi url params set
+func postAdmin(handler http.HandlerFunc, url string, params map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", url, strings.NewReader(""))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRepository_AdminDeleteReservation(t *testing.T) {
+	start := time.Date(2051, 3, 10, 0, 0, 0, 0, time.UTC)
+	id := insertBooking(t, start, start.AddDate(0, 0, 2))
+
+	var tests = []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"delete", strconv.Itoa(id), http.StatusSeeOther},
+		{"already-deleted", strconv.Itoa(id), http.StatusNotFound},
+		{"invalid-id", "abc", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		rr := postAdmin(Repo.AdminDeleteReservation, "/admin/reservation/all/"+e.id+"/delete", map[string]string{"src": "all", "id": e.id})
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusSeeOther && rr.Header().Get("Location") != "/admin/reservations-all" {
+			t.Errorf("for %s, expected a redirect to /admin/reservations-all but got %s", e.name, rr.Header().Get("Location"))
+		}
+	}
+
+	available, err := testDB.SearchAvailabilityByDatesByRoomId(context.Background(), start, start.AddDate(0, 0, 2), 1)
+	if err != nil || !available {
+		t.Errorf("expected the delete to release the room, got %v (%v)", available, err)
+	}
+}
+
+func TestRepository_ReservationsCancelledMetric(t *testing.T) {
+	start := time.Date(2051, 8, 10, 0, 0, 0, 0, time.UTC)
+	cancelled, deleted := testutil.ToFloat64(metrics.ReservationsCancelled), testutil.ToFloat64(metrics.ReservationsDeleted)
+
+	id := strconv.Itoa(insertBooking(t, start, start.AddDate(0, 0, 2)))
+	rr := postAdmin(Repo.AdminDeleteReservation, "/admin/reservation/all/"+id+"/delete", map[string]string{"src": "all", "id": id})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if got := testutil.ToFloat64(metrics.ReservationsDeleted) - deleted; got != 1 {
+		t.Errorf("expected 1 deleted reservation, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.ReservationsCancelled) - cancelled; got != 0 {
+		t.Errorf("expected the trash not to count as cancelled, got %v", got)
+	}
+
+	id = strconv.Itoa(insertBooking(t, start, start.AddDate(0, 0, 2)))
+	rr, _ = serveGuest(Repo.AdminReservationStatus, "POST", "/admin/reservation/all/"+id+"/status", "status=cancelled",
+		map[string]interface{}{"user_id": 1}, map[string]string{"src": "all", "id": id})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if got := testutil.ToFloat64(metrics.ReservationsCancelled) - cancelled; got != 1 {
+		t.Errorf("expected 1 cancelled reservation, got %v", got)
+	}
+
+	booking := insertGroup(t, "Ottoline", "ottoline@guest.com", start.AddDate(0, 0, 5))
+	id = strconv.Itoa(booking.Reservations[0].ID)
+	rr = postAdmin(Repo.AdminCancelBooking, "/admin/reservation/all/"+id+"/cancel-booking", map[string]string{"src": "all", "id": id})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if got := testutil.ToFloat64(metrics.ReservationsCancelled) - cancelled; got != 3 {
+		t.Errorf("expected every room of the booking to be counted, got %v", got)
+	}
+}
+
+func TestRepository_AdminDeletedReservations(t *testing.T) {
+	start := time.Date(2051, 4, 10, 0, 0, 0, 0, time.UTC)
+	id := insertBooking(t, start, start.AddDate(0, 0, 2))
+	if err := testDB.DeleteReservationById(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/reservations-deleted", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminDeletedReservations)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	defer testDB.FailOn("DeletedReservations", errors.New("connection refused"))()
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected %d when the trash cannot be loaded but got %d", http.StatusInternalServerError, rr.Code)
+	}
+}
+
+func TestRepository_AdminRestoreReservation(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2051, 5, 10, 0, 0, 0, 0, time.UTC)
+
+	restored := insertBooking(t, start, start.AddDate(0, 0, 2))
+	blocked := insertBooking(t, start.AddDate(0, 1, 0), start.AddDate(0, 1, 2))
+	for _, id := range []int{restored, blocked} {
+		if err := testDB.DeleteReservationById(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insertBooking(t, start.AddDate(0, 1, 1), start.AddDate(0, 1, 3))
+
+	var tests = []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"restore", strconv.Itoa(restored), http.StatusSeeOther},
+		{"room-taken", strconv.Itoa(blocked), http.StatusSeeOther},
+		{"not-deleted", strconv.Itoa(restored), http.StatusNotFound},
+		{"invalid-id", "abc", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		rr := postAdmin(Repo.AdminRestoreReservation, "/admin/reservations-deleted/"+e.id+"/restore", map[string]string{"id": e.id})
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusSeeOther && rr.Header().Get("Location") != "/admin/reservations-deleted" {
+			t.Errorf("for %s, expected a redirect to the trash but got %s", e.name, rr.Header().Get("Location"))
+		}
+	}
+
+	if _, err := testDB.GetReservationById(ctx, restored); err != nil {
+		t.Errorf("expected reservation %d to be restored, got %v", restored, err)
+	}
+	if _, err := testDB.GetReservationById(ctx, blocked); err == nil {
+		t.Errorf("expected reservation %d to stay in the trash", blocked)
+	}
+}
+
+func TestRepository_AdminDashboard(t *testing.T) {
+	var tests = []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+	}{
+		{"last-30-days", "", http.StatusOK},
+		{"range", "?from=2061-01-01&to=2061-03-31&period=week", http.StatusOK},
+		{"unknown-period", "?period=year", http.StatusBadRequest},
+		{"invalid-date", "?from=someday", http.StatusBadRequest},
+		{"backwards", "?from=2061-02-01&to=2061-01-01", http.StatusBadRequest},
+		{"too-long", "?from=2061-01-01&to=2065-01-01", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/dashboard"+e.query, nil)
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDashboard)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminAnalytics(t *testing.T) {
+	start := time.Date(2061, 1, 3, 0, 0, 0, 0, time.UTC)
+	insertBooking(t, start, start.AddDate(0, 0, 3))
+	cancelled := insertBooking(t, start.AddDate(0, 0, 5), start.AddDate(0, 0, 6))
+	if err := testDB.UpdateReservationStatus(context.Background(), cancelled, domain.Cancelled, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(handler http.HandlerFunc, url string, v interface{}) int {
+		req, _ := http.NewRequest("GET", url, nil)
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr.Code
+	}
+
+	var occupancy occupancyResponse
+	code := get(Repo.AdminOccupancy, "/admin/analytics/occupancy?from=2061-01-03&to=2061-01-12&period=day", &occupancy)
+	if code != http.StatusOK {
+		t.Fatalf("expected %d but got %d", http.StatusOK, code)
+	}
+	if len(occupancy.Labels) != 10 || occupancy.Labels[0] != "2061-01-03" || len(occupancy.Rooms) != 2 {
+		t.Fatalf("expected 10 days of 2 rooms, got %+v", occupancy)
+	}
+	if room := occupancy.Rooms[0]; room.ID != 1 || room.Rates[0] != 1 || room.Booked[3] != 0 || room.Booked[5] != 0 {
+		t.Errorf("expected room 1 booked for 3 nights, got %+v", room)
+	}
+	if occupancy.Rate != 0.15 {
+		t.Errorf("expected 3 of 20 nights booked, got %v", occupancy.Rate)
+	}
+
+	var bookings bookingsResponse
+	code = get(Repo.AdminBookings, "/admin/analytics/bookings?from=2061-01-01&to=2061-01-31&period=month", &bookings)
+	if code != http.StatusOK {
+		t.Fatalf("expected %d but got %d", http.StatusOK, code)
+	}
+	if len(bookings.Labels) != 1 || bookings.Labels[0] != "2061-01-01" || bookings.Reservations[0] != 2 || bookings.Cancelled[0] != 1 {
+		t.Errorf("expected one month with 2 arrivals and 1 cancellation, got %+v", bookings)
+	}
+	if bookings.Totals.CancellationRate != 0.5 || bookings.Totals.AverageStay != 3 || bookings.Totals.AverageLeadTime <= 0 {
+		t.Errorf("unexpected totals %+v", bookings.Totals)
+	}
+
+	if code := get(Repo.AdminOccupancy, "/admin/analytics/occupancy?period=quarter", nil); code != http.StatusBadRequest {
+		t.Errorf("expected %d for an unknown period, got %d", http.StatusBadRequest, code)
+	}
+	if code := get(Repo.AdminBookings, "/admin/analytics/bookings?to=2061-13-01", nil); code != http.StatusBadRequest {
+		t.Errorf("expected %d for an invalid date, got %d", http.StatusBadRequest, code)
+	}
+}
+
+//getExport downloads a report in a format through its export handler
+func getExport(handler http.HandlerFunc, url, format string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", url, nil)
+	ctx := getCtx(req)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("format", format)
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRepository_AdminExportReservations(t *testing.T) {
+	start := time.Date(2062, 5, 1, 0, 0, 0, 0, time.UTC)
+	first := insertBooking(t, start, start.AddDate(0, 0, 2))
+	second := insertBooking(t, start.AddDate(0, 0, 10), start.AddDate(0, 0, 13))
+
+	rr := getExport(Repo.AdminExportReservations, "/admin/export/reservations.csv?from=2062-05-01&to=2062-05-31&sort=arrival&dir=desc&after=bogus&size=1", "csv")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("expected a CSV download, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rr.Header().Get("Content-Disposition"), `filename="reservations-`) {
+		t.Errorf("unexpected Content-Disposition %q", rr.Header().Get("Content-Disposition"))
+	}
+
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "id,first_name,last_name") {
+		t.Fatalf("expected a header and both reservations whatever the page, got %q", rr.Body.String())
+	}
+	if !strings.HasPrefix(lines[1], strconv.Itoa(second)+",John,Smith") || !strings.Contains(lines[1], "2062-05-11,2062-05-14,3,0,0,pending") {
+		t.Errorf("expected the later reservation first, got %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], strconv.Itoa(first)+",") {
+		t.Errorf("expected the earlier reservation last, got %q", lines[2])
+	}
+
+	rr = getExport(Repo.AdminExportReservations, "/admin/export/reservations.xlsx?from=2062-05-01&to=2062-05-31", "xlsx")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" {
+		t.Fatalf("expected an Excel download, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if _, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len())); err != nil {
+		t.Errorf("expected a workbook, got %v", err)
+	}
+
+	if rr := getExport(Repo.AdminExportReservations, "/admin/export/reservations.pdf", "pdf"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %d for an unknown format, got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr := getExport(Repo.AdminExportReservations, "/admin/export/reservations.csv?status=lost", "csv"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for an unknown status, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestRepository_AdminExportReports(t *testing.T) {
+	start := time.Date(2062, 8, 1, 0, 0, 0, 0, time.UTC)
+	id := insertBooking(t, start, start.AddDate(0, 0, 2))
+
+	rr := getExport(Repo.AdminExportOccupancy, "/admin/export/occupancy.csv?from=2062-08-01&to=2062-08-02", "csv")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d but got %d", http.StatusOK, rr.Code)
+	}
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if lines[0] != "period,room_id,room,nights,booked,rate" {
+		t.Errorf("unexpected header %q", lines[0])
+	}
+	if !strings.Contains(rr.Body.String(), "\n2062-08-02,1,General's Quarters,1,1,1\n") {
+		t.Errorf("expected the booked night in the report, got %q", rr.Body.String())
+	}
+
+	rr = getExport(Repo.AdminExportRestrictions, "/admin/export/restrictions.csv?from=2062-08-01&to=2062-08-31", "csv")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d but got %d", http.StatusOK, rr.Code)
+	}
+	lines = strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[1], fmt.Sprintf("Reservation,2062-08-01,2062-08-03,%d,John Smith,", id)) {
+		t.Errorf("expected the stay in the restrictions, got %q", rr.Body.String())
+	}
+
+	if rr := getExport(Repo.AdminExportOccupancy, "/admin/export/occupancy.csv?period=year", "csv"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for an unknown period, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if rr := getExport(Repo.AdminExportRestrictions, "/admin/export/restrictions.ods", "ods"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %d for an unknown format, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+//postImport uploads a file to the import page with the other form fields
+func postImport(t *testing.T, file string, fields map[string]string) (*httptest.ResponseRecorder, context.Context) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	if file != "" {
+		fw, err := mw.CreateFormFile("file", "bookings.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(file))
+	}
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", "/admin/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminPostImport).ServeHTTP(rr, req)
+	return rr, ctx
+}
+
+func TestRepository_AdminImport(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/import", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminImport).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `name="map_start_date"`) {
+		t.Errorf("expected the upload form, got %d", rr.Code)
+	}
+
+	file := "Guest,Surname,Mail,Room,Arrival,Departure\n" +
+		"Mary,Major,mary@major.com,Major's Suite,2063-03-01,2063-03-04\n" +
+		"Mary,Major,mary@major.com,2,2063-03-10,2063-03-12\n"
+	mapping := map[string]string{
+		"map_first_name": "Guest", "map_last_name": "Surname", "map_email": "Mail",
+		"map_start_date": "Arrival", "map_end_date": "Departure",
+	}
+	count, _ := testDB.CountReservationsByStatus(context.Background())
+
+	dryRun := map[string]string{"dry_run": "1"}
+	for k, v := range mapping {
+		dryRun[k] = v
+	}
+	rr, _ = postImport(t, file, dryRun)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "2 rows read, 2 can be imported.") {
+		t.Errorf("expected the dry run to pass, got %d", rr.Code)
+	}
+	if after, _ := testDB.CountReservationsByStatus(context.Background()); after != count {
+		t.Errorf("expected a dry run to import nothing, got %d reservations instead of %d", after, count)
+	}
+
+	rr, _ = postImport(t, file, mapping)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/reservations-all" {
+		t.Fatalf("expected a redirect after the import, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if after, _ := testDB.CountReservationsByStatus(context.Background(), domain.Confirmed); after < 2 {
+		t.Errorf("expected the reservations to be imported as confirmed, got %d", after)
+	}
+
+	rr, ctx := postImport(t, file, mapping)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "The room is already booked or blocked on these dates") {
+		t.Errorf("expected the second import to clash with the first, got %d", rr.Code)
+	}
+	if after, _ := testDB.CountReservationsByStatus(context.Background()); after != count+2 {
+		t.Errorf("expected the clashing import to insert nothing, got %d reservations instead of %d", after, count+2)
+	}
+
+	req, _ = http.NewRequest("GET", "/admin/import/report.csv", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("format", "csv")
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminImportReport).ServeHTTP(rr, req)
+	expected := "line,field,problem\n2,room,The room is already booked or blocked on these dates\n" +
+		"3,room,The room is already booked or blocked on these dates\n"
+	if rr.Code != http.StatusOK || rr.Body.String() != expected {
+		t.Errorf("expected the error report %q, got %d %q", expected, rr.Code, rr.Body.String())
+	}
+
+	if rr := getExport(Repo.AdminImportReport, "/admin/import/report.csv", "csv"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected %d without a check in the session, got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr, _ := postImport(t, "", mapping); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Choose a CSV file") {
+		t.Errorf("expected the form again without a file, got %d", rr.Code)
+	}
+	if rr, _ := postImport(t, file, nil); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Cannot read the file") {
+		t.Errorf("expected the form again for a file without the columns, got %d", rr.Code)
+	}
+}
+
+//serveGuest serves a request to a guest handler with the session values and chi url params set,
+//and returns the context holding the session after the request
+func serveGuest(handler http.HandlerFunc, method, url, form string, put map[string]interface{}, params map[string]string) (*httptest.ResponseRecorder, context.Context) {
+	req, _ := http.NewRequest(method, url, strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	for k, v := range put {
+		session.Put(ctx, k, v)
+	}
+
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr, ctx
+}
+
+//signUp signs a guest up and verifies the account, it returns the id of the account
+func signUp(t *testing.T, first, email string) int {
+	form := fmt.Sprintf("first_name=%s&last_name=Guest&email=%s&password=secret-password&password_confirm=secret-password", first, email)
+	rr, _ := serveGuest(Repo.PostAccountSignup, "POST", "/account/signup", form, nil, nil)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("cannot sign up %s, got %d", email, rr.Code)
+	}
+
+	account, err := testDB.AuthenticateGuest(context.Background(), email, "secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.RenewGuestVerification(context.Background(), account.ID, hashToken("token-"+email), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	rr, _ = serveGuest(Repo.AccountVerify, "GET", "/account/verify?token="+url.QueryEscape("token-"+email), "", nil, nil)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("cannot verify %s, got %d", email, rr.Code)
+	}
+	return account.ID
+}
+
+func TestRepository_PostAccountSignup(t *testing.T) {
+	var tests = []struct {
+		name               string
+		form               string
+		expectedStatusCode int
+	}{
+		{"valid", "first_name=Rosalind&last_name=Guest&email=rosalind@guest.com&password=secret-password&password_confirm=secret-password", http.StatusSeeOther},
+		{"existing-account", "first_name=Rosalind&last_name=Guest&email=Rosalind@guest.com&password=secret-password&password_confirm=secret-password", http.StatusOK},
+		{"short-password", "first_name=Rosalind&last_name=Guest&email=rosa@guest.com&password=secret&password_confirm=secret", http.StatusOK},
+		{"passwords-differ", "first_name=Rosalind&last_name=Guest&email=rosa@guest.com&password=secret-password&password_confirm=secret-passwort", http.StatusOK},
+		{"invalid-email", "first_name=Rosalind&last_name=Guest&email=rosa&password=secret-password&password_confirm=secret-password", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		rr, _ := serveGuest(Repo.PostAccountSignup, "POST", "/account/signup", e.form, nil, nil)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	account, err := testDB.AuthenticateGuest(context.Background(), "rosalind@guest.com", "secret-password")
+	if err != nil || account.Verified() || account.VerifyToken == "" {
+		t.Errorf("expected an account waiting for verification, got %+v (%v)", account, err)
+	}
+}
+
+func TestRepository_AccountVerify(t *testing.T) {
+	rr, _ := serveGuest(Repo.AccountVerify, "GET", "/account/verify?token=made-up", "", nil, nil)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/account/login" {
+		t.Errorf("expected a redirect to the login for an unknown token, got %d", rr.Code)
+	}
+
+	id := signUp(t, "Ferdinand", "ferdinand@guest.com")
+	account, err := testDB.GetGuestAccount(context.Background(), id)
+	if err != nil || !account.Verified() {
+		t.Errorf("expected the account to be verified, got %+v (%v)", account, err)
+	}
+}
+
+func TestRepository_PostAccountLogin(t *testing.T) {
+	id := signUp(t, "Gwendolyn", "gwendolyn@guest.com")
+
+	rr, ctx := serveGuest(Repo.PostAccountLogin, "POST", "/account/login", "email=gwendolyn@guest.com&password=wrong-password", nil, nil)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/account/login" || session.Exists(ctx, "guest_account_id") {
+		t.Errorf("expected a wrong password to be refused, got %d", rr.Code)
+	}
+
+	rr, ctx = serveGuest(Repo.PostAccountLogin, "POST", "/account/login", "email=gwendolyn@guest.com&password=secret-password",
+		map[string]interface{}{"user_id": 1}, nil)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/account/bookings" {
+		t.Errorf("expected a redirect to the bookings, got %d to %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if session.GetInt(ctx, "guest_account_id") != id || session.Exists(ctx, "user_id") {
+		t.Errorf("expected only the guest to be logged in")
+	}
+
+	form := "first_name=Horatio&last_name=Guest&email=horatio@guest.com&password=secret-password&password_confirm=secret-password"
+	serveGuest(Repo.PostAccountSignup, "POST", "/account/signup", form, nil, nil)
+	before, err := testDB.AuthenticateGuest(context.Background(), "horatio@guest.com", "secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, ctx = serveGuest(Repo.PostAccountLogin, "POST", "/account/login", "email=horatio@guest.com&password=secret-password", nil, nil)
+	if rr.Code != http.StatusSeeOther || session.Exists(ctx, "guest_account_id") {
+		t.Errorf("expected an unverified account to be refused, got %d", rr.Code)
+	}
+	after, err := testDB.AuthenticateGuest(context.Background(), "horatio@guest.com", "secret-password")
+	if err != nil || after.VerifyToken == before.VerifyToken {
+		t.Errorf("expected a new verification link to be sent (%v)", err)
+	}
+}
+
+func TestRepository_MyBookings(t *testing.T) {
+	id := signUp(t, "Isolde", "isolde@guest.com")
+	account, err := testDB.GetGuestAccount(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().AddDate(2, 0, 0)
+	insertGuest(t, "Isolde", "isolde@guest.com", "", start)
+	insertGuest(t, "Isolde", "isolde@guest.com", "", time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC))
+
+	rr, _ := serveGuest(Repo.MyBookings, "GET", "/account/bookings", "", map[string]interface{}{"guest_account_id": id}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d but got %d", http.StatusOK, rr.Code)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, start.Format("2006-01-02")) || !strings.Contains(body, "2020-04-01") {
+		t.Errorf("expected the upcoming and the past stay of guest %d to be listed", account.GuestID)
+	}
+	if strings.Count(body, "Cancel booking") != 1 {
+		t.Errorf("expected only the upcoming stay to be cancellable")
+	}
+
+	rr, _ = serveGuest(Repo.MyBookings, "GET", "/account/bookings", "", map[string]interface{}{"guest_account_id": id + 1000}, nil)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/account/login" {
+		t.Errorf("expected a missing account to be sent to the login, got %d", rr.Code)
+	}
+}
+
+func TestRepository_CancelMyBooking(t *testing.T) {
+	id := signUp(t, "Jocasta", "jocasta@guest.com")
+	start := time.Now().AddDate(2, 1, 0)
+	insertGuest(t, "Jocasta", "jocasta@guest.com", "", start)
+	stays, err := testDB.GuestReservations(context.Background(), insertGuest(t, "Jocasta", "jocasta@guest.com", "", start.AddDate(0, 0, 5)))
+	if err != nil || len(stays) != 2 {
+		t.Fatalf("expected 2 stays, got %d (%v)", len(stays), err)
+	}
+	own := stays[0].ID
+	other := insertBooking(t, start.AddDate(0, 0, 10), start.AddDate(0, 0, 12))
+
+	var tests = []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"other-guest", strconv.Itoa(other), http.StatusNotFound, ""},
+		{"invalid-id", "abc", http.StatusBadRequest, ""},
+		{"own", strconv.Itoa(own), http.StatusSeeOther, "/account/bookings"},
+		{"already-cancelled", strconv.Itoa(own), http.StatusSeeOther, "/account/bookings"},
+	}
+
+	for _, e := range tests {
+		rr, _ := serveGuest(Repo.CancelMyBooking, "POST", "/account/bookings/"+e.id+"/cancel", "",
+			map[string]interface{}{"guest_account_id": id}, map[string]string{"id": e.id})
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("for %s, expected a redirect to %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+
+	res, err := testDB.GetReservationById(context.Background(), own)
+	if err != nil || res.Status != domain.Cancelled {
+		t.Errorf("expected the booking to be cancelled, got %s (%v)", res.Status, err)
+	}
+	if res, err := testDB.GetReservationById(context.Background(), other); err != nil || res.Status != domain.Pending {
+		t.Errorf("expected the booking of another guest to stay pending, got %s (%v)", res.Status, err)
+	}
+}
+
+func TestRepository_MyBookingsLinkedByPhone(t *testing.T) {
+	id := signUp(t, "Wilhelmina", "wilhelmina@guest.com")
+	account, err := testDB.GetGuestAccount(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest, err := testDB.GetGuestByID(context.Background(), account.GuestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest.Phone = "+44 20 7946 0958"
+	if err := testDB.UpdateGuest(context.Background(), guest); err != nil {
+		t.Fatal(err)
+	}
+
+	//a stranger booking with the phone number the account claims is linked to its guest
+	start := time.Now().AddDate(2, 6, 0)
+	if guestID := insertGuest(t, "Stranger", "stranger@other.com", "+44 20 7946 0958", start); guestID != account.GuestID {
+		t.Fatalf("expected the booking to be linked by phone to guest %d, got %d", account.GuestID, guestID)
+	}
+	stays, err := testDB.GuestReservations(context.Background(), account.GuestID)
+	if err != nil || len(stays) != 1 {
+		t.Fatalf("expected 1 stay, got %d (%v)", len(stays), err)
+	}
+	other := strconv.Itoa(stays[0].ID)
+
+	rr, _ := serveGuest(Repo.MyBookings, "GET", "/account/bookings", "", map[string]interface{}{"guest_account_id": id}, nil)
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), start.Format("2006-01-02")) {
+		t.Errorf("expected the booking of the stranger not to be listed, got %d", rr.Code)
+	}
+	rr, _ = serveGuest(Repo.CancelMyBooking, "POST", "/account/bookings/"+other+"/cancel", "",
+		map[string]interface{}{"guest_account_id": id}, map[string]string{"id": other})
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected the booking of the stranger to be missing, got %d", rr.Code)
+	}
+	if res, err := testDB.GetReservationById(context.Background(), stays[0].ID); err != nil || res.Status != domain.Pending {
+		t.Errorf("expected the booking of the stranger to stay pending, got %s (%v)", res.Status, err)
+	}
+}
+
+func TestRepository_ChangeMyBooking(t *testing.T) {
+	id := signUp(t, "Leopold", "leopold@guest.com")
+	start := time.Date(time.Now().Year()+2, 9, 10, 0, 0, 0, 0, time.UTC)
+	stays, err := testDB.GuestReservations(context.Background(), insertGuest(t, "Leopold", "leopold@guest.com", "", start))
+	if err != nil || len(stays) != 1 {
+		t.Fatalf("expected 1 stay, got %d (%v)", len(stays), err)
+	}
+	own := strconv.Itoa(stays[0].ID)
+	if err := testDB.InsetIntoRoomRestriction(context.Background(), models.RoomRestriction{
+		StartDate: start, EndDate: start.AddDate(0, 0, 2), RoomID: 2, ResevationID: stays[0].ID, RestrictionID: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.InsetIntoRoomRestriction(context.Background(), models.RoomRestriction{
+		StartDate: start.AddDate(0, 0, 20), EndDate: start.AddDate(0, 0, 22), RoomID: 2, RestrictionID: 2,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	date := func(days int) string { return start.AddDate(0, 0, days).Format("2006-01-02") }
+	var tests = []struct {
+		name  string
+		form  string
+		moved bool
+	}{
+		{"blocked", "start_date=" + date(19) + "&end_date=" + date(21), false},
+		{"end-before-start", "start_date=" + date(5) + "&end_date=" + date(4), false},
+		{"missing-date", "start_date=" + date(5), false},
+		{"past", "start_date=2020-01-01&end_date=2020-01-03", false},
+		{"free", "start_date=" + date(1) + "&end_date=" + date(4), true},
+	}
+
+	for _, e := range tests {
+		rr, ctx := serveGuest(Repo.ChangeMyBooking, "POST", "/account/bookings/"+own+"/dates", e.form,
+			map[string]interface{}{"guest_account_id": id}, map[string]string{"id": own})
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/account/bookings" {
+			t.Errorf("for %s, expected a redirect to the bookings but got %d", e.name, rr.Code)
+		}
+		if moved := session.Exists(ctx, "flash"); moved != e.moved {
+			t.Errorf("for %s, expected moved to be %v (%s)", e.name, e.moved, session.GetString(ctx, "error"))
+		}
+	}
+
+	res, err := testDB.GetReservationById(context.Background(), stays[0].ID)
+	if err != nil || res.StartDate.Format("2006-01-02") != date(1) || res.EndDate.Format("2006-01-02") != date(4) {
+		t.Errorf("expected the booking to be moved, got %v to %v (%v)", res.StartDate, res.EndDate, err)
+	}
+}
+
+func TestRepository_ReservationPrefill(t *testing.T) {
+	id := signUp(t, "Millicent", "millicent@guest.com")
+	account, err := testDB.GetGuestAccount(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, ctx := serveGuest(Repo.Reservation, "GET", "/make-reservation", "", map[string]interface{}{
+		"guest_account_id": id,
+		"reservation":      models.Reservation{RoomID: 1},
+	}, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `value="Millicent"`) {
+		t.Errorf("expected the form to be filled from the account, got %d", rr.Code)
+	}
+	res, ok := session.Get(ctx, "reservation").(models.Reservation)
+	if !ok || res.GuestID != account.GuestID {
+		t.Errorf("expected the reservation to be made for guest %d, got %+v", account.GuestID, res)
+	}
+}
+
+//insertGroup books rooms 1 and 2 together for a guest and returns the booking
+func insertGroup(t *testing.T, first, email string, start time.Time) models.Booking {
+	var reservations []models.Reservation
+	for _, room := range []int{1, 2} {
+		reservations = append(reservations, models.Reservation{
+			FirstName: first,
+			LastName:  "Guest",
+			Email:     email,
+			StartDate: start,
+			EndDate:   start.AddDate(0, 0, 2),
+			RoomID:    room,
+			Adults:    1,
+		})
+	}
+	id, _, err := testDB.InsertBooking(context.Background(), reservations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	booking, err := testDB.GetBooking(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return booking
+}
+
+func TestRepository_AddToCart(t *testing.T) {
+	start := time.Date(2064, 1, 10, 0, 0, 0, 0, time.UTC)
+	search := models.Reservation{StartDate: start, EndDate: start.AddDate(0, 0, 2), Adults: 3, Children: 1}
+	insertBooking(t, start.AddDate(0, 0, 20), start.AddDate(0, 0, 22))
+
+	var tests = []struct {
+		name             string
+		form             string
+		search           interface{}
+		cart             []models.Reservation
+		expectedLocation string
+		expectedCart     int
+	}{
+		{"no-search", "room_id=1", nil, nil, "/search-availability", 0},
+		{"too-big", "room_id=1", search, nil, "/search-availability", 0},
+		{"combination", "room_id=1&room_id=2", search, nil, "/cart", 2},
+		{"already-in-cart", "room_id=2", models.Reservation{StartDate: start.AddDate(0, 0, 1), EndDate: start.AddDate(0, 0, 3)},
+			[]models.Reservation{{RoomID: 2, StartDate: start, EndDate: start.AddDate(0, 0, 2)}}, "/search-availability", 1},
+		{"booked", "room_id=1", models.Reservation{StartDate: start.AddDate(0, 0, 20), EndDate: start.AddDate(0, 0, 22)},
+			nil, "/search-availability", 0},
+		{"other-dates", "room_id=2", models.Reservation{StartDate: start.AddDate(0, 0, 2), EndDate: start.AddDate(0, 0, 4)},
+			[]models.Reservation{{RoomID: 2, StartDate: start, EndDate: start.AddDate(0, 0, 2)}}, "/cart", 2},
+	}
+
+	for _, e := range tests {
+		put := map[string]interface{}{}
+		if e.search != nil {
+			put["reservation"] = e.search
+		}
+		if e.cart != nil {
+			put["cart"] = e.cart
+		}
+		rr, ctx := serveGuest(Repo.AddToCart, "POST", "/cart", e.form, put, nil)
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("for %s, expected a redirect to %s but got %d %s", e.name, e.expectedLocation, rr.Code, rr.Header().Get("Location"))
+		}
+		cart, _ := session.Get(ctx, "cart").([]models.Reservation)
+		if len(cart) != e.expectedCart {
+			t.Errorf("for %s, expected %d rooms in the cart, got %d", e.name, e.expectedCart, len(cart))
+		}
+		if e.name == "combination" && (cart[0].Adults != 2 || cart[0].Children != 0 || cart[1].Adults != 1 || cart[1].Children != 1) {
+			t.Errorf("expected the party to be spread over the rooms, got %+v", cart)
+		}
+	}
+
+	rr, _ := serveGuest(Repo.AddToCart, "POST", "/cart", "room_id=abc", map[string]interface{}{"reservation": search}, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for an invalid room, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestRepository_RemoveFromCart(t *testing.T) {
+	start := time.Date(2064, 2, 10, 0, 0, 0, 0, time.UTC)
+	cart := []models.Reservation{
+		{RoomID: 1, StartDate: start, EndDate: start.AddDate(0, 0, 2)},
+		{RoomID: 2, StartDate: start, EndDate: start.AddDate(0, 0, 2)},
+	}
+
+	rr, ctx := serveGuest(Repo.RemoveFromCart, "POST", "/cart/0/remove", "", map[string]interface{}{"cart": cart}, map[string]string{"n": "0"})
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	left, _ := session.Get(ctx, "cart").([]models.Reservation)
+	if len(left) != 1 || left[0].RoomID != 2 {
+		t.Errorf("expected room 2 to be left, got %+v", left)
+	}
+
+	rr, _ = serveGuest(Repo.RemoveFromCart, "POST", "/cart/2/remove", "", map[string]interface{}{"cart": cart}, map[string]string{"n": "2"})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %d but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestRepository_PostCart(t *testing.T) {
+	start := time.Date(2064, 3, 10, 0, 0, 0, 0, time.UTC)
+	booked := start.AddDate(0, 0, 20)
+	insertBooking(t, booked, booked.AddDate(0, 0, 2))
+	cart := []models.Reservation{
+		{RoomID: 1, StartDate: start, EndDate: start.AddDate(0, 0, 2), Adults: 2},
+		{RoomID: 2, StartDate: start, EndDate: start.AddDate(0, 0, 2), Adults: 1, Children: 2},
+	}
+	contact := "first_name=Evangeline&last_name=Guest&email=evangeline@guest.com&phone=555"
+
+	var tests = []struct {
+		name               string
+		form               string
+		cart               []models.Reservation
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"empty", contact, nil, http.StatusSeeOther, "/search-availability"},
+		{"invalid-form", "first_name=Co&email=not-an-email", cart, http.StatusOK, ""},
+		{"unavailable", contact, []models.Reservation{cart[1], {RoomID: 1, StartDate: booked, EndDate: booked.AddDate(0, 0, 2)}},
+			http.StatusSeeOther, "/cart"},
+		{"booked", contact, cart, http.StatusSeeOther, "/booking-summary"},
+		{"back-to-back", contact, []models.Reservation{
+			{RoomID: 1, StartDate: start.AddDate(0, 0, 40), EndDate: start.AddDate(0, 0, 42), Adults: 1},
+			{RoomID: 1, StartDate: start.AddDate(0, 0, 42), EndDate: start.AddDate(0, 0, 44), Adults: 1},
+		}, http.StatusSeeOther, "/booking-summary"},
+	}
+
+	for _, e := range tests {
+		put := map[string]interface{}{}
+		if e.cart != nil {
+			put["cart"] = e.cart
+		}
+		rr, ctx := serveGuest(Repo.PostCart, "POST", "/cart/checkout", e.form, put, nil)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("for %s, expected a redirect to %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+		if e.name != "booked" {
+			continue
+		}
+
+		if session.Exists(ctx, "cart") {
+			t.Error("expected the cart to be emptied")
+		}
+		id := session.GetInt(ctx, "booking_id")
+		booking, err := testDB.GetBooking(context.Background(), id)
+		if err != nil || len(booking.Reservations) != 2 || booking.Guests() != 5 {
+			t.Fatalf("expected a booking of 2 rooms for 5 guests, got %+v (%v)", booking, err)
+		}
+		for _, res := range booking.Reservations {
+			if res.BookingID != id || res.FirstName != "Evangeline" {
+				t.Errorf("unexpected reservation %+v", res)
+			}
+		}
+
+		rr, _ = serveGuest(Repo.BookingSummary, "GET", "/booking-summary", "", map[string]interface{}{"booking_id": id}, nil)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Major&#39;s Suite") {
+			t.Errorf("expected the summary to list the rooms, got %d", rr.Code)
+		}
+	}
+
+	//nothing of a booking is kept when one of its rooms is taken
+	rr, _ := serveGuest(Repo.PostCart, "POST", "/cart/checkout", contact, map[string]interface{}{"cart": cart}, nil)
+	if rr.Header().Get("Location") != "/cart" {
+		t.Errorf("expected a booked room to be refused, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func TestRepository_CancelMyGroupBooking(t *testing.T) {
+	id := signUp(t, "Theodora", "theodora@guest.com")
+	other := signUp(t, "Bartholomew", "bartholomew@guest.com")
+	booking := insertGroup(t, "Theodora", "theodora@guest.com", time.Now().AddDate(3, 0, 0))
+	bookingID := strconv.Itoa(booking.ID)
+
+	rr, _ := serveGuest(Repo.MyBookings, "GET", "/account/bookings", "", map[string]interface{}{"guest_account_id": id}, nil)
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), "/account/bookings/group/"+bookingID+"/cancel") != 1 {
+		t.Errorf("expected one button to cancel the whole booking, got %d", rr.Code)
+	}
+
+	var tests = []struct {
+		name               string
+		account            int
+		id                 string
+		expectedStatusCode int
+	}{
+		{"other-guest", other, bookingID, http.StatusNotFound},
+		{"invalid-id", id, "abc", http.StatusBadRequest},
+		{"missing", id, "999999", http.StatusNotFound},
+		{"own", id, bookingID, http.StatusSeeOther},
+	}
+
+	for _, e := range tests {
+		rr, _ := serveGuest(Repo.CancelMyGroupBooking, "POST", "/account/bookings/group/"+e.id+"/cancel", "",
+			map[string]interface{}{"guest_account_id": e.account}, map[string]string{"id": e.id})
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	cancelled, err := testDB.GetBooking(context.Background(), booking.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range cancelled.Reservations {
+		if res.Status != domain.Cancelled {
+			t.Errorf("expected every room to be cancelled, got %s for reservation %d", res.Status, res.ID)
+		}
+	}
+}
+
+func TestRepository_AdminCancelBooking(t *testing.T) {
+	start := time.Date(2064, 4, 10, 0, 0, 0, 0, time.UTC)
+	booking := insertGroup(t, "Augustina", "augustina@guest.com", start)
+	single := insertBooking(t, start.AddDate(0, 0, 10), start.AddDate(0, 0, 12))
+
+	url := fmt.Sprintf("/admin/reservation/all/%d", booking.Reservations[0].ID)
+	req, _ := http.NewRequest("GET", url, nil)
+	req.RequestURI = url
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminShowReservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Cancel whole booking") {
+		t.Errorf("expected the page to show the booking, got %d", rr.Code)
+	}
+
+	var tests = []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"invalid-id", "abc", http.StatusBadRequest},
+		{"no-booking", strconv.Itoa(single), http.StatusNotFound},
+		{"booking", strconv.Itoa(booking.Reservations[1].ID), http.StatusSeeOther},
+	}
+
+	for _, e := range tests {
+		rr := postAdmin(Repo.AdminCancelBooking, "/admin/reservation/all/"+e.id+"/cancel-booking", map[string]string{"src": "all", "id": e.id})
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	cancelled, err := testDB.GetBooking(context.Background(), booking.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range cancelled.Reservations {
+		if res.Status != domain.Cancelled {
+			t.Errorf("expected every room to be cancelled, got %s for reservation %d", res.Status, res.ID)
+		}
+	}
+	if available, _ := testDB.SearchAvailabilityByDatesByRoomId(context.Background(), start, start.AddDate(0, 0, 2), 1); !available {
+		t.Error("expected the rooms to be released")
+	}
+}
+
+//insertGuest books room 2 for a guest and returns the id of the guest the reservation was linked to
+func insertGuest(t *testing.T, first, email, phone string, start time.Time) int {
+	ctx := context.Background()
+	id, err := testDB.InsertReservation(ctx, models.Reservation{
+		FirstName: first,
+		LastName:  "Guest",
+		Email:     email,
+		Phone:     phone,
+		StartDate: start,
+		EndDate:   start.AddDate(0, 0, 2),
+		RoomID:    2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := testDB.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.GuestID
+}
+
+//getGuest gets a guest page with the id set as chi url param
+func getGuest(handler http.HandlerFunc, url, id string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", url, nil)
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRepository_AdminGuests(t *testing.T) {
+	start := time.Date(2052, 1, 10, 0, 0, 0, 0, time.UTC)
+	id := insertGuest(t, "Wilhelmina", "wilhelmina@guest.com", "", start)
+
+	rr := getGuest(Repo.AdminGuests, "/admin/guests?q=wilhel", "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), fmt.Sprintf("/admin/guests/%d", id)) {
+		t.Errorf("expected the search to list the guest, got %d", rr.Code)
+	}
+
+	var tests = []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"found", strconv.Itoa(id), http.StatusOK},
+		{"invalid-id", "abc", http.StatusBadRequest},
+		{"missing-guest", strconv.Itoa(id + 1000), http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		rr := getGuest(Repo.AdminShowGuest, "/admin/guests/"+e.id, e.id)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminPostGuest(t *testing.T) {
+	start := time.Date(2052, 2, 10, 0, 0, 0, 0, time.UTC)
+	id := insertGuest(t, "Bartholomew", "bart@guest.com", "", start)
+
+	var tests = []struct {
+		name               string
+		form               string
+		expectedStatusCode int
+	}{
+		{"saved", "first_name=Bart&last_name=Guest&email=bart@guest.com&tags=VIP,%20late%20arrival&notes=Quiet+room", http.StatusSeeOther},
+		{"missing-name", "first_name=&last_name=Guest", http.StatusOK},
+		{"invalid-email", "first_name=Bart&last_name=Guest&email=bart", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/guests/"+strconv.Itoa(id), strings.NewReader(e.form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strconv.Itoa(id))
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostGuest)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	guest, err := testDB.GetGuestByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guest.FirstName != "Bart" || guest.Notes != "Quiet room" || strings.Join(guest.Tags, ",") != "late-arrival,vip" {
+		t.Errorf("unexpected guest %+v", guest)
+	}
+}
+
+func TestRepository_AdminMergeGuest(t *testing.T) {
+	start := time.Date(2052, 3, 10, 0, 0, 0, 0, time.UTC)
+	id := insertGuest(t, "Cornelius", "cornelius@guest.com", "", start)
+	duplicate := insertGuest(t, "Cornelius", "cornelius@work.com", "", start.AddDate(0, 0, 7))
+
+	var tests = []struct {
+		name               string
+		id                 string
+		duplicate          string
+		expectedStatusCode int
+	}{
+		{"same-guest", strconv.Itoa(id), strconv.Itoa(id), http.StatusBadRequest},
+		{"invalid-duplicate", strconv.Itoa(id), "abc", http.StatusBadRequest},
+		{"missing-guest", strconv.Itoa(id + 1000), strconv.Itoa(duplicate), http.StatusNotFound},
+		{"merged", strconv.Itoa(id), strconv.Itoa(duplicate), http.StatusSeeOther},
+		{"already-merged", strconv.Itoa(id), strconv.Itoa(duplicate), http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		url := "/admin/guests/" + e.id + "/merge?duplicate=" + e.duplicate
+		rr := postAdmin(Repo.AdminMergeGuest, url, map[string]string{"id": e.id})
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	stays, err := testDB.GuestReservations(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stays) != 2 {
+		t.Errorf("expected both stays on the guest, got %d", len(stays))
+	}
+}
+
+func TestRepository_AdminAudit(t *testing.T) {
+	var tests = []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+	}{
+		{"no-filter", "", http.StatusOK},
+		{"filters", "?action=reservation.delete&entity=reservation&entity_id=1&user=1&from=2050-01-01&to=2050-01-31", http.StatusOK},
+		{"unknown-action", "?action=reservation.drop", http.StatusBadRequest},
+		{"invalid-date", "?from=yesterday", http.StatusBadRequest},
+		{"invalid-user", "?user=admin", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/audit"+e.query, nil)
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminAudit)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminAuditExport(t *testing.T) {
+	start := time.Date(2051, 6, 10, 0, 0, 0, 0, time.UTC)
+	id := insertBooking(t, start, start.AddDate(0, 0, 2))
+
+	rr := postAdmin(Repo.AdminDeleteReservation, "/admin/reservation/all/"+strconv.Itoa(id)+"/delete", map[string]string{"src": "all", "id": strconv.Itoa(id)})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected the delete to succeed, got %d", rr.Code)
+	}
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/audit/export?action=reservation.delete&entity_id=%d", id), nil)
+	req = req.WithContext(getCtx(req))
+	rr = httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminAuditExport)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("expected a CSV download, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "id,created_at,user_id,user,ip,action,entity,entity_id,changes") {
+		t.Fatalf("expected a header and the delete, got %q", rr.Body.String())
+	}
+	if !strings.Contains(lines[1], "reservation.delete") || !strings.Contains(lines[1], "command line") {
+		t.Errorf("unexpected audit row %q", lines[1])
+	}
+
+	req, _ = http.NewRequest("GET", "/admin/audit/export?to=someday", nil)
+	req = req.WithContext(getCtx(req))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for an invalid filter, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestRepository_AdminNotifications(t *testing.T) {
+	_, err := testDB.InsertNotification(context.Background(), models.Notification{
+		Title: "New reservation",
+		Body:  "Smith booked General's Quarters",
+		Link:  "/admin/reservation/new/1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unread, err := testDB.UnreadNotificationCount(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/notifications", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	session.Put(ctx, "user_id", 1)
+
+	handler := http.HandlerFunc(Repo.AdminNotifications)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminNotifications handler return wrong response code. Got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	var resp notificationsResponse
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal("failed to parse json", err)
+	}
+	if resp.Unread != unread || len(resp.Notifications) == 0 || resp.Notifications[0].Title != "New reservation" {
+		t.Errorf("unexpected notifications response %+v", resp)
+	}
+}
+
+func TestRepository_AdminNotificationsCancelled(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/notifications", nil)
+	ctx, cancel := context.WithCancel(getCtx(req))
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	//the client went away before the query ran
+	cancel()
+
+	handler := http.HandlerFunc(Repo.AdminNotifications)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("AdminNotifications handler return wrong response code for a cancelled request. Got %d, wanted %d", rr.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestRepository_AdminReadNotification(t *testing.T) {
+	id, err := testDB.InsertNotification(context.Background(), models.Notification{Title: "New reservation"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"valid-id", strconv.Itoa(id), http.StatusNoContent},
+		{"invalid-id", "abc", http.StatusBadRequest},
+		{"missing-notification", strconv.Itoa(id + 1000), http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/notifications/"+e.id+"/read", nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReadNotification)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminReadAllNotifications(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/notifications/read-all", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminReadAllNotifications)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("AdminReadAllNotifications handler return wrong response code. Got %d, wanted %d", rr.Code, http.StatusNoContent)
+	}
+}
+
+func TestRepository_AdminEvents(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/events", nil)
+	ctx, cancel := context.WithCancel(getCtx(req))
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		Repo.AdminEvents(rr, req)
+		close(done)
+	}()
+
+	//wait for the handler to subscribe before publishing
+	for app.Events.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	count, err := testDB.CountReservationsByStatus(context.Background(), domain.Pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Repo.publish(context.Background(), events.ReservationCreated, 7)
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	<-done
+
+	if app.Events.Subscribers() != 0 {
+		t.Error("subscriber was not removed after the client disconnected")
+	}
+	if rr.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("wrong content type %s", rr.Header().Get("Content-Type"))
+	}
+
+	body := rr.Body.String()
+	if !strings.Contains(body, `"type":"count"`) {
+		t.Error("initial count event was not sent")
+	}
+	if !strings.Contains(body, fmt.Sprintf(`"type":"reservation.created","reservation_id":7,"new_count":%d`, count)) {
+		t.Errorf("published event was not streamed, got %s", body)
+	}
+}
+
+func TestRepository_Healthz(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.Healthz)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Healthz handler return wrong response code. Got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRepository_Readyz(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.Readyz)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Readyz handler return wrong response code. Got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	var report health.Report
+	err := json.Unmarshal(rr.Body.Bytes(), &report)
+	if err != nil {
+		t.Fatal("failed to parse json", err)
+	}
+	if report.Checks["templates"].Status != "ok" {
+		t.Errorf("template check reported %+v", report.Checks["templates"])
+	}
+
+	//test with a failing check
+	checks := Repo.Checks
+	defer func() { Repo.Checks = checks }()
+	Repo.Checks = append(Repo.Checks, health.Check{
+		Name:    "database",
+		Timeout: time.Second,
+		Run: func(ctx context.Context) error {
+			return errors.New("connection refused")
+		},
+	})
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Readyz handler return wrong response code for failing check. Got %d, wanted %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	if !strings.Contains(rr.Body.String(), "connection refused") {
+		t.Error("failing check details missing from readiness response")
+	}
+}
+
+func TestRepository_Version(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/version", nil)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.Version)
+	handler.ServeHTTP(rr, req)
+
+	var resp map[string]string
+	err := json.Unmarshal(rr.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal("failed to parse json", err)
+	}
+	if resp["version"] != version.Version || resp["commit"] != version.Commit {
+		t.Errorf("unexpected version response %v", resp)
 	}
 }
 
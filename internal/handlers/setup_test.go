@@ -12,8 +12,12 @@ import (
 	"time"
 
 	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/events"
+	"github.com/ArmanurRahman/booking/internal/helpers"
+	"github.com/ArmanurRahman/booking/internal/logging"
 	"github.com/ArmanurRahman/booking/internal/models"
 	"github.com/ArmanurRahman/booking/internal/render"
+	"github.com/ArmanurRahman/booking/internal/repository/dbrepo"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/v5/middleware"
@@ -21,13 +25,19 @@ import (
 )
 
 var app config.AppConfig
+
+//testDB is the in-memory repository behind Repo, tests inject faults into it
+var testDB *dbrepo.MemoryDBRepo
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate": render.HumanDate,
+}
 
 func TestMain(m *testing.M) {
 	//what am i put in session
 	gob.Register(models.Reservation{})
+	gob.Register([]models.Reservation{})
 	//change this value to true in production
 	app.IsProduction = false
 
@@ -41,6 +51,8 @@ func TestMain(m *testing.M) {
 
 	mailChan := make(chan models.MailData)
 	app.MailChan = mailChan
+
+	app.Events = events.NewBus()
 	defer close(app.MailChan)
 
 	listenForMail()
@@ -52,17 +64,14 @@ func TestMain(m *testing.M) {
 
 	app.TemplateCache = tc
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	app.Logger = logging.New(os.Stdout, false, "info")
 
 	app.UseCache = true
-	repo := NewTestRepo(&app)
-	NewHandlers(repo)
+	testDB = dbrepo.NewMemoryRepo(&app)
+	NewHandlers(NewTestRepo(&app, testDB))
 
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 	os.Exit(m.Run())
 }
 
@@ -0,0 +1,134 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/config"
+)
+
+//Check is a single readiness check
+type Check struct {
+	Name    string
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+//Result is the outcome of one check
+type Result struct {
+	Status   string `json:"status"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+//Report is the outcome of all checks, Status is ok only when every check passed
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+//OK reports whether every check passed
+func (r Report) OK() bool {
+	return r.Status == "ok"
+}
+
+//Run runs the checks concurrently, each one bounded by its own timeout
+func Run(ctx context.Context, checks []Check) Report {
+	report := Report{
+		Status: "ok",
+		Checks: make(map[string]Result),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c Check) {
+			defer wg.Done()
+			result := run(ctx, c)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.Name] = result
+			if result.Status != "ok" {
+				report.Status = "fail"
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func run(ctx context.Context, c Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- c.Run(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errChan:
+	case <-ctx.Done():
+		err = errors.New("timed out")
+	}
+
+	result := Result{
+		Status:   "ok",
+		Duration: time.Since(start).Round(time.Microsecond).String(),
+	}
+	if err != nil {
+		result.Status = "fail"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+//Database checks that the database answers a ping
+func Database(db *sql.DB, timeout time.Duration) Check {
+	return Check{
+		Name:    "database",
+		Timeout: timeout,
+		Run: func(ctx context.Context) error {
+			return db.PingContext(ctx)
+		},
+	}
+}
+
+//Templates checks that the template cache is loaded
+func Templates(a *config.AppConfig, timeout time.Duration) Check {
+	return Check{
+		Name:    "templates",
+		Timeout: timeout,
+		Run: func(ctx context.Context) error {
+			if len(a.TemplateCache) == 0 {
+				return errors.New("template cache is empty")
+			}
+			return nil
+		},
+	}
+}
+
+//Dial checks that a TCP service, such as the mail server, accepts connections
+func Dial(name, addr string, timeout time.Duration) Check {
+	return Check{
+		Name:    name,
+		Timeout: timeout,
+		Run: func(ctx context.Context) error {
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		},
+	}
+}
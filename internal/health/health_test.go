@@ -0,0 +1,78 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/config"
+)
+
+func TestRun(t *testing.T) {
+	checks := []Check{
+		{Name: "fine", Timeout: time.Second, Run: func(ctx context.Context) error { return nil }},
+		{Name: "broken", Timeout: time.Second, Run: func(ctx context.Context) error { return errors.New("down") }},
+		{Name: "slow", Timeout: 20 * time.Millisecond, Run: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}},
+	}
+
+	start := time.Now()
+	report := Run(context.Background(), checks)
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("slow check was not cut off by its timeout")
+	}
+	if report.OK() {
+		t.Error("report is ok although checks failed")
+	}
+	if report.Checks["fine"].Status != "ok" {
+		t.Errorf("fine check reported %+v", report.Checks["fine"])
+	}
+	if r := report.Checks["broken"]; r.Status != "fail" || r.Error != "down" {
+		t.Errorf("broken check reported %+v", r)
+	}
+	if r := report.Checks["slow"]; r.Status != "fail" || r.Error != "timed out" {
+		t.Errorf("slow check reported %+v", r)
+	}
+
+	report = Run(context.Background(), checks[:1])
+	if !report.OK() {
+		t.Error("report should be ok when every check passes")
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	var a config.AppConfig
+	check := Templates(&a, time.Second)
+
+	if check.Run(context.Background()) == nil {
+		t.Error("empty template cache should fail")
+	}
+
+	a.TemplateCache = map[string]*template.Template{"home.page.html": template.New("home")}
+	if err := check.Run(context.Background()); err != nil {
+		t.Errorf("loaded template cache failed: %s", err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	if err = Dial("mail", addr, time.Second).Run(context.Background()); err != nil {
+		t.Errorf("dial to a listening port failed: %s", err)
+	}
+
+	ln.Close()
+	if Dial("mail", addr, time.Second).Run(context.Background()) == nil {
+		t.Error("dial to a closed port should fail")
+	}
+}
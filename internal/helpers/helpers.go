@@ -1,11 +1,13 @@
 package helpers
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/logging"
 )
 
 var app *config.AppConfig
@@ -15,14 +17,28 @@ func NewHelpers(a *config.AppConfig) {
 	app = a
 }
 
-func ClientError(w http.ResponseWriter, status int) {
-	app.InfoLog.Println("Client error with status of", status)
+//ClientError logs a rejected request as a warning and sends the status to the client
+func ClientError(w http.ResponseWriter, r *http.Request, status int) {
+	logging.FromContext(r.Context()).Warn("client error", "status", status)
 	http.Error(w, http.StatusText(status), status)
 }
 
-func ServerError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.ErrorLog.Println(trace)
+//ServerError logs the error with its stack trace and sends a 500 to the client.
+//A query cancelled by the client leaving or by the query timeout is not a server
+//error and gets a 503 instead.
+func ServerError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		logger := logging.FromContext(r.Context())
+		if r.Context().Err() != nil {
+			logger.Info("request cancelled", "error", err)
+		} else {
+			logger.Warn("query timed out", "error", err)
+		}
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	logging.FromContext(r.Context()).Error("server error", "error", err, "stack", string(debug.Stack()))
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
@@ -30,3 +46,8 @@ func IsAuthinticate(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+//IsGuest reports whether a guest is logged in to a guest account
+func IsGuest(r *http.Request) bool {
+	return app.Session.Exists(r.Context(), "guest_account_id")
+}
@@ -0,0 +1,337 @@
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/domain"
+	"github.com/ArmanurRahman/booking/internal/export"
+	"github.com/ArmanurRahman/booking/internal/forms"
+	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/ArmanurRahman/booking/internal/repository"
+)
+
+//The reservation fields a column of the file can be mapped to
+const (
+	FirstName = "first_name"
+	LastName  = "last_name"
+	Email     = "email"
+	Phone     = "phone"
+	Room      = "room"
+	StartDate = "start_date"
+	EndDate   = "end_date"
+	Status    = "status"
+	Adults    = "adults"
+	Children  = "children"
+)
+
+//Fields lists every field in the order of the upload form
+var Fields = []string{FirstName, LastName, Email, Phone, Room, StartDate, EndDate, Status, Adults, Children}
+
+//optional reports whether a field may be missing from the file, unless it was mapped to a column
+func optional(field string) bool {
+	return field == Phone || field == Status || field == Adults || field == Children
+}
+
+//DefaultStatus is the status of a row without one, the old system had already accepted the booking
+const DefaultStatus = domain.Confirmed
+
+//ErrInvalidRows is returned by Import when a row of the check did not pass
+var ErrInvalidRows = errors.New("some rows are invalid, nothing was imported")
+
+//Mapping maps a field to the header of the column holding it. A field that is
+//not mapped is read from the column named like the field.
+type Mapping map[string]string
+
+//ParseMapping parses a comma separated list of field=column pairs
+func ParseMapping(s string) (Mapping, error) {
+	mapping := Mapping{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		field, column, ok := strings.Cut(pair, "=")
+		field = strings.TrimSpace(field)
+		if !ok || strings.TrimSpace(column) == "" {
+			return nil, fmt.Errorf("invalid mapping %q, use field=column", pair)
+		}
+		if !known(field) {
+			return nil, fmt.Errorf("unknown field %q, fields are %s", field, strings.Join(Fields, ", "))
+		}
+		mapping[field] = strings.TrimSpace(column)
+	}
+	return mapping, nil
+}
+
+func known(field string) bool {
+	for _, f := range Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+//Column returns the header of the column the field is read from
+func (m Mapping) Column(field string) string {
+	if column := m[field]; column != "" {
+		return column
+	}
+	return field
+}
+
+//Problem is why a row of the file cannot be imported. Line is the line of the
+//file the row starts on, Field is empty for a problem with the whole row.
+type Problem struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+//Result is the outcome of checking a file. Reservations holds the rows that passed,
+//Problems why the others did not.
+type Result struct {
+	Rows         int
+	Reservations []models.Reservation
+	Problems     []Problem
+	IDs          []int
+}
+
+//Valid reports whether every row passed the check
+func (res *Result) Valid() bool {
+	return len(res.Problems) == 0
+}
+
+//Invalid returns the number of rows with a problem
+func (res *Result) Invalid() int {
+	lines := make(map[int]bool)
+	for _, p := range res.Problems {
+		lines[p.Line] = true
+	}
+	return len(lines)
+}
+
+//WriteReport writes the problems as a report, one row per problem
+func WriteReport(w export.Writer, problems []Problem) error {
+	err := w.Write("line", "field", "problem")
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		err = w.Write(p.Line, p.Field, p.Message)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Importer reads reservations from a CSV file with a header row
+type Importer struct {
+	DB      repository.DatabaseRepo
+	Mapping Mapping
+}
+
+//stay is a reservation of an earlier row, to find rows booking the same room twice
+type stay struct {
+	line int
+	res  models.Reservation
+}
+
+//Check validates every row of the file, with the rules of the reservation form,
+//and looks for rooms that are taken on the dates of a row, by a reservation or
+//block in the database or by an earlier row of the file. Nothing is written.
+func (i *Importer) Check(ctx context.Context, r io.Reader) (*Result, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	} else if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for n, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = n
+	}
+	index := make(map[string]int)
+	for _, field := range Fields {
+		n, ok := columns[strings.ToLower(i.Mapping.Column(field))]
+		if !ok {
+			if optional(field) && i.Mapping[field] == "" {
+				continue
+			}
+			return nil, fmt.Errorf("no column %q for %s", i.Mapping.Column(field), field)
+		}
+		index[field] = n
+	}
+
+	rooms, err := i.DB.AllRooms(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{}
+	var stays []stay
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			res.Rows++
+			res.Problems = append(res.Problems, Problem{Line: parseErr.StartLine, Message: parseErr.Err.Error()})
+			continue
+		}
+		res.Rows++
+		line, _ := cr.FieldPos(0)
+
+		values := url.Values{}
+		for field, n := range index {
+			if n < len(record) {
+				values.Set(field, strings.TrimSpace(record[n]))
+			}
+		}
+
+		reservation, form := parseRow(values, rooms)
+		if form.Valid() && reservation.Status.Stays() {
+			err = i.checkAvailability(ctx, reservation, stays, form)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !form.Valid() {
+			for _, field := range Fields {
+				for _, message := range form.Errors[field] {
+					res.Problems = append(res.Problems, Problem{Line: line, Field: field, Message: message})
+				}
+			}
+			continue
+		}
+		if reservation.Status.Stays() {
+			stays = append(stays, stay{line: line, res: reservation})
+		}
+		res.Reservations = append(res.Reservations, reservation)
+	}
+	return res, nil
+}
+
+//parseRow validates a row like the reservation form and returns its reservation
+func parseRow(values url.Values, rooms []models.Room) (models.Reservation, *forms.Form) {
+	form := forms.New(values)
+	form.Required(FirstName, LastName, Email, Room, StartDate, EndDate)
+	if form.Get(FirstName) != "" {
+		form.MinLength(FirstName, 3, nil)
+	}
+	if form.Get(Email) != "" {
+		form.IsEmail(Email)
+	}
+
+	reservation := models.Reservation{
+		FirstName: form.Get(FirstName),
+		LastName:  form.Get(LastName),
+		Email:     form.Get(Email),
+		Phone:     form.Get(Phone),
+		Status:    DefaultStatus,
+		Adults:    form.IntRange(Adults, 1, domain.MaxPartySize, 1),
+		Children:  form.IntRange(Children, 0, domain.MaxPartySize, 0),
+	}
+
+	if name := form.Get(Room); name != "" {
+		room, ok := findRoom(rooms, name)
+		if !ok {
+			form.Errors.Add(Room, fmt.Sprintf("No room %q", name))
+		} else if form.Errors.Get(Adults) == "" && form.Errors.Get(Children) == "" && !room.Fits(reservation.Guests()) {
+			form.Errors.Add(Adults, fmt.Sprintf("The %s sleeps at most %d guests", room.RoomName, room.MaxOccupancy))
+		}
+		reservation.RoomID = room.ID
+		reservation.Room = room
+	}
+
+	var err error
+	if s := form.Get(StartDate); s != "" {
+		reservation.StartDate, err = time.Parse("2006-01-02", s)
+		if err != nil {
+			form.Errors.Add(StartDate, "Not a date, use 2006-01-02")
+		}
+	}
+	if s := form.Get(EndDate); s != "" {
+		reservation.EndDate, err = time.Parse("2006-01-02", s)
+		if err != nil {
+			form.Errors.Add(EndDate, "Not a date, use 2006-01-02")
+		}
+	}
+	if !reservation.StartDate.IsZero() && !reservation.EndDate.IsZero() && !reservation.EndDate.After(reservation.StartDate) {
+		form.Errors.Add(EndDate, "The departure must be after the arrival")
+	}
+
+	if s := form.Get(Status); s != "" {
+		reservation.Status, err = domain.ParseStatus(s)
+		if err != nil {
+			form.Errors.Add(Status, fmt.Sprintf("Unknown status %q", s))
+		}
+	}
+	return reservation, form
+}
+
+//findRoom returns the room with the id or the name, ignoring case
+func findRoom(rooms []models.Room, name string) (models.Room, bool) {
+	id, _ := strconv.Atoi(name)
+	for _, room := range rooms {
+		if room.ID == id || strings.EqualFold(room.RoomName, name) {
+			return room, true
+		}
+	}
+	return models.Room{}, false
+}
+
+//checkAvailability adds a problem to the form when the room of the reservation is taken
+func (i *Importer) checkAvailability(ctx context.Context, reservation models.Reservation, stays []stay, form *forms.Form) error {
+	for _, s := range stays {
+		if s.res.RoomID == reservation.RoomID && domain.Overlaps(reservation.StartDate, reservation.EndDate, s.res.StartDate, s.res.EndDate) {
+			form.Errors.Add(Room, fmt.Sprintf("The room is also booked on line %d", s.line))
+			return nil
+		}
+	}
+
+	available, err := i.DB.SearchAvailabilityByDatesByRoomId(ctx, reservation.StartDate, reservation.EndDate, reservation.RoomID)
+	if err != nil {
+		return err
+	}
+	if !available {
+		form.Errors.Add(Room, "The room is already booked or blocked on these dates")
+	}
+	return nil
+}
+
+//Import inserts the reservations of a check that every row passed, all of them in one transaction
+func (i *Importer) Import(ctx context.Context, res *Result) error {
+	if !res.Valid() {
+		return ErrInvalidRows
+	}
+	if len(res.Reservations) == 0 {
+		return nil
+	}
+
+	ids, err := i.DB.ImportReservations(ctx, res.Reservations)
+	if err != nil {
+		return err
+	}
+	res.IDs = ids
+	return nil
+}
@@ -0,0 +1,225 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/domain"
+	"github.com/ArmanurRahman/booking/internal/export"
+	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/ArmanurRahman/booking/internal/repository/dbrepo"
+)
+
+//newRepo returns an in-memory repository with room 2 booked from the 10th to the 12th of May 2040
+func newRepo(t *testing.T) *dbrepo.MemoryDBRepo {
+	repo := dbrepo.NewMemoryRepo(&config.AppConfig{})
+	err := repo.InsetIntoRoomRestriction(context.Background(), models.RoomRestriction{
+		StartDate:     time.Date(2040, 5, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2040, 5, 12, 0, 0, 0, 0, time.UTC),
+		RoomID:        2,
+		RestrictionID: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestParseMapping(t *testing.T) {
+	mapping, err := ParseMapping("first_name=Given, last_name = Family,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping.Column(FirstName) != "Given" || mapping.Column(LastName) != "Family" || mapping.Column(Email) != "email" {
+		t.Errorf("unexpected mapping %v", mapping)
+	}
+
+	for _, s := range []string{"first_name", "first_name=", "nickname=Nick"} {
+		if _, err := ParseMapping(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestImporter_Check(t *testing.T) {
+	file := `Given,Family,Mail,Suite,From,To,State
+John,Smith,john@smith.com,1,2040-05-01,2040-05-03,checked-out
+Jo,Smith,not-an-email,Penthouse,2040-05-01,2040-05-03,
+Jane,Doe,jane@doe.com,major's suite,2040-05-11,2040-05-14,
+Jane,Doe,jane@doe.com,2,2040-05-11,2040-05-14,cancelled
+Max,Mustermann,max@example.com,General's Quarters,2040-05-02,2040-05-04,
+Max,Mustermann,max@example.com,1,2040-05-07,05/09/2040,gone
+Max,,max@example.com,1,2040-05-09,2040-05-09
+`
+	imp := &Importer{
+		DB: newRepo(t),
+		Mapping: Mapping{
+			FirstName: "Given", LastName: "Family", Email: "Mail", Room: "Suite",
+			StartDate: "From", EndDate: "To", Status: "State",
+		},
+	}
+
+	res, err := imp.Check(context.Background(), strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Rows != 7 || len(res.Reservations) != 2 || res.Invalid() != 5 {
+		t.Fatalf("expected 2 of 7 rows to pass, got %d of %d with %d invalid", len(res.Reservations), res.Rows, res.Invalid())
+	}
+	if r := res.Reservations[0]; r.Status != domain.CheckedOut || r.RoomID != 1 || r.FirstName != "John" {
+		t.Errorf("unexpected reservation %+v", r)
+	}
+	if r := res.Reservations[1]; r.Status != domain.Cancelled || r.RoomID != 2 {
+		t.Errorf("expected a cancelled stay not to need the room, got %+v", r)
+	}
+
+	expected := []Problem{
+		{3, FirstName, "This field must be at least 3 characters long"},
+		{3, Email, "Not valid email"},
+		{3, Room, `No room "Penthouse"`},
+		{4, Room, "The room is already booked or blocked on these dates"},
+		{6, Room, "The room is also booked on line 2"},
+		{7, EndDate, "Not a date, use 2006-01-02"},
+		{7, Status, `Unknown status "gone"`},
+		{8, LastName, "This field cannot be blank"},
+		{8, EndDate, "The departure must be after the arrival"},
+	}
+	if len(res.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %+v", len(expected), res.Problems)
+	}
+	for i, p := range expected {
+		if res.Problems[i] != p {
+			t.Errorf("expected %+v but got %+v", p, res.Problems[i])
+		}
+	}
+}
+
+func TestImporter_CheckSameDayTurnover(t *testing.T) {
+	file := `first_name,last_name,email,room,start_date,end_date
+John,Smith,john@smith.com,2,2040-05-08,2040-05-10
+Jane,Doe,jane@doe.com,2,2040-05-12,2040-05-14
+Max,Mustermann,max@example.com,2,2040-05-14,2040-05-16
+`
+	res, err := (&Importer{DB: newRepo(t)}).Check(context.Background(), strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Reservations) != 3 || len(res.Problems) != 0 {
+		t.Errorf("expected stays to turn over on the same day, got %+v", res.Problems)
+	}
+}
+
+func TestImporter_CheckColumns(t *testing.T) {
+	imp := &Importer{DB: newRepo(t)}
+
+	if _, err := imp.Check(context.Background(), strings.NewReader("")); err == nil {
+		t.Error("expected an error for an empty file")
+	}
+	if _, err := imp.Check(context.Background(), strings.NewReader("first_name,last_name,email,room,start_date\n")); err == nil {
+		t.Error("expected an error for a missing column")
+	}
+
+	imp.Mapping = Mapping{Phone: "Mobile"}
+	if _, err := imp.Check(context.Background(), strings.NewReader("first_name,last_name,email,room,start_date,end_date\n")); err == nil {
+		t.Error("expected an error for a mapped column that is missing")
+	}
+
+	imp.Mapping = nil
+	res, err := imp.Check(context.Background(), strings.NewReader("\ufeffFirst_Name,last_name,email,room,start_date,end_date\n"+
+		"John,Smith,john@smith.com,1,2040-06-01,2040-06-03\n\"Jane,Doe\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Reservations) != 1 || res.Reservations[0].Status != DefaultStatus {
+		t.Errorf("expected the columns to be matched by name, got %+v", res.Reservations)
+	}
+	if len(res.Problems) != 1 || res.Problems[0].Line != 3 || res.Problems[0].Field != "" {
+		t.Errorf("expected a problem with the unterminated row, got %+v", res.Problems)
+	}
+}
+
+func TestImporter_Import(t *testing.T) {
+	repo := newRepo(t)
+	imp := &Importer{DB: repo}
+	file := "first_name,last_name,email,phone,room,start_date,end_date,status,adults,children\n" +
+		"John,Smith,john@smith.com,555-0100,1,2040-05-01,2040-05-03,checked-out,,\n" +
+		"Jane,Doe,jane@doe.com,,2,2040-05-20,2040-05-22,,2,2\n"
+
+	res, err := imp.Check(context.Background(), strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := imp.Import(context.Background(), res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.IDs) != 2 {
+		t.Fatalf("expected 2 reservations to be imported, got %v", res.IDs)
+	}
+
+	saved, err := repo.GetReservationById(context.Background(), res.IDs[1])
+	if err != nil || saved.Email != "jane@doe.com" || saved.Status != domain.Confirmed || saved.Adults != 2 || saved.Children != 2 {
+		t.Errorf("unexpected reservation %+v (%v)", saved, err)
+	}
+
+	res, err = imp.Check(context.Background(), strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Invalid() != 2 {
+		t.Errorf("expected both rows to clash with the import, got %+v", res.Problems)
+	}
+	if err := imp.Import(context.Background(), res); !errors.Is(err, ErrInvalidRows) {
+		t.Errorf("expected ErrInvalidRows, got %v", err)
+	}
+}
+
+func TestImporter_CheckGuests(t *testing.T) {
+	imp := &Importer{DB: newRepo(t)}
+	file := "first_name,last_name,email,room,start_date,end_date,adults,children\n" +
+		"John,Smith,john@smith.com,1,2040-06-01,2040-06-03,,\n" +
+		"Jane,Doe,jane@doe.com,1,2040-06-05,2040-06-07,2,1\n" +
+		"Max,Mustermann,max@example.com,2,2040-06-05,2040-06-07,0,many\n"
+
+	res, err := imp.Check(context.Background(), strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Reservations) != 1 || res.Reservations[0].Adults != 1 || res.Reservations[0].Children != 0 {
+		t.Errorf("expected a row without guest counts to be for one adult, got %+v", res.Reservations)
+	}
+
+	expected := []Problem{
+		{3, Adults, "The General's Quarters sleeps at most 2 guests"},
+		{4, Adults, "Enter a number from 1 to 20"},
+		{4, Children, "Enter a number from 0 to 20"},
+	}
+	if len(res.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %+v", len(expected), res.Problems)
+	}
+	for i, p := range expected {
+		if res.Problems[i] != p {
+			t.Errorf("expected %+v but got %+v", p, res.Problems[i])
+		}
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := export.New(export.CSV, &buf, "ignored")
+
+	err := WriteReport(w, []Problem{{Line: 2, Field: Email, Message: "Not valid email"}, {Line: 5, Message: "bare \" in non-quoted field"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	expected := "line,field,problem\n2,email,Not valid email\n5,,\"bare \"\" in non-quoted field\"\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q but got %q", expected, buf.String())
+	}
+}
@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestKey
+)
+
+//Request holds the details of the request being served that are added to every log line
+type Request struct {
+	ID     string
+	Start  time.Time
+	UserID int
+}
+
+//New creates the app logger, JSON in production and human readable text in development
+func New(w io.Writer, production bool, level string) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level: ParseLevel(level),
+	}
+
+	var h slog.Handler
+	if production {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(contextHandler{h})
+}
+
+//ParseLevel converts debug, info, warn or error to a slog level, defaulting to info
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+//NewContext returns a copy of ctx carrying the logger and the request details
+func NewContext(ctx context.Context, logger *slog.Logger, req *Request) context.Context {
+	ctx = context.WithValue(ctx, loggerKey, logger)
+	return context.WithValue(ctx, requestKey, req)
+}
+
+//RequestFrom returns the request details stored in ctx, or nil outside of a request
+func RequestFrom(ctx context.Context) *Request {
+	req, _ := ctx.Value(requestKey).(*Request)
+	return req
+}
+
+//FromContext returns the logger of the request in ctx. Every line logged through it
+//carries the request ID, the route, the user ID and the latency so far.
+func FromContext(ctx context.Context) *slog.Logger {
+	logger, ok := ctx.Value(loggerKey).(*slog.Logger)
+	if !ok {
+		logger = slog.Default()
+	}
+	return slog.New(boundHandler{Handler: logger.Handler(), ctx: ctx})
+}
+
+//contextHandler adds the request details found in the context to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if req := RequestFrom(ctx); req != nil {
+		r.AddAttrs(slog.String("request_id", req.ID))
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			r.AddAttrs(slog.String("route", rctx.RoutePattern()))
+		}
+		if req.UserID != 0 {
+			r.AddAttrs(slog.Int("user_id", req.UserID))
+		}
+		r.AddAttrs(slog.Duration("latency", time.Since(req.Start)))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+//boundHandler logs with the context it was created with, so callers of
+//FromContext do not need the Context variants of the log methods
+type boundHandler struct {
+	slog.Handler
+	ctx context.Context
+}
+
+func (h boundHandler) Handle(_ context.Context, r slog.Record) error {
+	return h.Handler.Handle(h.ctx, r)
+}
+
+func (h boundHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return boundHandler{Handler: h.Handler.WithAttrs(attrs), ctx: h.ctx}
+}
+
+func (h boundHandler) WithGroup(name string) slog.Handler {
+	return boundHandler{Handler: h.Handler.WithGroup(name), ctx: h.ctx}
+}
@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew_JSONInProduction(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, true, "info")
+
+	logger.Info("hello", "key", "value")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("production log is not JSON: %v (%s)", err, buf.String())
+	}
+	if line["msg"] != "hello" || line["key"] != "value" {
+		t.Errorf("unexpected log line %v", line)
+	}
+}
+
+func TestNew_Level(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, false, "warn")
+
+	logger.Info("skipped")
+	if buf.Len() != 0 {
+		t.Errorf("info line logged at warn level: %s", buf.String())
+	}
+
+	logger.Warn("kept")
+	if !strings.Contains(buf.String(), "kept") {
+		t.Errorf("warn line not logged: %s", buf.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	var tests = []struct {
+		in   string
+		want slog.Level
+	}{
+		{"debug", slog.LevelDebug},
+		{"INFO", slog.LevelInfo},
+		{"warning", slog.LevelWarn},
+		{"error", slog.LevelError},
+		{"", slog.LevelInfo},
+		{"nonsense", slog.LevelInfo},
+	}
+
+	for _, e := range tests {
+		if got := ParseLevel(e.in); got != e.want {
+			t.Errorf("ParseLevel(%q) = %v, wanted %v", e.in, got, e.want)
+		}
+	}
+}
+
+func TestFromContext_AddsRequestDetails(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, true, "info")
+
+	req := &Request{ID: "abc-123", Start: time.Now(), UserID: 7}
+	ctx := NewContext(context.Background(), logger, req)
+
+	FromContext(ctx).Info("inside request")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["request_id"] != "abc-123" {
+		t.Errorf("expected request_id abc-123, got %v", line["request_id"])
+	}
+	if line["user_id"] != float64(7) {
+		t.Errorf("expected user_id 7, got %v", line["user_id"])
+	}
+	if _, ok := line["latency"]; !ok {
+		t.Error("latency missing from log line")
+	}
+}
+
+func TestFromContext_OutsideRequest(t *testing.T) {
+	if FromContext(context.Background()) == nil {
+		t.Error("expected the default logger outside of a request")
+	}
+	if RequestFrom(context.Background()) != nil {
+		t.Error("expected no request details outside of a request")
+	}
+}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "booking"
+
+//Registry holds every metric exposed on /metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	//HTTPRequests counts requests by method, chi route pattern and status code
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	//HTTPDuration observes request latency by method and chi route pattern
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	//QueryDuration observes the duration of each repository method
+	QueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Duration of repository methods.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 3},
+	}, []string{"method"})
+
+	//QueryErrors counts failed repository methods
+	QueryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_query_errors_total",
+		Help:      "Repository methods that returned an error.",
+	}, []string{"method"})
+
+	//MailSent counts sent emails by outcome, success or failure
+	MailSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mail_sent_total",
+		Help:      "Emails sent by outcome.",
+	}, []string{"outcome"})
+
+	//ReservationsCreated counts reservations made by guests
+	ReservationsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reservations_created_total",
+		Help:      "Reservations created.",
+	})
+
+	//ReservationsCancelled counts reservations cancelled, by staff or by guests
+	ReservationsCancelled = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reservations_cancelled_total",
+		Help:      "Reservations cancelled.",
+	})
+
+	//ReservationsDeleted counts reservations moved to the trash by staff
+	ReservationsDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reservations_deleted_total",
+		Help:      "Reservations moved to the trash.",
+	})
+
+	//ReservationStatusChanges counts reservations moved to another status by staff, by the new status
+	ReservationStatusChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reservation_status_changes_total",
+		Help:      "Reservation status changes by the status moved to.",
+	}, []string{"status"})
+
+	//AvailabilitySearches counts availability searches by result, found, combined or empty
+	AvailabilitySearches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "availability_searches_total",
+		Help:      "Availability searches by result, combined when only several rooms together sleep the party and empty when none do.",
+	}, []string{"result"})
+
+	//AuditFailures counts changes that were made but could not be recorded in the audit log, by action
+	AuditFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audit_failures_total",
+		Help:      "Changes made but not recorded in the audit log, by action.",
+	}, []string{"action"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		QueryDuration,
+		QueryErrors,
+		MailSent,
+		ReservationsCreated,
+		ReservationsCancelled,
+		ReservationsDeleted,
+		ReservationStatusChanges,
+		AvailabilitySearches,
+		AuditFailures,
+	)
+}
+
+//Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+//RegisterDB exposes the connection pool stats of the database
+func RegisterDB(db *sql.DB) {
+	register(collectors.NewDBStatsCollector(db, namespace))
+}
+
+//RegisterMailQueue exposes the number of emails waiting to be sent
+func RegisterMailQueue(mailChan chan models.MailData) {
+	register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mail_queue_depth",
+		Help:      "Emails waiting in the mail queue.",
+	}, func() float64 {
+		return float64(len(mailChan))
+	}))
+}
+
+//ObserveQuery records the duration and outcome of a repository method
+func ObserveQuery(method string, start time.Time, err error) {
+	QueryDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		QueryErrors.WithLabelValues(method).Inc()
+	}
+}
+
+//register adds a collector, replacing one registered earlier under the same name
+func register(c prometheus.Collector) {
+	if err := Registry.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			Registry.Unregister(are.ExistingCollector)
+			Registry.MustRegister(c)
+		}
+	}
+}
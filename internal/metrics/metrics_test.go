@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveQuery(t *testing.T) {
+	ObserveQuery("GetRoomByID", time.Now(), nil)
+	ObserveQuery("GetRoomByID", time.Now(), errors.New("no rows"))
+
+	if got := testutil.ToFloat64(QueryErrors.WithLabelValues("GetRoomByID")); got != 1 {
+		t.Errorf("expected 1 query error, got %v", got)
+	}
+	if got := testutil.CollectAndCount(QueryDuration, "booking_db_query_duration_seconds"); got != 1 {
+		t.Errorf("expected 1 duration series, got %d", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	mailChan := make(chan models.MailData, 5)
+	mailChan <- models.MailData{}
+	mailChan <- models.MailData{}
+	RegisterMailQueue(mailChan)
+	//registering again must not panic
+	RegisterMailQueue(mailChan)
+
+	ReservationsCreated.Inc()
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		"booking_mail_queue_depth 2",
+		"booking_reservations_created_total 1",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output is missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//dialect holds the statements that differ between the databases
+type dialect struct {
+	//lock and unlock are held around a migration run, so app instances starting
+	//at the same time do not apply the same migration twice. SQLite has no lock,
+	//a racing instance fails on the version key and rolls its migration back.
+	lock   string
+	unlock string
+	//exists tells whether the table named by its parameter exists
+	exists string
+}
+
+var dialects = map[string]dialect{
+	"postgres": {
+		lock:   `select pg_advisory_lock(4276352)`,
+		unlock: `select pg_advisory_unlock(4276352)`,
+		exists: `select to_regclass($1) is not null`,
+	},
+	"sqlite": {
+		exists: `select count(*) > 0 from sqlite_master where type = 'table' and name = $1`,
+	},
+}
+
+//Migration is one versioned schema change with the SQL that applies and reverts it
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+//Status is a migration and when it was applied, AppliedAt is zero while it is pending
+type Status struct {
+	Migration
+	AppliedAt time.Time
+}
+
+//Applied reports whether the migration was applied
+func (s Status) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+//Migrator applies migrations to a database and records them in the schema_migrations table
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+	dialect    dialect
+}
+
+var filename = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+//Load reads the <version>_<name>.up.sql and .down.sql files of fsys, sorted by version
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, f := range files {
+		parts := filename.FindStringSubmatch(f)
+		if parts == nil {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.up.sql or .down.sql", f)
+		}
+		version, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", f, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		} else if m.Name != parts[2] {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", m.Name, parts[2], version)
+		}
+
+		content, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, err
+		}
+		if parts[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+//New creates a migrator for a postgres or sqlite db with the migrations in fsys
+func New(db *sql.DB, driver string, fsys fs.FS) (*Migrator, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("cannot migrate database driver %q", driver)
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		DB:         db,
+		Migrations: migrations,
+		dialect:    d,
+	}, nil
+}
+
+//Up applies every pending migration in version order and returns the ones it applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, mig := range pending(m.Migrations, applied) {
+			err := m.apply(ctx, conn, mig.Up, `insert into schema_migrations (version, name, applied_at) values ($1, $2, $3)`,
+				mig.Version, mig.Name, time.Now())
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+//Down reverts the last steps applied migrations, newest first, and returns the ones it reverted
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, mig := range latest(m.Migrations, applied, steps) {
+			err := m.apply(ctx, conn, mig.Down, `delete from schema_migrations where version = $1`, mig.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+//Status lists every migration and whether it was applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, mig := range m.Migrations {
+			statuses = append(statuses, Status{Migration: mig, AppliedAt: applied[mig.Version]})
+		}
+		return nil
+	})
+
+	return statuses, err
+}
+
+//apply runs the migration SQL and records it in one transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, script, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//locked runs fn on one connection holding the migration lock, with the versions already applied
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, applied map[int64]time.Time) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.dialect.lock != "" {
+		_, err = conn.ExecContext(ctx, m.dialect.lock)
+		if err != nil {
+			return fmt.Errorf("cannot take the migration lock: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), m.dialect.unlock)
+	}
+
+	err = m.createTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+//createTable creates the schema_migrations table. A database set up with soda
+//has its versions copied over so its migrations are not applied again.
+func (m *Migrator) createTable(ctx context.Context, conn *sql.Conn) error {
+	exists, err := m.tableExists(ctx, conn, "schema_migrations")
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx, `create table schema_migrations
+		(
+			version bigint primary key,
+			name varchar(255) not null,
+			applied_at timestamp not null
+		)`)
+	if err != nil {
+		return err
+	}
+
+	soda, err := m.tableExists(ctx, conn, "schema_migration")
+	if err != nil || !soda {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, `select version from schema_migration`)
+	if err != nil {
+		return err
+	}
+	var versions []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		versions = append(versions, v)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	known := make(map[int64]Migration)
+	for _, mig := range m.Migrations {
+		known[mig.Version] = mig
+	}
+	for _, v := range versions {
+		version, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		mig, ok := known[version]
+		if !ok {
+			continue
+		}
+		_, err = conn.ExecContext(ctx, `insert into schema_migrations (version, name, applied_at) values ($1, $2, $3)`,
+			mig.Version, mig.Name, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) tableExists(ctx context.Context, conn *sql.Conn, table string) (bool, error) {
+	var exists bool
+	err := conn.QueryRowContext(ctx, m.dialect.exists, table).Scan(&exists)
+	return exists, err
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `select version, applied_at from schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+//pending returns the migrations not applied yet, oldest first
+func pending(all []Migration, applied map[int64]time.Time) []Migration {
+	var out []Migration
+	for _, mig := range all {
+		if _, ok := applied[mig.Version]; !ok {
+			out = append(out, mig)
+		}
+	}
+	return out
+}
+
+//latest returns the last steps applied migrations, newest first
+func latest(all []Migration, applied map[int64]time.Time, steps int) []Migration {
+	var out []Migration
+	for i := len(all) - 1; i >= 0 && len(out) < steps; i-- {
+		if _, ok := applied[all[i].Version]; ok {
+			out = append(out, all[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,235 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/drivers"
+	"github.com/ArmanurRahman/booking/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"2_create_rooms.up.sql":   {Data: []byte("create table rooms (id int);")},
+		"2_create_rooms.down.sql": {Data: []byte("drop table rooms;")},
+		"1_create_users.up.sql":   {Data: []byte("create table users (id int);")},
+		"1_create_users.down.sql": {Data: []byte("drop table users;")},
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("expected 2 migrations, got %d", len(migrations))
+	}
+	if migrations[0].Version != 1 || migrations[0].Name != "create_users" || migrations[1].Version != 2 {
+		t.Errorf("migrations not sorted by version: %+v", migrations)
+	}
+	if migrations[1].Down != "drop table rooms;" {
+		t.Errorf("unexpected down script %q", migrations[1].Down)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	var tests = []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{"missing-down", fstest.MapFS{
+			"1_create_users.up.sql": {Data: []byte("create table users (id int);")},
+		}},
+		{"bad-name", fstest.MapFS{
+			"create_users.sql": {Data: []byte("create table users (id int);")},
+		}},
+		{"shared-version", fstest.MapFS{
+			"1_create_users.up.sql":   {Data: []byte("select 1;")},
+			"1_create_users.down.sql": {Data: []byte("select 1;")},
+			"1_create_rooms.up.sql":   {Data: []byte("select 1;")},
+			"1_create_rooms.down.sql": {Data: []byte("select 1;")},
+		}},
+	}
+
+	for _, e := range tests {
+		if _, err := Load(e.fsys); err == nil {
+			t.Errorf("for %s, expected an error", e.name)
+		}
+	}
+}
+
+func TestLoad_Embedded(t *testing.T) {
+	var versions [][]int64
+	for _, driver := range []string{"postgres", "sqlite"} {
+		fsys, err := migrations.For(driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all, err := Load(fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) == 0 {
+			t.Errorf("no %s migrations embedded in the binary", driver)
+		}
+
+		var v []int64
+		for _, mig := range all {
+			v = append(v, mig.Version)
+		}
+		versions = append(versions, v)
+	}
+
+	if fmt.Sprint(versions[0]) != fmt.Sprint(versions[1]) {
+		t.Errorf("postgres and sqlite migrations differ: %v and %v", versions[0], versions[1])
+	}
+}
+
+func TestMigrator_SQLite(t *testing.T) {
+	db, err := drivers.ConnectSQLite(filepath.Join(t.TempDir(), "booking.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SQL.Close()
+
+	fsys, err := migrations.For("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(db.SQL, "sqlite", fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(m.Migrations) {
+		t.Errorf("expected %d migrations applied, got %d", len(m.Migrations), len(applied))
+	}
+
+	applied, err = m.Up(ctx)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("expected nothing left to apply, got %d (%v)", len(applied), err)
+	}
+
+	_, err = db.SQL.ExecContext(ctx, `insert into audit_log (action, entity, changes) values ('room.create', 'room', '{}')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SQL.ExecContext(ctx, `update audit_log set action = 'room.delete'`); err == nil {
+		t.Error("expected the audit log to refuse updates")
+	}
+	if _, err := db.SQL.ExecContext(ctx, `delete from audit_log`); err == nil {
+		t.Error("expected the audit log to refuse deletes")
+	}
+
+	reverted, err := m.Down(ctx, 2)
+	if err != nil || len(reverted) != 2 {
+		t.Fatalf("expected 2 migrations reverted, got %d (%v)", len(reverted), err)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := statuses[len(statuses)-1]
+	if last.Applied() || !statuses[0].Applied() {
+		t.Errorf("unexpected status after down: first %v, last %v", statuses[0].Applied(), last.Applied())
+	}
+}
+
+func TestMigrator_SQLiteGuests(t *testing.T) {
+	db, err := drivers.ConnectSQLite(filepath.Join(t.TempDir(), "booking.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SQL.Close()
+
+	fsys, err := migrations.For("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(db.SQL, "sqlite", fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	//revert down to before the guests migration, so the reservations below are made before it
+	steps := 0
+	for _, mig := range m.Migrations {
+		if mig.Version >= 20261019140000 {
+			steps++
+		}
+	}
+	if _, err := m.Down(ctx, steps); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, guest := range [][3]string{
+		{"John", "john@smith.com", "555-010-0100"},
+		{"Johnny", " John@Smith.com", "(555) 010-0199"},
+		{"Jane", "jane@doe.com", "55501"},
+	} {
+		_, err := db.SQL.ExecContext(ctx, `insert into reservations (first_name, last_name, email, phone, room_id, create_at)
+				values (?, 'Smith', ?, ?, 1, ?)`, guest[0], guest[1], guest[2], time.Date(2040, 1, i+1, 0, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.SQL.QueryContext(ctx, `select g.first_name, g.email_key, g.phone_key, count(r.id)
+			from guests g join reservations r on r.guest_id = g.id
+			group by g.id order by g.email_key`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var guests []string
+	for rows.Next() {
+		var name, email, phone string
+		var stays int
+		if err := rows.Scan(&name, &email, &phone, &stays); err != nil {
+			t.Fatal(err)
+		}
+		guests = append(guests, fmt.Sprintf("%s %s %s %d", name, email, phone, stays))
+	}
+	expected := []string{"Jane jane@doe.com  1", "Johnny john@smith.com 5550100199 2"}
+	if fmt.Sprint(guests) != fmt.Sprint(expected) {
+		t.Errorf("expected guests %v, got %v", expected, guests)
+	}
+}
+
+func TestPendingAndLatest(t *testing.T) {
+	all := []Migration{{Version: 1}, {Version: 2}, {Version: 3}}
+	applied := map[int64]time.Time{1: time.Now(), 2: time.Now()}
+
+	p := pending(all, applied)
+	if len(p) != 1 || p[0].Version != 3 {
+		t.Errorf("expected migration 3 to be pending, got %+v", p)
+	}
+
+	l := latest(all, applied, 5)
+	if len(l) != 2 || l[0].Version != 2 || l[1].Version != 1 {
+		t.Errorf("expected migrations 2 and 1 to be reverted, got %+v", l)
+	}
+
+	l = latest(all, applied, 1)
+	if len(l) != 1 || l[0].Version != 2 {
+		t.Errorf("expected only migration 2 to be reverted, got %+v", l)
+	}
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"github.com/ArmanurRahman/booking/internal/domain"
 )
 
 //User is the user model
@@ -16,12 +18,19 @@ type User struct {
 	UpdatedAt   time.Time
 }
 
-//Room is room model
+//Room is room model, MaxOccupancy is the most guests it sleeps and Beds describes its beds
 type Room struct {
-	ID        int
-	RoomName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID           int
+	RoomName     string
+	MaxOccupancy int
+	Beds         string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+//Fits reports whether the room sleeps that many guests
+func (r Room) Fits(guests int) bool {
+	return guests <= r.MaxOccupancy
 }
 
 //Restriction is restriction model
@@ -32,7 +41,8 @@ type Restriction struct {
 	UpdatedAt       time.Time
 }
 
-//Reservation is reservation model
+//Reservation is reservation model, DeletedAt is zero unless it is in the trash and
+//BookingID is zero unless it was booked together with other rooms
 type Reservation struct {
 	ID        int
 	FirstName string
@@ -42,10 +52,192 @@ type Reservation struct {
 	StartDate time.Time
 	EndDate   time.Time
 	RoomID    int
+	GuestID   int
+	BookingID int
+	Adults    int
+	Children  int
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Room      Room
-	Process   int
+	Status    domain.Status
+	DeletedAt time.Time
+}
+
+//Guests returns the number of people staying, adults and children
+func (r Reservation) Guests() int {
+	return r.Adults + r.Children
+}
+
+//Booking groups the reservations of several rooms made in one checkout, for the same
+//or different dates. Each reservation keeps its own room, dates and status.
+type Booking struct {
+	ID           int
+	Reservations []Reservation
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+//Guests returns the number of people staying in the rooms of the booking
+func (b Booking) Guests() int {
+	guests := 0
+	for _, res := range b.Reservations {
+		guests += res.Guests()
+	}
+	return guests
+}
+
+//Guest is a person who books, reservations are linked to the guest with the same
+//email or phone number. Stays counts the reservations of the guest.
+type Guest struct {
+	ID        int
+	FirstName string
+	LastName  string
+	Email     string
+	Phone     string
+	Notes     string
+	Tags      []string
+	Stays     int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+//HasTag reports whether the guest is tagged with tag
+func (g Guest) HasTag(tag string) bool {
+	for _, t := range g.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+//GuestAccount is the login of a guest, kept apart from the staff users so a guest
+//never gets an access level. VerifyToken holds the hash of the token emailed to
+//confirm the address, it is empty once the address is verified.
+type GuestAccount struct {
+	ID            int
+	GuestID       int
+	Email         string
+	Password      string
+	VerifyToken   string
+	VerifyExpires time.Time
+	VerifiedAt    time.Time
+	Guest         Guest
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+//Verified reports whether the guest confirmed the email address of the account
+func (a GuestAccount) Verified() bool {
+	return !a.VerifiedAt.IsZero()
+}
+
+//StatusChange records who moved a reservation from one status to another and when,
+//UserID is zero for changes made outside of the admin pages
+type StatusChange struct {
+	ID            int
+	ReservationID int
+	From          domain.Status
+	To            domain.Status
+	UserID        int
+	CreatedAt     time.Time
+}
+
+//AuditEntry is one change recorded in the append-only audit log. UserID is zero
+//for changes made from the command line, Changes is the JSON diff of the entity.
+type AuditEntry struct {
+	ID        int
+	UserID    int
+	Action    string
+	Entity    string
+	EntityID  int
+	Changes   string
+	IP        string
+	Actor     string
+	CreatedAt time.Time
+}
+
+//AuditFilter narrows the audit entries listed, zero fields match every entry.
+//From is inclusive and To exclusive, Limit zero lists every entry.
+type AuditFilter struct {
+	UserID   int
+	Action   string
+	Entity   string
+	EntityID int
+	From     time.Time
+	To       time.Time
+	Limit    int
+}
+
+//The orders a reservation list can be sorted in
+const (
+	SortByArrival   = "arrival"
+	SortByDeparture = "departure"
+	SortByLastName  = "last_name"
+	SortByID        = "id"
+)
+
+//ReservationSorts are the orders a reservation list can be sorted in
+var ReservationSorts = []string{SortByArrival, SortByDeparture, SortByLastName, SortByID}
+
+//DefaultPageSize and MaxPageSize bound the size of a page of reservations
+const (
+	DefaultPageSize = 25
+	MaxPageSize     = 100
+)
+
+//ReservationQuery selects a page of the reservations, zero fields match every reservation.
+//From and To bound the arrival date and are inclusive, Search matches the start of the
+//first name, last name or email for every word. A page follows the one whose Next cursor
+//is given as After, or precedes the one whose Prev cursor is given as Before.
+type ReservationQuery struct {
+	Statuses []domain.Status
+	RoomID   int
+	From     time.Time
+	To       time.Time
+	Search   string
+	Sort     string
+	Desc     bool
+	Size     int
+	After    string
+	Before   string
+}
+
+//ReservationPage is a page of reservations, Next and Prev are empty on the last and first page
+type ReservationPage struct {
+	Reservations []Reservation
+	Next         string
+	Prev         string
+}
+
+//The periods the analytics are grouped by, a week starts on Monday
+const (
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+//Periods are the periods the analytics can be grouped by
+var Periods = []string{PeriodDay, PeriodWeek, PeriodMonth}
+
+//Occupancy is how many nights of a room were booked in a period. Nights counts the
+//nights of the period inside the range asked for, cancelled reservations book none.
+type Occupancy struct {
+	RoomID   int
+	RoomName string
+	Period   time.Time
+	Nights   int
+	Booked   int
+}
+
+//BookingStats sums up the reservations arriving in a period. Nights and LeadDays add up
+//the length of stay and the days booked ahead of the reservations not cancelled.
+type BookingStats struct {
+	Period       time.Time
+	Reservations int
+	Cancelled    int
+	Nights       int
+	LeadDays     int
 }
 
 //RoomRestriction is the roomRestriction model
@@ -60,7 +252,7 @@ type RoomRestriction struct {
 	UpdatedAt     time.Time
 	Room          Room
 	Reservation   Reservation
-	Restriction   Reservation
+	Restriction   Restriction
 }
 
 //holds an email message
@@ -70,4 +262,24 @@ type MailData struct {
 	Subject  string
 	Content  string
 	Template string
+	//Failed is called by the mail worker when the email could not be sent
+	Failed func()
+}
+
+//SentNotification records a guest notification that has already been sent
+type SentNotification struct {
+	ID            int
+	ReservationID int
+	Kind          string
+	CreatedAt     time.Time
+}
+
+//Notification is an in-app notification shown to staff, Read is set per user
+type Notification struct {
+	ID        int
+	Title     string
+	Body      string
+	Link      string
+	Read      bool
+	CreatedAt time.Time
 }
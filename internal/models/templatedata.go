@@ -14,4 +14,6 @@ type TemplateData struct {
 	Error      string
 	Form       *forms.Form
 	IsLoggedIn int
+	IsGuest    int
+	CartSize   int
 }
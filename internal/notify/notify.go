@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/ArmanurRahman/booking/internal/repository"
+	"github.com/ArmanurRahman/booking/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//Channel delivers a staff notification through one medium
+type Channel interface {
+	Notify(ctx context.Context, n models.Notification) error
+}
+
+//Dispatcher sends staff notifications to every configured channel
+type Dispatcher struct {
+	App      *config.AppConfig
+	Channels []Channel
+	wg       sync.WaitGroup
+}
+
+//NewDispatcher builds a dispatcher with the channels enabled in the app config
+func NewDispatcher(a *config.AppConfig, db repository.DatabaseRepo) *Dispatcher {
+	var channels []Channel
+
+	cfg := a.Notifications
+	if len(cfg.StaffEmails) > 0 {
+		channels = append(channels, &MailChannel{MailChan: a.MailChan, From: a.Mail.From, To: cfg.StaffEmails, PublicURL: a.PublicURL})
+	}
+	if cfg.WebhookURL != "" {
+		channels = append(channels, NewWebhookChannel(cfg.WebhookURL))
+	}
+	if cfg.InApp {
+		channels = append(channels, &InAppChannel{DB: db})
+	}
+
+	return &Dispatcher{
+		App:      a,
+		Channels: channels,
+	}
+}
+
+//Notify sends the notification to every channel in the background, so a slow
+//channel never delays the request that triggered it. The channels keep the trace
+//of ctx but are not cancelled when the request ends.
+func (d *Dispatcher) Notify(ctx context.Context, n models.Notification) {
+	ctx = context.WithoutCancel(ctx)
+	for _, c := range d.Channels {
+		d.wg.Add(1)
+		go func(c Channel) {
+			defer d.wg.Done()
+			name := fmt.Sprintf("%T", c)
+			ctx, span := tracing.Start(ctx, "notify", attribute.String("notify.channel", name))
+			err := c.Notify(ctx, n)
+			tracing.End(span, err)
+			if err != nil && d.App.Logger != nil {
+				d.App.Logger.Error("cannot notify staff", "channel", name, "error", err)
+			}
+		}(c)
+	}
+}
+
+//Wait blocks until the notifications being sent are delivered
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+//MailChannel emails the staff distribution list through the mail queue. The
+//links of notifications are relative to PublicURL, the address of the site.
+type MailChannel struct {
+	MailChan  chan models.MailData
+	From      string
+	To        []string
+	PublicURL string
+}
+
+func (c *MailChannel) Notify(ctx context.Context, n models.Notification) error {
+	link := strings.TrimSuffix(c.PublicURL, "/") + n.Link
+	content := fmt.Sprintf(`
+			<strong>%s</strong><br>
+			%s<br>
+			<a href="%s">View reservation</a>
+		`, template.HTMLEscapeString(n.Title), template.HTMLEscapeString(n.Body), template.HTMLEscapeString(link))
+
+	for _, to := range c.To {
+		c.MailChan <- models.MailData{
+			To:       to,
+			From:     c.From,
+			Subject:  n.Title,
+			Content:  content,
+			Template: "basic.html",
+		}
+	}
+	return nil
+}
+
+//WebhookChannel posts the notification as JSON to a chat webhook
+type WebhookChannel struct {
+	URL    string
+	Client *http.Client
+}
+
+//NewWebhookChannel creates a webhook channel with a request timeout
+func NewWebhookChannel(url string) *WebhookChannel {
+	return &WebhookChannel{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type webhookPayload struct {
+	Text  string `json:"text"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Link  string `json:"link"`
+}
+
+func (c *WebhookChannel) Notify(ctx context.Context, n models.Notification) error {
+	out, err := json.Marshal(webhookPayload{
+		Text:  fmt.Sprintf("%s: %s", n.Title, n.Body),
+		Title: n.Title,
+		Body:  n.Body,
+		Link:  n.Link,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(out))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+//InAppChannel stores the notification for the admin notification bell
+type InAppChannel struct {
+	DB repository.DatabaseRepo
+}
+
+func (c *InAppChannel) Notify(ctx context.Context, n models.Notification) error {
+	_, err := c.DB.InsertNotification(ctx, n)
+	return err
+}
@@ -0,0 +1,89 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/models"
+)
+
+var notification = models.Notification{
+	Title: "New reservation",
+	Body:  "Smith booked General's Quarters",
+	Link:  "/admin/reservation/new/1",
+}
+
+func TestMailChannel_Notify(t *testing.T) {
+	mailChan := make(chan models.MailData, 2)
+	c := &MailChannel{MailChan: mailChan, From: "bookings@test.com", To: []string{"a@test.com", "b@test.com"}, PublicURL: "https://booking.test/"}
+
+	if err := c.Notify(context.Background(), notification); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mailChan) != 2 {
+		t.Fatalf("expected one email per staff address, got %d", len(mailChan))
+	}
+	msg := <-mailChan
+	if msg.To != "a@test.com" || msg.Subject != notification.Title {
+		t.Errorf("unexpected email %+v", msg)
+	}
+	if !strings.Contains(msg.Content, `href="https://booking.test/admin/reservation/new/1"`) {
+		t.Errorf("expected an absolute link in %s", msg.Content)
+	}
+	if !strings.Contains(msg.Content, "General&#39;s Quarters") {
+		t.Errorf("expected the body to be escaped in %s", msg.Content)
+	}
+}
+
+func TestWebhookChannel_Notify(t *testing.T) {
+	var got webhookPayload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("webhook posted with content type %s", r.Header.Get("Content-Type"))
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer ts.Close()
+
+	if err := NewWebhookChannel(ts.URL).Notify(context.Background(), notification); err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != notification.Title || got.Link != notification.Link || got.Text == "" {
+		t.Errorf("unexpected payload %+v", got)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	if err := NewWebhookChannel(failing.URL).Notify(context.Background(), notification); err == nil {
+		t.Error("expected an error when the webhook fails")
+	}
+}
+
+func TestNewDispatcher(t *testing.T) {
+	a := config.AppConfig{
+		Notifications: config.NotificationConfig{
+			StaffEmails: []string{"staff@test.com"},
+			WebhookURL:  "http://localhost/hook",
+			InApp:       true,
+		},
+	}
+
+	d := NewDispatcher(&a, nil)
+	if len(d.Channels) != 3 {
+		t.Errorf("expected 3 channels, got %d", len(d.Channels))
+	}
+
+	d = NewDispatcher(&config.AppConfig{}, nil)
+	if len(d.Channels) != 0 {
+		t.Errorf("expected no channels, got %d", len(d.Channels))
+	}
+}
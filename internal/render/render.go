@@ -11,8 +11,11 @@ import (
 	"path/filepath"
 
 	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/logging"
 	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/ArmanurRahman/booking/internal/tracing"
 	"github.com/justinas/nosurf"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var functions = template.FuncMap{
@@ -31,6 +34,8 @@ func HumanDate(t time.Time) string {
 }
 
 func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.TemplateData) error {
+	_, span := tracing.Start(r.Context(), "render", attribute.String("template", tmpl))
+	defer span.End()
 
 	var tc map[string]*template.Template
 
@@ -51,12 +56,16 @@ func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.Te
 
 	td = AddDefaultData(td, r)
 
-	_ = t.Execute(buf, td)
+	err := t.Execute(buf, td)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("cannot execute template", "template", tmpl, "error", err)
+		span.RecordError(err)
+	}
 
-	_, err := buf.WriteTo(w)
+	_, err = buf.WriteTo(w)
 
 	if err != nil {
-		fmt.Println("Error writing template to browser", err)
+		logging.FromContext(r.Context()).Error("cannot write template to browser", "template", tmpl, "error", err)
 		return err
 	}
 
@@ -110,5 +119,11 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsLoggedIn = 1
 	}
+	if app.Session.Exists(r.Context(), "guest_account_id") {
+		td.IsGuest = 1
+	}
+	if cart, ok := app.Session.Get(r.Context(), "cart").([]models.Reservation); ok {
+		td.CartSize = len(cart)
+	}
 	return td
 }
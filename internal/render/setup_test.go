@@ -2,13 +2,13 @@ package render
 
 import (
 	"encoding/gob"
-	"log"
 	"net/http"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/logging"
 	"github.com/ArmanurRahman/booking/internal/models"
 	"github.com/alexedwards/scs/v2"
 )
@@ -23,11 +23,7 @@ func TestMain(m *testing.M) {
 	//change this value to true in production
 	testApp.IsProduction = false
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	testApp.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	testApp.ErrorLog = errorLog
+	testApp.Logger = logging.New(os.Stdout, false, "info")
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
 	session.Cookie.Persist = true
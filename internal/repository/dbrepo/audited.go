@@ -0,0 +1,323 @@
+package dbrepo
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/audit"
+	"github.com/ArmanurRahman/booking/internal/domain"
+	"github.com/ArmanurRahman/booking/internal/logging"
+	"github.com/ArmanurRahman/booking/internal/metrics"
+	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/ArmanurRahman/booking/internal/repository"
+)
+
+//auditedDBRepo records every write of the wrapped repository in the audit log, with the
+//actor stored in the context. The reads and the notification bookkeeping go straight through.
+type auditedDBRepo struct {
+	repository.DatabaseRepo
+}
+
+//NewAuditedRepo wraps a repository so its writes are recorded in the audit log
+func NewAuditedRepo(repo repository.DatabaseRepo) repository.DatabaseRepo {
+	return &auditedDBRepo{repo}
+}
+
+//record appends an entry to the audit log. The change was already made, so a failure
+//is logged and counted in the audit_failures_total metric rather than returned to the caller.
+func (m *auditedDBRepo) record(ctx context.Context, action, entity string, id int, before, after map[string]interface{}) {
+	actor := audit.ActorFrom(ctx)
+	_, err := m.DatabaseRepo.InsertAuditEntry(ctx, models.AuditEntry{
+		UserID:   actor.UserID,
+		Action:   action,
+		Entity:   entity,
+		EntityID: id,
+		Changes:  audit.Diff(before, after),
+		IP:       actor.IP,
+		Actor:    actor.Name,
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot write audit log", "action", action, "entity", entity, "entity_id", id, "error", err)
+		metrics.AuditFailures.WithLabelValues(action).Inc()
+	}
+}
+
+//reservationSnapshot loads the audited fields of a reservation, nil when it cannot be loaded
+func (m *auditedDBRepo) reservationSnapshot(ctx context.Context, id int) map[string]interface{} {
+	res, err := m.DatabaseRepo.GetReservationById(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return reservationFieldsOf(res)
+}
+
+func reservationFieldsOf(res models.Reservation) map[string]interface{} {
+	//a loaded reservation has its room joined instead of the room id set
+	roomID := res.RoomID
+	if roomID == 0 {
+		roomID = res.Room.ID
+	}
+	return map[string]interface{}{
+		"first_name": res.FirstName,
+		"last_name":  res.LastName,
+		"email":      res.Email,
+		"phone":      res.Phone,
+		"room_id":    roomID,
+		"start_date": res.StartDate.Format("2006-01-02"),
+		"end_date":   res.EndDate.Format("2006-01-02"),
+		"status":     string(res.Status),
+	}
+}
+
+//guestFieldsOf returns the audited fields of a guest
+func guestFieldsOf(guest models.Guest) map[string]interface{} {
+	return map[string]interface{}{
+		"first_name": guest.FirstName,
+		"last_name":  guest.LastName,
+		"email":      guest.Email,
+		"phone":      guest.Phone,
+		"notes":      guest.Notes,
+		"tags":       strings.Join(guest.Tags, ","),
+	}
+}
+
+//userFieldsOf returns the audited fields of a user, the password is never recorded
+func userFieldsOf(user models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"first_name":   user.FirstName,
+		"last_name":    user.LastName,
+		"email":        user.Email,
+		"access_level": user.AccessLevel,
+	}
+}
+
+func (m *auditedDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	id, err := m.DatabaseRepo.InsertReservation(ctx, res)
+	if err != nil {
+		return id, err
+	}
+	res.Status = domain.Pending
+	m.record(ctx, audit.ReservationCreate, "reservation", id, nil, reservationFieldsOf(res))
+	return id, nil
+}
+
+func (m *auditedDBRepo) InsetIntoRoomRestriction(ctx context.Context, res models.RoomRestriction) error {
+	err := m.DatabaseRepo.InsetIntoRoomRestriction(ctx, res)
+	if err != nil {
+		return err
+	}
+	m.record(ctx, audit.RestrictionCreate, "room", res.RoomID, nil, map[string]interface{}{
+		"start_date":     res.StartDate.Format("2006-01-02"),
+		"end_date":       res.EndDate.Format("2006-01-02"),
+		"reservation_id": res.ResevationID,
+		"restriction_id": res.RestrictionID,
+	})
+	return nil
+}
+
+func (m *auditedDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	id, err := m.DatabaseRepo.InsertRoom(ctx, room)
+	if err != nil {
+		return id, err
+	}
+	m.record(ctx, audit.RoomCreate, "room", id, nil, map[string]interface{}{"room_name": room.RoomName, "max_occupancy": room.MaxOccupancy, "beds": room.Beds})
+	return id, nil
+}
+
+func (m *auditedDBRepo) UpdateUserById(ctx context.Context, user models.User) error {
+	var before map[string]interface{}
+	if old, err := m.DatabaseRepo.GetUserById(ctx, user.ID); err == nil {
+		before = userFieldsOf(old)
+	}
+
+	err := m.DatabaseRepo.UpdateUserById(ctx, user)
+	if err != nil {
+		return err
+	}
+	m.record(ctx, audit.UserUpdate, "user", user.ID, before, userFieldsOf(user))
+	return nil
+}
+
+func (m *auditedDBRepo) InsertUser(ctx context.Context, user models.User) (int, error) {
+	id, err := m.DatabaseRepo.InsertUser(ctx, user)
+	if err != nil {
+		return id, err
+	}
+	m.record(ctx, audit.UserCreate, "user", id, nil, userFieldsOf(user))
+	return id, nil
+}
+
+func (m *auditedDBRepo) UpdateUserPassword(ctx context.Context, id int, hashedPassword string) error {
+	err := m.DatabaseRepo.UpdateUserPassword(ctx, id, hashedPassword)
+	if err != nil {
+		return err
+	}
+	m.record(ctx, audit.UserPassword, "user", id, nil, nil)
+	return nil
+}
+
+func (m *auditedDBRepo) UpdateReservationById(ctx context.Context, reservation models.Reservation) error {
+	before := m.reservationSnapshot(ctx, reservation.ID)
+
+	err := m.DatabaseRepo.UpdateReservationById(ctx, reservation)
+	if err != nil {
+		return err
+	}
+	m.record(ctx, audit.ReservationUpdate, "reservation", reservation.ID, before, m.reservationSnapshot(ctx, reservation.ID))
+	return nil
+}
+
+func (m *auditedDBRepo) DeleteReservationById(ctx context.Context, id int) error {
+	before := m.reservationSnapshot(ctx, id)
+
+	err := m.DatabaseRepo.DeleteReservationById(ctx, id)
+	if err != nil {
+		return err
+	}
+	m.record(ctx, audit.ReservationDelete, "reservation", id, before, nil)
+	return nil
+}
+
+func (m *auditedDBRepo) RestoreReservation(ctx context.Context, id int) error {
+	err := m.DatabaseRepo.RestoreReservation(ctx, id)
+	if err != nil {
+		return err
+	}
+	m.record(ctx, audit.ReservationRestore, "reservation", id, nil, m.reservationSnapshot(ctx, id))
+	return nil
+}
+
+func (m *auditedDBRepo) ImportReservations(ctx context.Context, reservations []models.Reservation) ([]int, error) {
+	ids, err := m.DatabaseRepo.ImportReservations(ctx, reservations)
+	if err != nil {
+		return ids, err
+	}
+	for i, id := range ids {
+		m.record(ctx, audit.ReservationImport, "reservation", id, nil, reservationFieldsOf(reservations[i]))
+	}
+	return ids, nil
+}
+
+func (m *auditedDBRepo) PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error) {
+	n, err := m.DatabaseRepo.PurgeDeletedReservations(ctx, before)
+	if err != nil || n == 0 {
+		return n, err
+	}
+	m.record(ctx, audit.ReservationPurge, "reservation", 0, nil, map[string]interface{}{
+		"deleted_before": before.UTC().Format(time.RFC3339),
+		"purged":         n,
+	})
+	return n, nil
+}
+
+func (m *auditedDBRepo) UpdateReservationStatus(ctx context.Context, id int, to domain.Status, userID int) error {
+	before := m.reservationSnapshot(ctx, id)
+
+	err := m.DatabaseRepo.UpdateReservationStatus(ctx, id, to, userID)
+	if err != nil {
+		return err
+	}
+	m.record(ctx, audit.ReservationStatus, "reservation", id, before, m.reservationSnapshot(ctx, id))
+	return nil
+}
+
+func (m *auditedDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time) error {
+	before := m.reservationSnapshot(ctx, id)
+
+	err := m.DatabaseRepo.ChangeReservationDates(ctx, id, start, end)
+	if err != nil {
+		return err
+	}
+	m.record(ctx, audit.ReservationDates, "reservation", id, before, m.reservationSnapshot(ctx, id))
+	return nil
+}
+
+func (m *auditedDBRepo) UpdateGuest(ctx context.Context, guest models.Guest) error {
+	var before map[string]interface{}
+	if old, err := m.DatabaseRepo.GetGuestByID(ctx, guest.ID); err == nil {
+		before = guestFieldsOf(old)
+	}
+
+	err := m.DatabaseRepo.UpdateGuest(ctx, guest)
+	if err != nil {
+		return err
+	}
+
+	var after map[string]interface{}
+	if saved, err := m.DatabaseRepo.GetGuestByID(ctx, guest.ID); err == nil {
+		after = guestFieldsOf(saved)
+	}
+	m.record(ctx, audit.GuestUpdate, "guest", guest.ID, before, after)
+	return nil
+}
+
+func (m *auditedDBRepo) MergeGuests(ctx context.Context, into, from int) error {
+	var before map[string]interface{}
+	if old, err := m.DatabaseRepo.GetGuestByID(ctx, into); err == nil {
+		before = guestFieldsOf(old)
+	}
+
+	err := m.DatabaseRepo.MergeGuests(ctx, into, from)
+	if err != nil {
+		return err
+	}
+
+	after := map[string]interface{}{}
+	if merged, err := m.DatabaseRepo.GetGuestByID(ctx, into); err == nil {
+		after = guestFieldsOf(merged)
+	}
+	after["merged_guest_id"] = from
+	m.record(ctx, audit.GuestMerge, "guest", into, before, after)
+	return nil
+}
+
+//InsertGuestAccount records the new account, the password and the verification token are never recorded
+func (m *auditedDBRepo) InsertGuestAccount(ctx context.Context, account models.GuestAccount) (int, error) {
+	id, err := m.DatabaseRepo.InsertGuestAccount(ctx, account)
+	if err != nil {
+		return id, err
+	}
+
+	after := map[string]interface{}{"email": domain.EmailKey(account.Email)}
+	if saved, err := m.DatabaseRepo.GetGuestAccount(ctx, id); err == nil {
+		after["guest_id"] = saved.GuestID
+	}
+	m.record(ctx, audit.AccountCreate, "guest_account", id, nil, after)
+	return id, nil
+}
+
+//InsertBooking records every reservation of the booking and the booking with their ids
+func (m *auditedDBRepo) InsertBooking(ctx context.Context, reservations []models.Reservation) (int, []int, error) {
+	bookingID, ids, err := m.DatabaseRepo.InsertBooking(ctx, reservations)
+	if err != nil {
+		return bookingID, ids, err
+	}
+	for i, id := range ids {
+		res := reservations[i]
+		res.Status = domain.Pending
+		m.record(ctx, audit.ReservationCreate, "reservation", id, nil, reservationFieldsOf(res))
+	}
+	m.record(ctx, audit.BookingCreate, "booking", bookingID, nil, map[string]interface{}{"reservations": ids})
+	return bookingID, ids, nil
+}
+
+//CancelBooking records the status change of every cancelled reservation and the booking with their ids
+func (m *auditedDBRepo) CancelBooking(ctx context.Context, id int, userID int) ([]int, error) {
+	before := make(map[int]map[string]interface{})
+	if booking, err := m.DatabaseRepo.GetBooking(ctx, id); err == nil {
+		for _, res := range booking.Reservations {
+			before[res.ID] = reservationFieldsOf(res)
+		}
+	}
+
+	ids, err := m.DatabaseRepo.CancelBooking(ctx, id, userID)
+	if err != nil || len(ids) == 0 {
+		return ids, err
+	}
+	for _, res := range ids {
+		m.record(ctx, audit.ReservationStatus, "reservation", res, before[res], m.reservationSnapshot(ctx, res))
+	}
+	m.record(ctx, audit.BookingCancel, "booking", id, nil, map[string]interface{}{"cancelled": ids})
+	return ids, nil
+}
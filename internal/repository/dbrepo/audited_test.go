@@ -0,0 +1,98 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/audit"
+	"github.com/ArmanurRahman/booking/internal/domain"
+	"github.com/ArmanurRahman/booking/internal/metrics"
+	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAuditedDBRepo_RecordsWrites(t *testing.T) {
+	memory := NewMemoryRepo(testApp)
+	repo := NewAuditedRepo(memory)
+	ctx := audit.NewContext(context.Background(), audit.Actor{UserID: 7, IP: "192.0.2.1"})
+
+	id, err := repo.InsertReservation(ctx, models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: day(10),
+		EndDate:   day(12),
+		RoomID:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Email = "jane@smith.com"
+	if err := repo.UpdateReservationById(ctx, res); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdateReservationStatus(ctx, id, domain.Confirmed, 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdateUserPassword(ctx, 1, "$2a$12$secret-hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := memory.AuditEntries(ctx, models.AuditFilter{})
+	if err != nil || len(entries) != 4 {
+		t.Fatalf("expected 4 audit entries, got %+v (%v)", entries, err)
+	}
+
+	update := entries[2]
+	if update.Action != audit.ReservationUpdate || update.Entity != "reservation" || update.EntityID != id {
+		t.Errorf("unexpected update entry %+v", update)
+	}
+	if update.UserID != 7 || update.IP != "192.0.2.1" {
+		t.Errorf("entry not recorded with the actor, got %+v", update)
+	}
+	if update.Changes != `{"email":{"before":"john@smith.com","after":"jane@smith.com"}}` {
+		t.Errorf("expected only the email in the diff, got %s", update.Changes)
+	}
+
+	if status := audit.Parse(entries[1].Changes)["status"]; status.Before != "pending" || status.After != "confirmed" {
+		t.Errorf("expected the status change in the diff, got %s", entries[1].Changes)
+	}
+	if strings.Contains(entries[0].Changes, "secret-hash") {
+		t.Errorf("the password hash was recorded: %s", entries[0].Changes)
+	}
+}
+
+func TestAuditedDBRepo_Failures(t *testing.T) {
+	memory := NewMemoryRepo(testApp)
+	repo := NewAuditedRepo(memory)
+	ctx := context.Background()
+
+	restore := memory.FailOn("InsertRoom", errors.New("disk full"))
+	if _, err := repo.InsertRoom(ctx, models.Room{RoomName: "Colonel's Cabin"}); err == nil {
+		t.Error("expected the write to fail")
+	}
+	restore()
+	if entries, _ := memory.AuditEntries(ctx, models.AuditFilter{}); len(entries) != 0 {
+		t.Errorf("a failed write was recorded: %+v", entries)
+	}
+
+	failures := testutil.ToFloat64(metrics.AuditFailures.WithLabelValues(audit.RoomCreate))
+	defer memory.FailOn("InsertAuditEntry", errors.New("disk full"))()
+	if _, err := repo.InsertRoom(ctx, models.Room{RoomName: "Colonel's Cabin"}); err != nil {
+		t.Errorf("the write should not fail with the audit log, got %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.AuditFailures.WithLabelValues(audit.RoomCreate)) - failures; got != 1 {
+		t.Errorf("expected the missing audit entry to be counted, got %v", got)
+	}
+	if _, err := repo.PurgeDeletedReservations(ctx, time.Now()); err != nil {
+		t.Errorf("purging nothing should not fail, got %v", err)
+	}
+}
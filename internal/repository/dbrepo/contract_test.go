@@ -0,0 +1,1619 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/domain"
+	"github.com/ArmanurRahman/booking/internal/drivers"
+	"github.com/ArmanurRahman/booking/internal/migrate"
+	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/ArmanurRahman/booking/internal/repository"
+	"github.com/ArmanurRahman/booking/migrations"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//testApp is the config of the repositories under test
+var testApp = &config.AppConfig{DB: config.DBConfig{QueryTimeout: 5 * time.Second, ExportTimeout: 5 * time.Second}}
+
+//implementations are the repositories the contract runs against, each open
+//returns an empty repository holding only the rows the migrations seed
+var implementations = []struct {
+	name string
+	open func(t *testing.T) repository.DatabaseRepo
+}{
+	{"memory", func(t *testing.T) repository.DatabaseRepo {
+		return NewMemoryRepo(testApp)
+	}},
+	{"sqlite", func(t *testing.T) repository.DatabaseRepo {
+		db, err := drivers.ConnectSQLite(filepath.Join(t.TempDir(), "booking.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.SQL.Close() })
+
+		migrateFresh(t, db)
+		return New(db, testApp)
+	}},
+	{"postgres", func(t *testing.T) repository.DatabaseRepo {
+		//the postgres database is wiped, so it only runs against a database set aside for it,
+		//set BOOKING_TEST_POSTGRES_DSN to run the contract against postgres too
+		dsn := os.Getenv("BOOKING_TEST_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("BOOKING_TEST_POSTGRES_DSN is not set, the contract is not checked against postgres")
+		}
+		db, err := drivers.ConnectSQL(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		migrateFresh(t, db)
+		return New(db, testApp)
+	}},
+}
+
+//migrateFresh reverts every migration of db and applies them again
+func migrateFresh(t *testing.T, db *drivers.DB) {
+	t.Helper()
+
+	fsys, err := migrations.For(db.Driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.New(db.SQL, db.Driver, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, err := m.Down(ctx, len(m.Migrations)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//contract is the behaviour every repository shares
+var contract = []struct {
+	name string
+	run  func(t *testing.T, repo repository.DatabaseRepo)
+}{
+	{"reservations", contractReservations},
+	{"status", contractStatus},
+	{"trash", contractTrash},
+	{"import", contractImport},
+	{"guests", contractGuests},
+	{"guest accounts", contractGuestAccounts},
+	{"change dates", contractChangeDates},
+	{"bookings", contractBookings},
+	{"concurrent bookings", contractConcurrentBookings},
+	{"reservation list", contractReservationList},
+	{"exports", contractExports},
+	{"availability", contractAvailability},
+	{"rooms", contractRooms},
+	{"users", contractUsers},
+	{"guest notifications", contractGuestNotifications},
+	{"staff notifications", contractStaffNotifications},
+	{"analytics", contractAnalytics},
+	{"audit log", contractAuditLog},
+	{"cancelled context", contractCancelledContext},
+}
+
+func TestContract(t *testing.T) {
+	for _, impl := range implementations {
+		t.Run(impl.name, func(t *testing.T) {
+			for _, c := range contract {
+				t.Run(c.name, func(t *testing.T) {
+					c.run(t, impl.open(t))
+				})
+			}
+		})
+	}
+}
+
+//day returns a date in January 2050
+func day(d int) time.Time {
+	return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+func contractReservations(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id, err := repo.InsertReservation(ctx, models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		Phone:     "555-555-5555",
+		StartDate: day(10),
+		EndDate:   day(12),
+		RoomID:    1,
+		Adults:    2,
+		Children:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetReservationById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != id || res.Email != "john@smith.com" || res.Status != domain.Pending || res.Adults != 2 || res.Children != 1 {
+		t.Errorf("unexpected reservation %+v", res)
+	}
+	if !res.StartDate.Equal(day(10)) || !res.EndDate.Equal(day(12)) {
+		t.Errorf("dates changed on the way through, got %s to %s", res.StartDate, res.EndDate)
+	}
+	if res.Room.ID != 1 || res.Room.RoomName != "General's Quarters" {
+		t.Errorf("reservation not joined with its room, got %+v", res.Room)
+	}
+
+	if _, err := repo.GetReservationById(ctx, id+100); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing reservation, got %v", err)
+	}
+
+	res.FirstName = "Jane"
+	if err := repo.UpdateReservationById(ctx, res); err != nil {
+		t.Fatal(err)
+	}
+	res, err = repo.GetReservationById(ctx, id)
+	if err != nil || res.FirstName != "Jane" {
+		t.Errorf("reservation was not updated: %+v (%v)", res, err)
+	}
+
+	arriving, err := repo.ReservationsByStartDate(ctx, day(10), day(10))
+	if err != nil || len(arriving) != 1 || arriving[0].ID != id {
+		t.Errorf("expected reservation %d to arrive on the 10th, got %+v (%v)", id, arriving, err)
+	}
+	departing, err := repo.ReservationsByEndDate(ctx, day(10), day(10))
+	if err != nil || len(departing) != 0 {
+		t.Errorf("expected nobody to depart on the 10th, got %+v (%v)", departing, err)
+	}
+	arriving, err = repo.ReservationsByStartDate(ctx, day(5), day(12))
+	if err != nil || len(arriving) != 1 || arriving[0].ID != id {
+		t.Errorf("expected reservation %d to arrive between the 5th and the 12th, got %+v (%v)", id, arriving, err)
+	}
+	if arriving, err := repo.ReservationsByStartDate(ctx, day(11), day(20)); err != nil || len(arriving) != 0 {
+		t.Errorf("expected nobody to arrive after the 10th, got %+v (%v)", arriving, err)
+	}
+
+	all, err := repo.ReservationsByStatus(ctx)
+	if err != nil || len(all) != 1 {
+		t.Errorf("expected 1 reservation in all, got %d (%v)", len(all), err)
+	}
+
+	if err := repo.DeleteReservationById(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	all, err = repo.ReservationsByStatus(ctx)
+	if err != nil || len(all) != 0 {
+		t.Errorf("expected no reservations after the delete, got %d (%v)", len(all), err)
+	}
+}
+
+//book inserts a reservation of room 1 with the restriction that blocks the room
+func book(t *testing.T, repo repository.DatabaseRepo, start, end time.Time) int {
+	ctx := context.Background()
+
+	id, err := repo.InsertReservation(ctx, models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		Phone:     "555-555-5555",
+		StartDate: start,
+		EndDate:   end,
+		RoomID:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.InsetIntoRoomRestriction(ctx, models.RoomRestriction{
+		StartDate:     start,
+		EndDate:       end,
+		RoomID:        1,
+		ResevationID:  id,
+		RestrictionID: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func contractTrash(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id := book(t, repo, day(10), day(12))
+	if err := repo.UpdateReservationStatus(ctx, id, domain.Confirmed, 7); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ClaimNotification(ctx, id, "pre-arrival"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.DeleteReservationById(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, day(10), day(12), 1)
+	if err != nil || !available {
+		t.Errorf("expected the room to be released by the delete, got %v (%v)", available, err)
+	}
+	if _, err := repo.GetReservationById(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a deleted reservation, got %v", err)
+	}
+	if count, err := repo.CountReservationsByStatus(ctx); err != nil || count != 0 {
+		t.Errorf("expected deleted reservations not to be counted, got %d (%v)", count, err)
+	}
+	if arriving, err := repo.ReservationsByStartDate(ctx, day(10), day(10)); err != nil || len(arriving) != 0 {
+		t.Errorf("expected nobody to arrive on the 10th, got %+v (%v)", arriving, err)
+	}
+	if err := repo.UpdateReservationStatus(ctx, id, domain.CheckedIn, 7); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for the status of a deleted reservation, got %v", err)
+	}
+	if err := repo.DeleteReservationById(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for deleting twice, got %v", err)
+	}
+
+	deleted, err := repo.DeletedReservations(ctx)
+	if err != nil || len(deleted) != 1 {
+		t.Fatalf("expected 1 reservation in the trash, got %+v (%v)", deleted, err)
+	}
+	if deleted[0].ID != id || deleted[0].DeletedAt.IsZero() || deleted[0].Room.ID != 1 {
+		t.Errorf("unexpected deleted reservation %+v", deleted[0])
+	}
+
+	if err := repo.RestoreReservation(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	res, err := repo.GetReservationById(ctx, id)
+	if err != nil || res.Status != domain.Confirmed {
+		t.Errorf("expected the restored reservation to keep its status, got %+v (%v)", res, err)
+	}
+	available, err = repo.SearchAvailabilityByDatesByRoomId(ctx, day(10), day(12), 1)
+	if err != nil || available {
+		t.Errorf("expected the restore to block the room again, got %v (%v)", available, err)
+	}
+	if err := repo.RestoreReservation(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for restoring a reservation not in the trash, got %v", err)
+	}
+
+	if err := repo.DeleteReservationById(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	book(t, repo, day(11), day(14))
+	if err := repo.RestoreReservation(ctx, id); !errors.Is(err, domain.ErrRoomUnavailable) {
+		t.Errorf("expected domain.ErrRoomUnavailable once the room is booked again, got %v", err)
+	}
+
+	n, err := repo.PurgeDeletedReservations(ctx, time.Now().Add(-time.Hour))
+	if err != nil || n != 0 {
+		t.Errorf("expected nothing deleted an hour ago to be purged, got %d (%v)", n, err)
+	}
+	n, err = repo.PurgeDeletedReservations(ctx, time.Now().Add(time.Minute))
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 reservation to be purged, got %d (%v)", n, err)
+	}
+
+	deleted, err = repo.DeletedReservations(ctx)
+	if err != nil || len(deleted) != 0 {
+		t.Errorf("expected an empty trash after the purge, got %+v (%v)", deleted, err)
+	}
+	history, err := repo.ReservationStatusHistory(ctx, id)
+	if err != nil || len(history) != 0 {
+		t.Errorf("expected the history to be purged, got %+v (%v)", history, err)
+	}
+	claimed, err := repo.ClaimNotification(ctx, id, "pre-arrival")
+	if err != nil || !claimed {
+		t.Errorf("expected the sent notifications to be purged, got %v (%v)", claimed, err)
+	}
+
+	//a cancelled reservation comes back without its room
+	cancelled := book(t, repo, day(20), day(22))
+	if err := repo.UpdateReservationStatus(ctx, cancelled, domain.Cancelled, 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteReservationById(ctx, cancelled); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RestoreReservation(ctx, cancelled); err != nil {
+		t.Fatal(err)
+	}
+	available, err = repo.SearchAvailabilityByDatesByRoomId(ctx, day(20), day(22), 1)
+	if err != nil || !available {
+		t.Errorf("expected a cancelled reservation to be restored without blocking the room, got %v (%v)", available, err)
+	}
+	if err := repo.DeleteReservationById(ctx, cancelled); err != nil {
+		t.Fatal(err)
+	}
+	book(t, repo, day(20), day(22))
+	if err := repo.RestoreReservation(ctx, cancelled); err != nil {
+		t.Errorf("expected a cancelled reservation to be restored while the room is booked, got %v", err)
+	}
+}
+
+func contractImport(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	guest := func(room int, start, end time.Time, status domain.Status) models.Reservation {
+		return models.Reservation{
+			FirstName: "Jane",
+			LastName:  "Doe",
+			Email:     "jane@doe.com",
+			StartDate: start,
+			EndDate:   end,
+			RoomID:    room,
+			Status:    status,
+		}
+	}
+
+	ids, err := repo.ImportReservations(ctx, []models.Reservation{
+		guest(1, day(10), day(12), domain.CheckedOut),
+		guest(2, day(10), day(12), domain.Confirmed),
+		guest(1, day(11), day(13), domain.Cancelled),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 {
+		t.Fatalf("expected 3 ids, got %v", ids)
+	}
+
+	res, err := repo.GetReservationById(ctx, ids[0])
+	if err != nil || res.Status != domain.CheckedOut || !res.StartDate.Equal(day(10)) || res.Room.ID != 1 {
+		t.Errorf("expected the reservation to keep its status and dates, got %+v (%v)", res, err)
+	}
+	res, err = repo.GetReservationById(ctx, ids[2])
+	if err != nil || res.Status != domain.Cancelled {
+		t.Errorf("expected a cancelled reservation, got %+v (%v)", res, err)
+	}
+
+	for room := 1; room <= 2; room++ {
+		available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, day(10), day(12), room)
+		if err != nil || available {
+			t.Errorf("expected room %d to be blocked by the import, got %v (%v)", room, available, err)
+		}
+	}
+	available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, day(13), day(13), 1)
+	if err != nil || !available {
+		t.Errorf("expected a cancelled reservation not to block its room, got %v (%v)", available, err)
+	}
+
+	count, _ := repo.CountReservationsByStatus(ctx)
+	_, err = repo.ImportReservations(ctx, []models.Reservation{
+		guest(2, day(20), day(22), domain.Confirmed),
+		guest(1, day(11), day(12), domain.Confirmed),
+	})
+	if !errors.Is(err, domain.ErrRoomUnavailable) {
+		t.Errorf("expected domain.ErrRoomUnavailable for a taken room, got %v", err)
+	}
+	if after, _ := repo.CountReservationsByStatus(ctx); after != count {
+		t.Errorf("expected a failed import to insert nothing, got %d reservations instead of %d", after, count)
+	}
+	available, err = repo.SearchAvailabilityByDatesByRoomId(ctx, day(20), day(22), 2)
+	if err != nil || !available {
+		t.Errorf("expected a failed import to block nothing, got %v (%v)", available, err)
+	}
+
+	_, err = repo.ImportReservations(ctx, []models.Reservation{
+		guest(2, day(20), day(22), domain.Confirmed),
+		guest(2, day(21), day(23), domain.Confirmed),
+	})
+	if !errors.Is(err, domain.ErrRoomUnavailable) {
+		t.Errorf("expected domain.ErrRoomUnavailable for two stays in the same room, got %v", err)
+	}
+}
+
+func contractGuests(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	insert := func(first, last, email, phone string, start time.Time) int {
+		id, err := repo.InsertReservation(ctx, models.Reservation{
+			FirstName: first,
+			LastName:  last,
+			Email:     email,
+			Phone:     phone,
+			StartDate: start,
+			EndDate:   start.AddDate(0, 0, 2),
+			RoomID:    1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := repo.GetReservationById(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.GuestID
+	}
+
+	john := insert("John", "Smith", "john@smith.com", "555-010-0100", day(1))
+	if john == 0 {
+		t.Fatal("expected the reservation to be linked to a guest")
+	}
+	if again := insert("Johnny", "Smith", " John@Smith.com ", "", day(5)); again != john {
+		t.Errorf("expected the same email to find guest %d, got %d", john, again)
+	}
+	if byPhone := insert("John", "Smith", "", "(555) 010 0100", day(9)); byPhone != john {
+		t.Errorf("expected the same phone number to find guest %d, got %d", john, byPhone)
+	}
+	jane := insert("Jane", "Doe", "jane@doe.com", "555-0199", day(13))
+	other := insert("John", "Smith", "j.smith@work.com", "", day(17))
+	if jane == john || other == john || other == jane {
+		t.Fatalf("expected new guests for new emails, got %d, %d and %d", john, jane, other)
+	}
+
+	guest, err := repo.GetGuestByID(ctx, john)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guest.FirstName != "John" || guest.Email != "john@smith.com" || guest.Stays != 3 || len(guest.Tags) != 0 {
+		t.Errorf("unexpected guest %+v", guest)
+	}
+	if _, err := repo.GetGuestByID(ctx, 1000); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing guest, got %v", err)
+	}
+
+	stays, err := repo.GuestReservations(ctx, john)
+	if err != nil || len(stays) != 3 || !stays[0].StartDate.Equal(day(9)) || stays[0].Room.ID != 1 || stays[0].GuestID != john {
+		t.Errorf("expected the stays latest first, got %+v (%v)", stays, err)
+	}
+
+	guests, err := repo.ListGuests(ctx, "")
+	if err != nil || len(guests) != 3 || guests[0].ID != jane || guests[1].ID != john || guests[2].ID != other {
+		t.Errorf("expected the guests by name, got %+v (%v)", guests, err)
+	}
+	for search, expected := range map[string][]int{
+		"smi jo":  {john, other},
+		"JANE@":   {jane},
+		"5550199": {jane},
+		"100%":    nil,
+	} {
+		guests, err := repo.ListGuests(ctx, search)
+		var ids []int
+		for _, g := range guests {
+			ids = append(ids, g.ID)
+		}
+		if err != nil || !sameIDs(ids, expected) {
+			t.Errorf("for %q, expected guests %v, got %v (%v)", search, expected, ids, err)
+		}
+	}
+
+	duplicates, err := repo.DuplicateGuests(ctx, john)
+	if err != nil || len(duplicates) != 1 || duplicates[0].ID != other || duplicates[0].Stays != 1 {
+		t.Errorf("expected guest %d to look like a duplicate, got %+v (%v)", other, duplicates, err)
+	}
+
+	guest.Notes = "Prefers the top floor"
+	guest.Tags = []string{"VIP", "late arrival"}
+	if err := repo.UpdateGuest(ctx, guest); err != nil {
+		t.Fatal(err)
+	}
+	guest, err = repo.GetGuestByID(ctx, john)
+	if err != nil || guest.Notes != "Prefers the top floor" || !guest.HasTag(domain.TagVIP) || !guest.HasTag("late-arrival") {
+		t.Errorf("guest was not updated: %+v (%v)", guest, err)
+	}
+	if err := repo.UpdateGuest(ctx, models.Guest{ID: 1000}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for updating a missing guest, got %v", err)
+	}
+
+	otherGuest, _ := repo.GetGuestByID(ctx, other)
+	otherGuest.Phone = "555-777-0000"
+	otherGuest.Notes = "Called about parking"
+	otherGuest.Tags = []string{domain.TagDoNotRent}
+	if err := repo.UpdateGuest(ctx, otherGuest); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.MergeGuests(ctx, john, john); !errors.Is(err, ErrSameGuest) {
+		t.Errorf("expected ErrSameGuest, got %v", err)
+	}
+	if err := repo.MergeGuests(ctx, john, 1000); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for merging a missing guest, got %v", err)
+	}
+	if err := repo.MergeGuests(ctx, john, other); err != nil {
+		t.Fatal(err)
+	}
+
+	guest, err = repo.GetGuestByID(ctx, john)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guest.Stays != 4 || guest.Phone != "555-010-0100" || guest.Notes != "Prefers the top floor\n\nCalled about parking" {
+		t.Errorf("unexpected merged guest %+v", guest)
+	}
+	if len(guest.Tags) != 3 || !guest.HasTag(domain.TagDoNotRent) || !guest.HasTag(domain.TagVIP) {
+		t.Errorf("expected the tags of both guests, got %v", guest.Tags)
+	}
+	if _, err := repo.GetGuestByID(ctx, other); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the merged guest to be removed, got %v", err)
+	}
+	if again := insert("John", "Smith", "j.smith@work.com", "", day(21)); again != john {
+		t.Errorf("expected the email of the merged guest to find guest %d, got %d", john, again)
+	}
+}
+
+func contractGuestAccounts(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reservation := book(t, repo, day(1), day(3))
+	res, err := repo.GetReservationById(ctx, reservation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account := models.GuestAccount{
+		Email:         " John@Smith.com",
+		Password:      string(hash),
+		VerifyToken:   "first-token",
+		VerifyExpires: time.Now().Add(-time.Minute),
+		Guest:         models.Guest{FirstName: "Johnny", LastName: "Smith"},
+	}
+	id, err := repo.InsertGuestAccount(ctx, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.InsertGuestAccount(ctx, account); !errors.Is(err, ErrAccountExists) {
+		t.Errorf("expected ErrAccountExists for a second account with the email, got %v", err)
+	}
+
+	saved, err := repo.GetGuestAccount(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.GuestID != res.GuestID || saved.Email != "john@smith.com" || saved.Verified() {
+		t.Errorf("expected an unverified account of guest %d, got %+v", res.GuestID, saved)
+	}
+	if saved.Guest.FirstName != "John" || saved.Guest.Phone != "555-555-5555" {
+		t.Errorf("expected the account to come with its guest, got %+v", saved.Guest)
+	}
+
+	if _, err := repo.AuthenticateGuest(ctx, "john@smith.com", "wrong"); err == nil {
+		t.Error("expected a wrong password to fail")
+	}
+	if _, err := repo.AuthenticateGuest(ctx, "nobody@smith.com", "password"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown email, got %v", err)
+	}
+	if a, err := repo.AuthenticateGuest(ctx, "JOHN@smith.com", "password"); err != nil || a.ID != id {
+		t.Errorf("expected account %d to authenticate, got %d (%v)", id, a.ID, err)
+	}
+
+	if _, err := repo.VerifyGuestAccount(ctx, "first-token"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected an expired token to fail with sql.ErrNoRows, got %v", err)
+	}
+	if err := repo.RenewGuestVerification(ctx, id, "second-token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.VerifyGuestAccount(ctx, ""); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected an empty token to fail with sql.ErrNoRows, got %v", err)
+	}
+	if verified, err := repo.VerifyGuestAccount(ctx, "second-token"); err != nil || verified != id {
+		t.Errorf("expected account %d to be verified, got %d (%v)", id, verified, err)
+	}
+	if _, err := repo.VerifyGuestAccount(ctx, "second-token"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a used token to fail with sql.ErrNoRows, got %v", err)
+	}
+	if err := repo.RenewGuestVerification(ctx, id, "third-token", time.Now().Add(time.Hour)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a verified account to keep its state, got %v", err)
+	}
+	if saved, err = repo.GetGuestAccount(ctx, id); err != nil || !saved.Verified() {
+		t.Errorf("expected the account to be verified, got %+v (%v)", saved, err)
+	}
+
+	other, err := repo.InsertGuestAccount(ctx, models.GuestAccount{
+		Email:    "jane@doe.com",
+		Password: string(hash),
+		Guest:    models.Guest{FirstName: "Jane", LastName: "Doe", Phone: "555-0199"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jane, err := repo.GetGuestAccount(ctx, other)
+	if err != nil || jane.GuestID == saved.GuestID || jane.Guest.FirstName != "Jane" {
+		t.Fatalf("expected a new guest for a new email, got %+v (%v)", jane, err)
+	}
+
+	//the phone number of a guest does not give another email access to the guest's bookings
+	stranger, err := repo.InsertGuestAccount(ctx, models.GuestAccount{
+		Email:    "stranger@example.com",
+		Password: string(hash),
+		Guest:    models.Guest{FirstName: "Mallory", LastName: "Stranger", Phone: "555-555-5555"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, err := repo.GetGuestAccount(ctx, stranger); err != nil || a.GuestID == res.GuestID || a.Guest.Phone != "555-555-5555" {
+		t.Errorf("expected a new guest for an account with the phone number of guest %d, got %+v (%v)", res.GuestID, a, err)
+	}
+
+	if err := repo.MergeGuests(ctx, saved.GuestID, jane.GuestID); err != nil {
+		t.Fatal(err)
+	}
+	if jane, err = repo.GetGuestAccount(ctx, other); err != nil || jane.GuestID != saved.GuestID {
+		t.Errorf("expected the merge to move the account to guest %d, got %+v (%v)", saved.GuestID, jane, err)
+	}
+}
+
+func contractConcurrentBookings(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	//every checkout wants both rooms, half of them listed the other way round
+	const checkouts = 8
+	errs := make(chan error, checkouts)
+	var wg sync.WaitGroup
+	for i := 0; i < checkouts; i++ {
+		rooms := []int{1, 2}
+		if i%2 == 1 {
+			rooms = []int{2, 1}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reservations []models.Reservation
+			for _, room := range rooms {
+				reservations = append(reservations, models.Reservation{
+					FirstName: "John",
+					LastName:  "Smith",
+					Email:     "john@smith.com",
+					StartDate: day(40),
+					EndDate:   day(42),
+					RoomID:    room,
+					Adults:    1,
+				})
+			}
+			_, _, err := repo.InsertBooking(ctx, reservations)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	booked := 0
+	for err := range errs {
+		if err == nil {
+			booked++
+		} else if !errors.Is(err, domain.ErrRoomUnavailable) {
+			t.Errorf("expected domain.ErrRoomUnavailable, got %v", err)
+		}
+	}
+	if booked != 1 {
+		t.Errorf("expected exactly one checkout to book the rooms, %d did", booked)
+	}
+}
+
+func contractBookings(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	room := func(id int, start, end time.Time, adults, children int) models.Reservation {
+		return models.Reservation{
+			FirstName: "John",
+			LastName:  "Smith",
+			Email:     "john@smith.com",
+			Phone:     "555-010-0100",
+			StartDate: start,
+			EndDate:   end,
+			RoomID:    id,
+			Adults:    adults,
+			Children:  children,
+		}
+	}
+
+	bookingID, ids, err := repo.InsertBooking(ctx, []models.Reservation{
+		room(2, day(1), day(3), 2, 2),
+		room(1, day(1), day(3), 2, 0),
+		room(1, day(5), day(6), 1, 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 {
+		t.Fatalf("expected 3 reservations, got %v", ids)
+	}
+
+	booking, err := repo.GetBooking(ctx, bookingID)
+	if err != nil || booking.ID != bookingID || len(booking.Reservations) != 3 || booking.Guests() != 7 {
+		t.Fatalf("unexpected booking %+v (%v)", booking, err)
+	}
+	first := booking.Reservations[0]
+	if first.ID != ids[1] || first.Room.ID != 1 || booking.Reservations[1].ID != ids[0] || booking.Reservations[2].ID != ids[2] {
+		t.Errorf("expected the rooms by arrival and room, got %+v", booking.Reservations)
+	}
+	if first.BookingID != bookingID || first.Status != domain.Pending || first.GuestID == 0 || first.GuestID != booking.Reservations[2].GuestID {
+		t.Errorf("expected pending reservations of one guest, got %+v", first)
+	}
+	if res, err := repo.GetReservationById(ctx, ids[0]); err != nil || res.BookingID != bookingID || res.Adults != 2 || res.Children != 2 {
+		t.Errorf("expected the reservation to know its booking, got %+v (%v)", res, err)
+	}
+	if available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, day(5), day(6), 1); err != nil || available {
+		t.Errorf("expected every room of the booking to be blocked, got %v (%v)", available, err)
+	}
+
+	//nothing is inserted when one room is taken, or when the booking takes a room twice
+	for _, reservations := range [][]models.Reservation{
+		{room(1, day(10), day(12), 1, 0), room(2, day(2), day(4), 1, 0)},
+		{room(2, day(10), day(12), 1, 0), room(2, day(11), day(13), 1, 0)},
+	} {
+		if _, _, err := repo.InsertBooking(ctx, reservations); !errors.Is(err, domain.ErrRoomUnavailable) {
+			t.Errorf("expected domain.ErrRoomUnavailable, got %v", err)
+		}
+	}
+	for _, id := range []int{1, 2} {
+		if available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, day(10), day(12), id); err != nil || !available {
+			t.Errorf("expected room %d to stay free after a failed booking, got %v (%v)", id, available, err)
+		}
+	}
+
+	//a booking may take a room again from the day it leaves it
+	if _, _, err := repo.InsertBooking(ctx, []models.Reservation{room(1, day(20), day(22), 1, 0), room(1, day(22), day(24), 1, 0)}); err != nil {
+		t.Errorf("expected back to back stays in one room to be booked, got %v", err)
+	}
+
+	//one room is cancelled on its own, the others with the booking
+	if err := repo.UpdateReservationStatus(ctx, ids[2], domain.Cancelled, 0); err != nil {
+		t.Fatal(err)
+	}
+	cancelled, err := repo.CancelBooking(ctx, bookingID, 1)
+	if err != nil || len(cancelled) != 2 || cancelled[0] != ids[0] || cancelled[1] != ids[1] {
+		t.Fatalf("expected the other 2 rooms to be cancelled, got %v (%v)", cancelled, err)
+	}
+	for _, id := range ids {
+		if res, err := repo.GetReservationById(ctx, id); err != nil || res.Status != domain.Cancelled {
+			t.Errorf("expected reservation %d to be cancelled, got %s (%v)", id, res.Status, err)
+		}
+	}
+	if available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, day(1), day(3), 2); err != nil || !available {
+		t.Errorf("expected the rooms to be released, got %v (%v)", available, err)
+	}
+	history, err := repo.ReservationStatusHistory(ctx, ids[0])
+	if err != nil || len(history) != 1 || history[0].To != domain.Cancelled || history[0].UserID != 1 {
+		t.Errorf("expected the cancellation to be recorded, got %+v (%v)", history, err)
+	}
+
+	if cancelled, err := repo.CancelBooking(ctx, bookingID, 1); err != nil || len(cancelled) != 0 {
+		t.Errorf("expected nothing left to cancel, got %v (%v)", cancelled, err)
+	}
+	if _, err := repo.CancelBooking(ctx, bookingID+100, 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing booking, got %v", err)
+	}
+	if _, err := repo.GetBooking(ctx, bookingID+100); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing booking, got %v", err)
+	}
+}
+
+func contractChangeDates(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	first := book(t, repo, day(1), day(3))
+	book(t, repo, day(10), day(12))
+
+	if err := repo.ChangeReservationDates(ctx, first, day(9), day(11)); !errors.Is(err, domain.ErrRoomUnavailable) {
+		t.Errorf("expected domain.ErrRoomUnavailable for taken dates, got %v", err)
+	}
+	if err := repo.ChangeReservationDates(ctx, first+100, day(20), day(22)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing reservation, got %v", err)
+	}
+	if err := repo.ChangeReservationDates(ctx, first, day(2), day(5)); err != nil {
+		t.Fatalf("expected a reservation to move over its own dates, got %v", err)
+	}
+
+	res, err := repo.GetReservationById(ctx, first)
+	if err != nil || !res.StartDate.Equal(day(2)) || !res.EndDate.Equal(day(5)) {
+		t.Errorf("expected the reservation to be moved, got %v to %v (%v)", res.StartDate, res.EndDate, err)
+	}
+	if available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, day(1), day(1), 1); err != nil || !available {
+		t.Errorf("expected the old first night to be free, got %v (%v)", available, err)
+	}
+	if available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, day(4), day(5), 1); err != nil || available {
+		t.Errorf("expected the room to be blocked on the new dates, got %v (%v)", available, err)
+	}
+	if err := repo.ChangeReservationDates(ctx, first, day(8), day(10)); err != nil {
+		t.Errorf("expected a stay to end on the arrival day of another one, got %v", err)
+	}
+
+	if err := repo.DeleteReservationById(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.ChangeReservationDates(ctx, first, day(20), day(22)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a reservation in the trash, got %v", err)
+	}
+}
+
+func contractReservationList(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	guests := []struct {
+		first, last, email string
+		start, room        int
+	}{
+		{"John", "Smith", "john@smith.com", 10, 1},
+		{"Jane", "Doe", "jane@doe.com", 5, 2},
+		{"Mary", "Smith", "mary_s@example.com", 20, 1},
+		{"Peter", "Jones", "peter@jones.com", 5, 1},
+		{"Anna", "Brown", "anna@brown.com", 15, 2},
+		{"Zoe", "Adams", "zoe@adams.com", 25, 1},
+	}
+	ids := make([]int, len(guests))
+	for i, g := range guests {
+		id, err := repo.InsertReservation(ctx, models.Reservation{
+			FirstName: g.first,
+			LastName:  g.last,
+			Email:     g.email,
+			Phone:     "555-555-5555",
+			StartDate: day(g.start),
+			EndDate:   day(g.start + 2),
+			RoomID:    g.room,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+	if err := repo.UpdateReservationStatus(ctx, ids[2], domain.Confirmed, 0); err != nil {
+		t.Fatal(err)
+	}
+	deleted := book(t, repo, day(1), day(2))
+	if err := repo.DeleteReservationById(ctx, deleted); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		query    models.ReservationQuery
+		expected []int
+	}{
+		{"arrival", models.ReservationQuery{}, []int{ids[1], ids[3], ids[0], ids[4], ids[2], ids[5]}},
+		{"arrival descending", models.ReservationQuery{Desc: true}, []int{ids[5], ids[2], ids[4], ids[0], ids[3], ids[1]}},
+		{"last name", models.ReservationQuery{Sort: models.SortByLastName}, []int{ids[5], ids[4], ids[1], ids[3], ids[0], ids[2]}},
+		{"last name descending", models.ReservationQuery{Sort: models.SortByLastName, Desc: true}, []int{ids[2], ids[0], ids[3], ids[1], ids[4], ids[5]}},
+		{"departure", models.ReservationQuery{Sort: models.SortByDeparture}, []int{ids[1], ids[3], ids[0], ids[4], ids[2], ids[5]}},
+		{"id descending", models.ReservationQuery{Sort: models.SortByID, Desc: true}, []int{ids[5], ids[4], ids[3], ids[2], ids[1], ids[0]}},
+		{"status", models.ReservationQuery{Statuses: []domain.Status{domain.Confirmed}}, []int{ids[2]}},
+		{"statuses", models.ReservationQuery{Statuses: []domain.Status{domain.Confirmed, domain.Pending}, RoomID: 2}, []int{ids[1], ids[4]}},
+		{"room", models.ReservationQuery{RoomID: 1}, []int{ids[3], ids[0], ids[2], ids[5]}},
+		{"arrival range", models.ReservationQuery{From: day(10), To: day(20)}, []int{ids[0], ids[4], ids[2]}},
+		{"search last name", models.ReservationQuery{Search: "SMI"}, []int{ids[0], ids[2]}},
+		{"search words", models.ReservationQuery{Search: "mary smith"}, []int{ids[2]}},
+		{"search email", models.ReservationQuery{Search: "peter@"}, []int{ids[3]}},
+		{"search wildcards", models.ReservationQuery{Search: "%"}, nil},
+		{"search underscore", models.ReservationQuery{Search: "mary_"}, []int{ids[2]}},
+		{"no match", models.ReservationQuery{Search: "nobody"}, nil},
+	}
+
+	for _, e := range tests {
+		for _, size := range []int{0, 2, 4} {
+			q := e.query
+			q.Size = size
+
+			forward := readPages(t, repo, q, false)
+			if !sameIDs(forward, e.expected) {
+				t.Errorf("for %s in pages of %d, expected %v but got %v", e.name, size, e.expected, forward)
+			}
+			if backward := readPages(t, repo, q, true); !sameIDs(backward, e.expected) {
+				t.Errorf("for %s in pages of %d read backwards, expected %v but got %v", e.name, size, e.expected, backward)
+			}
+		}
+	}
+
+	if _, err := repo.ListReservations(ctx, models.ReservationQuery{After: "bogus"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor for a bogus cursor, got %v", err)
+	}
+	if _, err := repo.ListReservations(ctx, models.ReservationQuery{Sort: "phone"}); err == nil {
+		t.Error("expected an error for an unknown sort")
+	}
+
+	page, err := repo.ListReservations(ctx, models.ReservationQuery{Size: models.MaxPageSize + 1})
+	if err != nil || len(page.Reservations) != len(ids) || page.Next != "" || page.Prev != "" {
+		t.Errorf("expected a single page, got %+v (%v)", page, err)
+	}
+	if r := page.Reservations[0]; r.Room.RoomName != "Major's Suite" || r.Email != "jane@doe.com" {
+		t.Errorf("expected the reservation joined with its room, got %+v", r)
+	}
+}
+
+func contractExports(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	var ids []int
+	for i, last := range []string{"Smith", "Adams", "Jones"} {
+		id, err := repo.InsertReservation(ctx, models.Reservation{
+			FirstName: "John",
+			LastName:  last,
+			Email:     "john@example.com",
+			StartDate: day(10 + i*5),
+			EndDate:   day(12 + i*5),
+			RoomID:    1 + i%2,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	deleted := book(t, repo, day(1), day(2))
+	if err := repo.DeleteReservationById(ctx, deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.InsetIntoRoomRestriction(ctx, models.RoomRestriction{StartDate: day(10), EndDate: day(12), RoomID: 1, ResevationID: ids[0], RestrictionID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.InsetIntoRoomRestriction(ctx, models.RoomRestriction{StartDate: day(8), EndDate: day(9), RoomID: 2, RestrictionID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.InsetIntoRoomRestriction(ctx, models.RoomRestriction{StartDate: day(25), EndDate: day(26), RoomID: 2, RestrictionID: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(q models.ReservationQuery) []int {
+		var read []int
+		err := repo.EachReservation(ctx, q, func(r models.Reservation) error {
+			if r.Room.RoomName == "" {
+				t.Errorf("expected reservation %d joined with its room", r.ID)
+			}
+			read = append(read, r.ID)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return read
+	}
+
+	//the page and its cursor are ignored, every reservation is read
+	if got := read(models.ReservationQuery{Size: 1, After: "bogus"}); !sameIDs(got, ids) {
+		t.Errorf("expected %v by arrival, got %v", ids, got)
+	}
+	if got := read(models.ReservationQuery{Sort: models.SortByLastName, Desc: true}); !sameIDs(got, []int{ids[0], ids[2], ids[1]}) {
+		t.Errorf("expected the reservations by last name descending, got %v", got)
+	}
+	if got := read(models.ReservationQuery{RoomID: 1, From: day(11)}); !sameIDs(got, []int{ids[2]}) {
+		t.Errorf("expected only the filtered reservation, got %v", got)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err := repo.EachReservation(ctx, models.ReservationQuery{}, func(r models.Reservation) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("expected the error of fn to stop the reading, got %v after %d calls", err, calls)
+	}
+
+	var restrictions []models.RoomRestriction
+	err = repo.EachRoomRestriction(ctx, day(9), day(25), func(rr models.RoomRestriction) error {
+		restrictions = append(restrictions, rr)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 2 {
+		t.Fatalf("expected the 2 restrictions in the range, got %+v", restrictions)
+	}
+	block, stay := restrictions[0], restrictions[1]
+	if block.RoomID != 2 || block.Room.RoomName != "Major's Suite" || block.Restriction.RestrictionName != "Owner Block" || block.ResevationID != 0 {
+		t.Errorf("unexpected owner block %+v", block)
+	}
+	if stay.ResevationID != ids[0] || stay.Reservation.LastName != "Smith" || stay.Restriction.RestrictionName != "Reservation" {
+		t.Errorf("unexpected reservation restriction %+v", stay)
+	}
+	if stay.StartDate.Format("2006-01-02") != "2050-01-10" || stay.EndDate.Format("2006-01-02") != "2050-01-12" {
+		t.Errorf("unexpected dates %s to %s", stay.StartDate, stay.EndDate)
+	}
+}
+
+//readPages reads every page of q from the first one following Next, or from the last
+//one following Prev when backwards, and returns the ids in list order
+func readPages(t *testing.T, repo repository.DatabaseRepo, q models.ReservationQuery, backwards bool) []int {
+	ctx := context.Background()
+
+	if backwards {
+		//find the last page first
+		for {
+			page, err := repo.ListReservations(ctx, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Next == "" {
+				break
+			}
+			q.After = page.Next
+		}
+	}
+
+	var pages [][]int
+	for {
+		page, err := repo.ListReservations(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, r := range page.Reservations {
+			ids = append(ids, r.ID)
+		}
+		pages = append(pages, ids)
+
+		cursor := page.Next
+		if backwards {
+			cursor = page.Prev
+		}
+		if cursor == "" {
+			break
+		}
+		if len(pages) > 10 {
+			t.Fatal("the pages do not end")
+		}
+		if backwards {
+			q.After, q.Before = "", cursor
+		} else {
+			q.After = cursor
+		}
+	}
+
+	var ids []int
+	if backwards {
+		for i := len(pages) - 1; i >= 0; i-- {
+			ids = append(ids, pages[i]...)
+		}
+		return ids
+	}
+	for _, page := range pages {
+		ids = append(ids, page...)
+	}
+	return ids
+}
+
+func sameIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func contractStatus(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	var ids []int
+	for _, d := range []int{20, 10, 15} {
+		id, err := repo.InsertReservation(ctx, models.Reservation{
+			FirstName: "John",
+			LastName:  "Smith",
+			Email:     "john@smith.com",
+			Phone:     "555-555-5555",
+			StartDate: day(d),
+			EndDate:   day(d + 1),
+			RoomID:    1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	count, err := repo.CountReservationsByStatus(ctx, domain.Pending)
+	if err != nil || count != 3 {
+		t.Errorf("expected 3 pending reservations, got %d (%v)", count, err)
+	}
+
+	for _, to := range []domain.Status{domain.Confirmed, domain.CheckedIn} {
+		if err := repo.UpdateReservationStatus(ctx, ids[0], to, 7); err != nil {
+			t.Fatalf("cannot move to %s: %v", to, err)
+		}
+	}
+	if err := repo.UpdateReservationStatus(ctx, ids[1], domain.Cancelled, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.UpdateReservationStatus(ctx, ids[1], domain.Confirmed, 7)
+	if !errors.Is(err, domain.ErrInvalidTransition) {
+		t.Errorf("expected a cancelled reservation to stay cancelled, got %v", err)
+	}
+	if err := repo.UpdateReservationStatus(ctx, ids[2]+100, domain.Confirmed, 7); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing reservation, got %v", err)
+	}
+
+	res, err := repo.GetReservationById(ctx, ids[0])
+	if err != nil || res.Status != domain.CheckedIn {
+		t.Errorf("expected reservation %d to be checked in, got %s (%v)", ids[0], res.Status, err)
+	}
+
+	history, err := repo.ReservationStatusHistory(ctx, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 status changes, got %+v", history)
+	}
+	if history[0].From != domain.Pending || history[0].To != domain.Confirmed || history[1].To != domain.CheckedIn {
+		t.Errorf("unexpected history %+v", history)
+	}
+	if history[1].UserID != 7 || history[1].ReservationID != ids[0] || history[1].CreatedAt.IsZero() {
+		t.Errorf("change not recorded with who and when, got %+v", history[1])
+	}
+
+	history, err = repo.ReservationStatusHistory(ctx, ids[1])
+	if err != nil || len(history) != 1 {
+		t.Errorf("the refused change should not be recorded, got %+v (%v)", history, err)
+	}
+
+	pending, err := repo.ReservationsByStatus(ctx, domain.Pending)
+	if err != nil || len(pending) != 1 || pending[0].ID != ids[2] {
+		t.Errorf("expected only reservation %d to be pending, got %+v (%v)", ids[2], pending, err)
+	}
+
+	open, err := repo.ReservationsByStatus(ctx, domain.Pending, domain.CheckedIn)
+	if err != nil || len(open) != 2 {
+		t.Fatalf("expected 2 pending or checked in reservations, got %+v (%v)", open, err)
+	}
+	if open[0].ID != ids[2] || open[1].ID != ids[0] {
+		t.Errorf("expected the reservations ordered by arrival, got %d then %d", open[0].ID, open[1].ID)
+	}
+
+	count, err = repo.CountReservationsByStatus(ctx)
+	if err != nil || count != 3 {
+		t.Errorf("expected 3 reservations without a status filter, got %d (%v)", count, err)
+	}
+
+	booked := book(t, repo, day(25), day(27))
+	if err := repo.UpdateReservationStatus(ctx, booked, domain.Cancelled, 7); err != nil {
+		t.Fatal(err)
+	}
+	available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, day(25), day(27), 1)
+	if err != nil || !available {
+		t.Errorf("expected the cancellation to release the room, got %v (%v)", available, err)
+	}
+}
+
+func contractAvailability(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	err := repo.InsetIntoRoomRestriction(ctx, models.RoomRestriction{
+		StartDate:     day(10),
+		EndDate:       day(12),
+		RoomID:        1,
+		RestrictionID: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name      string
+		start     time.Time
+		end       time.Time
+		available bool
+	}{
+		{"before", day(1), day(5), true},
+		{"overlapping the start", day(9), day(11), false},
+		{"inside", day(11), day(11), false},
+		{"around", day(8), day(14), false},
+		{"overlapping the end", day(11), day(13), false},
+		{"ending on the arrival day", day(7), day(10), true},
+		{"starting on the departure day", day(12), day(14), true},
+		{"after", day(13), day(15), true},
+	}
+
+	//a stay holds the nights from its start up to its end, like domain.Overlaps, and the
+	//search of one room and of all rooms agree on it
+	for _, e := range tests {
+		available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, e.start, e.end, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if available != e.available {
+			t.Errorf("room 1 %s: expected available %v, got %v", e.name, e.available, available)
+		}
+
+		rooms, err := repo.SearchAvailabilityForAllRooms(ctx, e.start, e.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		free := false
+		for _, room := range rooms {
+			free = free || room.ID == 1
+		}
+		if free != e.available {
+			t.Errorf("all rooms %s: expected room 1 free %v, got %+v", e.name, e.available, rooms)
+		}
+	}
+
+	available, err := repo.SearchAvailabilityByDatesByRoomId(ctx, day(10), day(12), 2)
+	if err != nil || !available {
+		t.Errorf("room 2 should be free, got %v (%v)", available, err)
+	}
+	rooms, err := repo.SearchAvailabilityForAllRooms(ctx, day(9), day(11))
+	if err != nil || len(rooms) != 1 || rooms[0].ID != 2 || rooms[0].RoomName != "Major's Suite" {
+		t.Errorf("expected only the Major's Suite to be free, got %+v (%v)", rooms, err)
+	}
+
+	//a guest may arrive on the day another one leaves
+	id, err := repo.InsertReservation(ctx, models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		StartDate: day(12),
+		EndDate:   day(14),
+		RoomID:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteReservationById(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RestoreReservation(ctx, id); err != nil {
+		t.Errorf("expected a stay starting on the departure day to be restored, got %v", err)
+	}
+}
+
+func contractRooms(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	room, err := repo.GetRoomByID(ctx, 2)
+	if err != nil || room.RoomName != "Major's Suite" || room.MaxOccupancy != 4 || room.Beds == "" {
+		t.Errorf("expected the seeded Major's Suite, got %+v (%v)", room, err)
+	}
+
+	id, err := repo.InsertRoom(ctx, models.Room{RoomName: "Colonel's Cabin", MaxOccupancy: 6, Beds: "3 bunk beds"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 3 {
+		t.Errorf("expected the new room to follow the seeded ones, got id %d", id)
+	}
+	room, err = repo.GetRoomByID(ctx, id)
+	if err != nil || room.RoomName != "Colonel's Cabin" || room.MaxOccupancy != 6 || room.Beds != "3 bunk beds" || room.CreatedAt.IsZero() {
+		t.Errorf("unexpected room %+v (%v)", room, err)
+	}
+
+	if _, err := repo.GetRoomByID(ctx, 100); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing room, got %v", err)
+	}
+
+	rooms, err := repo.AllRooms(ctx)
+	if err != nil || len(rooms) != 3 || rooms[0].ID != 1 || rooms[2].RoomName != "Colonel's Cabin" {
+		t.Errorf("expected the 3 rooms ordered by id, got %+v (%v)", rooms, err)
+	}
+
+	rooms, err = repo.SearchAvailabilityForAllRooms(ctx, day(1), day(3))
+	if err != nil || len(rooms) != 3 || rooms[0].MaxOccupancy != 2 || rooms[2].MaxOccupancy != 6 || rooms[2].Beds != "3 bunk beds" {
+		t.Errorf("expected the free rooms with their capacity, got %+v (%v)", rooms, err)
+	}
+}
+
+func contractUsers(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := repo.InsertUser(ctx, models.User{
+		FirstName:   "Admin",
+		LastName:    "User",
+		Email:       "admin@admin.com",
+		Password:    string(hash),
+		AccessLevel: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := repo.GetUserByEmail(ctx, "admin@admin.com")
+	if err != nil || user.ID != id || user.AccessLevel != 3 {
+		t.Errorf("unexpected user %+v (%v)", user, err)
+	}
+	if _, err := repo.GetUserByEmail(ctx, "nobody@admin.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing user, got %v", err)
+	}
+
+	user.FirstName = "Renamed"
+	if err := repo.UpdateUserById(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	user, err = repo.GetUserById(ctx, id)
+	if err != nil || user.FirstName != "Renamed" {
+		t.Errorf("user was not updated: %+v (%v)", user, err)
+	}
+
+	authID, _, err := repo.Authenticate(ctx, "admin@admin.com", "password")
+	if err != nil || authID != id {
+		t.Errorf("expected user %d to authenticate, got %d (%v)", id, authID, err)
+	}
+	if _, _, err := repo.Authenticate(ctx, "admin@admin.com", "wrong"); err == nil {
+		t.Error("expected a wrong password to fail")
+	}
+	if _, _, err := repo.Authenticate(ctx, "nobody@admin.com", "password"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown email, got %v", err)
+	}
+
+	hash, err = bcrypt.GenerateFromPassword([]byte("changed"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdateUserPassword(ctx, id, string(hash)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := repo.Authenticate(ctx, "admin@admin.com", "changed"); err != nil {
+		t.Errorf("the changed password does not authenticate: %v", err)
+	}
+}
+
+func contractGuestNotifications(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	//claims race between scheduler instances, exactly one of them may win
+	var wg sync.WaitGroup
+	wins := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claimed, err := repo.ClaimNotification(ctx, 1, "reminder")
+			if err != nil {
+				t.Error(err)
+			}
+			wins <- claimed
+		}()
+	}
+	wg.Wait()
+	close(wins)
+
+	won := 0
+	for claimed := range wins {
+		if claimed {
+			won++
+		}
+	}
+	if won != 1 {
+		t.Errorf("expected exactly one claim to win, got %d", won)
+	}
+
+	claimed, err := repo.ClaimNotification(ctx, 1, "followup")
+	if err != nil || !claimed {
+		t.Errorf("another kind of notification should be claimed separately, got %v (%v)", claimed, err)
+	}
+
+	//a released claim is sent again by the next run
+	if err := repo.ReleaseNotification(ctx, 1, "followup"); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err = repo.ClaimNotification(ctx, 1, "followup")
+	if err != nil || !claimed {
+		t.Errorf("expected a released notification to be claimed again, got %v (%v)", claimed, err)
+	}
+	claimed, err = repo.ClaimNotification(ctx, 1, "reminder")
+	if err != nil || claimed {
+		t.Errorf("expected releasing one kind to keep the others claimed, got %v (%v)", claimed, err)
+	}
+}
+
+func contractStaffNotifications(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	var ids []int
+	for _, title := range []string{"first", "second", "third"} {
+		id, err := repo.InsertNotification(ctx, models.Notification{Title: title, Body: "body", Link: "/admin"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+		//notifications are ordered by their creation time
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	recent, err := repo.RecentNotifications(ctx, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 2 || recent[0].Title != "third" || recent[1].Title != "second" {
+		t.Fatalf("expected the 2 latest notifications, newest first, got %+v", recent)
+	}
+
+	if err := repo.MarkNotificationRead(ctx, ids[2], 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.MarkNotificationRead(ctx, ids[2], 1); err != nil {
+		t.Errorf("marking a notification read twice should not fail: %v", err)
+	}
+	if err := repo.MarkNotificationRead(ctx, ids[2]+100, 1); err == nil {
+		t.Error("expected an error for a missing notification")
+	}
+
+	recent, err = repo.RecentNotifications(ctx, 1, 10)
+	if err != nil || len(recent) != 3 || !recent[0].Read || recent[1].Read {
+		t.Errorf("unexpected read state %+v (%v)", recent, err)
+	}
+
+	unread, err := repo.UnreadNotificationCount(ctx, 1)
+	if err != nil || unread != 2 {
+		t.Errorf("expected 2 unread notifications, got %d (%v)", unread, err)
+	}
+	unread, err = repo.UnreadNotificationCount(ctx, 2)
+	if err != nil || unread != 3 {
+		t.Errorf("read state leaked to another user, got %d unread (%v)", unread, err)
+	}
+
+	if err := repo.MarkAllNotificationsRead(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	unread, err = repo.UnreadNotificationCount(ctx, 1)
+	if err != nil || unread != 0 {
+		t.Errorf("expected no unread notifications, got %d (%v)", unread, err)
+	}
+}
+
+func contractAnalytics(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	reserve := func(room, start, end int) int {
+		id, err := repo.InsertReservation(ctx, models.Reservation{
+			FirstName: "John",
+			LastName:  "Smith",
+			Email:     "john@smith.com",
+			StartDate: day(start),
+			EndDate:   day(end),
+			RoomID:    room,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	reserve(1, 2, 3)
+	reserve(1, 3, 6)
+	reserve(1, 6, 8)
+	if err := repo.UpdateReservationStatus(ctx, reserve(2, 4, 5), domain.Cancelled, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteReservationById(ctx, reserve(2, 10, 12)); err != nil {
+		t.Fatal(err)
+	}
+
+	occupancy, err := repo.Occupancy(ctx, day(1), day(8), models.PeriodDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(occupancy) != 14 {
+		t.Fatalf("expected 7 days of 2 rooms, got %d rows", len(occupancy))
+	}
+	booked := 0
+	for i, o := range occupancy {
+		if o.Period.Format("2006-01-02") != day(1+i/2).Format("2006-01-02") || o.RoomID != 1+i%2 || o.Nights != 1 {
+			t.Errorf("unexpected occupancy row %d %+v", i, o)
+		}
+		if o.RoomID == 2 && o.Booked != 0 {
+			t.Errorf("expected the cancelled and deleted reservations to book nothing, got %+v", o)
+		}
+		booked += o.Booked
+	}
+	if booked != 6 || occupancy[0].Booked != 0 || occupancy[2].Booked != 1 || occupancy[0].RoomName != "General's Quarters" {
+		t.Errorf("expected room 1 booked from the 2nd, got %+v", occupancy)
+	}
+
+	//January 1st 2050 is a Saturday
+	weeks, err := repo.Occupancy(ctx, day(1), day(8), models.PeriodWeek)
+	if err != nil || len(weeks) != 4 {
+		t.Fatalf("expected 2 weeks of 2 rooms, got %+v (%v)", weeks, err)
+	}
+	if weeks[0].Period.Format("2006-01-02") != "2049-12-27" || weeks[0].Nights != 2 || weeks[0].Booked != 1 {
+		t.Errorf("unexpected first week %+v", weeks[0])
+	}
+	if weeks[2].Period.Format("2006-01-02") != "2050-01-03" || weeks[2].Nights != 5 || weeks[2].Booked != 5 {
+		t.Errorf("unexpected second week %+v", weeks[2])
+	}
+
+	months, err := repo.Occupancy(ctx, day(1), day(8), models.PeriodMonth)
+	if err != nil || len(months) != 2 || months[0].Nights != 7 || months[0].Booked != 6 || months[1].Booked != 0 {
+		t.Errorf("unexpected month %+v (%v)", months, err)
+	}
+
+	stats, err := repo.BookingStats(ctx, day(1), day(31), models.PeriodWeek)
+	if err != nil || len(stats) != 2 {
+		t.Fatalf("expected arrivals in 2 weeks, got %+v (%v)", stats, err)
+	}
+	if stats[0].Period.Format("2006-01-02") != "2049-12-27" || stats[0].Reservations != 1 || stats[0].Nights != 1 {
+		t.Errorf("unexpected first week %+v", stats[0])
+	}
+	if s := stats[1]; s.Reservations != 3 || s.Cancelled != 1 || s.Nights != 5 {
+		t.Errorf("expected 3 arrivals, 1 cancelled and 5 nights, got %+v", s)
+	}
+
+	//the reservations were made today
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	lead := int(day(3).Sub(today).Hours()/24) + int(day(6).Sub(today).Hours()/24)
+	if stats[1].LeadDays != lead {
+		t.Errorf("expected %d days of lead time, got %d", lead, stats[1].LeadDays)
+	}
+
+	if stats, err := repo.BookingStats(ctx, day(20), day(31), models.PeriodDay); err != nil || len(stats) != 0 {
+		t.Errorf("expected no arrivals, got %+v (%v)", stats, err)
+	}
+	if _, err := repo.Occupancy(ctx, day(1), day(8), "year"); err == nil {
+		t.Error("expected an error for an unknown period")
+	}
+	if _, err := repo.BookingStats(ctx, day(8), day(8), models.PeriodDay); err == nil {
+		t.Error("expected an error for an empty range")
+	}
+}
+
+func contractAuditLog(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	start := time.Now()
+
+	entries := []models.AuditEntry{
+		{UserID: 1, Action: "reservation.update", Entity: "reservation", EntityID: 5, Changes: `{"email":{"before":"a@b.com","after":"c@d.com"}}`, IP: "192.0.2.1"},
+		{UserID: 2, Action: "reservation.delete", Entity: "reservation", EntityID: 5, Changes: `{}`, IP: "192.0.2.2"},
+		{Action: "room.create", Entity: "room", EntityID: 3, Changes: `{"room_name":{"after":"Suite"}}`, Actor: "cli:alice"},
+	}
+	for _, e := range entries {
+		if _, err := repo.InsertAuditEntry(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := repo.AuditEntries(ctx, models.AuditFilter{})
+	if err != nil || len(all) != 3 {
+		t.Fatalf("expected 3 audit entries, got %+v (%v)", all, err)
+	}
+	if all[0].Action != "room.create" || all[2].Action != "reservation.update" {
+		t.Errorf("expected the latest entry first, got %s then %s", all[0].Action, all[2].Action)
+	}
+	if all[2].UserID != 1 || all[2].IP != "192.0.2.1" || all[2].Changes != entries[0].Changes || all[2].CreatedAt.IsZero() {
+		t.Errorf("entry not stored as given, got %+v", all[2])
+	}
+	if all[0].Actor != "cli:alice" {
+		t.Errorf("expected the actor of a command line change to be stored, got %+v", all[0])
+	}
+
+	var tests = []struct {
+		name   string
+		filter models.AuditFilter
+		want   int
+	}{
+		{"user", models.AuditFilter{UserID: 2}, 1},
+		{"action", models.AuditFilter{Action: "reservation.update"}, 1},
+		{"entity", models.AuditFilter{Entity: "reservation", EntityID: 5}, 2},
+		{"other entity", models.AuditFilter{Entity: "reservation", EntityID: 6}, 0},
+		{"from", models.AuditFilter{From: start.Add(-time.Minute)}, 3},
+		{"to", models.AuditFilter{To: start.Add(-time.Minute)}, 0},
+		{"limit", models.AuditFilter{Entity: "reservation", Limit: 1}, 1},
+	}
+	for _, e := range tests {
+		got, err := repo.AuditEntries(ctx, e.filter)
+		if err != nil || len(got) != e.want {
+			t.Errorf("for %s, expected %d entries, got %d (%v)", e.name, e.want, len(got), err)
+		}
+	}
+}
+
+func contractCancelledContext(t *testing.T, repo repository.DatabaseRepo) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := repo.GetRoomByID(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if _, err := repo.InsertReservation(ctx, models.Reservation{RoomID: 1, StartDate: day(1), EndDate: day(2)}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
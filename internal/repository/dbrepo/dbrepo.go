@@ -1,10 +1,21 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/domain"
+	"github.com/ArmanurRahman/booking/internal/drivers"
+	"github.com/ArmanurRahman/booking/internal/models"
 	"github.com/ArmanurRahman/booking/internal/repository"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type postgressDBRepo struct {
@@ -12,8 +23,23 @@ type postgressDBRepo struct {
 	DB  *sql.DB
 }
 
-type testDBRepo struct {
+type sqliteDBRepo struct {
 	App *config.AppConfig
+	DB  *sql.DB
+}
+
+//NewRepo returns the repository the application uses: the repository of the driver with
+//query metrics, tracing and the audit log
+func NewRepo(db *drivers.DB, a *config.AppConfig) repository.DatabaseRepo {
+	return NewAuditedRepo(NewInstrumentedRepo(New(db, a), db.Driver))
+}
+
+//New returns the repository for the driver db was opened with
+func New(db *drivers.DB, a *config.AppConfig) repository.DatabaseRepo {
+	if db.Driver == "sqlite" {
+		return NewSQLiteRepo(db.SQL, a)
+	}
+	return NewPostgresRepo(db.SQL, a)
 }
 
 func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
@@ -23,8 +49,446 @@ func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo
 	}
 }
 
-func NewTestDBRepo(a *config.AppConfig) repository.DatabaseRepo {
-	return &testDBRepo{
+//queryContext bounds a query by the configured query timeout. The query is also
+//cancelled when ctx is, so an abandoned request does not keep its query running.
+func (m *postgressDBRepo) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, m.App.DB.QueryTimeout)
+}
+
+//exportContext bounds a query streaming an export by the configured export timeout
+func (m *postgressDBRepo) exportContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, m.App.DB.ExportTimeout)
+}
+
+//postgresPlaceholder returns the placeholder of the n-th argument of a postgres query
+func postgresPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func NewSQLiteRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
+	return &sqliteDBRepo{
 		App: a,
+		DB:  conn,
+	}
+}
+
+//queryContext bounds a query by the configured query timeout
+func (m *sqliteDBRepo) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, m.App.DB.QueryTimeout)
+}
+
+//exportContext bounds a query streaming an export by the configured export timeout
+func (m *sqliteDBRepo) exportContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, m.App.DB.ExportTimeout)
+}
+
+//statusNames returns the statuses as the strings stored in the status column
+func statusNames(statuses []domain.Status) []string {
+	names := make([]string, len(statuses))
+	for i, s := range statuses {
+		names[i] = string(s)
+	}
+	return names
+}
+
+//auditConditions returns the where clause matching an audit filter and its arguments,
+//placeholder returns the placeholder of the n-th argument, counting from 1
+func auditConditions(f models.AuditFilter, placeholder func(n int) string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, placeholder(len(args))))
+	}
+
+	if f.UserID != 0 {
+		add("user_id = %s", f.UserID)
+	}
+	if f.Action != "" {
+		add("action = %s", f.Action)
+	}
+	if f.Entity != "" {
+		add("entity = %s", f.Entity)
+	}
+	if f.EntityID != 0 {
+		add("entity_id = %s", f.EntityID)
+	}
+	//create_at is written in UTC, so the bounds are too
+	if !f.From.IsZero() {
+		add("create_at >= %s", f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		add("create_at < %s", f.To.UTC())
+	}
+
+	if len(conditions) == 0 {
+		return "1 = 1", nil
+	}
+	return strings.Join(conditions, " and "), args
+}
+
+//ErrInvalidCursor is returned for a page cursor ListReservations did not hand out
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+//reservationSorts maps the orders of a reservation list to the column sorted by
+var reservationSorts = map[string]string{
+	models.SortByArrival:   "r.start_date",
+	models.SortByDeparture: "r.end_date",
+	models.SortByLastName:  "r.last_name",
+	models.SortByID:        "r.id",
+}
+
+//pageCursor is the position of a reservation in a sorted list, the value of the sort
+//column and the id that breaks the ties between equal values
+type pageCursor struct {
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"id"`
+}
+
+func encodeCursor(r models.Reservation, sort string) string {
+	b, _ := json.Marshal(pageCursor{Value: sortValue(r, sort), ID: r.ID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//decodeCursor returns the cursor of the page q asks for, ok is false on the first page
+func decodeCursor(q models.ReservationQuery) (c pageCursor, ok bool, err error) {
+	s := q.After
+	if q.Before != "" {
+		s = q.Before
+	}
+	if s == "" {
+		return c, false, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || c.ID <= 0 {
+		return c, false, ErrInvalidCursor
+	}
+	if q.Sort == models.SortByArrival || q.Sort == models.SortByDeparture {
+		if _, err := time.Parse("2006-01-02", c.Value); err != nil {
+			return c, false, ErrInvalidCursor
+		}
+	}
+	return c, true, nil
+}
+
+//sortValue returns the value of the sort column of a reservation as kept in a cursor,
+//dates are formatted so they sort as strings
+func sortValue(r models.Reservation, sort string) string {
+	switch sort {
+	case models.SortByArrival:
+		return r.StartDate.Format("2006-01-02")
+	case models.SortByDeparture:
+		return r.EndDate.Format("2006-01-02")
+	case models.SortByLastName:
+		return r.LastName
+	}
+	return ""
+}
+
+//normalizeQuery fills in the default sort and page size of a reservation query
+func normalizeQuery(q models.ReservationQuery) (models.ReservationQuery, error) {
+	if q.Sort == "" {
+		q.Sort = models.SortByArrival
+	}
+	if _, ok := reservationSorts[q.Sort]; !ok {
+		return q, fmt.Errorf("unknown reservation sort %q", q.Sort)
+	}
+	if q.After != "" && q.Before != "" {
+		return q, ErrInvalidCursor
+	}
+
+	if q.Size <= 0 {
+		q.Size = models.DefaultPageSize
+	} else if q.Size > models.MaxPageSize {
+		q.Size = models.MaxPageSize
+	}
+	return q, nil
+}
+
+//readsBackwards reports whether the rows of a page are read in the reverse of the list order,
+//a page before a cursor is read backwards from it
+func readsBackwards(q models.ReservationQuery) bool {
+	return q.Desc != (q.Before != "")
+}
+
+//searchPatterns returns a LIKE pattern matching the start of each word of a search
+func searchPatterns(search string) []string {
+	escape := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+	var patterns []string
+	for _, word := range strings.Fields(strings.ToLower(search)) {
+		patterns = append(patterns, escape.Replace(word)+"%")
+	}
+	return patterns
+}
+
+//conditions builds a where clause, placeholder returns the placeholder of the n-th argument
+type conditions struct {
+	placeholder func(n int) string
+	clauses     []string
+	args        []interface{}
+}
+
+//add appends a condition, the %s verbs in it are replaced by the placeholders of values
+func (c *conditions) add(condition string, values ...interface{}) {
+	placeholders := make([]interface{}, len(values))
+	for i, v := range values {
+		c.args = append(c.args, v)
+		placeholders[i] = c.placeholder(len(c.args))
+	}
+	c.clauses = append(c.clauses, fmt.Sprintf(condition, placeholders...))
+}
+
+func (c *conditions) where() string {
+	if len(c.clauses) == 0 {
+		return "where 1 = 1"
+	}
+	return "where " + strings.Join(c.clauses, " and ")
+}
+
+//reservationConditions returns the conditions matching the filters of a reservation query
+func reservationConditions(q models.ReservationQuery, placeholder func(n int) string) *conditions {
+	c := &conditions{placeholder: placeholder}
+	c.add("r.deleted_at is null")
+
+	if len(q.Statuses) > 0 {
+		values := make([]interface{}, len(q.Statuses))
+		for i, s := range q.Statuses {
+			values[i] = string(s)
+		}
+		c.add("r.status in ("+strings.TrimSuffix(strings.Repeat("%s, ", len(values)), ", ")+")", values...)
+	}
+	if q.RoomID != 0 {
+		c.add("r.room_id = %s", q.RoomID)
+	}
+	//the dates are bound as strings, they convert to a date in postgres and compare as one in sqlite
+	if !q.From.IsZero() {
+		c.add("r.start_date >= %s", q.From.Format("2006-01-02"))
+	}
+	if !q.To.IsZero() {
+		c.add("r.start_date <= %s", q.To.Format("2006-01-02"))
+	}
+	for _, pattern := range searchPatterns(q.Search) {
+		c.add(`(lower(r.first_name) like %s escape '\' or lower(r.last_name) like %s escape '\' or lower(r.email) like %s escape '\')`,
+			pattern, pattern, pattern)
+	}
+	return c
+}
+
+//reservationOrder returns the order by clause of a normalized query read forwards or backwards
+func reservationOrder(q models.ReservationQuery, backwards bool) string {
+	dir := "asc"
+	if backwards {
+		dir = "desc"
+	}
+	if q.Sort == models.SortByID {
+		return "order by r.id " + dir
+	}
+	return fmt.Sprintf("order by %s %s, r.id %s", reservationSorts[q.Sort], dir, dir)
+}
+
+//reservationListClauses returns the where, order by and limit clauses reading the page of a
+//normalized query and their arguments. One row more than the page is read to tell whether
+//another page follows. placeholder returns the placeholder of the n-th argument, counting from 1.
+func reservationListClauses(q models.ReservationQuery, placeholder func(n int) string) (string, []interface{}, error) {
+	cursor, paged, err := decodeCursor(q)
+	if err != nil {
+		return "", nil, err
+	}
+
+	c := reservationConditions(q, placeholder)
+	backwards := readsBackwards(q)
+	if paged {
+		op := ">"
+		if backwards {
+			op = "<"
+		}
+		if q.Sort == models.SortByID {
+			c.add("r.id "+op+" %s", cursor.ID)
+		} else {
+			c.add("("+reservationSorts[q.Sort]+", r.id) "+op+" (%s, %s)", cursor.Value, cursor.ID)
+		}
+	}
+
+	return fmt.Sprintf("%s %s limit %d", c.where(), reservationOrder(q, backwards), q.Size+1), c.args, nil
+}
+
+//reservationExportClauses returns the where and order by clauses reading every reservation
+//a normalized query selects, the page and its cursors are ignored
+func reservationExportClauses(q models.ReservationQuery, placeholder func(n int) string) (string, []interface{}) {
+	c := reservationConditions(q, placeholder)
+	return c.where() + " " + reservationOrder(q, q.Desc), c.args
+}
+
+//newReservationPage trims the rows read for a normalized query to its page and sets the
+//cursors of the pages around it. rows holds one row more than the page when another
+//page follows in the direction it was read, and is in list order again on return.
+func newReservationPage(q models.ReservationQuery, rows []models.Reservation) models.ReservationPage {
+	more := len(rows) > q.Size
+	if more {
+		rows = rows[:q.Size]
+	}
+
+	page := models.ReservationPage{Reservations: rows}
+	if len(rows) == 0 {
+		return page
+	}
+
+	if q.Before != "" {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+		if more {
+			page.Prev = encodeCursor(rows[0], q.Sort)
+		}
+		page.Next = encodeCursor(rows[len(rows)-1], q.Sort)
+		return page
+	}
+
+	if more {
+		page.Next = encodeCursor(rows[len(rows)-1], q.Sort)
+	}
+	if q.After != "" {
+		page.Prev = encodeCursor(rows[0], q.Sort)
+	}
+	return page
+}
+
+//checkPeriod validates the range and period of an analytics query, from is inclusive and to exclusive
+func checkPeriod(from, to time.Time, period string) error {
+	switch period {
+	case models.PeriodDay, models.PeriodWeek, models.PeriodMonth:
+	default:
+		return fmt.Errorf("unknown period %q", period)
+	}
+	if !from.Before(to) {
+		return fmt.Errorf("the range from %s to %s is empty", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	}
+	return nil
+}
+
+//periodStart returns the first day of the period holding the date t
+func periodStart(t time.Time, period string) time.Time {
+	t = dateOnly(t)
+	switch period {
+	case models.PeriodWeek:
+		return t.AddDate(0, 0, -(int(t.Weekday())+6)%7)
+	case models.PeriodMonth:
+		return t.AddDate(0, 0, 1-t.Day())
+	}
+	return t
+}
+
+//ErrSameGuest is returned for merging a guest into itself
+var ErrSameGuest = errors.New("a guest cannot be merged into itself")
+
+//guestColumns are the columns the guest queries scan with scanGuest, the stays of a guest
+//count the reservations linked to it that are not in the trash
+const guestColumns = `g.id, g.first_name, g.last_name, g.email, g.phone, g.notes, g.tags, g.create_at, g.update_at,
+			(select count(r.id) from reservations r where r.guest_id = g.id and r.deleted_at is null)
+			from guests g`
+
+//guestOrder sorts the guests by name
+const guestOrder = `order by lower(g.last_name), lower(g.first_name), g.id`
+
+//guestDuplicates are the conditions on a guest g to look like the guest o
+const guestDuplicates = `g.id <> o.id and ((g.email_key = o.email_key and o.email_key <> '')
+			or (g.phone_key = o.phone_key and o.phone_key <> '')
+			or (lower(g.first_name) = lower(o.first_name) and lower(g.last_name) = lower(o.last_name)))`
+
+//rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+//scanGuest reads a row of guestColumns
+func scanGuest(row rowScanner) (models.Guest, error) {
+	var g models.Guest
+	var tags string
+	err := row.Scan(
+		&g.ID,
+		&g.FirstName,
+		&g.LastName,
+		&g.Email,
+		&g.Phone,
+		&g.Notes,
+		&tags,
+		&g.CreatedAt,
+		&g.UpdatedAt,
+		&g.Stays,
+	)
+	g.Tags = domain.ParseTags(tags)
+	return g, err
+}
+
+//guestConditions returns the conditions matching the guests named like a search, or
+//with an email or phone number starting like it
+func guestConditions(search string, placeholder func(n int) string) *conditions {
+	c := &conditions{placeholder: placeholder}
+	for _, pattern := range searchPatterns(search) {
+		c.add(`(lower(g.first_name) like %s escape '\' or lower(g.last_name) like %s escape '\'
+			or g.email_key like %s escape '\' or g.phone_key like %s escape '\')`,
+			pattern, pattern, pattern, pattern)
+	}
+	return c
+}
+
+//ErrAccountExists is returned for signing up with the email of an existing guest account
+var ErrAccountExists = errors.New("an account with this email already exists")
+
+//guestAccountColumns are the columns the account queries scan with scanGuestAccount,
+//the account comes with the name and phone number of its guest
+const guestAccountColumns = `a.id, a.guest_id, a.email, a.password, a.verify_token, a.verify_expires, a.verified_at,
+			a.create_at, a.update_at, g.first_name, g.last_name, g.email, g.phone
+			from guest_accounts a join guests g on g.id = a.guest_id`
+
+//scanGuestAccount reads a row of guestAccountColumns
+func scanGuestAccount(row rowScanner) (models.GuestAccount, error) {
+	var a models.GuestAccount
+	var expires, verified sql.NullTime
+	err := row.Scan(
+		&a.ID,
+		&a.GuestID,
+		&a.Email,
+		&a.Password,
+		&a.VerifyToken,
+		&expires,
+		&verified,
+		&a.CreatedAt,
+		&a.UpdatedAt,
+		&a.Guest.FirstName,
+		&a.Guest.LastName,
+		&a.Guest.Email,
+		&a.Guest.Phone,
+	)
+	a.VerifyExpires = expires.Time
+	a.VerifiedAt = verified.Time
+	a.Guest.ID = a.GuestID
+	return a, err
+}
+
+//checkPassword compares a password with the hash of an account or user
+func checkPassword(hashedPassword, testPassword string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return errors.New("incorrect password")
+	}
+	return err
+}
+
+//mergeGuest returns the guest into with the details it lacks taken from the guest from,
+//the notes of both and the tags of either
+func mergeGuest(into, from models.Guest) models.Guest {
+	if into.Email == "" {
+		into.Email = from.Email
+	}
+	if into.Phone == "" {
+		into.Phone = from.Phone
+	}
+	if from.Notes != "" {
+		into.Notes = strings.TrimSpace(into.Notes + "\n\n" + from.Notes)
 	}
+	into.Tags = domain.ParseTags(strings.Join(append(append([]string{}, into.Tags...), from.Tags...), ","))
+	return into
 }
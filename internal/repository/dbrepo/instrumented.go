@@ -0,0 +1,387 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/domain"
+	"github.com/ArmanurRahman/booking/internal/metrics"
+	"github.com/ArmanurRahman/booking/internal/models"
+	"github.com/ArmanurRahman/booking/internal/repository"
+	"github.com/ArmanurRahman/booking/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//instrumentedDBRepo records the duration and errors of every method of the wrapped
+//repository and traces each call as a span of the request that made it
+type instrumentedDBRepo struct {
+	repo   repository.DatabaseRepo
+	system string
+}
+
+//dbSystems maps a database driver to the db.system of its spans
+var dbSystems = map[string]string{
+	"postgres": "postgresql",
+	"sqlite":   "sqlite",
+}
+
+//NewInstrumentedRepo wraps a repository of the given database driver with query metrics and tracing
+func NewInstrumentedRepo(repo repository.DatabaseRepo, driver string) repository.DatabaseRepo {
+	system, ok := dbSystems[driver]
+	if !ok {
+		system = driver
+	}
+	return &instrumentedDBRepo{
+		repo:   repo,
+		system: system,
+	}
+}
+
+//startSpan starts the span of a repository call
+func (m *instrumentedDBRepo) startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "db."+method,
+		attribute.String("db.system", m.system),
+		attribute.String("db.operation", method),
+	)
+}
+
+//observe ends the span and records the duration and outcome of a repository call
+func observe(method string, span trace.Span, start time.Time, err *error) {
+	metrics.ObserveQuery(method, start, *err)
+	tracing.End(span, *err)
+}
+
+func (m *instrumentedDBRepo) AllUsers(ctx context.Context) bool {
+	return m.repo.AllUsers(ctx)
+}
+
+func (m *instrumentedDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (result int, err error) {
+	ctx, span := m.startSpan(ctx, "InsertReservation")
+	defer observe("InsertReservation", span, time.Now(), &err)
+	return m.repo.InsertReservation(ctx, res)
+}
+
+func (m *instrumentedDBRepo) InsetIntoRoomRestriction(ctx context.Context, res models.RoomRestriction) (err error) {
+	ctx, span := m.startSpan(ctx, "InsetIntoRoomRestriction")
+	defer observe("InsetIntoRoomRestriction", span, time.Now(), &err)
+	return m.repo.InsetIntoRoomRestriction(ctx, res)
+}
+
+func (m *instrumentedDBRepo) SearchAvailabilityByDatesByRoomId(ctx context.Context, start, end time.Time, roomId int) (result bool, err error) {
+	ctx, span := m.startSpan(ctx, "SearchAvailabilityByDatesByRoomId")
+	defer observe("SearchAvailabilityByDatesByRoomId", span, time.Now(), &err)
+	return m.repo.SearchAvailabilityByDatesByRoomId(ctx, start, end, roomId)
+}
+
+func (m *instrumentedDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) (result []models.Room, err error) {
+	ctx, span := m.startSpan(ctx, "SearchAvailabilityForAllRooms")
+	defer observe("SearchAvailabilityForAllRooms", span, time.Now(), &err)
+	return m.repo.SearchAvailabilityForAllRooms(ctx, start, end)
+}
+
+func (m *instrumentedDBRepo) GetRoomByID(ctx context.Context, id int) (result models.Room, err error) {
+	ctx, span := m.startSpan(ctx, "GetRoomByID")
+	defer observe("GetRoomByID", span, time.Now(), &err)
+	return m.repo.GetRoomByID(ctx, id)
+}
+
+func (m *instrumentedDBRepo) AllRooms(ctx context.Context) (result []models.Room, err error) {
+	ctx, span := m.startSpan(ctx, "AllRooms")
+	defer observe("AllRooms", span, time.Now(), &err)
+	return m.repo.AllRooms(ctx)
+}
+
+func (m *instrumentedDBRepo) InsertRoom(ctx context.Context, room models.Room) (result int, err error) {
+	ctx, span := m.startSpan(ctx, "InsertRoom")
+	defer observe("InsertRoom", span, time.Now(), &err)
+	return m.repo.InsertRoom(ctx, room)
+}
+
+func (m *instrumentedDBRepo) GetUserById(ctx context.Context, id int) (result models.User, err error) {
+	ctx, span := m.startSpan(ctx, "GetUserById")
+	defer observe("GetUserById", span, time.Now(), &err)
+	return m.repo.GetUserById(ctx, id)
+}
+
+func (m *instrumentedDBRepo) UpdateUserById(ctx context.Context, user models.User) (err error) {
+	ctx, span := m.startSpan(ctx, "UpdateUserById")
+	defer observe("UpdateUserById", span, time.Now(), &err)
+	return m.repo.UpdateUserById(ctx, user)
+}
+
+func (m *instrumentedDBRepo) GetUserByEmail(ctx context.Context, email string) (result models.User, err error) {
+	ctx, span := m.startSpan(ctx, "GetUserByEmail")
+	defer observe("GetUserByEmail", span, time.Now(), &err)
+	return m.repo.GetUserByEmail(ctx, email)
+}
+
+func (m *instrumentedDBRepo) InsertUser(ctx context.Context, user models.User) (result int, err error) {
+	ctx, span := m.startSpan(ctx, "InsertUser")
+	defer observe("InsertUser", span, time.Now(), &err)
+	return m.repo.InsertUser(ctx, user)
+}
+
+func (m *instrumentedDBRepo) UpdateUserPassword(ctx context.Context, id int, hashedPassword string) (err error) {
+	ctx, span := m.startSpan(ctx, "UpdateUserPassword")
+	defer observe("UpdateUserPassword", span, time.Now(), &err)
+	return m.repo.UpdateUserPassword(ctx, id, hashedPassword)
+}
+
+func (m *instrumentedDBRepo) Authenticate(ctx context.Context, email, testPassword string) (r0 int, r1 string, err error) {
+	ctx, span := m.startSpan(ctx, "Authenticate")
+	defer observe("Authenticate", span, time.Now(), &err)
+	return m.repo.Authenticate(ctx, email, testPassword)
+}
+
+func (m *instrumentedDBRepo) ReservationsByStatus(ctx context.Context, statuses ...domain.Status) (result []models.Reservation, err error) {
+	ctx, span := m.startSpan(ctx, "ReservationsByStatus")
+	defer observe("ReservationsByStatus", span, time.Now(), &err)
+	return m.repo.ReservationsByStatus(ctx, statuses...)
+}
+
+func (m *instrumentedDBRepo) CountReservationsByStatus(ctx context.Context, statuses ...domain.Status) (result int, err error) {
+	ctx, span := m.startSpan(ctx, "CountReservationsByStatus")
+	defer observe("CountReservationsByStatus", span, time.Now(), &err)
+	return m.repo.CountReservationsByStatus(ctx, statuses...)
+}
+
+func (m *instrumentedDBRepo) ListReservations(ctx context.Context, q models.ReservationQuery) (result models.ReservationPage, err error) {
+	ctx, span := m.startSpan(ctx, "ListReservations")
+	defer observe("ListReservations", span, time.Now(), &err)
+	return m.repo.ListReservations(ctx, q)
+}
+
+func (m *instrumentedDBRepo) EachReservation(ctx context.Context, q models.ReservationQuery, fn func(models.Reservation) error) (err error) {
+	ctx, span := m.startSpan(ctx, "EachReservation")
+	defer observe("EachReservation", span, time.Now(), &err)
+	return m.repo.EachReservation(ctx, q, fn)
+}
+
+func (m *instrumentedDBRepo) EachRoomRestriction(ctx context.Context, from, to time.Time, fn func(models.RoomRestriction) error) (err error) {
+	ctx, span := m.startSpan(ctx, "EachRoomRestriction")
+	defer observe("EachRoomRestriction", span, time.Now(), &err)
+	return m.repo.EachRoomRestriction(ctx, from, to, fn)
+}
+
+func (m *instrumentedDBRepo) GetReservationById(ctx context.Context, id int) (result models.Reservation, err error) {
+	ctx, span := m.startSpan(ctx, "GetReservationById")
+	defer observe("GetReservationById", span, time.Now(), &err)
+	return m.repo.GetReservationById(ctx, id)
+}
+
+func (m *instrumentedDBRepo) UpdateReservationById(ctx context.Context, reservation models.Reservation) (err error) {
+	ctx, span := m.startSpan(ctx, "UpdateReservationById")
+	defer observe("UpdateReservationById", span, time.Now(), &err)
+	return m.repo.UpdateReservationById(ctx, reservation)
+}
+
+func (m *instrumentedDBRepo) DeleteReservationById(ctx context.Context, id int) (err error) {
+	ctx, span := m.startSpan(ctx, "DeleteReservationById")
+	defer observe("DeleteReservationById", span, time.Now(), &err)
+	return m.repo.DeleteReservationById(ctx, id)
+}
+
+func (m *instrumentedDBRepo) RestoreReservation(ctx context.Context, id int) (err error) {
+	ctx, span := m.startSpan(ctx, "RestoreReservation")
+	defer observe("RestoreReservation", span, time.Now(), &err)
+	return m.repo.RestoreReservation(ctx, id)
+}
+
+func (m *instrumentedDBRepo) ImportReservations(ctx context.Context, reservations []models.Reservation) (result []int, err error) {
+	ctx, span := m.startSpan(ctx, "ImportReservations")
+	defer observe("ImportReservations", span, time.Now(), &err)
+	return m.repo.ImportReservations(ctx, reservations)
+}
+
+func (m *instrumentedDBRepo) DeletedReservations(ctx context.Context) (result []models.Reservation, err error) {
+	ctx, span := m.startSpan(ctx, "DeletedReservations")
+	defer observe("DeletedReservations", span, time.Now(), &err)
+	return m.repo.DeletedReservations(ctx)
+}
+
+func (m *instrumentedDBRepo) PurgeDeletedReservations(ctx context.Context, before time.Time) (result int, err error) {
+	ctx, span := m.startSpan(ctx, "PurgeDeletedReservations")
+	defer observe("PurgeDeletedReservations", span, time.Now(), &err)
+	return m.repo.PurgeDeletedReservations(ctx, before)
+}
+
+func (m *instrumentedDBRepo) UpdateReservationStatus(ctx context.Context, id int, to domain.Status, userID int) (err error) {
+	ctx, span := m.startSpan(ctx, "UpdateReservationStatus")
+	defer observe("UpdateReservationStatus", span, time.Now(), &err)
+	return m.repo.UpdateReservationStatus(ctx, id, to, userID)
+}
+
+func (m *instrumentedDBRepo) ReservationStatusHistory(ctx context.Context, id int) (result []models.StatusChange, err error) {
+	ctx, span := m.startSpan(ctx, "ReservationStatusHistory")
+	defer observe("ReservationStatusHistory", span, time.Now(), &err)
+	return m.repo.ReservationStatusHistory(ctx, id)
+}
+
+func (m *instrumentedDBRepo) ReservationsByStartDate(ctx context.Context, from, to time.Time) (result []models.Reservation, err error) {
+	ctx, span := m.startSpan(ctx, "ReservationsByStartDate")
+	defer observe("ReservationsByStartDate", span, time.Now(), &err)
+	return m.repo.ReservationsByStartDate(ctx, from, to)
+}
+
+func (m *instrumentedDBRepo) ReservationsByEndDate(ctx context.Context, from, to time.Time) (result []models.Reservation, err error) {
+	ctx, span := m.startSpan(ctx, "ReservationsByEndDate")
+	defer observe("ReservationsByEndDate", span, time.Now(), &err)
+	return m.repo.ReservationsByEndDate(ctx, from, to)
+}
+
+func (m *instrumentedDBRepo) ClaimNotification(ctx context.Context, reservationID int, kind string) (result bool, err error) {
+	ctx, span := m.startSpan(ctx, "ClaimNotification")
+	defer observe("ClaimNotification", span, time.Now(), &err)
+	return m.repo.ClaimNotification(ctx, reservationID, kind)
+}
+
+func (m *instrumentedDBRepo) ReleaseNotification(ctx context.Context, reservationID int, kind string) (err error) {
+	ctx, span := m.startSpan(ctx, "ReleaseNotification")
+	defer observe("ReleaseNotification", span, time.Now(), &err)
+	return m.repo.ReleaseNotification(ctx, reservationID, kind)
+}
+
+func (m *instrumentedDBRepo) InsertNotification(ctx context.Context, n models.Notification) (result int, err error) {
+	ctx, span := m.startSpan(ctx, "InsertNotification")
+	defer observe("InsertNotification", span, time.Now(), &err)
+	return m.repo.InsertNotification(ctx, n)
+}
+
+func (m *instrumentedDBRepo) RecentNotifications(ctx context.Context, userID, limit int) (result []models.Notification, err error) {
+	ctx, span := m.startSpan(ctx, "RecentNotifications")
+	defer observe("RecentNotifications", span, time.Now(), &err)
+	return m.repo.RecentNotifications(ctx, userID, limit)
+}
+
+func (m *instrumentedDBRepo) UnreadNotificationCount(ctx context.Context, userID int) (result int, err error) {
+	ctx, span := m.startSpan(ctx, "UnreadNotificationCount")
+	defer observe("UnreadNotificationCount", span, time.Now(), &err)
+	return m.repo.UnreadNotificationCount(ctx, userID)
+}
+
+func (m *instrumentedDBRepo) MarkNotificationRead(ctx context.Context, id, userID int) (err error) {
+	ctx, span := m.startSpan(ctx, "MarkNotificationRead")
+	defer observe("MarkNotificationRead", span, time.Now(), &err)
+	return m.repo.MarkNotificationRead(ctx, id, userID)
+}
+
+func (m *instrumentedDBRepo) MarkAllNotificationsRead(ctx context.Context, userID int) (err error) {
+	ctx, span := m.startSpan(ctx, "MarkAllNotificationsRead")
+	defer observe("MarkAllNotificationsRead", span, time.Now(), &err)
+	return m.repo.MarkAllNotificationsRead(ctx, userID)
+}
+
+func (m *instrumentedDBRepo) Occupancy(ctx context.Context, from, to time.Time, period string) (result []models.Occupancy, err error) {
+	ctx, span := m.startSpan(ctx, "Occupancy")
+	defer observe("Occupancy", span, time.Now(), &err)
+	return m.repo.Occupancy(ctx, from, to, period)
+}
+
+func (m *instrumentedDBRepo) BookingStats(ctx context.Context, from, to time.Time, period string) (result []models.BookingStats, err error) {
+	ctx, span := m.startSpan(ctx, "BookingStats")
+	defer observe("BookingStats", span, time.Now(), &err)
+	return m.repo.BookingStats(ctx, from, to, period)
+}
+
+func (m *instrumentedDBRepo) ListGuests(ctx context.Context, search string) (result []models.Guest, err error) {
+	ctx, span := m.startSpan(ctx, "ListGuests")
+	defer observe("ListGuests", span, time.Now(), &err)
+	return m.repo.ListGuests(ctx, search)
+}
+
+func (m *instrumentedDBRepo) GetGuestByID(ctx context.Context, id int) (result models.Guest, err error) {
+	ctx, span := m.startSpan(ctx, "GetGuestByID")
+	defer observe("GetGuestByID", span, time.Now(), &err)
+	return m.repo.GetGuestByID(ctx, id)
+}
+
+func (m *instrumentedDBRepo) GuestReservations(ctx context.Context, id int) (result []models.Reservation, err error) {
+	ctx, span := m.startSpan(ctx, "GuestReservations")
+	defer observe("GuestReservations", span, time.Now(), &err)
+	return m.repo.GuestReservations(ctx, id)
+}
+
+func (m *instrumentedDBRepo) DuplicateGuests(ctx context.Context, id int) (result []models.Guest, err error) {
+	ctx, span := m.startSpan(ctx, "DuplicateGuests")
+	defer observe("DuplicateGuests", span, time.Now(), &err)
+	return m.repo.DuplicateGuests(ctx, id)
+}
+
+func (m *instrumentedDBRepo) UpdateGuest(ctx context.Context, guest models.Guest) (err error) {
+	ctx, span := m.startSpan(ctx, "UpdateGuest")
+	defer observe("UpdateGuest", span, time.Now(), &err)
+	return m.repo.UpdateGuest(ctx, guest)
+}
+
+func (m *instrumentedDBRepo) MergeGuests(ctx context.Context, into, from int) (err error) {
+	ctx, span := m.startSpan(ctx, "MergeGuests")
+	defer observe("MergeGuests", span, time.Now(), &err)
+	return m.repo.MergeGuests(ctx, into, from)
+}
+
+func (m *instrumentedDBRepo) InsertGuestAccount(ctx context.Context, account models.GuestAccount) (result int, err error) {
+	ctx, span := m.startSpan(ctx, "InsertGuestAccount")
+	defer observe("InsertGuestAccount", span, time.Now(), &err)
+	return m.repo.InsertGuestAccount(ctx, account)
+}
+
+func (m *instrumentedDBRepo) GetGuestAccount(ctx context.Context, id int) (result models.GuestAccount, err error) {
+	ctx, span := m.startSpan(ctx, "GetGuestAccount")
+	defer observe("GetGuestAccount", span, time.Now(), &err)
+	return m.repo.GetGuestAccount(ctx, id)
+}
+
+func (m *instrumentedDBRepo) AuthenticateGuest(ctx context.Context, email, testPassword string) (result models.GuestAccount, err error) {
+	ctx, span := m.startSpan(ctx, "AuthenticateGuest")
+	defer observe("AuthenticateGuest", span, time.Now(), &err)
+	return m.repo.AuthenticateGuest(ctx, email, testPassword)
+}
+
+func (m *instrumentedDBRepo) RenewGuestVerification(ctx context.Context, id int, token string, expires time.Time) (err error) {
+	ctx, span := m.startSpan(ctx, "RenewGuestVerification")
+	defer observe("RenewGuestVerification", span, time.Now(), &err)
+	return m.repo.RenewGuestVerification(ctx, id, token, expires)
+}
+
+func (m *instrumentedDBRepo) VerifyGuestAccount(ctx context.Context, token string) (result int, err error) {
+	ctx, span := m.startSpan(ctx, "VerifyGuestAccount")
+	defer observe("VerifyGuestAccount", span, time.Now(), &err)
+	return m.repo.VerifyGuestAccount(ctx, token)
+}
+
+func (m *instrumentedDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time) (err error) {
+	ctx, span := m.startSpan(ctx, "ChangeReservationDates")
+	defer observe("ChangeReservationDates", span, time.Now(), &err)
+	return m.repo.ChangeReservationDates(ctx, id, start, end)
+}
+
+func (m *instrumentedDBRepo) InsertBooking(ctx context.Context, reservations []models.Reservation) (r0 int, r1 []int, err error) {
+	ctx, span := m.startSpan(ctx, "InsertBooking")
+	defer observe("InsertBooking", span, time.Now(), &err)
+	return m.repo.InsertBooking(ctx, reservations)
+}
+
+func (m *instrumentedDBRepo) GetBooking(ctx context.Context, id int) (result models.Booking, err error) {
+	ctx, span := m.startSpan(ctx, "GetBooking")
+	defer observe("GetBooking", span, time.Now(), &err)
+	return m.repo.GetBooking(ctx, id)
+}
+
+func (m *instrumentedDBRepo) CancelBooking(ctx context.Context, id int, userID int) (result []int, err error) {
+	ctx, span := m.startSpan(ctx, "CancelBooking")
+	defer observe("CancelBooking", span, time.Now(), &err)
+	return m.repo.CancelBooking(ctx, id, userID)
+}
+
+func (m *instrumentedDBRepo) InsertAuditEntry(ctx context.Context, e models.AuditEntry) (result int, err error) {
+	ctx, span := m.startSpan(ctx, "InsertAuditEntry")
+	defer observe("InsertAuditEntry", span, time.Now(), &err)
+	return m.repo.InsertAuditEntry(ctx, e)
+}
+
+func (m *instrumentedDBRepo) AuditEntries(ctx context.Context, f models.AuditFilter) (result []models.AuditEntry, err error) {
+	ctx, span := m.startSpan(ctx, "AuditEntries")
+	defer observe("AuditEntries", span, time.Now(), &err)
+	return m.repo.AuditEntries(ctx, f)
+}
@@ -0,0 +1,1634 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/config"
+	"github.com/ArmanurRahman/booking/internal/domain"
+	"github.com/ArmanurRahman/booking/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//Fault decides whether a call to a MemoryDBRepo method fails. It gets the
+//arguments of the call after the context and returns the error to fail with,
+//or nil to let the call through.
+type Fault func(args ...interface{}) error
+
+//MemoryDBRepo is a repository kept in memory, for tests and demos. It starts
+//with the rooms and restrictions the migrations seed and answers every query
+//the way the SQL repositories do. Calls can be made to fail with FailOn and
+//FailWhen.
+type MemoryDBRepo struct {
+	App *config.AppConfig
+
+	mu                sync.Mutex
+	faults            map[string]Fault
+	users             []models.User
+	rooms             []models.Room
+	restrictions      []models.Restriction
+	reservations      []models.Reservation
+	bookings          []models.Booking
+	guests            []models.Guest
+	guestAccounts     []models.GuestAccount
+	roomRestrictions  []models.RoomRestriction
+	statusChanges     []models.StatusChange
+	sentNotifications []models.SentNotification
+	notifications     []models.Notification
+	reads             map[[2]int]time.Time
+	auditLog          []models.AuditEntry
+	lastID            map[string]int
+}
+
+//NewMemoryRepo creates an in-memory repository seeded like a migrated database
+func NewMemoryRepo(a *config.AppConfig) *MemoryDBRepo {
+	m := &MemoryDBRepo{
+		App:    a,
+		faults: make(map[string]Fault),
+		reads:  make(map[[2]int]time.Time),
+		lastID: make(map[string]int),
+	}
+
+	now := time.Now()
+	for _, room := range []models.Room{
+		{RoomName: "General's Quarters", MaxOccupancy: 2, Beds: "1 king bed"},
+		{RoomName: "Major's Suite", MaxOccupancy: 4, Beds: "1 queen bed, 2 single beds"},
+	} {
+		room.ID = m.nextID("rooms")
+		room.CreatedAt = now
+		room.UpdatedAt = now
+		m.rooms = append(m.rooms, room)
+	}
+	for _, name := range []string{"Reservation", "Owner Block"} {
+		m.restrictions = append(m.restrictions, models.Restriction{ID: m.nextID("restrictions"), RestrictionName: name, CreatedAt: now, UpdatedAt: now})
+	}
+
+	return m
+}
+
+//FailOn makes every call to method fail with err until the returned func is called
+func (m *MemoryDBRepo) FailOn(method string, err error) func() {
+	return m.FailWhen(method, func(args ...interface{}) error { return err })
+}
+
+//FailWhen runs fault before every call to method until the returned func is called
+func (m *MemoryDBRepo) FailWhen(method string, fault Fault) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.faults[method] = fault
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.faults, method)
+	}
+}
+
+//begin locks the repository for a call of method, it fails when ctx is done or a fault is set
+func (m *MemoryDBRepo) begin(ctx context.Context, method string, args ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if fault, ok := m.faults[method]; ok {
+		if err := fault(args...); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+//nextID returns the next id of table, like a serial column
+func (m *MemoryDBRepo) nextID(table string) int {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+//dateOnly drops the time of day, like a date column
+func dateOnly(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func (m *MemoryDBRepo) AllUsers(ctx context.Context) bool {
+	return true
+}
+
+func (m *MemoryDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	if err := m.begin(ctx, "InsertReservation", res); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	res.ID = m.nextID("reservations")
+	res.GuestID = m.linkGuest(res)
+	res.StartDate = dateOnly(res.StartDate)
+	res.EndDate = dateOnly(res.EndDate)
+	res.Room = models.Room{}
+	res.Status = domain.Pending
+	res.CreatedAt = now
+	res.UpdatedAt = now
+	m.reservations = append(m.reservations, res)
+
+	return res.ID, nil
+}
+
+//linkGuest returns the guest of a reservation made from a guest account, or the guest
+//with the email of the reservation, or the guest an earlier reservation with the email
+//was linked to, or else the guest with its phone number. The guest is added when there is none.
+func (m *MemoryDBRepo) linkGuest(res models.Reservation) int {
+	if res.GuestID != 0 {
+		return res.GuestID
+	}
+	emailKey, phoneKey := domain.EmailKey(res.Email), domain.PhoneKey(res.Phone)
+
+	byPhone := 0
+	for _, g := range m.guests {
+		if emailKey != "" && domain.EmailKey(g.Email) == emailKey {
+			return g.ID
+		}
+		if byPhone == 0 && phoneKey != "" && domain.PhoneKey(g.Phone) == phoneKey {
+			byPhone = g.ID
+		}
+	}
+	//a guest merged into another keeps the email on its reservations
+	for _, r := range m.reservations {
+		if emailKey != "" && r.GuestID != 0 && domain.EmailKey(r.Email) == emailKey {
+			return r.GuestID
+		}
+	}
+	if byPhone != 0 {
+		return byPhone
+	}
+	return m.addGuest(res)
+}
+
+//accountGuest returns the guest with the email of a guest account, or adds one. An account
+//is only linked by its email, a phone number proves nothing about who signs up.
+func (m *MemoryDBRepo) accountGuest(res models.Reservation) int {
+	emailKey := domain.EmailKey(res.Email)
+	for _, g := range m.guests {
+		if emailKey != "" && domain.EmailKey(g.Email) == emailKey {
+			return g.ID
+		}
+	}
+	return m.addGuest(res)
+}
+
+//addGuest adds a guest with the contact details of a reservation
+func (m *MemoryDBRepo) addGuest(res models.Reservation) int {
+	now := time.Now()
+	g := models.Guest{
+		ID:        m.nextID("guests"),
+		FirstName: res.FirstName,
+		LastName:  res.LastName,
+		Email:     res.Email,
+		Phone:     res.Phone,
+		Tags:      []string{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	m.guests = append(m.guests, g)
+	return g.ID
+}
+
+func (m *MemoryDBRepo) InsetIntoRoomRestriction(ctx context.Context, res models.RoomRestriction) error {
+	if err := m.begin(ctx, "InsetIntoRoomRestriction", res); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	res.ID = m.nextID("room_restrictions")
+	res.StartDate = dateOnly(res.StartDate)
+	res.EndDate = dateOnly(res.EndDate)
+	res.CreatedAt = now
+	res.UpdatedAt = now
+	m.roomRestrictions = append(m.roomRestrictions, res)
+
+	return nil
+}
+
+func (m *MemoryDBRepo) SearchAvailabilityByDatesByRoomId(ctx context.Context, start, end time.Time, roomId int) (bool, error) {
+	if err := m.begin(ctx, "SearchAvailabilityByDatesByRoomId", start, end, roomId); err != nil {
+		return false, err
+	}
+	defer m.mu.Unlock()
+
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID == roomId && domain.Overlaps(start, end, rr.StartDate, rr.EndDate) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (m *MemoryDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	if err := m.begin(ctx, "SearchAvailabilityForAllRooms", start, end); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	taken := make(map[int]bool)
+	for _, rr := range m.roomRestrictions {
+		if domain.Overlaps(start, end, rr.StartDate, rr.EndDate) {
+			taken[rr.RoomID] = true
+		}
+	}
+
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		if !taken[room.ID] {
+			rooms = append(rooms, models.Room{ID: room.ID, RoomName: room.RoomName, MaxOccupancy: room.MaxOccupancy, Beds: room.Beds})
+		}
+	}
+	return rooms, nil
+}
+
+func (m *MemoryDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	if err := m.begin(ctx, "GetRoomByID", id); err != nil {
+		return models.Room{}, err
+	}
+	defer m.mu.Unlock()
+
+	room, ok := m.room(id)
+	if !ok {
+		return room, sql.ErrNoRows
+	}
+	return room, nil
+}
+
+//AllRooms returns every room ordered by id
+func (m *MemoryDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	if err := m.begin(ctx, "AllRooms"); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	rooms := append([]models.Room(nil), m.rooms...)
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].ID < rooms[j].ID
+	})
+	return rooms, nil
+}
+
+func (m *MemoryDBRepo) room(id int) (models.Room, bool) {
+	for _, room := range m.rooms {
+		if room.ID == id {
+			return room, true
+		}
+	}
+	return models.Room{}, false
+}
+
+func (m *MemoryDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	if err := m.begin(ctx, "InsertRoom", room); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	room.ID = m.nextID("rooms")
+	room.CreatedAt = now
+	room.UpdatedAt = now
+	m.rooms = append(m.rooms, room)
+
+	return room.ID, nil
+}
+
+func (m *MemoryDBRepo) GetUserById(ctx context.Context, id int) (models.User, error) {
+	if err := m.begin(ctx, "GetUserById", id); err != nil {
+		return models.User{}, err
+	}
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+func (m *MemoryDBRepo) UpdateUserById(ctx context.Context, user models.User) error {
+	if err := m.begin(ctx, "UpdateUserById", user); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	for i := range m.users {
+		if m.users[i].ID == user.ID {
+			m.users[i].FirstName = user.FirstName
+			m.users[i].LastName = user.LastName
+			m.users[i].Email = user.Email
+			m.users[i].AccessLevel = user.AccessLevel
+			m.users[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	if err := m.begin(ctx, "GetUserByEmail", email); err != nil {
+		return models.User{}, err
+	}
+	defer m.mu.Unlock()
+
+	user, ok := m.userByEmail(email)
+	if !ok {
+		return user, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (m *MemoryDBRepo) userByEmail(email string) (models.User, bool) {
+	for _, user := range m.users {
+		if user.Email == email {
+			return user, true
+		}
+	}
+	return models.User{}, false
+}
+
+func (m *MemoryDBRepo) InsertUser(ctx context.Context, user models.User) (int, error) {
+	if err := m.begin(ctx, "InsertUser", user); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	user.ID = m.nextID("users")
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	m.users = append(m.users, user)
+
+	return user.ID, nil
+}
+
+func (m *MemoryDBRepo) UpdateUserPassword(ctx context.Context, id int, hashedPassword string) error {
+	if err := m.begin(ctx, "UpdateUserPassword", id, hashedPassword); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	for i := range m.users {
+		if m.users[i].ID == id {
+			m.users[i].Password = hashedPassword
+			m.users[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	if err := m.begin(ctx, "Authenticate", email, testPassword); err != nil {
+		return 0, "", err
+	}
+	user, ok := m.userByEmail(email)
+	m.mu.Unlock()
+
+	if !ok {
+		return 0, "", sql.ErrNoRows
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(testPassword))
+
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("incorrect password")
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	return user.ID, user.Password, nil
+}
+
+//ReservationsByStatus returns the reservations in any of the statuses, or every reservation without statuses
+func (m *MemoryDBRepo) ReservationsByStatus(ctx context.Context, statuses ...domain.Status) ([]models.Reservation, error) {
+	if err := m.begin(ctx, "ReservationsByStatus", statuses); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	reservations := m.filterReservations(func(r models.Reservation) bool {
+		return r.DeletedAt.IsZero() && hasStatus(r.Status, statuses)
+	})
+	sort.SliceStable(reservations, func(i, j int) bool {
+		return reservations[i].StartDate.Before(reservations[j].StartDate)
+	})
+	return reservations, nil
+}
+
+//ListReservations returns the page of the reservations the query selects
+func (m *MemoryDBRepo) ListReservations(ctx context.Context, q models.ReservationQuery) (models.ReservationPage, error) {
+	if err := m.begin(ctx, "ListReservations", q); err != nil {
+		return models.ReservationPage{}, err
+	}
+	defer m.mu.Unlock()
+
+	q, err := normalizeQuery(q)
+	if err != nil {
+		return models.ReservationPage{}, err
+	}
+	cursor, paged, err := decodeCursor(q)
+	if err != nil {
+		return models.ReservationPage{}, err
+	}
+
+	backwards := readsBackwards(q)
+	var reservations []models.Reservation
+	for _, r := range m.matchingReservations(q, backwards) {
+		if paged && !comesAfter(r, q.Sort, cursor.Value, cursor.ID, backwards) {
+			continue
+		}
+		reservations = append(reservations, r)
+		if len(reservations) > q.Size {
+			break
+		}
+	}
+	return newReservationPage(q, reservations), nil
+}
+
+//EachReservation calls fn with every reservation the query selects in its order. The page of
+//the query is ignored, and the first error of fn stops the reading.
+func (m *MemoryDBRepo) EachReservation(ctx context.Context, q models.ReservationQuery, fn func(models.Reservation) error) error {
+	if err := m.begin(ctx, "EachReservation", q); err != nil {
+		return err
+	}
+
+	q, err := normalizeQuery(q)
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	reservations := m.matchingReservations(q, q.Desc)
+	//fn runs unlocked, like a database it may be read while the rows stream
+	m.mu.Unlock()
+
+	for _, r := range reservations {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//matchingReservations returns the reservations the filters of a normalized query select,
+//sorted in the list order or in reverse when read backwards
+func (m *MemoryDBRepo) matchingReservations(q models.ReservationQuery, backwards bool) []models.Reservation {
+	from, to := q.From.Format("2006-01-02"), q.To.Format("2006-01-02")
+	patterns := strings.Fields(strings.ToLower(q.Search))
+
+	reservations := m.filterReservations(func(r models.Reservation) bool {
+		arrival := r.StartDate.Format("2006-01-02")
+		return r.DeletedAt.IsZero() && hasStatus(r.Status, q.Statuses) &&
+			(q.RoomID == 0 || r.RoomID == q.RoomID) &&
+			(q.From.IsZero() || arrival >= from) && (q.To.IsZero() || arrival <= to) &&
+			matchesSearch(r, patterns)
+	})
+	sort.Slice(reservations, func(i, j int) bool {
+		return comesAfter(reservations[j], q.Sort, sortValue(reservations[i], q.Sort), reservations[i].ID, backwards)
+	})
+	return reservations
+}
+
+//comesAfter reports whether r follows the sort value and id in the list order, or precedes
+//them when read backwards
+func comesAfter(r models.Reservation, sort, value string, id int, backwards bool) bool {
+	v := sortValue(r, sort)
+	if v == value {
+		return r.ID != id && (r.ID > id) != backwards
+	}
+	return (v > value) != backwards
+}
+
+//matchesSearch reports whether every word starts the first name, last name or email of r
+func matchesSearch(r models.Reservation, words []string) bool {
+	fields := []string{strings.ToLower(r.FirstName), strings.ToLower(r.LastName), strings.ToLower(r.Email)}
+	for _, word := range words {
+		found := false
+		for _, field := range fields {
+			if strings.HasPrefix(field, word) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//hasStatus reports whether s is one of the statuses, any status matches without statuses
+func hasStatus(s domain.Status, statuses []domain.Status) bool {
+	if len(statuses) == 0 {
+		return true
+	}
+	for _, status := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+//filterReservations returns the reservations keep accepts, joined with their room
+func (m *MemoryDBRepo) filterReservations(keep func(r models.Reservation) bool) []models.Reservation {
+	var reservations []models.Reservation
+	for _, r := range m.reservations {
+		if keep(r) {
+			reservations = append(reservations, m.withRoom(r))
+		}
+	}
+	return reservations
+}
+
+func (m *MemoryDBRepo) withRoom(r models.Reservation) models.Reservation {
+	room, _ := m.room(r.RoomID)
+	r.Room = models.Room{ID: room.ID, RoomName: room.RoomName}
+	return r
+}
+
+//CountReservationsByStatus returns the number of reservations in any of the statuses
+func (m *MemoryDBRepo) CountReservationsByStatus(ctx context.Context, statuses ...domain.Status) (int, error) {
+	if err := m.begin(ctx, "CountReservationsByStatus", statuses); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, r := range m.reservations {
+		if r.DeletedAt.IsZero() && hasStatus(r.Status, statuses) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryDBRepo) GetReservationById(ctx context.Context, id int) (models.Reservation, error) {
+	if err := m.begin(ctx, "GetReservationById", id); err != nil {
+		return models.Reservation{}, err
+	}
+	defer m.mu.Unlock()
+
+	for _, r := range m.reservations {
+		if r.ID == id && r.DeletedAt.IsZero() {
+			return m.withRoom(r), nil
+		}
+	}
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+func (m *MemoryDBRepo) UpdateReservationById(ctx context.Context, reservation models.Reservation) error {
+	if err := m.begin(ctx, "UpdateReservationById", reservation); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	for i := range m.reservations {
+		if m.reservations[i].ID == reservation.ID && m.reservations[i].DeletedAt.IsZero() {
+			m.reservations[i].FirstName = reservation.FirstName
+			m.reservations[i].LastName = reservation.LastName
+			m.reservations[i].Email = reservation.Email
+			m.reservations[i].Phone = reservation.Phone
+			m.reservations[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+//DeleteReservationById moves a reservation to the trash and releases its room
+func (m *MemoryDBRepo) DeleteReservationById(ctx context.Context, id int) error {
+	if err := m.begin(ctx, "DeleteReservationById", id); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	for i := range m.reservations {
+		if m.reservations[i].ID != id || !m.reservations[i].DeletedAt.IsZero() {
+			continue
+		}
+
+		now := time.Now()
+		m.reservations[i].DeletedAt = now
+		m.reservations[i].UpdatedAt = now
+		m.releaseRoom(id)
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+//releaseRoom removes the room restrictions of a reservation
+func (m *MemoryDBRepo) releaseRoom(id int) {
+	var kept []models.RoomRestriction
+	for _, rr := range m.roomRestrictions {
+		if rr.ResevationID != id {
+			kept = append(kept, rr)
+		}
+	}
+	m.roomRestrictions = kept
+}
+
+//RestoreReservation takes a reservation out of the trash. A reservation that stays blocks
+//its room again, unless the room was booked by someone else in the meantime.
+func (m *MemoryDBRepo) RestoreReservation(ctx context.Context, id int) error {
+	if err := m.begin(ctx, "RestoreReservation", id); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	for i := range m.reservations {
+		res := m.reservations[i]
+		if res.ID != id || res.DeletedAt.IsZero() {
+			continue
+		}
+
+		if res.Status.Stays() {
+			for _, rr := range m.roomRestrictions {
+				if rr.RoomID == res.RoomID && domain.Overlaps(res.StartDate, res.EndDate, rr.StartDate, rr.EndDate) {
+					return domain.ErrRoomUnavailable
+				}
+			}
+		}
+
+		now := time.Now()
+		m.reservations[i].DeletedAt = time.Time{}
+		m.reservations[i].UpdatedAt = now
+		if !res.Status.Stays() {
+			return nil
+		}
+		m.roomRestrictions = append(m.roomRestrictions, models.RoomRestriction{
+			ID:            m.nextID("room_restrictions"),
+			StartDate:     res.StartDate,
+			EndDate:       res.EndDate,
+			RoomID:        res.RoomID,
+			ResevationID:  id,
+			RestrictionID: 1,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+//ImportReservations inserts the reservations in their statuses and blocks the rooms of those
+//that stay, all of them or none when a room is taken on the dates of one
+func (m *MemoryDBRepo) ImportReservations(ctx context.Context, reservations []models.Reservation) ([]int, error) {
+	if err := m.begin(ctx, "ImportReservations", reservations); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	//roll back to these lengths when the import fails half way
+	inserted, blocked, guests := len(m.reservations), len(m.roomRestrictions), len(m.guests)
+
+	ids := make([]int, 0, len(reservations))
+	for i, res := range reservations {
+		now := time.Now()
+		res.ID = m.nextID("reservations")
+		res.GuestID = m.linkGuest(res)
+		res.StartDate = dateOnly(res.StartDate)
+		res.EndDate = dateOnly(res.EndDate)
+		res.Room = models.Room{}
+		res.CreatedAt = now
+		res.UpdatedAt = now
+
+		if res.Status.Stays() {
+			for _, rr := range m.roomRestrictions {
+				if rr.RoomID == res.RoomID && domain.Overlaps(res.StartDate, res.EndDate, rr.StartDate, rr.EndDate) {
+					m.reservations = m.reservations[:inserted]
+					m.roomRestrictions = m.roomRestrictions[:blocked]
+					m.guests = m.guests[:guests]
+					return nil, fmt.Errorf("reservation %d of the import: %w", i+1, domain.ErrRoomUnavailable)
+				}
+			}
+			m.roomRestrictions = append(m.roomRestrictions, models.RoomRestriction{
+				ID:            m.nextID("room_restrictions"),
+				StartDate:     res.StartDate,
+				EndDate:       res.EndDate,
+				RoomID:        res.RoomID,
+				ResevationID:  res.ID,
+				RestrictionID: 1,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			})
+		}
+		m.reservations = append(m.reservations, res)
+		ids = append(ids, res.ID)
+	}
+	return ids, nil
+}
+
+//DeletedReservations returns the reservations in the trash, the latest deleted first
+func (m *MemoryDBRepo) DeletedReservations(ctx context.Context) ([]models.Reservation, error) {
+	if err := m.begin(ctx, "DeletedReservations"); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	reservations := m.filterReservations(func(r models.Reservation) bool { return !r.DeletedAt.IsZero() })
+	sort.SliceStable(reservations, func(i, j int) bool {
+		return reservations[i].DeletedAt.After(reservations[j].DeletedAt)
+	})
+	return reservations, nil
+}
+
+//PurgeDeletedReservations permanently removes the reservations deleted before the given time,
+//with their status history and sent notifications, and returns how many it removed
+func (m *MemoryDBRepo) PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error) {
+	if err := m.begin(ctx, "PurgeDeletedReservations", before); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	purged := make(map[int]bool)
+	var kept []models.Reservation
+	for _, r := range m.reservations {
+		if !r.DeletedAt.IsZero() && r.DeletedAt.Before(before) {
+			purged[r.ID] = true
+			continue
+		}
+		kept = append(kept, r)
+	}
+	m.reservations = kept
+
+	var changes []models.StatusChange
+	for _, c := range m.statusChanges {
+		if !purged[c.ReservationID] {
+			changes = append(changes, c)
+		}
+	}
+	m.statusChanges = changes
+
+	var sent []models.SentNotification
+	for _, n := range m.sentNotifications {
+		if !purged[n.ReservationID] {
+			sent = append(sent, n)
+		}
+	}
+	m.sentNotifications = sent
+
+	return len(purged), nil
+}
+
+//UpdateReservationStatus moves a reservation to another status, when its lifecycle allows it,
+//and records the change with the user who made it. The room of a cancelled or missed stay
+//is released.
+func (m *MemoryDBRepo) UpdateReservationStatus(ctx context.Context, id int, to domain.Status, userID int) error {
+	if err := m.begin(ctx, "UpdateReservationStatus", id, to, userID); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	for i := range m.reservations {
+		if m.reservations[i].ID != id || !m.reservations[i].DeletedAt.IsZero() {
+			continue
+		}
+
+		from := m.reservations[i].Status
+		if err := domain.Transition(from, to); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		m.reservations[i].Status = to
+		m.reservations[i].UpdatedAt = now
+		if !to.Stays() {
+			m.releaseRoom(id)
+		}
+		m.statusChanges = append(m.statusChanges, models.StatusChange{
+			ID:            m.nextID("reservation_status_history"),
+			ReservationID: id,
+			From:          from,
+			To:            to,
+			UserID:        userID,
+			CreatedAt:     now,
+		})
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+//ReservationStatusHistory returns the status changes of a reservation, oldest first
+func (m *MemoryDBRepo) ReservationStatusHistory(ctx context.Context, id int) ([]models.StatusChange, error) {
+	if err := m.begin(ctx, "ReservationStatusHistory", id); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	var changes []models.StatusChange
+	for _, c := range m.statusChanges {
+		if c.ReservationID == id {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+//ReservationsByStartDate returns the reservations arriving between from and to, both included
+func (m *MemoryDBRepo) ReservationsByStartDate(ctx context.Context, from, to time.Time) ([]models.Reservation, error) {
+	if err := m.begin(ctx, "ReservationsByStartDate", from, to); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	return m.filterReservations(func(r models.Reservation) bool {
+		return !r.StartDate.Before(from) && !r.StartDate.After(to) && r.DeletedAt.IsZero()
+	}), nil
+}
+
+//ReservationsByEndDate returns the reservations departing between from and to, both included
+func (m *MemoryDBRepo) ReservationsByEndDate(ctx context.Context, from, to time.Time) ([]models.Reservation, error) {
+	if err := m.begin(ctx, "ReservationsByEndDate", from, to); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	return m.filterReservations(func(r models.Reservation) bool {
+		return !r.EndDate.Before(from) && !r.EndDate.After(to) && r.DeletedAt.IsZero()
+	}), nil
+}
+
+//ClaimNotification records that a notification of the given kind is being sent for a reservation.
+//It returns false when the notification was already claimed.
+func (m *MemoryDBRepo) ClaimNotification(ctx context.Context, reservationID int, kind string) (bool, error) {
+	if err := m.begin(ctx, "ClaimNotification", reservationID, kind); err != nil {
+		return false, err
+	}
+	defer m.mu.Unlock()
+
+	for _, sent := range m.sentNotifications {
+		if sent.ReservationID == reservationID && sent.Kind == kind {
+			return false, nil
+		}
+	}
+
+	m.sentNotifications = append(m.sentNotifications, models.SentNotification{
+		ID:            m.nextID("sent_notifications"),
+		ReservationID: reservationID,
+		Kind:          kind,
+		CreatedAt:     time.Now(),
+	})
+	return true, nil
+}
+
+//ReleaseNotification forgets a claimed notification so that it is sent again, when the email could not be sent
+func (m *MemoryDBRepo) ReleaseNotification(ctx context.Context, reservationID int, kind string) error {
+	if err := m.begin(ctx, "ReleaseNotification", reservationID, kind); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	for i, sent := range m.sentNotifications {
+		if sent.ReservationID == reservationID && sent.Kind == kind {
+			m.sentNotifications = append(m.sentNotifications[:i], m.sentNotifications[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+//InsertNotification stores a new in-app staff notification
+func (m *MemoryDBRepo) InsertNotification(ctx context.Context, n models.Notification) (int, error) {
+	if err := m.begin(ctx, "InsertNotification", n); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	n.ID = m.nextID("notifications")
+	n.Read = false
+	n.CreatedAt = time.Now()
+	m.notifications = append(m.notifications, n)
+
+	return n.ID, nil
+}
+
+//RecentNotifications returns the latest notifications with their read state for a user
+func (m *MemoryDBRepo) RecentNotifications(ctx context.Context, userID, limit int) ([]models.Notification, error) {
+	if err := m.begin(ctx, "RecentNotifications", userID, limit); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	var notifications []models.Notification
+	for _, n := range m.notifications {
+		_, n.Read = m.reads[[2]int{n.ID, userID}]
+		notifications = append(notifications, n)
+	}
+
+	sort.SliceStable(notifications, func(i, j int) bool {
+		return notifications[i].ID > notifications[j].ID
+	})
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+	return notifications, nil
+}
+
+//UnreadNotificationCount returns the number of notifications a user has not read yet
+func (m *MemoryDBRepo) UnreadNotificationCount(ctx context.Context, userID int) (int, error) {
+	if err := m.begin(ctx, "UnreadNotificationCount", userID); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, n := range m.notifications {
+		if _, read := m.reads[[2]int{n.ID, userID}]; !read {
+			count++
+		}
+	}
+	return count, nil
+}
+
+//MarkNotificationRead marks one notification as read by a user
+func (m *MemoryDBRepo) MarkNotificationRead(ctx context.Context, id, userID int) error {
+	if err := m.begin(ctx, "MarkNotificationRead", id, userID); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	for _, n := range m.notifications {
+		if n.ID == id {
+			if _, read := m.reads[[2]int{id, userID}]; !read {
+				m.reads[[2]int{id, userID}] = time.Now()
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("notification %d does not exist", id)
+}
+
+//MarkAllNotificationsRead marks every notification as read by a user
+func (m *MemoryDBRepo) MarkAllNotificationsRead(ctx context.Context, userID int) error {
+	if err := m.begin(ctx, "MarkAllNotificationsRead", userID); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	for _, n := range m.notifications {
+		if _, read := m.reads[[2]int{n.ID, userID}]; !read {
+			m.reads[[2]int{n.ID, userID}] = time.Now()
+		}
+	}
+	return nil
+}
+
+//InsertAuditEntry appends an entry to the audit log
+func (m *MemoryDBRepo) InsertAuditEntry(ctx context.Context, e models.AuditEntry) (int, error) {
+	if err := m.begin(ctx, "InsertAuditEntry", e); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	e.ID = m.nextID("audit_log")
+	e.CreatedAt = time.Now().UTC()
+	m.auditLog = append(m.auditLog, e)
+
+	return e.ID, nil
+}
+
+//AuditEntries returns the audit entries matching the filter, the latest first
+func (m *MemoryDBRepo) AuditEntries(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error) {
+	if err := m.begin(ctx, "AuditEntries", f); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	var entries []models.AuditEntry
+	for i := len(m.auditLog) - 1; i >= 0; i-- {
+		e := m.auditLog[i]
+		switch {
+		case f.UserID != 0 && e.UserID != f.UserID,
+			f.Action != "" && e.Action != f.Action,
+			f.Entity != "" && e.Entity != f.Entity,
+			f.EntityID != 0 && e.EntityID != f.EntityID,
+			!f.From.IsZero() && e.CreatedAt.Before(f.From),
+			!f.To.IsZero() && !e.CreatedAt.Before(f.To):
+			continue
+		}
+		entries = append(entries, e)
+		if f.Limit > 0 && len(entries) == f.Limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+//Occupancy returns the nights booked of every room in each period between from and to,
+//from is inclusive and to exclusive
+func (m *MemoryDBRepo) Occupancy(ctx context.Context, from, to time.Time, period string) ([]models.Occupancy, error) {
+	if err := m.begin(ctx, "Occupancy", from, to, period); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	if err := checkPeriod(from, to, period); err != nil {
+		return nil, err
+	}
+
+	rooms := append([]models.Room(nil), m.rooms...)
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].ID < rooms[j].ID
+	})
+
+	var occupancy []models.Occupancy
+	for day := dateOnly(from); day.Before(dateOnly(to)); day = day.AddDate(0, 0, 1) {
+		start := periodStart(day, period)
+		if len(occupancy) == 0 || !occupancy[len(occupancy)-1].Period.Equal(start) {
+			for _, room := range rooms {
+				occupancy = append(occupancy, models.Occupancy{RoomID: room.ID, RoomName: room.RoomName, Period: start})
+			}
+		}
+
+		counts := occupancy[len(occupancy)-len(rooms):]
+		for i := range counts {
+			counts[i].Nights++
+			if m.booked(counts[i].RoomID, day) {
+				counts[i].Booked++
+			}
+		}
+	}
+	return occupancy, nil
+}
+
+//booked reports whether a reservation not cancelled has the room on the night of day
+func (m *MemoryDBRepo) booked(roomID int, day time.Time) bool {
+	for _, r := range m.reservations {
+		if r.RoomID == roomID && r.DeletedAt.IsZero() && r.Status != domain.Cancelled &&
+			!dateOnly(r.StartDate).After(day) && dateOnly(r.EndDate).After(day) {
+			return true
+		}
+	}
+	return false
+}
+
+//BookingStats sums up the reservations arriving between from and to by period,
+//from is inclusive and to exclusive. Periods without arrivals are left out.
+func (m *MemoryDBRepo) BookingStats(ctx context.Context, from, to time.Time, period string) ([]models.BookingStats, error) {
+	if err := m.begin(ctx, "BookingStats", from, to, period); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	if err := checkPeriod(from, to, period); err != nil {
+		return nil, err
+	}
+
+	byPeriod := make(map[time.Time]*models.BookingStats)
+	for _, r := range m.reservations {
+		arrival := dateOnly(r.StartDate)
+		if !r.DeletedAt.IsZero() || arrival.Before(dateOnly(from)) || !arrival.Before(dateOnly(to)) {
+			continue
+		}
+
+		start := periodStart(arrival, period)
+		s, ok := byPeriod[start]
+		if !ok {
+			s = &models.BookingStats{Period: start}
+			byPeriod[start] = s
+		}
+		s.Reservations++
+		if r.Status == domain.Cancelled {
+			s.Cancelled++
+			continue
+		}
+		s.Nights += daysBetween(arrival, dateOnly(r.EndDate))
+		s.LeadDays += daysBetween(dateOnly(r.CreatedAt), arrival)
+	}
+
+	var stats []models.BookingStats
+	for _, s := range byPeriod {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Period.Before(stats[j].Period)
+	})
+	return stats, nil
+}
+
+func daysBetween(from, to time.Time) int {
+	return int(to.Sub(from).Hours() / 24)
+}
+
+//EachRoomRestriction calls fn with every room restriction between from and to, ordered by
+//start date and room. from is inclusive and to exclusive.
+func (m *MemoryDBRepo) EachRoomRestriction(ctx context.Context, from, to time.Time, fn func(models.RoomRestriction) error) error {
+	if err := m.begin(ctx, "EachRoomRestriction", from, to); err != nil {
+		return err
+	}
+
+	var restrictions []models.RoomRestriction
+	for _, rr := range m.roomRestrictions {
+		if rr.EndDate.Before(dateOnly(from)) || !rr.StartDate.Before(dateOnly(to)) {
+			continue
+		}
+
+		room, _ := m.room(rr.RoomID)
+		rr.Room = models.Room{ID: room.ID, RoomName: room.RoomName}
+		for _, restriction := range m.restrictions {
+			if restriction.ID == rr.RestrictionID {
+				rr.Restriction = restriction
+			}
+		}
+		rr.Reservation = models.Reservation{ID: rr.ResevationID}
+		for _, r := range m.reservations {
+			if r.ID == rr.ResevationID {
+				rr.Reservation = models.Reservation{ID: r.ID, FirstName: r.FirstName, LastName: r.LastName, Email: r.Email}
+			}
+		}
+		restrictions = append(restrictions, rr)
+	}
+	sort.SliceStable(restrictions, func(i, j int) bool {
+		a, b := restrictions[i], restrictions[j]
+		if !a.StartDate.Equal(b.StartDate) {
+			return a.StartDate.Before(b.StartDate)
+		}
+		if a.RoomID != b.RoomID {
+			return a.RoomID < b.RoomID
+		}
+		return a.ID < b.ID
+	})
+	m.mu.Unlock()
+
+	for _, rr := range restrictions {
+		if err := fn(rr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//guestWithStays returns a copy of the guest with its stays counted
+func (m *MemoryDBRepo) guestWithStays(g models.Guest) models.Guest {
+	g.Tags = append([]string{}, g.Tags...)
+	g.Stays = 0
+	for _, r := range m.reservations {
+		if r.GuestID == g.ID && r.DeletedAt.IsZero() {
+			g.Stays++
+		}
+	}
+	return g
+}
+
+//sortGuests orders guests by name like the SQL repositories
+func sortGuests(guests []models.Guest) {
+	sort.SliceStable(guests, func(i, j int) bool {
+		a, b := guests[i], guests[j]
+		if !strings.EqualFold(a.LastName, b.LastName) {
+			return strings.ToLower(a.LastName) < strings.ToLower(b.LastName)
+		}
+		if !strings.EqualFold(a.FirstName, b.FirstName) {
+			return strings.ToLower(a.FirstName) < strings.ToLower(b.FirstName)
+		}
+		return a.ID < b.ID
+	})
+}
+
+//ListGuests returns the guests matching a search by name, email or phone number,
+//every guest without a search, ordered by name and at most a page of them
+func (m *MemoryDBRepo) ListGuests(ctx context.Context, search string) ([]models.Guest, error) {
+	if err := m.begin(ctx, "ListGuests", search); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	var guests []models.Guest
+	for _, g := range m.guests {
+		matches := true
+		for _, word := range strings.Fields(strings.ToLower(search)) {
+			if !strings.HasPrefix(strings.ToLower(g.FirstName), word) && !strings.HasPrefix(strings.ToLower(g.LastName), word) &&
+				!strings.HasPrefix(domain.EmailKey(g.Email), word) && !strings.HasPrefix(domain.PhoneKey(g.Phone), word) {
+				matches = false
+			}
+		}
+		if matches {
+			guests = append(guests, m.guestWithStays(g))
+		}
+	}
+
+	sortGuests(guests)
+	if len(guests) > models.MaxPageSize {
+		guests = guests[:models.MaxPageSize]
+	}
+	return guests, nil
+}
+
+//guest returns the index of the guest with the id
+func (m *MemoryDBRepo) guest(id int) (int, bool) {
+	for i, g := range m.guests {
+		if g.ID == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+//GetGuestByID returns a guest with the number of stays
+func (m *MemoryDBRepo) GetGuestByID(ctx context.Context, id int) (models.Guest, error) {
+	if err := m.begin(ctx, "GetGuestByID", id); err != nil {
+		return models.Guest{}, err
+	}
+	defer m.mu.Unlock()
+
+	i, ok := m.guest(id)
+	if !ok {
+		return models.Guest{}, sql.ErrNoRows
+	}
+	return m.guestWithStays(m.guests[i]), nil
+}
+
+//GuestReservations returns the reservations of a guest that are not in the trash, the latest stay first
+func (m *MemoryDBRepo) GuestReservations(ctx context.Context, id int) ([]models.Reservation, error) {
+	if err := m.begin(ctx, "GuestReservations", id); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	reservations := m.filterReservations(func(r models.Reservation) bool {
+		return r.GuestID == id && r.DeletedAt.IsZero()
+	})
+	sort.SliceStable(reservations, func(i, j int) bool {
+		if !reservations[i].StartDate.Equal(reservations[j].StartDate) {
+			return reservations[i].StartDate.After(reservations[j].StartDate)
+		}
+		return reservations[i].ID > reservations[j].ID
+	})
+	return reservations, nil
+}
+
+//DuplicateGuests returns the other guests with the email, the phone number or the name of a guest
+func (m *MemoryDBRepo) DuplicateGuests(ctx context.Context, id int) ([]models.Guest, error) {
+	if err := m.begin(ctx, "DuplicateGuests", id); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	i, ok := m.guest(id)
+	if !ok {
+		return nil, nil
+	}
+	o := m.guests[i]
+
+	var guests []models.Guest
+	for _, g := range m.guests {
+		if g.ID == o.ID {
+			continue
+		}
+		sameEmail := domain.EmailKey(o.Email) != "" && domain.EmailKey(g.Email) == domain.EmailKey(o.Email)
+		samePhone := domain.PhoneKey(o.Phone) != "" && domain.PhoneKey(g.Phone) == domain.PhoneKey(o.Phone)
+		sameName := strings.EqualFold(g.FirstName, o.FirstName) && strings.EqualFold(g.LastName, o.LastName)
+		if sameEmail || samePhone || sameName {
+			guests = append(guests, m.guestWithStays(g))
+		}
+	}
+	return guests, nil
+}
+
+//UpdateGuest saves the details, notes and tags of a guest
+func (m *MemoryDBRepo) UpdateGuest(ctx context.Context, guest models.Guest) error {
+	if err := m.begin(ctx, "UpdateGuest", guest); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	i, ok := m.guest(guest.ID)
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	g := &m.guests[i]
+	g.FirstName = guest.FirstName
+	g.LastName = guest.LastName
+	g.Email = guest.Email
+	g.Phone = guest.Phone
+	g.Notes = guest.Notes
+	g.Tags = domain.ParseTags(strings.Join(guest.Tags, ","))
+	g.UpdatedAt = time.Now()
+	return nil
+}
+
+//MergeGuests moves the reservations of the guest from to the guest into, which keeps
+//the notes and tags of both, and removes the guest from
+func (m *MemoryDBRepo) MergeGuests(ctx context.Context, into, from int) error {
+	if into == from {
+		return ErrSameGuest
+	}
+	if err := m.begin(ctx, "MergeGuests", into, from); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	i, ok := m.guest(into)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	j, ok := m.guest(from)
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	merged := mergeGuest(m.guests[i], m.guests[j])
+	merged.UpdatedAt = time.Now()
+	m.guests[i] = merged
+	m.guests = append(m.guests[:j], m.guests[j+1:]...)
+
+	for k := range m.reservations {
+		if m.reservations[k].GuestID == from {
+			m.reservations[k].GuestID = into
+		}
+	}
+	for k := range m.guestAccounts {
+		if m.guestAccounts[k].GuestID == from {
+			m.guestAccounts[k].GuestID = into
+			m.guestAccounts[k].UpdatedAt = merged.UpdatedAt
+		}
+	}
+	return nil
+}
+
+//guestAccount returns the account with the details of its guest
+func (m *MemoryDBRepo) guestAccount(a models.GuestAccount) models.GuestAccount {
+	if i, ok := m.guest(a.GuestID); ok {
+		g := m.guests[i]
+		a.Guest = models.Guest{ID: g.ID, FirstName: g.FirstName, LastName: g.LastName, Email: g.Email, Phone: g.Phone}
+	}
+	return a
+}
+
+//InsertGuestAccount adds the account of a guest, linked to the guest with its email or phone
+//number like a reservation is, and returns its id. It fails with ErrAccountExists when the
+//email already has an account.
+func (m *MemoryDBRepo) InsertGuestAccount(ctx context.Context, account models.GuestAccount) (int, error) {
+	if err := m.begin(ctx, "InsertGuestAccount", account); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	account.Email = domain.EmailKey(account.Email)
+	for _, a := range m.guestAccounts {
+		if a.Email == account.Email {
+			return 0, ErrAccountExists
+		}
+	}
+
+	now := time.Now()
+	account.ID = m.nextID("guest_accounts")
+	account.GuestID = m.accountGuest(models.Reservation{
+		FirstName: account.Guest.FirstName,
+		LastName:  account.Guest.LastName,
+		Email:     account.Email,
+		Phone:     account.Guest.Phone,
+	})
+	account.Guest = models.Guest{}
+	account.VerifiedAt = time.Time{}
+	account.CreatedAt = now
+	account.UpdatedAt = now
+	m.guestAccounts = append(m.guestAccounts, account)
+	return account.ID, nil
+}
+
+//GetGuestAccount returns a guest account with the details of its guest
+func (m *MemoryDBRepo) GetGuestAccount(ctx context.Context, id int) (models.GuestAccount, error) {
+	if err := m.begin(ctx, "GetGuestAccount", id); err != nil {
+		return models.GuestAccount{}, err
+	}
+	defer m.mu.Unlock()
+
+	for _, a := range m.guestAccounts {
+		if a.ID == id {
+			return m.guestAccount(a), nil
+		}
+	}
+	return models.GuestAccount{}, sql.ErrNoRows
+}
+
+//AuthenticateGuest returns the guest account with the email when the password matches
+func (m *MemoryDBRepo) AuthenticateGuest(ctx context.Context, email, testPassword string) (models.GuestAccount, error) {
+	if err := m.begin(ctx, "AuthenticateGuest", email, testPassword); err != nil {
+		return models.GuestAccount{}, err
+	}
+	var account models.GuestAccount
+	found := false
+	for _, a := range m.guestAccounts {
+		if a.Email == domain.EmailKey(email) {
+			account, found = m.guestAccount(a), true
+		}
+	}
+	m.mu.Unlock()
+
+	if !found {
+		return models.GuestAccount{}, sql.ErrNoRows
+	}
+	if err := checkPassword(account.Password, testPassword); err != nil {
+		return models.GuestAccount{}, err
+	}
+	return account, nil
+}
+
+//RenewGuestVerification replaces the verification token of an account that is not verified yet
+func (m *MemoryDBRepo) RenewGuestVerification(ctx context.Context, id int, token string, expires time.Time) error {
+	if err := m.begin(ctx, "RenewGuestVerification", id, token, expires); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	for i := range m.guestAccounts {
+		if m.guestAccounts[i].ID == id && !m.guestAccounts[i].Verified() {
+			m.guestAccounts[i].VerifyToken = token
+			m.guestAccounts[i].VerifyExpires = expires
+			m.guestAccounts[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+//VerifyGuestAccount verifies the account with the token, unless the token expired, and returns its id
+func (m *MemoryDBRepo) VerifyGuestAccount(ctx context.Context, token string) (int, error) {
+	if err := m.begin(ctx, "VerifyGuestAccount", token); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for i, a := range m.guestAccounts {
+		if token != "" && a.VerifyToken == token && a.VerifyExpires.After(now) {
+			m.guestAccounts[i].VerifiedAt = now
+			m.guestAccounts[i].VerifyToken = ""
+			m.guestAccounts[i].UpdatedAt = now
+			return a.ID, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+//ChangeReservationDates moves a reservation that is not in the trash, and the block of its
+//room, to other dates unless the room is taken on them by another reservation or a block
+func (m *MemoryDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time) error {
+	if err := m.begin(ctx, "ChangeReservationDates", id, start, end); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	start, end = dateOnly(start), dateOnly(end)
+	for i := range m.reservations {
+		res := m.reservations[i]
+		if res.ID != id || !res.DeletedAt.IsZero() {
+			continue
+		}
+
+		for _, rr := range m.roomRestrictions {
+			if rr.RoomID == res.RoomID && rr.ResevationID != id && domain.Overlaps(start, end, rr.StartDate, rr.EndDate) {
+				return domain.ErrRoomUnavailable
+			}
+		}
+
+		now := time.Now()
+		m.reservations[i].StartDate = start
+		m.reservations[i].EndDate = end
+		m.reservations[i].UpdatedAt = now
+		for k := range m.roomRestrictions {
+			if m.roomRestrictions[k].ResevationID == id {
+				m.roomRestrictions[k].StartDate = start
+				m.roomRestrictions[k].EndDate = end
+				m.roomRestrictions[k].UpdatedAt = now
+			}
+		}
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+//InsertBooking inserts a booking with the reservations of its rooms and their room restrictions,
+//all of them or none when a room is taken on the dates of its reservation
+func (m *MemoryDBRepo) InsertBooking(ctx context.Context, reservations []models.Reservation) (int, []int, error) {
+	if err := m.begin(ctx, "InsertBooking", reservations); err != nil {
+		return 0, nil, err
+	}
+	defer m.mu.Unlock()
+
+	//roll back to these lengths when a room is taken
+	inserted, blocked, guests := len(m.reservations), len(m.roomRestrictions), len(m.guests)
+
+	now := time.Now()
+	booking := models.Booking{ID: m.nextID("bookings"), CreatedAt: now, UpdatedAt: now}
+
+	ids := make([]int, 0, len(reservations))
+	for i, res := range reservations {
+		res.ID = m.nextID("reservations")
+		res.GuestID = m.linkGuest(res)
+		res.BookingID = booking.ID
+		res.StartDate = dateOnly(res.StartDate)
+		res.EndDate = dateOnly(res.EndDate)
+		res.Room = models.Room{}
+		res.Status = domain.Pending
+		res.CreatedAt = now
+		res.UpdatedAt = now
+
+		for _, rr := range m.roomRestrictions {
+			if rr.RoomID == res.RoomID && domain.Overlaps(res.StartDate, res.EndDate, rr.StartDate, rr.EndDate) {
+				m.reservations = m.reservations[:inserted]
+				m.roomRestrictions = m.roomRestrictions[:blocked]
+				m.guests = m.guests[:guests]
+				return 0, nil, fmt.Errorf("room %d of the booking: %w", i+1, domain.ErrRoomUnavailable)
+			}
+		}
+		m.roomRestrictions = append(m.roomRestrictions, models.RoomRestriction{
+			ID:            m.nextID("room_restrictions"),
+			StartDate:     res.StartDate,
+			EndDate:       res.EndDate,
+			RoomID:        res.RoomID,
+			ResevationID:  res.ID,
+			RestrictionID: 1,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+		m.reservations = append(m.reservations, res)
+		ids = append(ids, res.ID)
+	}
+
+	m.bookings = append(m.bookings, booking)
+	return booking.ID, ids, nil
+}
+
+//GetBooking returns a booking with its reservations that are not in the trash, by arrival and room
+func (m *MemoryDBRepo) GetBooking(ctx context.Context, id int) (models.Booking, error) {
+	if err := m.begin(ctx, "GetBooking", id); err != nil {
+		return models.Booking{}, err
+	}
+	defer m.mu.Unlock()
+
+	for _, booking := range m.bookings {
+		if booking.ID != id {
+			continue
+		}
+		booking.Reservations = m.filterReservations(func(r models.Reservation) bool {
+			return r.BookingID == id && r.DeletedAt.IsZero()
+		})
+		sort.SliceStable(booking.Reservations, func(i, j int) bool {
+			a, b := booking.Reservations[i], booking.Reservations[j]
+			if !a.StartDate.Equal(b.StartDate) {
+				return a.StartDate.Before(b.StartDate)
+			}
+			if a.Room.ID != b.Room.ID {
+				return a.Room.ID < b.Room.ID
+			}
+			return a.ID < b.ID
+		})
+		return booking, nil
+	}
+	return models.Booking{}, sql.ErrNoRows
+}
+
+//CancelBooking cancels every reservation of a booking whose lifecycle still allows it, releases
+//their rooms and records the changes with the user who made them. It returns the ids of the
+//reservations it cancelled.
+func (m *MemoryDBRepo) CancelBooking(ctx context.Context, id int, userID int) ([]int, error) {
+	if err := m.begin(ctx, "CancelBooking", id, userID); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	found := false
+	for _, booking := range m.bookings {
+		if booking.ID == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+
+	now := time.Now()
+	ids := []int{}
+	for i := range m.reservations {
+		res := &m.reservations[i]
+		if res.BookingID != id || !res.DeletedAt.IsZero() || domain.Transition(res.Status, domain.Cancelled) != nil {
+			continue
+		}
+
+		m.statusChanges = append(m.statusChanges, models.StatusChange{
+			ID:            m.nextID("reservation_status_history"),
+			ReservationID: res.ID,
+			From:          res.Status,
+			To:            domain.Cancelled,
+			UserID:        userID,
+			CreatedAt:     now,
+		})
+		res.Status = domain.Cancelled
+		res.UpdatedAt = now
+		m.releaseRoom(res.ID)
+		ids = append(ids, res.ID)
+	}
+	return ids, nil
+}
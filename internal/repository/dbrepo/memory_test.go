@@ -0,0 +1,52 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ArmanurRahman/booking/internal/models"
+)
+
+func TestMemoryDBRepo_FailOn(t *testing.T) {
+	repo := NewMemoryRepo(testApp)
+	ctx := context.Background()
+
+	restore := repo.FailOn("InsertRoom", errors.New("disk full"))
+
+	if _, err := repo.InsertRoom(ctx, models.Room{RoomName: "Colonel's Cabin"}); err == nil || err.Error() != "disk full" {
+		t.Errorf("expected the injected error, got %v", err)
+	}
+	if _, err := repo.GetRoomByID(ctx, 1); err != nil {
+		t.Errorf("other methods should not fail, got %v", err)
+	}
+
+	restore()
+	if _, err := repo.InsertRoom(ctx, models.Room{RoomName: "Colonel's Cabin"}); err != nil {
+		t.Errorf("expected the call to succeed once restored, got %v", err)
+	}
+}
+
+func TestMemoryDBRepo_FailWhen(t *testing.T) {
+	repo := NewMemoryRepo(testApp)
+	ctx := context.Background()
+
+	defer repo.FailWhen("InsertReservation", func(args ...interface{}) error {
+		if args[0].(models.Reservation).RoomID == 2 {
+			return errors.New("room 2 is closed")
+		}
+		return nil
+	})()
+
+	if _, err := repo.InsertReservation(ctx, models.Reservation{RoomID: 2, StartDate: day(1), EndDate: day(2)}); err == nil {
+		t.Error("expected the reservation of room 2 to fail")
+	}
+	if _, err := repo.InsertReservation(ctx, models.Reservation{RoomID: 1, StartDate: day(1), EndDate: day(2)}); err != nil {
+		t.Errorf("expected the reservation of room 1 to succeed, got %v", err)
+	}
+
+	all, err := repo.ReservationsByStatus(ctx)
+	if err != nil || len(all) != 1 {
+		t.Errorf("a failed call should not store anything, got %d reservations (%v)", len(all), err)
+	}
+}
@@ -2,28 +2,45 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/ArmanurRahman/booking/internal/domain"
 	"github.com/ArmanurRahman/booking/internal/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *postgressDBRepo) AllUsers() bool {
+func (m *postgressDBRepo) AllUsers(ctx context.Context) bool {
 	return true
 }
 
-func (m *postgressDBRepo) InsertReservation(res models.Reservation) (int, error) {
+//InsertReservation inserts a reservation linked to its guest, the guest is added on a first stay
+func (m *postgressDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	guestID, err := m.linkGuest(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
 	var newId int
 	sql := `insert into reservations (first_name, last_name, email, phone, start_date, end_date,
-		room_id, create_at, update_at) 
-		values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id `
+		room_id, guest_id, adults, children, create_at, update_at) 
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning id `
 
-	err := m.DB.QueryRowContext(ctx, sql,
+	err = tx.QueryRowContext(ctx, sql,
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -31,6 +48,9 @@ func (m *postgressDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		guestID,
+		res.Adults,
+		res.Children,
 		time.Now(),
 		time.Now(),
 	).Scan(&newId)
@@ -38,12 +58,79 @@ func (m *postgressDBRepo) InsertReservation(res models.Reservation) (int, error)
 	if err != nil {
 		return 0, err
 	}
-	return newId, nil
+	return newId, tx.Commit()
+}
+
+//linkGuest returns the guest of a reservation made from a guest account, or the guest
+//with the email of the reservation, or the guest an earlier reservation with the email
+//was linked to, or else the guest with its phone number. The guest is added when there is none.
+func (m *postgressDBRepo) linkGuest(ctx context.Context, tx *sql.Tx, res models.Reservation) (int, error) {
+	if res.GuestID != 0 {
+		return res.GuestID, nil
+	}
+	emailKey, phoneKey := domain.EmailKey(res.Email), domain.PhoneKey(res.Phone)
+
+	var id int
+	err := tx.QueryRowContext(ctx, `select id from (
+				select id, 1 as rank from guests where email_key = $1 and $1 <> ''
+				union all
+				select guest_id, 2 from reservations where lower(email) = $1 and $1 <> '' and guest_id is not null
+				union all
+				select id, 3 from guests where phone_key = $2 and $2 <> ''
+			) matches
+			order by rank, id
+			limit 1`, emailKey, phoneKey).Scan(&id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return id, err
+	}
+	return m.addGuest(ctx, tx, res)
 }
 
-func (m *postgressDBRepo) InsetIntoRoomRestriction(res models.RoomRestriction) error {
+//accountGuest returns the guest with the email of a guest account, or adds one. An account
+//is only linked by its email, a phone number proves nothing about who signs up.
+func (m *postgressDBRepo) accountGuest(ctx context.Context, tx *sql.Tx, res models.Reservation) (int, error) {
+	var id int
+	err := tx.QueryRowContext(ctx, `select id from guests where email_key = $1 and $1 <> '' order by id limit 1`,
+		domain.EmailKey(res.Email)).Scan(&id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return id, err
+	}
+	return m.addGuest(ctx, tx, res)
+}
+
+//addGuest adds a guest with the contact details of a reservation
+func (m *postgressDBRepo) addGuest(ctx context.Context, tx *sql.Tx, res models.Reservation) (int, error) {
+	var id int
+	err := tx.QueryRowContext(ctx, `insert into guests (first_name, last_name, email, phone, email_key, phone_key,
+			create_at, update_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $7) returning id`,
+		res.FirstName, res.LastName, res.Email, res.Phone, domain.EmailKey(res.Email), domain.PhoneKey(res.Phone), time.Now(),
+	).Scan(&id)
+	return id, err
+}
+
+//lockRooms locks the rows of the rooms until the transaction ends, in id order so that two
+//transactions never wait on each other. The restrictions of a room read after its lock
+//include those of every transaction that held the lock before, so two transactions cannot
+//both find the room free and book it.
+func lockRooms(ctx context.Context, tx *sql.Tx, roomIDs ...int) error {
+	ids := append([]int(nil), roomIDs...)
+	sort.Ints(ids)
+	for i, id := range ids {
+		if i > 0 && id == ids[i-1] {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `select id from rooms where id = $1 for update`, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *postgressDBRepo) InsetIntoRoomRestriction(ctx context.Context, res models.RoomRestriction) error {
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	sql := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id,
@@ -65,14 +152,14 @@ func (m *postgressDBRepo) InsetIntoRoomRestriction(res models.RoomRestriction) e
 	return nil
 }
 
-func (m *postgressDBRepo) SearchAvailabilityByDatesByRoomId(start, end time.Time, roomId int) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgressDBRepo) SearchAvailabilityByDatesByRoomId(ctx context.Context, start, end time.Time, roomId int) (bool, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	var numRows int
 
 	sql := `select count(id) from room_restrictions 
-			where $1 <= end_date and $2 >= start_date and room_id = $3`
+			where $1 < end_date and $2 > start_date and room_id = $3`
 
 	row := m.DB.QueryRowContext(ctx, sql, start, end, roomId)
 	err := row.Scan(&numRows)
@@ -86,11 +173,11 @@ func (m *postgressDBRepo) SearchAvailabilityByDatesByRoomId(start, end time.Time
 
 }
 
-func (m *postgressDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgressDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
-	sql := `select r.id, r.room_name from rooms r where r.id not in 
+	sql := `select r.id, r.room_name, r.max_occupancy, r.beds from rooms r where r.id not in 
 	(select room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date)`
 
 	var rooms []models.Room
@@ -104,6 +191,8 @@ func (m *postgressDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([
 		err := rows.Scan(
 			&room.ID,
 			&room.RoomName,
+			&room.MaxOccupancy,
+			&room.Beds,
 		)
 
 		if err != nil {
@@ -118,17 +207,19 @@ func (m *postgressDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([
 	return rooms, nil
 }
 
-func (m *postgressDBRepo) GetRoomByID(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgressDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	var room models.Room
-	sql := `select id, room_name, create_at, update_at from rooms where id = $1`
+	sql := `select id, room_name, max_occupancy, beds, create_at, update_at from rooms where id = $1`
 	row := m.DB.QueryRowContext(ctx, sql, id)
 
 	err := row.Scan(
 		&room.ID,
 		&room.RoomName,
+		&room.MaxOccupancy,
+		&room.Beds,
 		&room.CreatedAt,
 		&room.UpdatedAt,
 	)
@@ -139,8 +230,57 @@ func (m *postgressDBRepo) GetRoomByID(id int) (models.Room, error) {
 
 }
 
-func (m *postgressDBRepo) GetUserById(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//AllRooms returns every room ordered by id
+func (m *postgressDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var rooms []models.Room
+
+	rows, err := m.DB.QueryContext(ctx, `select id, room_name, max_occupancy, beds, create_at, update_at from rooms order by id`)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var room models.Room
+		err := rows.Scan(
+			&room.ID,
+			&room.RoomName,
+			&room.MaxOccupancy,
+			&room.Beds,
+			&room.CreatedAt,
+			&room.UpdatedAt,
+		)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+	return rooms, nil
+}
+
+func (m *postgressDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var newId int
+	sql := `insert into rooms (room_name, max_occupancy, beds, create_at, update_at) values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, sql, room.RoomName, room.MaxOccupancy, room.Beds, time.Now(), time.Now()).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+	return newId, nil
+}
+
+func (m *postgressDBRepo) GetUserById(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	var user models.User
@@ -165,12 +305,12 @@ func (m *postgressDBRepo) GetUserById(id int) (models.User, error) {
 	return user, nil
 }
 
-func (m *postgressDBRepo) UpdateUserById(user models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgressDBRepo) UpdateUserById(ctx context.Context, user models.User) error {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
-	sql := `update user set first_name=$1, last_name=$2, email=$3, access_level=$4, update_at=$5
-			where id=&6
+	sql := `update users set first_name=$1, last_name=$2, email=$3, access_level=$4, update_at=$5
+			where id=$6
 	`
 	_, err := m.DB.ExecContext(ctx, sql,
 		user.FirstName,
@@ -187,8 +327,72 @@ func (m *postgressDBRepo) UpdateUserById(user models.User) error {
 	return nil
 }
 
-func (m *postgressDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgressDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var user models.User
+	sql := `select id, first_name, last_name, email, password, access_level, create_at, update_at
+			from users where email=$1`
+
+	row := m.DB.QueryRowContext(ctx, sql, email)
+
+	err := row.Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Password,
+		&user.AccessLevel,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+func (m *postgressDBRepo) InsertUser(ctx context.Context, user models.User) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var newId int
+	sql := `insert into users (first_name, last_name, email, password, access_level, create_at, update_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, sql,
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		user.Password,
+		user.AccessLevel,
+		time.Now(),
+		time.Now(),
+	).Scan(&newId)
+
+	if err != nil {
+		return 0, err
+	}
+	return newId, nil
+}
+
+func (m *postgressDBRepo) UpdateUserPassword(ctx context.Context, id int, hashedPassword string) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	sql := `update users set password=$1, update_at=$2 where id=$3`
+
+	_, err := m.DB.ExecContext(ctx, sql, hashedPassword, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *postgressDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	var id int
@@ -215,17 +419,20 @@ func (m *postgressDBRepo) Authenticate(email, testPassword string) (int, string,
 	return id, hashedPassword, nil
 }
 
-func (m *postgressDBRepo) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//ReservationsByStatus returns the reservations in any of the statuses, or every reservation without statuses
+func (m *postgressDBRepo) ReservationsByStatus(ctx context.Context, statuses ...domain.Status) ([]models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	var reservations []models.Reservation
 
-	sql := `select r.id, r.first_name, r.last_name, r.email, r.phone,	
-			r.start_date, r.end_date, r.create_at, r.update_at, r.process,
-			rm.id, rm.room_name 
-			from reservations r left join rooms rm on r.room_id=rm.id`
-	rows, err := m.DB.QueryContext(ctx, sql)
+	sql := `select r.id, r.first_name, r.last_name, r.email, r.phone,
+			r.start_date, r.end_date, r.create_at, r.update_at, r.status,
+			rm.id, rm.room_name
+			from reservations r left join rooms rm on r.room_id=rm.id
+			where r.deleted_at is null and (cardinality($1::text[]) = 0 or r.status = any($1))
+			order by r.start_date, r.id`
+	rows, err := m.DB.QueryContext(ctx, sql, statusNames(statuses))
 	if err != nil {
 		return reservations, err
 	}
@@ -242,7 +449,7 @@ func (m *postgressDBRepo) AllReservations() ([]models.Reservation, error) {
 			&reservation.EndDate,
 			&reservation.CreatedAt,
 			&reservation.UpdatedAt,
-			&reservation.Process,
+			&reservation.Status,
 			&reservation.Room.ID,
 			&reservation.Room.RoomName,
 		)
@@ -259,25 +466,77 @@ func (m *postgressDBRepo) AllReservations() ([]models.Reservation, error) {
 	}
 
 	return reservations, nil
+}
 
+//CountReservationsByStatus returns the number of reservations in any of the statuses
+func (m *postgressDBRepo) CountReservationsByStatus(ctx context.Context, statuses ...domain.Status) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var count int
+	sql := `select count(id) from reservations
+			where deleted_at is null and (cardinality($1::text[]) = 0 or status = any($1))`
+
+	err := m.DB.QueryRowContext(ctx, sql, statusNames(statuses)).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
 }
 
-func (m *postgressDBRepo) NewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//ListReservations returns the page of the reservations the query selects
+func (m *postgressDBRepo) ListReservations(ctx context.Context, q models.ReservationQuery) (models.ReservationPage, error) {
+	q, err := normalizeQuery(q)
+	if err != nil {
+		return models.ReservationPage{}, err
+	}
+	clauses, args, err := reservationListClauses(q, postgresPlaceholder)
+	if err != nil {
+		return models.ReservationPage{}, err
+	}
+
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	var reservations []models.Reservation
+	err = m.eachReservation(ctx, clauses, args, func(r models.Reservation) error {
+		reservations = append(reservations, r)
+		return nil
+	})
+	if err != nil {
+		return models.ReservationPage{}, err
+	}
+	return newReservationPage(q, reservations), nil
+}
 
-	sql := `select r.id, r.first_name, r.last_name, r.email, r.phone,	
-			r.start_date, r.end_date, r.create_at, r.update_at, r.process,
-			rm.id, rm.room_name 
+//EachReservation calls fn with every reservation the query selects in its order, as they are
+//read. The page of the query is ignored, and the first error of fn stops the reading.
+func (m *postgressDBRepo) EachReservation(ctx context.Context, q models.ReservationQuery, fn func(models.Reservation) error) error {
+	q, err := normalizeQuery(q)
+	if err != nil {
+		return err
+	}
+	clauses, args := reservationExportClauses(q, postgresPlaceholder)
+
+	ctx, cancel := m.exportContext(ctx)
+	defer cancel()
+
+	return m.eachReservation(ctx, clauses, args, fn)
+}
+
+//eachReservation reads the reservations joined with their room the clauses select
+func (m *postgressDBRepo) eachReservation(ctx context.Context, clauses string, args []interface{}, fn func(models.Reservation) error) error {
+	sql := `select r.id, r.first_name, r.last_name, r.email, r.phone,
+			r.start_date, r.end_date, r.create_at, r.update_at, r.status, coalesce(r.guest_id, 0),
+			coalesce(r.booking_id, 0), r.adults, r.children, rm.id, rm.room_name
 			from reservations r left join rooms rm on r.room_id=rm.id
-			where process=0`
-	rows, err := m.DB.QueryContext(ctx, sql)
+			` + clauses
+	rows, err := m.DB.QueryContext(ctx, sql, args...)
 	if err != nil {
-		return reservations, err
+		return err
 	}
 	defer rows.Close()
+
 	for rows.Next() {
 		var reservation models.Reservation
 		err := rows.Scan(
@@ -290,37 +549,88 @@ func (m *postgressDBRepo) NewReservations() ([]models.Reservation, error) {
 			&reservation.EndDate,
 			&reservation.CreatedAt,
 			&reservation.UpdatedAt,
-			&reservation.Process,
+			&reservation.Status,
+			&reservation.GuestID,
+			&reservation.BookingID,
+			&reservation.Adults,
+			&reservation.Children,
 			&reservation.Room.ID,
 			&reservation.Room.RoomName,
 		)
-
 		if err != nil {
-			return reservations, err
+			return err
 		}
 
-		reservations = append(reservations, reservation)
+		if err := fn(reservation); err != nil {
+			return err
+		}
 	}
 
-	if err = rows.Err(); err != nil {
-		return reservations, err
+	return rows.Err()
+}
+
+//EachRoomRestriction calls fn with every room restriction between from and to as they are read,
+//ordered by start date and room. from is inclusive and to exclusive.
+func (m *postgressDBRepo) EachRoomRestriction(ctx context.Context, from, to time.Time, fn func(models.RoomRestriction) error) error {
+	ctx, cancel := m.exportContext(ctx)
+	defer cancel()
+
+	sql := `select rr.id, rr.start_date, rr.end_date, rr.room_id, coalesce(rr.reservation_id, 0), rr.restriction_id,
+			rm.room_name, rs.restriction_name,
+			coalesce(r.first_name, ''), coalesce(r.last_name, ''), coalesce(r.email, '')
+			from room_restrictions rr
+			join rooms rm on rm.id = rr.room_id
+			join restrictions rs on rs.id = rr.restriction_id
+			left join reservations r on r.id = rr.reservation_id
+			where rr.end_date >= $1 and rr.start_date < $2
+			order by rr.start_date, rr.room_id, rr.id`
+	rows, err := m.DB.QueryContext(ctx, sql, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return err
 	}
+	defer rows.Close()
 
-	return reservations, nil
+	for rows.Next() {
+		var rr models.RoomRestriction
+		err := rows.Scan(
+			&rr.ID,
+			&rr.StartDate,
+			&rr.EndDate,
+			&rr.RoomID,
+			&rr.ResevationID,
+			&rr.RestrictionID,
+			&rr.Room.RoomName,
+			&rr.Restriction.RestrictionName,
+			&rr.Reservation.FirstName,
+			&rr.Reservation.LastName,
+			&rr.Reservation.Email,
+		)
+		if err != nil {
+			return err
+		}
+		rr.Room.ID = rr.RoomID
+		rr.Restriction.ID = rr.RestrictionID
+		rr.Reservation.ID = rr.ResevationID
+
+		if err := fn(rr); err != nil {
+			return err
+		}
+	}
 
+	return rows.Err()
 }
 
-func (m *postgressDBRepo) GetReservationById(id int) (models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgressDBRepo) GetReservationById(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	var reservation models.Reservation
 
 	sql := `select r.id, r.first_name, r.last_name, r.email, r.phone,	
-		r.start_date, r.end_date, r.create_at, r.update_at, r.process,
-		rm.id, rm.room_name 
+		r.start_date, r.end_date, r.create_at, r.update_at, r.status, coalesce(r.guest_id, 0),
+		coalesce(r.booking_id, 0), r.adults, r.children, rm.id, rm.room_name 
 		from reservations r left join rooms rm on r.room_id=rm.id
-		where r.id = $1`
+		where r.id = $1 and r.deleted_at is null`
 
 	row := m.DB.QueryRowContext(ctx, sql, id)
 
@@ -334,7 +644,11 @@ func (m *postgressDBRepo) GetReservationById(id int) (models.Reservation, error)
 		&reservation.EndDate,
 		&reservation.CreatedAt,
 		&reservation.UpdatedAt,
-		&reservation.Process,
+		&reservation.Status,
+		&reservation.GuestID,
+		&reservation.BookingID,
+		&reservation.Adults,
+		&reservation.Children,
 		&reservation.Room.ID,
 		&reservation.Room.RoomName,
 	)
@@ -345,12 +659,12 @@ func (m *postgressDBRepo) GetReservationById(id int) (models.Reservation, error)
 	return reservation, nil
 }
 
-func (m *postgressDBRepo) UpdateReservationById(reservation models.Reservation) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgressDBRepo) UpdateReservationById(ctx context.Context, reservation models.Reservation) error {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	sql := `update reservations set first_name=$1, last_name=$2, email=$3, phone=$4, update_at=$5
-			where id=$6
+			where id=$6 and deleted_at is null
 	`
 	_, err := m.DB.ExecContext(ctx, sql,
 		reservation.FirstName,
@@ -367,32 +681,1157 @@ func (m *postgressDBRepo) UpdateReservationById(reservation models.Reservation)
 	return nil
 }
 
-func (m *postgressDBRepo) DeleteReservationById(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//DeleteReservationById moves a reservation to the trash and releases its room
+func (m *postgressDBRepo) DeleteReservationById(ctx context.Context, id int) error {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
-	sql := `delete from reservations 
-			where id=$1
-	`
-	_, err := m.DB.ExecContext(ctx, sql, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	result, err := tx.ExecContext(ctx, `update reservations set deleted_at=$1, update_at=$1
+			where id=$2 and deleted_at is null`, time.Now(), id)
 	if err != nil {
 		return err
 	}
-	return nil
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id=$1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (m *postgressDBRepo) UpdateProcessedForReservation(process, id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//RestoreReservation takes a reservation out of the trash. A reservation that stays blocks
+//its room again, unless the room was booked by someone else in the meantime.
+func (m *postgressDBRepo) RestoreReservation(ctx context.Context, id int) error {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
-	sql := `update reservations set process=$1 
-			where id=$2
-	`
-	_, err := m.DB.ExecContext(ctx, sql, process, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var res models.Reservation
+	err = tx.QueryRowContext(ctx, `select room_id, start_date, end_date, status from reservations
+			where id=$1 and deleted_at is not null for update`, id).Scan(
+		&res.RoomID,
+		&res.StartDate,
+		&res.EndDate,
+		&res.Status,
+	)
+	if err != nil {
+		return err
+	}
+
+	if res.Status.Stays() {
+		err = lockRooms(ctx, tx, res.RoomID)
+		if err != nil {
+			return err
+		}
 
+		var taken int
+		err = tx.QueryRowContext(ctx, `select count(id) from room_restrictions
+				where $1 < end_date and $2 > start_date and room_id = $3`,
+			res.StartDate, res.EndDate, res.RoomID).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			return domain.ErrRoomUnavailable
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set deleted_at=null, update_at=$1 where id=$2`, time.Now(), id)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	if res.Status.Stays() {
+		sql := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id,
+				create_at, update_at)
+				values ($1, $2, $3, $4, 1, $5, $5)`
+		_, err = tx.ExecContext(ctx, sql, res.StartDate, res.EndDate, res.RoomID, id, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//ImportReservations inserts the reservations in their statuses in one transaction and blocks
+//the rooms of those that stay. Either every reservation is inserted or none is, and none is
+//when a room is taken on the dates of one. It runs as long as an export may.
+func (m *postgressDBRepo) ImportReservations(ctx context.Context, reservations []models.Reservation) ([]int, error) {
+	ctx, cancel := m.exportContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var rooms []int
+	for _, res := range reservations {
+		if res.Status.Stays() {
+			rooms = append(rooms, res.RoomID)
+		}
+	}
+	err = lockRooms(ctx, tx, rooms...)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(reservations))
+	for i, res := range reservations {
+		now := time.Now()
+		if res.Status.Stays() {
+			var taken int
+			err = tx.QueryRowContext(ctx, `select count(id) from room_restrictions
+					where $1 < end_date and $2 > start_date and room_id = $3`,
+				res.StartDate, res.EndDate, res.RoomID).Scan(&taken)
+			if err != nil {
+				return nil, err
+			}
+			if taken > 0 {
+				return nil, fmt.Errorf("reservation %d of the import: %w", i+1, domain.ErrRoomUnavailable)
+			}
+		}
+
+		guestID, err := m.linkGuest(ctx, tx, res)
+		if err != nil {
+			return nil, err
+		}
+
+		var id int
+		err = tx.QueryRowContext(ctx, `insert into reservations (first_name, last_name, email, phone, start_date, end_date,
+				room_id, guest_id, adults, children, status, create_at, update_at)
+				values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $12) returning id`,
+			res.FirstName, res.LastName, res.Email, res.Phone, res.StartDate, res.EndDate, res.RoomID, guestID, res.Adults, res.Children,
+			string(res.Status), now,
+		).Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.Status.Stays() {
+			_, err = tx.ExecContext(ctx, `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id,
+					create_at, update_at)
+					values ($1, $2, $3, $4, 1, $5, $5)`, res.StartDate, res.EndDate, res.RoomID, id, now)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, tx.Commit()
+}
+
+//DeletedReservations returns the reservations in the trash, the latest deleted first
+func (m *postgressDBRepo) DeletedReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	sql := `select r.id, r.first_name, r.last_name, r.email, r.phone,
+			r.start_date, r.end_date, r.create_at, r.update_at, r.status, r.deleted_at,
+			rm.id, rm.room_name
+			from reservations r left join rooms rm on r.room_id=rm.id
+			where r.deleted_at is not null
+			order by r.deleted_at desc, r.id`
+	rows, err := m.DB.QueryContext(ctx, sql)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reservation models.Reservation
+		err := rows.Scan(
+			&reservation.ID,
+			&reservation.FirstName,
+			&reservation.LastName,
+			&reservation.Email,
+			&reservation.Phone,
+			&reservation.StartDate,
+			&reservation.EndDate,
+			&reservation.CreatedAt,
+			&reservation.UpdatedAt,
+			&reservation.Status,
+			&reservation.DeletedAt,
+			&reservation.Room.ID,
+			&reservation.Room.RoomName,
+		)
+
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, reservation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+//PurgeDeletedReservations permanently removes the reservations deleted before the given time,
+//with their status history and sent notifications, and returns how many it removed
+func (m *postgressDBRepo) PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	purged := `select id from reservations where deleted_at < $1`
+	for _, table := range []string{"reservation_status_history", "sent_notifications"} {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`delete from %s where reservation_id in (%s)`, table, purged), before)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `delete from reservations where deleted_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), tx.Commit()
+}
+
+//UpdateReservationStatus moves a reservation to another status, when its lifecycle allows it,
+//and records the change with the user who made it. The room of a cancelled or missed stay
+//is released.
+func (m *postgressDBRepo) UpdateReservationStatus(ctx context.Context, id int, to domain.Status, userID int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	//the row stays locked until the commit, so two staff members cannot both move it
+	var from domain.Status
+	err = tx.QueryRowContext(ctx, `select status from reservations where id=$1 and deleted_at is null for update`, id).Scan(&from)
+	if err != nil {
+		return err
+	}
+
+	err = domain.Transition(from, to)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set status=$1, update_at=$2 where id=$3`, string(to), time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	if !to.Stays() {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id=$1`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	sql := `insert into reservation_status_history (reservation_id, from_status, to_status, user_id, create_at)
+			values ($1, $2, $3, $4, $5)`
+	_, err = tx.ExecContext(ctx, sql, id, string(from), string(to), userID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//ReservationStatusHistory returns the status changes of a reservation, oldest first
+func (m *postgressDBRepo) ReservationStatusHistory(ctx context.Context, id int) ([]models.StatusChange, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var changes []models.StatusChange
+
+	sql := `select id, reservation_id, from_status, to_status, user_id, create_at
+			from reservation_status_history
+			where reservation_id = $1
+			order by create_at, id`
+	rows, err := m.DB.QueryContext(ctx, sql, id)
+	if err != nil {
+		return changes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.StatusChange
+		err := rows.Scan(
+			&c.ID,
+			&c.ReservationID,
+			&c.From,
+			&c.To,
+			&c.UserID,
+			&c.CreatedAt,
+		)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+//ReservationsByStartDate returns the reservations arriving between from and to, both included
+func (m *postgressDBRepo) ReservationsByStartDate(ctx context.Context, from, to time.Time) ([]models.Reservation, error) {
+	return m.reservationsByDate(ctx, "start_date", from, to)
+}
+
+//ReservationsByEndDate returns the reservations departing between from and to, both included
+func (m *postgressDBRepo) ReservationsByEndDate(ctx context.Context, from, to time.Time) ([]models.Reservation, error) {
+	return m.reservationsByDate(ctx, "end_date", from, to)
+}
+
+func (m *postgressDBRepo) reservationsByDate(ctx context.Context, column string, from, to time.Time) ([]models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	sql := fmt.Sprintf(`select r.id, r.first_name, r.last_name, r.email, r.phone,	
+			r.start_date, r.end_date, r.create_at, r.update_at, r.status,
+			rm.id, rm.room_name 
+			from reservations r left join rooms rm on r.room_id=rm.id
+			where r.%s between $1 and $2 and r.deleted_at is null`, column)
+	rows, err := m.DB.QueryContext(ctx, sql, from, to)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reservation models.Reservation
+		err := rows.Scan(
+			&reservation.ID,
+			&reservation.FirstName,
+			&reservation.LastName,
+			&reservation.Email,
+			&reservation.Phone,
+			&reservation.StartDate,
+			&reservation.EndDate,
+			&reservation.CreatedAt,
+			&reservation.UpdatedAt,
+			&reservation.Status,
+			&reservation.Room.ID,
+			&reservation.Room.RoomName,
+		)
+
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, reservation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+//ClaimNotification records that a notification of the given kind is being sent for a reservation.
+//It returns false when the notification was already claimed, by this or by another instance.
+func (m *postgressDBRepo) ClaimNotification(ctx context.Context, reservationID int, kind string) (bool, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	sql := `insert into sent_notifications (reservation_id, kind, create_at)
+			values ($1, $2, $3)
+			on conflict (reservation_id, kind) do nothing`
+
+	result, err := m.DB.ExecContext(ctx, sql, reservationID, kind, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+//ReleaseNotification forgets a claimed notification so that it is sent again, when the email could not be sent
+func (m *postgressDBRepo) ReleaseNotification(ctx context.Context, reservationID int, kind string) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from sent_notifications where reservation_id = $1 and kind = $2`, reservationID, kind)
+	return err
+}
+
+//InsertNotification stores a new in-app staff notification
+func (m *postgressDBRepo) InsertNotification(ctx context.Context, n models.Notification) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var newId int
+	sql := `insert into notifications (title, body, link, create_at, update_at)
+			values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, sql,
+		n.Title,
+		n.Body,
+		n.Link,
+		time.Now(),
+		time.Now(),
+	).Scan(&newId)
+
+	if err != nil {
+		return 0, err
+	}
+	return newId, nil
+}
+
+//RecentNotifications returns the latest notifications with their read state for a user
+func (m *postgressDBRepo) RecentNotifications(ctx context.Context, userID, limit int) ([]models.Notification, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var notifications []models.Notification
+
+	sql := `select n.id, n.title, n.body, n.link, n.create_at, nr.user_id is not null
+			from notifications n
+			left join notification_reads nr on nr.notification_id = n.id and nr.user_id = $1
+			order by n.create_at desc
+			limit $2`
+	rows, err := m.DB.QueryContext(ctx, sql, userID, limit)
+	if err != nil {
+		return notifications, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n models.Notification
+		err := rows.Scan(
+			&n.ID,
+			&n.Title,
+			&n.Body,
+			&n.Link,
+			&n.CreatedAt,
+			&n.Read,
+		)
+		if err != nil {
+			return notifications, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return notifications, err
+	}
+	return notifications, nil
+}
+
+//UnreadNotificationCount returns the number of notifications a user has not read yet
+func (m *postgressDBRepo) UnreadNotificationCount(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var count int
+	sql := `select count(n.id) from notifications n
+			where not exists (select 1 from notification_reads nr
+				where nr.notification_id = n.id and nr.user_id = $1)`
+
+	err := m.DB.QueryRowContext(ctx, sql, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+//MarkNotificationRead marks one notification as read by a user
+func (m *postgressDBRepo) MarkNotificationRead(ctx context.Context, id, userID int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	sql := `insert into notification_reads (notification_id, user_id, create_at)
+			values ($1, $2, $3)
+			on conflict (notification_id, user_id) do nothing`
+
+	_, err := m.DB.ExecContext(ctx, sql, id, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//MarkAllNotificationsRead marks every notification as read by a user
+func (m *postgressDBRepo) MarkAllNotificationsRead(ctx context.Context, userID int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	sql := `insert into notification_reads (notification_id, user_id, create_at)
+			select id, $1, $2 from notifications
+			on conflict (notification_id, user_id) do nothing`
+
+	_, err := m.DB.ExecContext(ctx, sql, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//InsertAuditEntry appends an entry to the audit log
+func (m *postgressDBRepo) InsertAuditEntry(ctx context.Context, e models.AuditEntry) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var newId int
+	sql := `insert into audit_log (user_id, action, entity, entity_id, changes, ip, actor, create_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, sql,
+		e.UserID,
+		e.Action,
+		e.Entity,
+		e.EntityID,
+		e.Changes,
+		e.IP,
+		e.Actor,
+		time.Now().UTC(),
+	).Scan(&newId)
+
+	if err != nil {
+		return 0, err
+	}
+	return newId, nil
+}
+
+//AuditEntries returns the audit entries matching the filter, the latest first
+func (m *postgressDBRepo) AuditEntries(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var entries []models.AuditEntry
+
+	where, args := auditConditions(f, postgresPlaceholder)
+	sql := `select id, user_id, action, entity, entity_id, changes, ip, actor, create_at
+			from audit_log
+			where ` + where + `
+			order by create_at desc, id desc`
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		sql += fmt.Sprintf(" limit $%d", len(args))
+	}
+
+	rows, err := m.DB.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEntry
+		err := rows.Scan(
+			&e.ID,
+			&e.UserID,
+			&e.Action,
+			&e.Entity,
+			&e.EntityID,
+			&e.Changes,
+			&e.IP,
+			&e.Actor,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+	return entries, nil
+}
+
+//Occupancy returns the nights booked of every room in each period between from and to,
+//from is inclusive and to exclusive
+func (m *postgressDBRepo) Occupancy(ctx context.Context, from, to time.Time, period string) ([]models.Occupancy, error) {
+	if err := checkPeriod(from, to, period); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var occupancy []models.Occupancy
+
+	sql := `select rm.id, rm.room_name, date_trunc($3, d.day)::date,
+			count(*),
+			count(*) filter (where exists (
+				select 1 from reservations r
+				where r.room_id = rm.id and r.deleted_at is null and r.status <> $4
+				and r.start_date <= d.day and r.end_date > d.day))
+			from rooms rm
+			cross join generate_series($1::date, $2::date - 1, interval '1 day') as d(day)
+			group by rm.id, rm.room_name, 3
+			order by 3, rm.id`
+	rows, err := m.DB.QueryContext(ctx, sql, from.Format("2006-01-02"), to.Format("2006-01-02"), period, string(domain.Cancelled))
+	if err != nil {
+		return occupancy, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o models.Occupancy
+		err := rows.Scan(
+			&o.RoomID,
+			&o.RoomName,
+			&o.Period,
+			&o.Nights,
+			&o.Booked,
+		)
+		if err != nil {
+			return occupancy, err
+		}
+		occupancy = append(occupancy, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return occupancy, err
+	}
+	return occupancy, nil
+}
+
+//BookingStats sums up the reservations arriving between from and to by period,
+//from is inclusive and to exclusive. Periods without arrivals are left out.
+func (m *postgressDBRepo) BookingStats(ctx context.Context, from, to time.Time, period string) ([]models.BookingStats, error) {
+	if err := checkPeriod(from, to, period); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var stats []models.BookingStats
+
+	sql := `select date_trunc($3, r.start_date::timestamp)::date,
+			count(*),
+			count(*) filter (where r.status = $4),
+			coalesce(sum(r.end_date - r.start_date) filter (where r.status <> $4), 0),
+			coalesce(sum(r.start_date - r.create_at::date) filter (where r.status <> $4), 0)
+			from reservations r
+			where r.deleted_at is null and r.start_date >= $1 and r.start_date < $2
+			group by 1
+			order by 1`
+	rows, err := m.DB.QueryContext(ctx, sql, from.Format("2006-01-02"), to.Format("2006-01-02"), period, string(domain.Cancelled))
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.BookingStats
+		err := rows.Scan(
+			&s.Period,
+			&s.Reservations,
+			&s.Cancelled,
+			&s.Nights,
+			&s.LeadDays,
+		)
+		if err != nil {
+			return stats, err
+		}
+		stats = append(stats, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+//ListGuests returns the guests matching a search by name, email or phone number,
+//every guest without a search, ordered by name and at most a page of them
+func (m *postgressDBRepo) ListGuests(ctx context.Context, search string) ([]models.Guest, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	c := guestConditions(search, postgresPlaceholder)
+	sql := fmt.Sprintf(`select %s %s %s limit %d`, guestColumns, c.where(), guestOrder, models.MaxPageSize)
+	return m.guests(ctx, sql, c.args...)
+}
+
+//guests reads the guests a query of guestColumns selects
+func (m *postgressDBRepo) guests(ctx context.Context, sql string, args ...interface{}) ([]models.Guest, error) {
+	rows, err := m.DB.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var guests []models.Guest
+	for rows.Next() {
+		g, err := scanGuest(rows)
+		if err != nil {
+			return nil, err
+		}
+		guests = append(guests, g)
+	}
+	return guests, rows.Err()
+}
+
+//GetGuestByID returns a guest with the number of stays
+func (m *postgressDBRepo) GetGuestByID(ctx context.Context, id int) (models.Guest, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	return scanGuest(m.DB.QueryRowContext(ctx, `select `+guestColumns+` where g.id = $1`, id))
+}
+
+//GuestReservations returns the reservations of a guest that are not in the trash, the latest stay first
+func (m *postgressDBRepo) GuestReservations(ctx context.Context, id int) ([]models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var reservations []models.Reservation
+	err := m.eachReservation(ctx, `where r.guest_id = $1 and r.deleted_at is null order by r.start_date desc, r.id desc`,
+		[]interface{}{id}, func(r models.Reservation) error {
+			reservations = append(reservations, r)
+			return nil
+		})
+	return reservations, err
+}
+
+//DuplicateGuests returns the other guests with the email, the phone number or the name of a guest
+func (m *postgressDBRepo) DuplicateGuests(ctx context.Context, id int) ([]models.Guest, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	sql := `select ` + guestColumns + ` join guests o on o.id = $1 where ` + guestDuplicates + ` order by g.id`
+	return m.guests(ctx, sql, id)
+}
+
+//UpdateGuest saves the details, notes and tags of a guest
+func (m *postgressDBRepo) UpdateGuest(ctx context.Context, guest models.Guest) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `update guests set first_name=$1, last_name=$2, email=$3, phone=$4,
+			email_key=$5, phone_key=$6, notes=$7, tags=$8, update_at=$9
+			where id=$10`,
+		guest.FirstName,
+		guest.LastName,
+		guest.Email,
+		guest.Phone,
+		domain.EmailKey(guest.Email),
+		domain.PhoneKey(guest.Phone),
+		guest.Notes,
+		strings.Join(domain.ParseTags(strings.Join(guest.Tags, ",")), ","),
+		time.Now(),
+		guest.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//MergeGuests moves the reservations of the guest from to the guest into, which keeps
+//the notes and tags of both, and removes the guest from
+func (m *postgressDBRepo) MergeGuests(ctx context.Context, into, from int) error {
+	if into == from {
+		return ErrSameGuest
+	}
+
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	//both rows stay locked until the commit, so the guests cannot change half way through
+	var guests [2]models.Guest
+	for i, id := range []int{into, from} {
+		guests[i], err = scanGuest(tx.QueryRowContext(ctx, `select g.id, g.first_name, g.last_name, g.email, g.phone,
+				g.notes, g.tags, g.create_at, g.update_at, 0
+				from guests g where g.id = $1 for update`, id))
+		if err != nil {
+			return err
+		}
+	}
+	merged := mergeGuest(guests[0], guests[1])
+
+	_, err = tx.ExecContext(ctx, `update reservations set guest_id=$1 where guest_id=$2`, into, from)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update guest_accounts set guest_id=$1, update_at=$2 where guest_id=$3`, into, time.Now(), from)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update guests set email=$1, phone=$2, email_key=$3, phone_key=$4, notes=$5, tags=$6,
+			update_at=$7 where id=$8`,
+		merged.Email, merged.Phone, domain.EmailKey(merged.Email), domain.PhoneKey(merged.Phone),
+		merged.Notes, strings.Join(merged.Tags, ","), time.Now(), into)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from guests where id=$1`, from)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//InsertGuestAccount adds the account of a guest, linked to the guest with its email or phone
+//number like a reservation is, and returns its id. It fails with ErrAccountExists when the
+//email already has an account.
+func (m *postgressDBRepo) InsertGuestAccount(ctx context.Context, account models.GuestAccount) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	email := domain.EmailKey(account.Email)
+	var taken int
+	err = tx.QueryRowContext(ctx, `select count(id) from guest_accounts where email=$1`, email).Scan(&taken)
+	if err != nil {
+		return 0, err
+	}
+	if taken > 0 {
+		return 0, ErrAccountExists
+	}
+
+	guestID, err := m.accountGuest(ctx, tx, models.Reservation{
+		FirstName: account.Guest.FirstName,
+		LastName:  account.Guest.LastName,
+		Email:     account.Email,
+		Phone:     account.Guest.Phone,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = tx.QueryRowContext(ctx, `insert into guest_accounts (guest_id, email, password, verify_token, verify_expires,
+			create_at, update_at)
+			values ($1, $2, $3, $4, $5, $6, $6) returning id`,
+		guestID, email, account.Password, account.VerifyToken, account.VerifyExpires.UTC(), time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+//GetGuestAccount returns a guest account with the details of its guest
+func (m *postgressDBRepo) GetGuestAccount(ctx context.Context, id int) (models.GuestAccount, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	return scanGuestAccount(m.DB.QueryRowContext(ctx, `select `+guestAccountColumns+` where a.id = $1`, id))
+}
+
+//AuthenticateGuest returns the guest account with the email when the password matches
+func (m *postgressDBRepo) AuthenticateGuest(ctx context.Context, email, testPassword string) (models.GuestAccount, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	account, err := scanGuestAccount(m.DB.QueryRowContext(ctx, `select `+guestAccountColumns+` where a.email = $1`,
+		domain.EmailKey(email)))
+	if err != nil {
+		return models.GuestAccount{}, err
+	}
+
+	err = checkPassword(account.Password, testPassword)
+	if err != nil {
+		return models.GuestAccount{}, err
+	}
+	return account, nil
+}
+
+//RenewGuestVerification replaces the verification token of an account that is not verified yet
+func (m *postgressDBRepo) RenewGuestVerification(ctx context.Context, id int, token string, expires time.Time) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `update guest_accounts set verify_token=$1, verify_expires=$2, update_at=$3
+			where id=$4 and verified_at is null`, token, expires.UTC(), time.Now(), id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//VerifyGuestAccount verifies the account with the token, unless the token expired, and returns its id
+func (m *postgressDBRepo) VerifyGuestAccount(ctx context.Context, token string) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, `update guest_accounts set verified_at=$1, verify_token='', update_at=$1
+			where verify_token=$2 and $2 <> '' and verify_expires > $1
+			returning id`, time.Now().UTC(), token).Scan(&id)
+	return id, err
+}
+
+//ChangeReservationDates moves a reservation that is not in the trash, and the block of its
+//room, to other dates unless the room is taken on them by another reservation or a block
+func (m *postgressDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select room_id from reservations where id=$1 and deleted_at is null for update`, id).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+	err = lockRooms(ctx, tx, roomID)
+	if err != nil {
+		return err
+	}
+
+	var taken int
+	err = tx.QueryRowContext(ctx, `select count(id) from room_restrictions
+			where $1 < end_date and $2 > start_date and room_id = $3 and reservation_id <> $4`,
+		start, end, roomID, id).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return domain.ErrRoomUnavailable
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set start_date=$1, end_date=$2, update_at=$3 where id=$4`,
+		start, end, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update room_restrictions set start_date=$1, end_date=$2, update_at=$3
+			where reservation_id=$4`, start, end, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//InsertBooking inserts a booking with the reservations of its rooms and their room restrictions,
+//all in one transaction. Either every reservation is inserted or none is, and none is when a
+//room is taken on the dates of its reservation, by another stay, a block or an earlier room
+//of the booking.
+func (m *postgressDBRepo) InsertBooking(ctx context.Context, reservations []models.Reservation) (int, []int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	rooms := make([]int, len(reservations))
+	for i, res := range reservations {
+		rooms[i] = res.RoomID
+	}
+	err = lockRooms(ctx, tx, rooms...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	now := time.Now()
+	var bookingID int
+	err = tx.QueryRowContext(ctx, `insert into bookings (create_at, update_at) values ($1, $1) returning id`, now).Scan(&bookingID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ids := make([]int, 0, len(reservations))
+	for i, res := range reservations {
+		var taken int
+		err = tx.QueryRowContext(ctx, `select count(id) from room_restrictions
+				where $1 < end_date and $2 > start_date and room_id = $3`,
+			res.StartDate, res.EndDate, res.RoomID).Scan(&taken)
+		if err != nil {
+			return 0, nil, err
+		}
+		if taken > 0 {
+			return 0, nil, fmt.Errorf("room %d of the booking: %w", i+1, domain.ErrRoomUnavailable)
+		}
+
+		guestID, err := m.linkGuest(ctx, tx, res)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		var id int
+		err = tx.QueryRowContext(ctx, `insert into reservations (first_name, last_name, email, phone, start_date, end_date,
+				room_id, guest_id, booking_id, adults, children, create_at, update_at)
+				values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $12) returning id`,
+			res.FirstName, res.LastName, res.Email, res.Phone, res.StartDate, res.EndDate, res.RoomID, guestID, bookingID,
+			res.Adults, res.Children, now,
+		).Scan(&id)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id,
+				create_at, update_at)
+				values ($1, $2, $3, $4, 1, $5, $5)`, res.StartDate, res.EndDate, res.RoomID, id, now)
+		if err != nil {
+			return 0, nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return bookingID, ids, tx.Commit()
+}
+
+//GetBooking returns a booking with its reservations that are not in the trash, by arrival and room
+func (m *postgressDBRepo) GetBooking(ctx context.Context, id int) (models.Booking, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var booking models.Booking
+	err := m.DB.QueryRowContext(ctx, `select id, create_at, update_at from bookings where id = $1`, id).
+		Scan(&booking.ID, &booking.CreatedAt, &booking.UpdatedAt)
+	if err != nil {
+		return booking, err
+	}
+
+	err = m.eachReservation(ctx, `where r.booking_id = $1 and r.deleted_at is null order by r.start_date, r.room_id, r.id`,
+		[]interface{}{id}, func(r models.Reservation) error {
+			booking.Reservations = append(booking.Reservations, r)
+			return nil
+		})
+	return booking, err
+}
+
+//CancelBooking cancels every reservation of a booking whose lifecycle still allows it, in one
+//transaction, releases their rooms and records the changes with the user who made them. It
+//returns the ids of the reservations it cancelled.
+func (m *postgressDBRepo) CancelBooking(ctx context.Context, id int, userID int) ([]int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	//the booking row stays locked until the commit, so two cancellations run one after the other
+	err = tx.QueryRowContext(ctx, `select id from bookings where id = $1 for update`, id).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `select id, status from reservations
+			where booking_id = $1 and deleted_at is null order by id for update`, id)
+	if err != nil {
+		return nil, err
+	}
+	var stays []models.Reservation
+	for rows.Next() {
+		var res models.Reservation
+		if err := rows.Scan(&res.ID, &res.Status); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stays = append(stays, res)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	ids := []int{}
+	for _, res := range stays {
+		if domain.Transition(res.Status, domain.Cancelled) != nil {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `update reservations set status=$1, update_at=$2 where id=$3`, string(domain.Cancelled), now, res.ID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id=$1`, res.ID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `insert into reservation_status_history (reservation_id, from_status, to_status, user_id, create_at)
+				values ($1, $2, $3, $4, $5)`, res.ID, string(res.Status), string(domain.Cancelled), userID, now)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, res.ID)
+	}
+
+	return ids, tx.Commit()
 }
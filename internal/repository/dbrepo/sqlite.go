@@ -0,0 +1,1661 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ArmanurRahman/booking/internal/domain"
+	"github.com/ArmanurRahman/booking/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//sqliteDate is the layout of the date columns. SQLite has no date type, so dates
+//are stored as text and only compare correctly when they all share one layout.
+const sqliteDate = "2006-01-02"
+
+//reservationColumns are the columns the reservation queries scan with scanReservation
+const reservationColumns = `r.id, r.first_name, r.last_name, r.email, r.phone,
+			r.start_date, r.end_date, r.create_at, r.update_at, r.status, coalesce(r.guest_id, 0),
+			coalesce(r.booking_id, 0), r.adults, r.children, rm.id, rm.room_name
+			from reservations r left join rooms rm on r.room_id=rm.id`
+
+func (m *sqliteDBRepo) AllUsers(ctx context.Context) bool {
+	return true
+}
+
+//InsertReservation inserts a reservation linked to its guest, the guest is added on a first stay
+func (m *sqliteDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	guestID, err := m.linkGuest(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
+	var newId int
+	sql := `insert into reservations (first_name, last_name, email, phone, start_date, end_date,
+		room_id, guest_id, adults, children, create_at, update_at)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) returning id`
+
+	err = tx.QueryRowContext(ctx, sql,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate.Format(sqliteDate),
+		res.EndDate.Format(sqliteDate),
+		res.RoomID,
+		guestID,
+		res.Adults,
+		res.Children,
+		time.Now(),
+		time.Now(),
+	).Scan(&newId)
+
+	if err != nil {
+		return 0, err
+	}
+	return newId, tx.Commit()
+}
+
+//linkGuest returns the guest of a reservation made from a guest account, or the guest
+//with the email of the reservation, or the guest an earlier reservation with the email
+//was linked to, or else the guest with its phone number. The guest is added when there is none.
+func (m *sqliteDBRepo) linkGuest(ctx context.Context, tx *sql.Tx, res models.Reservation) (int, error) {
+	if res.GuestID != 0 {
+		return res.GuestID, nil
+	}
+	emailKey, phoneKey := domain.EmailKey(res.Email), domain.PhoneKey(res.Phone)
+
+	var id int
+	err := tx.QueryRowContext(ctx, `select id from (
+				select id, 1 as rank from guests where email_key = ?1 and ?1 <> ''
+				union all
+				select guest_id, 2 from reservations where lower(email) = ?1 and ?1 <> '' and guest_id is not null
+				union all
+				select id, 3 from guests where phone_key = ?2 and ?2 <> ''
+			)
+			order by rank, id
+			limit 1`, emailKey, phoneKey).Scan(&id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return id, err
+	}
+	return m.addGuest(ctx, tx, res)
+}
+
+//accountGuest returns the guest with the email of a guest account, or adds one. An account
+//is only linked by its email, a phone number proves nothing about who signs up.
+func (m *sqliteDBRepo) accountGuest(ctx context.Context, tx *sql.Tx, res models.Reservation) (int, error) {
+	var id int
+	err := tx.QueryRowContext(ctx, `select id from guests where email_key = ?1 and ?1 <> '' order by id limit 1`,
+		domain.EmailKey(res.Email)).Scan(&id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return id, err
+	}
+	return m.addGuest(ctx, tx, res)
+}
+
+//addGuest adds a guest with the contact details of a reservation
+func (m *sqliteDBRepo) addGuest(ctx context.Context, tx *sql.Tx, res models.Reservation) (int, error) {
+	var id int
+	err := tx.QueryRowContext(ctx, `insert into guests (first_name, last_name, email, phone, email_key, phone_key,
+			create_at, update_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?) returning id`,
+		res.FirstName, res.LastName, res.Email, res.Phone, domain.EmailKey(res.Email), domain.PhoneKey(res.Phone),
+		time.Now(), time.Now(),
+	).Scan(&id)
+	return id, err
+}
+
+func (m *sqliteDBRepo) InsetIntoRoomRestriction(ctx context.Context, res models.RoomRestriction) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	sql := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id,
+		create_at, update_at)
+		values (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := m.DB.ExecContext(ctx, sql,
+		res.StartDate.Format(sqliteDate),
+		res.EndDate.Format(sqliteDate),
+		res.RoomID,
+		res.ResevationID,
+		res.RestrictionID,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *sqliteDBRepo) SearchAvailabilityByDatesByRoomId(ctx context.Context, start, end time.Time, roomId int) (bool, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var numRows int
+
+	sql := `select count(id) from room_restrictions
+			where ? < end_date and ? > start_date and room_id = ?`
+
+	err := m.DB.QueryRowContext(ctx, sql, start.Format(sqliteDate), end.Format(sqliteDate), roomId).Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+	return numRows == 0, nil
+}
+
+func (m *sqliteDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	sql := `select r.id, r.room_name, r.max_occupancy, r.beds from rooms r where r.id not in
+	(select room_id from room_restrictions rr where ? < rr.end_date and ? > rr.start_date)`
+
+	var rooms []models.Room
+	rows, err := m.DB.QueryContext(ctx, sql, start.Format(sqliteDate), end.Format(sqliteDate))
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var room models.Room
+		err := rows.Scan(
+			&room.ID,
+			&room.RoomName,
+			&room.MaxOccupancy,
+			&room.Beds,
+		)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+	return rooms, nil
+}
+
+func (m *sqliteDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var room models.Room
+	sql := `select id, room_name, max_occupancy, beds, create_at, update_at from rooms where id = ?`
+
+	err := m.DB.QueryRowContext(ctx, sql, id).Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.MaxOccupancy,
+		&room.Beds,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+	if err != nil {
+		return room, err
+	}
+	return room, nil
+}
+
+//AllRooms returns every room ordered by id
+func (m *sqliteDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var rooms []models.Room
+
+	rows, err := m.DB.QueryContext(ctx, `select id, room_name, max_occupancy, beds, create_at, update_at from rooms order by id`)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var room models.Room
+		err := rows.Scan(
+			&room.ID,
+			&room.RoomName,
+			&room.MaxOccupancy,
+			&room.Beds,
+			&room.CreatedAt,
+			&room.UpdatedAt,
+		)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+	return rooms, nil
+}
+
+func (m *sqliteDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var newId int
+	sql := `insert into rooms (room_name, max_occupancy, beds, create_at, update_at) values (?, ?, ?, ?, ?) returning id`
+
+	err := m.DB.QueryRowContext(ctx, sql, room.RoomName, room.MaxOccupancy, room.Beds, time.Now(), time.Now()).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+	return newId, nil
+}
+
+func (m *sqliteDBRepo) GetUserById(ctx context.Context, id int) (models.User, error) {
+	return m.user(ctx, "id", id)
+}
+
+func (m *sqliteDBRepo) UpdateUserById(ctx context.Context, user models.User) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	sql := `update users set first_name=?, last_name=?, email=?, access_level=?, update_at=?
+			where id=?`
+
+	_, err := m.DB.ExecContext(ctx, sql,
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		user.AccessLevel,
+		time.Now(),
+		user.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *sqliteDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	return m.user(ctx, "email", email)
+}
+
+//user returns the user whose column matches value
+func (m *sqliteDBRepo) user(ctx context.Context, column string, value interface{}) (models.User, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var user models.User
+	sql := fmt.Sprintf(`select id, first_name, last_name, email, password, access_level, create_at, update_at
+			from users where %s=?`, column)
+
+	err := m.DB.QueryRowContext(ctx, sql, value).Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Password,
+		&user.AccessLevel,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+func (m *sqliteDBRepo) InsertUser(ctx context.Context, user models.User) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var newId int
+	sql := `insert into users (first_name, last_name, email, password, access_level, create_at, update_at)
+		values (?, ?, ?, ?, ?, ?, ?) returning id`
+
+	err := m.DB.QueryRowContext(ctx, sql,
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		user.Password,
+		user.AccessLevel,
+		time.Now(),
+		time.Now(),
+	).Scan(&newId)
+
+	if err != nil {
+		return 0, err
+	}
+	return newId, nil
+}
+
+func (m *sqliteDBRepo) UpdateUserPassword(ctx context.Context, id int, hashedPassword string) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	sql := `update users set password=?, update_at=? where id=?`
+
+	_, err := m.DB.ExecContext(ctx, sql, hashedPassword, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *sqliteDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+	sql := `select id, password from users where email=?`
+
+	err := m.DB.QueryRowContext(ctx, sql, email).Scan(
+		&id,
+		&hashedPassword,
+	)
+	if err != nil {
+		return id, "", err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("incorrect password")
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	return id, hashedPassword, nil
+}
+
+//ReservationsByStatus returns the reservations in any of the statuses, or every reservation without statuses
+func (m *sqliteDBRepo) ReservationsByStatus(ctx context.Context, statuses ...domain.Status) ([]models.Reservation, error) {
+	where, args := statusIn("r.status", statuses)
+	return m.reservations(ctx, "where r.deleted_at is null and "+where+" order by r.start_date, r.id", args...)
+}
+
+//CountReservationsByStatus returns the number of reservations in any of the statuses
+func (m *sqliteDBRepo) CountReservationsByStatus(ctx context.Context, statuses ...domain.Status) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	where, args := statusIn("status", statuses)
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, `select count(id) from reservations where deleted_at is null and `+where, args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+//ListReservations returns the page of the reservations the query selects
+func (m *sqliteDBRepo) ListReservations(ctx context.Context, q models.ReservationQuery) (models.ReservationPage, error) {
+	q, err := normalizeQuery(q)
+	if err != nil {
+		return models.ReservationPage{}, err
+	}
+	clauses, args, err := reservationListClauses(q, func(n int) string { return "?" })
+	if err != nil {
+		return models.ReservationPage{}, err
+	}
+
+	reservations, err := m.reservations(ctx, clauses, args...)
+	if err != nil {
+		return models.ReservationPage{}, err
+	}
+	return newReservationPage(q, reservations), nil
+}
+
+//EachReservation calls fn with every reservation the query selects in its order, as they are
+//read. The page of the query is ignored, and the first error of fn stops the reading.
+func (m *sqliteDBRepo) EachReservation(ctx context.Context, q models.ReservationQuery, fn func(models.Reservation) error) error {
+	q, err := normalizeQuery(q)
+	if err != nil {
+		return err
+	}
+	clauses, args := reservationExportClauses(q, func(n int) string { return "?" })
+
+	ctx, cancel := m.exportContext(ctx)
+	defer cancel()
+
+	return m.eachReservation(ctx, clauses, args, fn)
+}
+
+//EachRoomRestriction calls fn with every room restriction between from and to as they are read,
+//ordered by start date and room. from is inclusive and to exclusive.
+func (m *sqliteDBRepo) EachRoomRestriction(ctx context.Context, from, to time.Time, fn func(models.RoomRestriction) error) error {
+	ctx, cancel := m.exportContext(ctx)
+	defer cancel()
+
+	sql := `select rr.id, rr.start_date, rr.end_date, rr.room_id, coalesce(rr.reservation_id, 0), rr.restriction_id,
+			rm.room_name, rs.restriction_name,
+			coalesce(r.first_name, ''), coalesce(r.last_name, ''), coalesce(r.email, '')
+			from room_restrictions rr
+			join rooms rm on rm.id = rr.room_id
+			join restrictions rs on rs.id = rr.restriction_id
+			left join reservations r on r.id = rr.reservation_id
+			where rr.end_date >= ? and rr.start_date < ?
+			order by rr.start_date, rr.room_id, rr.id`
+	rows, err := m.DB.QueryContext(ctx, sql, from.Format(sqliteDate), to.Format(sqliteDate))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rr models.RoomRestriction
+		err := rows.Scan(
+			&rr.ID,
+			&rr.StartDate,
+			&rr.EndDate,
+			&rr.RoomID,
+			&rr.ResevationID,
+			&rr.RestrictionID,
+			&rr.Room.RoomName,
+			&rr.Restriction.RestrictionName,
+			&rr.Reservation.FirstName,
+			&rr.Reservation.LastName,
+			&rr.Reservation.Email,
+		)
+		if err != nil {
+			return err
+		}
+		rr.Room.ID = rr.RoomID
+		rr.Restriction.ID = rr.RestrictionID
+		rr.Reservation.ID = rr.ResevationID
+
+		if err := fn(rr); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+//statusIn returns a condition matching column to any of the statuses, or anything without statuses
+func statusIn(column string, statuses []domain.Status) (string, []interface{}) {
+	if len(statuses) == 0 {
+		return "1 = 1", nil
+	}
+
+	placeholders := make([]string, len(statuses))
+	args := make([]interface{}, len(statuses))
+	for i, s := range statuses {
+		placeholders[i] = "?"
+		args[i] = string(s)
+	}
+	return fmt.Sprintf("%s in (%s)", column, strings.Join(placeholders, ", ")), args
+}
+
+func (m *sqliteDBRepo) GetReservationById(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var reservation models.Reservation
+	sql := `select ` + reservationColumns + ` where r.id = ? and r.deleted_at is null`
+
+	err := m.DB.QueryRowContext(ctx, sql, id).Scan(reservationFields(&reservation)...)
+	if err != nil {
+		return reservation, err
+	}
+	return reservation, nil
+}
+
+func (m *sqliteDBRepo) UpdateReservationById(ctx context.Context, reservation models.Reservation) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	sql := `update reservations set first_name=?, last_name=?, email=?, phone=?, update_at=?
+			where id=? and deleted_at is null`
+
+	_, err := m.DB.ExecContext(ctx, sql,
+		reservation.FirstName,
+		reservation.LastName,
+		reservation.Email,
+		reservation.Phone,
+		time.Now(),
+		reservation.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//DeleteReservationById moves a reservation to the trash and releases its room
+func (m *sqliteDBRepo) DeleteReservationById(ctx context.Context, id int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	//deleted_at is kept in UTC, so the text compares in time order when purging
+	now := time.Now().UTC()
+	result, err := tx.ExecContext(ctx, `update reservations set deleted_at=?, update_at=?
+			where id=? and deleted_at is null`, now, now, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id=?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//RestoreReservation takes a reservation out of the trash. A reservation that stays blocks
+//its room again, unless the room was booked by someone else in the meantime.
+func (m *sqliteDBRepo) RestoreReservation(ctx context.Context, id int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	var start, end string
+	var status domain.Status
+	err = tx.QueryRowContext(ctx, `select room_id, date(start_date), date(end_date), status from reservations
+			where id=? and deleted_at is not null`, id).Scan(&roomID, &start, &end, &status)
+	if err != nil {
+		return err
+	}
+
+	if status.Stays() {
+		var taken int
+		err = tx.QueryRowContext(ctx, `select count(id) from room_restrictions
+				where ? < end_date and ? > start_date and room_id = ?`, start, end, roomID).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			return domain.ErrRoomUnavailable
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set deleted_at=null, update_at=? where id=?`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	if status.Stays() {
+		_, err = tx.ExecContext(ctx, `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id,
+				create_at, update_at)
+				values (?, ?, ?, ?, 1, ?, ?)`, start, end, roomID, id, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//ImportReservations inserts the reservations in their statuses in one transaction and blocks
+//the rooms of those that stay. Either every reservation is inserted or none is, and none is
+//when a room is taken on the dates of one. It runs as long as an export may.
+func (m *sqliteDBRepo) ImportReservations(ctx context.Context, reservations []models.Reservation) ([]int, error) {
+	ctx, cancel := m.exportContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, 0, len(reservations))
+	for i, res := range reservations {
+		now := time.Now()
+		start, end := res.StartDate.Format(sqliteDate), res.EndDate.Format(sqliteDate)
+		if res.Status.Stays() {
+			var taken int
+			err = tx.QueryRowContext(ctx, `select count(id) from room_restrictions
+					where ? < end_date and ? > start_date and room_id = ?`, start, end, res.RoomID).Scan(&taken)
+			if err != nil {
+				return nil, err
+			}
+			if taken > 0 {
+				return nil, fmt.Errorf("reservation %d of the import: %w", i+1, domain.ErrRoomUnavailable)
+			}
+		}
+
+		guestID, err := m.linkGuest(ctx, tx, res)
+		if err != nil {
+			return nil, err
+		}
+
+		var id int
+		err = tx.QueryRowContext(ctx, `insert into reservations (first_name, last_name, email, phone, start_date, end_date,
+				room_id, guest_id, adults, children, status, create_at, update_at)
+				values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) returning id`,
+			res.FirstName, res.LastName, res.Email, res.Phone, start, end, res.RoomID, guestID, res.Adults, res.Children,
+			string(res.Status), now, now,
+		).Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.Status.Stays() {
+			_, err = tx.ExecContext(ctx, `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id,
+					create_at, update_at)
+					values (?, ?, ?, ?, 1, ?, ?)`, start, end, res.RoomID, id, now, now)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, tx.Commit()
+}
+
+//DeletedReservations returns the reservations in the trash, the latest deleted first
+func (m *sqliteDBRepo) DeletedReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	sql := `select r.deleted_at, ` + reservationColumns + `
+			where r.deleted_at is not null
+			order by r.deleted_at desc, r.id`
+	rows, err := m.DB.QueryContext(ctx, sql)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reservation models.Reservation
+		err := rows.Scan(append([]interface{}{&reservation.DeletedAt}, reservationFields(&reservation)...)...)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, reservation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+//PurgeDeletedReservations permanently removes the reservations deleted before the given time,
+//with their status history and sent notifications, and returns how many it removed
+func (m *sqliteDBRepo) PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	before = before.UTC()
+	purged := `select id from reservations where deleted_at < ?`
+	for _, table := range []string{"reservation_status_history", "sent_notifications"} {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`delete from %s where reservation_id in (%s)`, table, purged), before)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `delete from reservations where deleted_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), tx.Commit()
+}
+
+//UpdateReservationStatus moves a reservation to another status, when its lifecycle allows it,
+//and records the change with the user who made it. The room of a cancelled or missed stay
+//is released.
+func (m *sqliteDBRepo) UpdateReservationStatus(ctx context.Context, id int, to domain.Status, userID int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	//the pool holds a single connection, so nothing else runs until the commit
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var from domain.Status
+	err = tx.QueryRowContext(ctx, `select status from reservations where id=? and deleted_at is null`, id).Scan(&from)
+	if err != nil {
+		return err
+	}
+
+	err = domain.Transition(from, to)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set status=?, update_at=? where id=?`, string(to), time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	if !to.Stays() {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id=?`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	sql := `insert into reservation_status_history (reservation_id, from_status, to_status, user_id, create_at)
+			values (?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, sql, id, string(from), string(to), userID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//ReservationStatusHistory returns the status changes of a reservation, oldest first
+func (m *sqliteDBRepo) ReservationStatusHistory(ctx context.Context, id int) ([]models.StatusChange, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var changes []models.StatusChange
+
+	sql := `select id, reservation_id, from_status, to_status, user_id, create_at
+			from reservation_status_history
+			where reservation_id = ?
+			order by create_at, id`
+	rows, err := m.DB.QueryContext(ctx, sql, id)
+	if err != nil {
+		return changes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.StatusChange
+		err := rows.Scan(
+			&c.ID,
+			&c.ReservationID,
+			&c.From,
+			&c.To,
+			&c.UserID,
+			&c.CreatedAt,
+		)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+//ReservationsByStartDate returns the reservations arriving between from and to, both included
+func (m *sqliteDBRepo) ReservationsByStartDate(ctx context.Context, from, to time.Time) ([]models.Reservation, error) {
+	return m.reservations(ctx, "where r.start_date between ? and ? and r.deleted_at is null", from.Format(sqliteDate), to.Format(sqliteDate))
+}
+
+//ReservationsByEndDate returns the reservations departing between from and to, both included
+func (m *sqliteDBRepo) ReservationsByEndDate(ctx context.Context, from, to time.Time) ([]models.Reservation, error) {
+	return m.reservations(ctx, "where r.end_date between ? and ? and r.deleted_at is null", from.Format(sqliteDate), to.Format(sqliteDate))
+}
+
+//reservations returns the reservations matching the where clause, with their room
+func (m *sqliteDBRepo) reservations(ctx context.Context, where string, args ...interface{}) ([]models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var reservations []models.Reservation
+	err := m.eachReservation(ctx, where, args, func(r models.Reservation) error {
+		reservations = append(reservations, r)
+		return nil
+	})
+	return reservations, err
+}
+
+//eachReservation calls fn with the reservations joined with their room the clauses select
+func (m *sqliteDBRepo) eachReservation(ctx context.Context, clauses string, args []interface{}, fn func(models.Reservation) error) error {
+	rows, err := m.DB.QueryContext(ctx, `select `+reservationColumns+` `+clauses, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reservation models.Reservation
+		if err := rows.Scan(reservationFields(&reservation)...); err != nil {
+			return err
+		}
+		if err := fn(reservation); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+//reservationFields returns the scan destinations of reservationColumns
+func reservationFields(r *models.Reservation) []interface{} {
+	return []interface{}{
+		&r.ID,
+		&r.FirstName,
+		&r.LastName,
+		&r.Email,
+		&r.Phone,
+		&r.StartDate,
+		&r.EndDate,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+		&r.Status,
+		&r.GuestID,
+		&r.BookingID,
+		&r.Adults,
+		&r.Children,
+		&r.Room.ID,
+		&r.Room.RoomName,
+	}
+}
+
+//ClaimNotification records that a notification of the given kind is being sent for a reservation.
+//It returns false when the notification was already claimed, by this or by another instance.
+func (m *sqliteDBRepo) ClaimNotification(ctx context.Context, reservationID int, kind string) (bool, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	sql := `insert into sent_notifications (reservation_id, kind, create_at)
+			values (?, ?, ?)
+			on conflict (reservation_id, kind) do nothing`
+
+	result, err := m.DB.ExecContext(ctx, sql, reservationID, kind, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+//ReleaseNotification forgets a claimed notification so that it is sent again, when the email could not be sent
+func (m *sqliteDBRepo) ReleaseNotification(ctx context.Context, reservationID int, kind string) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from sent_notifications where reservation_id = ? and kind = ?`, reservationID, kind)
+	return err
+}
+
+//InsertNotification stores a new in-app staff notification
+func (m *sqliteDBRepo) InsertNotification(ctx context.Context, n models.Notification) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var newId int
+	sql := `insert into notifications (title, body, link, create_at, update_at)
+			values (?, ?, ?, ?, ?) returning id`
+
+	err := m.DB.QueryRowContext(ctx, sql,
+		n.Title,
+		n.Body,
+		n.Link,
+		time.Now(),
+		time.Now(),
+	).Scan(&newId)
+
+	if err != nil {
+		return 0, err
+	}
+	return newId, nil
+}
+
+//RecentNotifications returns the latest notifications with their read state for a user
+func (m *sqliteDBRepo) RecentNotifications(ctx context.Context, userID, limit int) ([]models.Notification, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var notifications []models.Notification
+
+	sql := `select n.id, n.title, n.body, n.link, n.create_at, nr.user_id is not null
+			from notifications n
+			left join notification_reads nr on nr.notification_id = n.id and nr.user_id = ?
+			order by n.create_at desc, n.id desc
+			limit ?`
+	rows, err := m.DB.QueryContext(ctx, sql, userID, limit)
+	if err != nil {
+		return notifications, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n models.Notification
+		err := rows.Scan(
+			&n.ID,
+			&n.Title,
+			&n.Body,
+			&n.Link,
+			&n.CreatedAt,
+			&n.Read,
+		)
+		if err != nil {
+			return notifications, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return notifications, err
+	}
+	return notifications, nil
+}
+
+//UnreadNotificationCount returns the number of notifications a user has not read yet
+func (m *sqliteDBRepo) UnreadNotificationCount(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var count int
+	sql := `select count(n.id) from notifications n
+			where not exists (select 1 from notification_reads nr
+				where nr.notification_id = n.id and nr.user_id = ?)`
+
+	err := m.DB.QueryRowContext(ctx, sql, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+//MarkNotificationRead marks one notification as read by a user
+func (m *sqliteDBRepo) MarkNotificationRead(ctx context.Context, id, userID int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	sql := `insert into notification_reads (notification_id, user_id, create_at)
+			values (?, ?, ?)
+			on conflict (notification_id, user_id) do nothing`
+
+	_, err := m.DB.ExecContext(ctx, sql, id, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//MarkAllNotificationsRead marks every notification as read by a user
+func (m *sqliteDBRepo) MarkAllNotificationsRead(ctx context.Context, userID int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	sql := `insert or ignore into notification_reads (notification_id, user_id, create_at)
+			select id, ?, ? from notifications`
+
+	_, err := m.DB.ExecContext(ctx, sql, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//InsertAuditEntry appends an entry to the audit log
+func (m *sqliteDBRepo) InsertAuditEntry(ctx context.Context, e models.AuditEntry) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var newId int
+	sql := `insert into audit_log (user_id, action, entity, entity_id, changes, ip, actor, create_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?) returning id`
+
+	err := m.DB.QueryRowContext(ctx, sql,
+		e.UserID,
+		e.Action,
+		e.Entity,
+		e.EntityID,
+		e.Changes,
+		e.IP,
+		e.Actor,
+		time.Now().UTC(),
+	).Scan(&newId)
+
+	if err != nil {
+		return 0, err
+	}
+	return newId, nil
+}
+
+//AuditEntries returns the audit entries matching the filter, the latest first
+func (m *sqliteDBRepo) AuditEntries(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var entries []models.AuditEntry
+
+	where, args := auditConditions(f, func(int) string { return "?" })
+	sql := `select id, user_id, action, entity, entity_id, changes, ip, actor, create_at
+			from audit_log
+			where ` + where + `
+			order by create_at desc, id desc`
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		sql += " limit ?"
+	}
+
+	rows, err := m.DB.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEntry
+		err := rows.Scan(
+			&e.ID,
+			&e.UserID,
+			&e.Action,
+			&e.Entity,
+			&e.EntityID,
+			&e.Changes,
+			&e.IP,
+			&e.Actor,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+	return entries, nil
+}
+
+//sqlitePeriod returns the expression of the first day of the period holding the date in column
+func sqlitePeriod(column, period string) string {
+	switch period {
+	case models.PeriodWeek:
+		return fmt.Sprintf(`date(%s, '-' || ((cast(strftime('%%w', %s) as integer) + 6) %% 7) || ' days')`, column, column)
+	case models.PeriodMonth:
+		return fmt.Sprintf(`strftime('%%Y-%%m-01', %s)`, column)
+	}
+	return fmt.Sprintf("date(%s)", column)
+}
+
+//Occupancy returns the nights booked of every room in each period between from and to,
+//from is inclusive and to exclusive
+func (m *sqliteDBRepo) Occupancy(ctx context.Context, from, to time.Time, period string) ([]models.Occupancy, error) {
+	if err := checkPeriod(from, to, period); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var occupancy []models.Occupancy
+
+	sql := `with recursive days(day) as (
+				select date(?)
+				union all
+				select date(day, '+1 day') from days where day < date(?, '-1 day')
+			)
+			select rm.id, rm.room_name, ` + sqlitePeriod("days.day", period) + `,
+			count(*),
+			sum(exists (
+				select 1 from reservations r
+				where r.room_id = rm.id and r.deleted_at is null and r.status <> ?
+				and r.start_date <= days.day and r.end_date > days.day))
+			from rooms rm cross join days
+			group by rm.id, rm.room_name, 3
+			order by 3, rm.id`
+	rows, err := m.DB.QueryContext(ctx, sql, from.Format(sqliteDate), to.Format(sqliteDate), string(domain.Cancelled))
+	if err != nil {
+		return occupancy, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o models.Occupancy
+		var start string
+		err := rows.Scan(
+			&o.RoomID,
+			&o.RoomName,
+			&start,
+			&o.Nights,
+			&o.Booked,
+		)
+		if err != nil {
+			return occupancy, err
+		}
+		if o.Period, err = time.Parse(sqliteDate, start); err != nil {
+			return occupancy, err
+		}
+		occupancy = append(occupancy, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return occupancy, err
+	}
+	return occupancy, nil
+}
+
+//BookingStats sums up the reservations arriving between from and to by period,
+//from is inclusive and to exclusive. Periods without arrivals are left out.
+func (m *sqliteDBRepo) BookingStats(ctx context.Context, from, to time.Time, period string) ([]models.BookingStats, error) {
+	if err := checkPeriod(from, to, period); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var stats []models.BookingStats
+
+	//create_at is stored as text starting with the date it was written on
+	sql := `select ` + sqlitePeriod("r.start_date", period) + `,
+			count(*),
+			coalesce(sum(r.status = ?), 0),
+			coalesce(sum(case when r.status <> ? then julianday(r.end_date) - julianday(r.start_date) end), 0),
+			coalesce(sum(case when r.status <> ? then julianday(r.start_date) - julianday(substr(r.create_at, 1, 10)) end), 0)
+			from reservations r
+			where r.deleted_at is null and r.start_date >= ? and r.start_date < ?
+			group by 1
+			order by 1`
+	cancelled := string(domain.Cancelled)
+	rows, err := m.DB.QueryContext(ctx, sql, cancelled, cancelled, cancelled, from.Format(sqliteDate), to.Format(sqliteDate))
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.BookingStats
+		var start string
+		var nights, leadDays float64
+		err := rows.Scan(
+			&start,
+			&s.Reservations,
+			&s.Cancelled,
+			&nights,
+			&leadDays,
+		)
+		if err != nil {
+			return stats, err
+		}
+		if s.Period, err = time.Parse(sqliteDate, start); err != nil {
+			return stats, err
+		}
+		s.Nights, s.LeadDays = int(nights), int(leadDays)
+		stats = append(stats, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+//ListGuests returns the guests matching a search by name, email or phone number,
+//every guest without a search, ordered by name and at most a page of them
+func (m *sqliteDBRepo) ListGuests(ctx context.Context, search string) ([]models.Guest, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	c := guestConditions(search, func(n int) string { return "?" })
+	sql := fmt.Sprintf(`select %s %s %s limit %d`, guestColumns, c.where(), guestOrder, models.MaxPageSize)
+	return m.guests(ctx, sql, c.args...)
+}
+
+//guests reads the guests a query of guestColumns selects
+func (m *sqliteDBRepo) guests(ctx context.Context, sql string, args ...interface{}) ([]models.Guest, error) {
+	rows, err := m.DB.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var guests []models.Guest
+	for rows.Next() {
+		g, err := scanGuest(rows)
+		if err != nil {
+			return nil, err
+		}
+		guests = append(guests, g)
+	}
+	return guests, rows.Err()
+}
+
+//GetGuestByID returns a guest with the number of stays
+func (m *sqliteDBRepo) GetGuestByID(ctx context.Context, id int) (models.Guest, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	return scanGuest(m.DB.QueryRowContext(ctx, `select `+guestColumns+` where g.id = ?`, id))
+}
+
+//GuestReservations returns the reservations of a guest that are not in the trash, the latest stay first
+func (m *sqliteDBRepo) GuestReservations(ctx context.Context, id int) ([]models.Reservation, error) {
+	return m.reservations(ctx, `where r.guest_id = ? and r.deleted_at is null order by r.start_date desc, r.id desc`, id)
+}
+
+//DuplicateGuests returns the other guests with the email, the phone number or the name of a guest
+func (m *sqliteDBRepo) DuplicateGuests(ctx context.Context, id int) ([]models.Guest, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	sql := `select ` + guestColumns + ` join guests o on o.id = ? where ` + guestDuplicates + ` order by g.id`
+	return m.guests(ctx, sql, id)
+}
+
+//UpdateGuest saves the details, notes and tags of a guest
+func (m *sqliteDBRepo) UpdateGuest(ctx context.Context, guest models.Guest) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `update guests set first_name=?, last_name=?, email=?, phone=?,
+			email_key=?, phone_key=?, notes=?, tags=?, update_at=?
+			where id=?`,
+		guest.FirstName,
+		guest.LastName,
+		guest.Email,
+		guest.Phone,
+		domain.EmailKey(guest.Email),
+		domain.PhoneKey(guest.Phone),
+		guest.Notes,
+		strings.Join(domain.ParseTags(strings.Join(guest.Tags, ",")), ","),
+		time.Now(),
+		guest.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//MergeGuests moves the reservations of the guest from to the guest into, which keeps
+//the notes and tags of both, and removes the guest from
+func (m *sqliteDBRepo) MergeGuests(ctx context.Context, into, from int) error {
+	if into == from {
+		return ErrSameGuest
+	}
+
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var guests [2]models.Guest
+	for i, id := range []int{into, from} {
+		guests[i], err = scanGuest(tx.QueryRowContext(ctx, `select `+guestColumns+` where g.id = ?`, id))
+		if err != nil {
+			return err
+		}
+	}
+	merged := mergeGuest(guests[0], guests[1])
+
+	_, err = tx.ExecContext(ctx, `update reservations set guest_id=? where guest_id=?`, into, from)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update guest_accounts set guest_id=?, update_at=? where guest_id=?`, into, time.Now(), from)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update guests set email=?, phone=?, email_key=?, phone_key=?, notes=?, tags=?,
+			update_at=? where id=?`,
+		merged.Email, merged.Phone, domain.EmailKey(merged.Email), domain.PhoneKey(merged.Phone),
+		merged.Notes, strings.Join(merged.Tags, ","), time.Now(), into)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from guests where id=?`, from)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//InsertGuestAccount adds the account of a guest, linked to the guest with its email or phone
+//number like a reservation is, and returns its id. It fails with ErrAccountExists when the
+//email already has an account.
+func (m *sqliteDBRepo) InsertGuestAccount(ctx context.Context, account models.GuestAccount) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	email := domain.EmailKey(account.Email)
+	var taken int
+	err = tx.QueryRowContext(ctx, `select count(id) from guest_accounts where email=?`, email).Scan(&taken)
+	if err != nil {
+		return 0, err
+	}
+	if taken > 0 {
+		return 0, ErrAccountExists
+	}
+
+	guestID, err := m.accountGuest(ctx, tx, models.Reservation{
+		FirstName: account.Guest.FirstName,
+		LastName:  account.Guest.LastName,
+		Email:     account.Email,
+		Phone:     account.Guest.Phone,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = tx.QueryRowContext(ctx, `insert into guest_accounts (guest_id, email, password, verify_token, verify_expires,
+			create_at, update_at)
+			values (?, ?, ?, ?, ?, ?, ?) returning id`,
+		guestID, email, account.Password, account.VerifyToken, account.VerifyExpires.UTC(), time.Now(), time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+//GetGuestAccount returns a guest account with the details of its guest
+func (m *sqliteDBRepo) GetGuestAccount(ctx context.Context, id int) (models.GuestAccount, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	return scanGuestAccount(m.DB.QueryRowContext(ctx, `select `+guestAccountColumns+` where a.id = ?`, id))
+}
+
+//AuthenticateGuest returns the guest account with the email when the password matches
+func (m *sqliteDBRepo) AuthenticateGuest(ctx context.Context, email, testPassword string) (models.GuestAccount, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	account, err := scanGuestAccount(m.DB.QueryRowContext(ctx, `select `+guestAccountColumns+` where a.email = ?`,
+		domain.EmailKey(email)))
+	if err != nil {
+		return models.GuestAccount{}, err
+	}
+
+	err = checkPassword(account.Password, testPassword)
+	if err != nil {
+		return models.GuestAccount{}, err
+	}
+	return account, nil
+}
+
+//RenewGuestVerification replaces the verification token of an account that is not verified yet
+func (m *sqliteDBRepo) RenewGuestVerification(ctx context.Context, id int, token string, expires time.Time) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `update guest_accounts set verify_token=?, verify_expires=?, update_at=?
+			where id=? and verified_at is null`, token, expires.UTC(), time.Now(), id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//VerifyGuestAccount verifies the account with the token, unless the token expired, and returns
+//its id. The expiry is kept in UTC, so the text compares in time order.
+func (m *sqliteDBRepo) VerifyGuestAccount(ctx context.Context, token string) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, `update guest_accounts set verified_at=?1, verify_token='', update_at=?1
+			where verify_token=?2 and ?2 <> '' and verify_expires > ?1
+			returning id`, time.Now().UTC(), token).Scan(&id)
+	return id, err
+}
+
+//ChangeReservationDates moves a reservation that is not in the trash, and the block of its
+//room, to other dates unless the room is taken on them by another reservation or a block
+func (m *sqliteDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	//the pool holds a single connection, so nothing else runs until the commit
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select room_id from reservations where id=? and deleted_at is null`, id).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	var taken int
+	err = tx.QueryRowContext(ctx, `select count(id) from room_restrictions
+			where ? < end_date and ? > start_date and room_id = ? and reservation_id <> ?`,
+		start.Format(sqliteDate), end.Format(sqliteDate), roomID, id).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return domain.ErrRoomUnavailable
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set start_date=?, end_date=?, update_at=? where id=?`,
+		start.Format(sqliteDate), end.Format(sqliteDate), time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update room_restrictions set start_date=?, end_date=?, update_at=?
+			where reservation_id=?`, start.Format(sqliteDate), end.Format(sqliteDate), time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//InsertBooking inserts a booking with the reservations of its rooms and their room restrictions,
+//all in one transaction. Either every reservation is inserted or none is, and none is when a
+//room is taken on the dates of its reservation, by another stay, a block or an earlier room
+//of the booking.
+func (m *sqliteDBRepo) InsertBooking(ctx context.Context, reservations []models.Reservation) (int, []int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var bookingID int
+	err = tx.QueryRowContext(ctx, `insert into bookings (create_at, update_at) values (?1, ?1) returning id`, now).Scan(&bookingID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ids := make([]int, 0, len(reservations))
+	for i, res := range reservations {
+		start, end := res.StartDate.Format(sqliteDate), res.EndDate.Format(sqliteDate)
+		var taken int
+		err = tx.QueryRowContext(ctx, `select count(id) from room_restrictions
+				where ? < end_date and ? > start_date and room_id = ?`, start, end, res.RoomID).Scan(&taken)
+		if err != nil {
+			return 0, nil, err
+		}
+		if taken > 0 {
+			return 0, nil, fmt.Errorf("room %d of the booking: %w", i+1, domain.ErrRoomUnavailable)
+		}
+
+		guestID, err := m.linkGuest(ctx, tx, res)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		var id int
+		err = tx.QueryRowContext(ctx, `insert into reservations (first_name, last_name, email, phone, start_date, end_date,
+				room_id, guest_id, booking_id, adults, children, create_at, update_at)
+				values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) returning id`,
+			res.FirstName, res.LastName, res.Email, res.Phone, start, end, res.RoomID, guestID, bookingID,
+			res.Adults, res.Children, now, now,
+		).Scan(&id)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id,
+				create_at, update_at)
+				values (?, ?, ?, ?, 1, ?, ?)`, start, end, res.RoomID, id, now, now)
+		if err != nil {
+			return 0, nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return bookingID, ids, tx.Commit()
+}
+
+//GetBooking returns a booking with its reservations that are not in the trash, by arrival and room
+func (m *sqliteDBRepo) GetBooking(ctx context.Context, id int) (models.Booking, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var booking models.Booking
+	err := m.DB.QueryRowContext(ctx, `select id, create_at, update_at from bookings where id = ?`, id).
+		Scan(&booking.ID, &booking.CreatedAt, &booking.UpdatedAt)
+	if err != nil {
+		return booking, err
+	}
+
+	booking.Reservations, err = m.reservations(ctx, `where r.booking_id = ? and r.deleted_at is null order by r.start_date, r.room_id, r.id`, id)
+	return booking, err
+}
+
+//CancelBooking cancels every reservation of a booking whose lifecycle still allows it, in one
+//transaction, releases their rooms and records the changes with the user who made them. It
+//returns the ids of the reservations it cancelled.
+func (m *sqliteDBRepo) CancelBooking(ctx context.Context, id int, userID int) ([]int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	//the pool holds a single connection, so nothing else runs until the commit
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `select id from bookings where id = ?`, id).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `select id, status from reservations
+			where booking_id = ? and deleted_at is null order by id`, id)
+	if err != nil {
+		return nil, err
+	}
+	var stays []models.Reservation
+	for rows.Next() {
+		var res models.Reservation
+		if err := rows.Scan(&res.ID, &res.Status); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stays = append(stays, res)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	ids := []int{}
+	for _, res := range stays {
+		if domain.Transition(res.Status, domain.Cancelled) != nil {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `update reservations set status=?, update_at=? where id=?`, string(domain.Cancelled), now, res.ID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id=?`, res.ID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `insert into reservation_status_history (reservation_id, from_status, to_status, user_id, create_at)
+				values (?, ?, ?, ?, ?)`, res.ID, string(res.Status), string(domain.Cancelled), userID, now)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, res.ID)
+	}
+
+	return ids, tx.Commit()
+}
@@ -1,25 +1,68 @@
 package repository
 
 import (
+	"context"
 	"time"
 
+	"github.com/ArmanurRahman/booking/internal/domain"
 	"github.com/ArmanurRahman/booking/internal/models"
 )
 
 type DatabaseRepo interface {
-	AllUsers() bool
-	InsertReservation(res models.Reservation) (int, error)
-	InsetIntoRoomRestriction(res models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomId(start, end time.Time, roomId int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomByID(id int) (models.Room, error)
-	GetUserById(id int) (models.User, error)
-	UpdateUserById(user models.User) error
-	Authenticate(email, testPassword string) (int, string, error)
-	AllReservations() ([]models.Reservation, error)
-	NewReservations() ([]models.Reservation, error)
-	GetReservationById(id int) (models.Reservation, error)
-	UpdateReservationById(reservation models.Reservation) error
-	DeleteReservationById(id int) error
-	UpdateProcessedForReservation(process, id int) error
+	AllUsers(ctx context.Context) bool
+	InsertReservation(ctx context.Context, res models.Reservation) (int, error)
+	InsetIntoRoomRestriction(ctx context.Context, res models.RoomRestriction) error
+	SearchAvailabilityByDatesByRoomId(ctx context.Context, start, end time.Time, roomId int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error)
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	InsertRoom(ctx context.Context, room models.Room) (int, error)
+	GetUserById(ctx context.Context, id int) (models.User, error)
+	UpdateUserById(ctx context.Context, user models.User) error
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	InsertUser(ctx context.Context, user models.User) (int, error)
+	UpdateUserPassword(ctx context.Context, id int, hashedPassword string) error
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
+	ReservationsByStatus(ctx context.Context, statuses ...domain.Status) ([]models.Reservation, error)
+	CountReservationsByStatus(ctx context.Context, statuses ...domain.Status) (int, error)
+	ListReservations(ctx context.Context, q models.ReservationQuery) (models.ReservationPage, error)
+	EachReservation(ctx context.Context, q models.ReservationQuery, fn func(models.Reservation) error) error
+	EachRoomRestriction(ctx context.Context, from, to time.Time, fn func(models.RoomRestriction) error) error
+	GetReservationById(ctx context.Context, id int) (models.Reservation, error)
+	UpdateReservationById(ctx context.Context, reservation models.Reservation) error
+	DeleteReservationById(ctx context.Context, id int) error
+	RestoreReservation(ctx context.Context, id int) error
+	ImportReservations(ctx context.Context, reservations []models.Reservation) ([]int, error)
+	DeletedReservations(ctx context.Context) ([]models.Reservation, error)
+	PurgeDeletedReservations(ctx context.Context, before time.Time) (int, error)
+	UpdateReservationStatus(ctx context.Context, id int, to domain.Status, userID int) error
+	ReservationStatusHistory(ctx context.Context, id int) ([]models.StatusChange, error)
+	ReservationsByStartDate(ctx context.Context, from, to time.Time) ([]models.Reservation, error)
+	ReservationsByEndDate(ctx context.Context, from, to time.Time) ([]models.Reservation, error)
+	ClaimNotification(ctx context.Context, reservationID int, kind string) (bool, error)
+	ReleaseNotification(ctx context.Context, reservationID int, kind string) error
+	InsertNotification(ctx context.Context, n models.Notification) (int, error)
+	RecentNotifications(ctx context.Context, userID, limit int) ([]models.Notification, error)
+	UnreadNotificationCount(ctx context.Context, userID int) (int, error)
+	MarkNotificationRead(ctx context.Context, id, userID int) error
+	MarkAllNotificationsRead(ctx context.Context, userID int) error
+	Occupancy(ctx context.Context, from, to time.Time, period string) ([]models.Occupancy, error)
+	BookingStats(ctx context.Context, from, to time.Time, period string) ([]models.BookingStats, error)
+	ListGuests(ctx context.Context, search string) ([]models.Guest, error)
+	GetGuestByID(ctx context.Context, id int) (models.Guest, error)
+	GuestReservations(ctx context.Context, id int) ([]models.Reservation, error)
+	DuplicateGuests(ctx context.Context, id int) ([]models.Guest, error)
+	UpdateGuest(ctx context.Context, guest models.Guest) error
+	MergeGuests(ctx context.Context, into, from int) error
+	InsertGuestAccount(ctx context.Context, account models.GuestAccount) (int, error)
+	GetGuestAccount(ctx context.Context, id int) (models.GuestAccount, error)
+	AuthenticateGuest(ctx context.Context, email, testPassword string) (models.GuestAccount, error)
+	RenewGuestVerification(ctx context.Context, id int, token string, expires time.Time) error
+	VerifyGuestAccount(ctx context.Context, token string) (int, error)
+	ChangeReservationDates(ctx context.Context, id int, start, end time.Time) error
+	InsertBooking(ctx context.Context, reservations []models.Reservation) (int, []int, error)
+	GetBooking(ctx context.Context, id int) (models.Booking, error)
+	CancelBooking(ctx context.Context, id int, userID int) ([]int, error)
+	InsertAuditEntry(ctx context.Context, e models.AuditEntry) (int, error)
+	AuditEntries(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error)
 }
@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ArmanurRahman/booking/internal/config"
+)
+
+func TestSetup_None(t *testing.T) {
+	a := &config.AppConfig{Tracing: config.TracingConfig{Exporter: "none"}}
+
+	shutdown, err := Setup(context.Background(), a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetup_Stdout(t *testing.T) {
+	var out bytes.Buffer
+	a := &config.AppConfig{Tracing: config.TracingConfig{Exporter: "stdout"}}
+
+	shutdown, err := Setup(context.Background(), a, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, span := Start(context.Background(), "db.GetRoomByID")
+	End(span, errors.New("no rows"))
+
+	if err = shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "db.GetRoomByID") {
+		t.Error("span was not written to stdout")
+	}
+	if !strings.Contains(out.String(), "no rows") {
+		t.Error("span error was not recorded")
+	}
+}
+
+func TestSetup_UnknownExporter(t *testing.T) {
+	a := &config.AppConfig{Tracing: config.TracingConfig{Exporter: "jaeger"}}
+
+	if _, err := Setup(context.Background(), a, nil); err == nil {
+		t.Error("expected an error for an unknown exporter")
+	}
+}
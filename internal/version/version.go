@@ -0,0 +1,8 @@
+package version
+
+//Version and Commit are set at build time, e.g.
+//go build -ldflags "-X github.com/ArmanurRahman/booking/internal/version.Version=1.2.0 -X github.com/ArmanurRahman/booking/internal/version.Commit=$(git rev-parse --short HEAD)" ./cmd/web
+var (
+	Version = "dev"
+	Commit  = "unknown"
+)
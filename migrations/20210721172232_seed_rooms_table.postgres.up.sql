@@ -1,3 +0,0 @@
-insert into rooms (room_name, create_at, update_at) values 
-('General''s Quarters', current_timestamp, current_timestamp),
-('Major''s Suite', current_timestamp, current_timestamp);
\ No newline at end of file
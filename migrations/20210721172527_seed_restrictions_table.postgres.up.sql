@@ -1,3 +0,0 @@
-insert into restrictions (restriction_name, create_at, update_at)
-values('Reservation', current_timestamp, current_timestamp),
-values('Owner Block', current_timestamp, current_timestamp);
\ No newline at end of file
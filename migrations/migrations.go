@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+)
+
+//files holds the schema migrations of every database driver compiled into the binary
+//
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+//For returns the migrations of a database driver, postgres or sqlite
+func For(driver string) (fs.FS, error) {
+	switch driver {
+	case "postgres", "sqlite":
+		return fs.Sub(files, driver)
+	default:
+		return nil, fmt.Errorf("no migrations for database driver %q", driver)
+	}
+}
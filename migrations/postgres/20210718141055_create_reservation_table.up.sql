@@ -0,0 +1,13 @@
+create table reservations
+(
+    id serial primary key,
+    first_name varchar(50) not null,
+    last_name varchar(50) not null,
+    email varchar(100) not null,
+    phone varchar(20) not null,
+    start_date date,
+    end_date date,
+    room_id int not null,
+    create_at timestamp,
+    update_at timestamp
+);
@@ -0,0 +1 @@
+alter table reservations add column process int default 0;
@@ -0,0 +1 @@
+drop table sent_notifications;
@@ -0,0 +1,17 @@
+create table notifications
+(
+    id serial primary key,
+    title varchar(255) not null,
+    body text not null,
+    link varchar(255),
+    create_at timestamp,
+    update_at timestamp
+);
+
+create table notification_reads
+(
+    notification_id int not null references notifications(id) on delete cascade,
+    user_id int not null,
+    create_at timestamp,
+    primary key (notification_id, user_id)
+);
@@ -0,0 +1,6 @@
+drop table reservation_status_history;
+
+drop index reservations_status_idx;
+alter table reservations add column process int default 0;
+update reservations set process = 1 where status <> 'pending';
+alter table reservations drop column status;
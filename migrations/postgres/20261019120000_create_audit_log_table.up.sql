@@ -0,0 +1,22 @@
+create table audit_log
+(
+    id serial primary key,
+    user_id int not null default 0,
+    action varchar(50) not null,
+    entity varchar(50) not null,
+    entity_id int not null default 0,
+    changes text not null,
+    ip varchar(45) not null default '',
+    create_at timestamp
+);
+create index audit_log_create_at_idx on audit_log (create_at);
+create index audit_log_entity_idx on audit_log (entity, entity_id);
+
+create function audit_log_append_only() returns trigger as $$
+begin
+    raise exception 'audit_log is append-only';
+end;
+$$ language plpgsql;
+
+create trigger audit_log_append_only before update or delete on audit_log
+    for each row execute procedure audit_log_append_only();
@@ -0,0 +1,7 @@
+drop index reservations_email_search_idx;
+drop index reservations_last_name_search_idx;
+drop index reservations_first_name_search_idx;
+drop index reservations_room_id_start_date_idx;
+drop index reservations_last_name_id_idx;
+drop index reservations_end_date_id_idx;
+drop index reservations_start_date_id_idx;
@@ -0,0 +1,33 @@
+create table guests
+(
+    id serial primary key,
+    first_name varchar(50) not null,
+    last_name varchar(50) not null,
+    email varchar(100) not null,
+    phone varchar(20) not null,
+    email_key varchar(100) not null default '',
+    phone_key varchar(20) not null default '',
+    notes text not null default '',
+    tags varchar(255) not null default '',
+    create_at timestamp,
+    update_at timestamp
+);
+create index guests_email_key_idx on guests (email_key) where email_key <> '';
+create index guests_phone_key_idx on guests (phone_key) where phone_key <> '';
+create index guests_last_name_idx on guests (lower(last_name), lower(first_name), id);
+
+alter table reservations add column guest_id int references guests (id) on delete set null;
+create index reservations_guest_id_idx on reservations (guest_id);
+
+-- one guest per email of the existing reservations, with the details of the latest one
+insert into guests (first_name, last_name, email, phone, email_key, phone_key, create_at, update_at)
+select distinct on (lower(trim(email))) first_name, last_name, email, phone, lower(trim(email)),
+       case when length(regexp_replace(phone, '\D', '', 'g')) >= 7 then regexp_replace(phone, '\D', '', 'g') else '' end,
+       create_at, create_at
+from reservations
+where trim(email) <> ''
+order by lower(trim(email)), create_at desc, id desc;
+
+update reservations r set guest_id = g.id
+from guests g
+where g.email_key = lower(trim(r.email)) and trim(r.email) <> '';
@@ -0,0 +1,5 @@
+alter table reservations drop column children;
+alter table reservations drop column adults;
+
+alter table rooms drop column beds;
+alter table rooms drop column max_occupancy;
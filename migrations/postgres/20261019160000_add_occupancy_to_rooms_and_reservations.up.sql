@@ -0,0 +1,8 @@
+alter table rooms add column max_occupancy int not null default 2;
+alter table rooms add column beds varchar(100) not null default '';
+
+update rooms set max_occupancy = 2, beds = '1 king bed' where room_name = 'General''s Quarters';
+update rooms set max_occupancy = 4, beds = '1 queen bed, 2 single beds' where room_name = 'Major''s Suite';
+
+alter table reservations add column adults int not null default 1;
+alter table reservations add column children int not null default 0;
@@ -0,0 +1,3 @@
+drop index reservations_booking_id_idx;
+alter table reservations drop column booking_id;
+drop table bookings;
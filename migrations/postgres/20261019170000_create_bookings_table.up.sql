@@ -0,0 +1,9 @@
+create table bookings
+(
+    id serial primary key,
+    create_at timestamp,
+    update_at timestamp
+);
+
+alter table reservations add column booking_id int references bookings (id) on delete set null;
+create index reservations_booking_id_idx on reservations (booking_id);
@@ -0,0 +1,11 @@
+create table users
+(
+    id integer primary key autoincrement,
+    first_name varchar(50) not null,
+    last_name varchar(50) not null,
+    email varchar(100) not null,
+    password varchar(500) not null,
+    access_level int default(1),
+    create_at timestamp,
+    update_at timestamp
+);
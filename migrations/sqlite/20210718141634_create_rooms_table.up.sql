@@ -0,0 +1,7 @@
+create table rooms
+(
+    id integer primary key autoincrement,
+    room_name varchar(50) not null,
+    create_at timestamp,
+    update_at timestamp
+);
@@ -0,0 +1,7 @@
+create table restrictions
+(
+    id integer primary key autoincrement,
+    restriction_name varchar(50) not null,
+    create_at timestamp,
+    update_at timestamp
+);
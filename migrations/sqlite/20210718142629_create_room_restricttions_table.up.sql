@@ -0,0 +1,11 @@
+create table room_restrictions
+(
+    id integer primary key autoincrement,
+    start_date date,
+    end_date date,
+    room_id int not null,
+    reservation_id int not null,
+    restriction_id int ,
+    create_at timestamp,
+    update_at timestamp
+);
@@ -0,0 +1,3 @@
+insert into restrictions (restriction_name, create_at, update_at) values
+('Reservation', current_timestamp, current_timestamp),
+('Owner Block', current_timestamp, current_timestamp);
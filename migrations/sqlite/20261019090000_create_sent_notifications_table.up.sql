@@ -0,0 +1,8 @@
+create table sent_notifications
+(
+    id integer primary key autoincrement,
+    reservation_id int not null,
+    kind varchar(50) not null,
+    create_at timestamp,
+    unique (reservation_id, kind)
+);
@@ -0,0 +1,15 @@
+alter table reservations add column status varchar(20) not null default 'pending';
+update reservations set status = 'confirmed' where process = 1;
+alter table reservations drop column process;
+create index reservations_status_idx on reservations (status);
+
+create table reservation_status_history
+(
+    id integer primary key autoincrement,
+    reservation_id int not null,
+    from_status varchar(20) not null,
+    to_status varchar(20) not null,
+    user_id int not null default 0,
+    create_at timestamp
+);
+create index reservation_status_history_reservation_id_idx on reservation_status_history (reservation_id);
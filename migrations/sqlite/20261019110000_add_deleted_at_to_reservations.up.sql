@@ -0,0 +1,2 @@
+alter table reservations add column deleted_at timestamp;
+create index reservations_deleted_at_idx on reservations (deleted_at);
@@ -0,0 +1,23 @@
+create table audit_log
+(
+    id integer primary key autoincrement,
+    user_id int not null default 0,
+    action varchar(50) not null,
+    entity varchar(50) not null,
+    entity_id int not null default 0,
+    changes text not null,
+    ip varchar(45) not null default '',
+    create_at timestamp
+);
+create index audit_log_create_at_idx on audit_log (create_at);
+create index audit_log_entity_idx on audit_log (entity, entity_id);
+
+create trigger audit_log_no_update before update on audit_log
+begin
+    select raise(abort, 'audit_log is append-only');
+end;
+
+create trigger audit_log_no_delete before delete on audit_log
+begin
+    select raise(abort, 'audit_log is append-only');
+end;
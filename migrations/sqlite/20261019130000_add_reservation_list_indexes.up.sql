@@ -0,0 +1,7 @@
+create index reservations_start_date_id_idx on reservations (start_date, id) where deleted_at is null;
+create index reservations_end_date_id_idx on reservations (end_date, id) where deleted_at is null;
+create index reservations_last_name_id_idx on reservations (last_name, id) where deleted_at is null;
+create index reservations_room_id_start_date_idx on reservations (room_id, start_date, id) where deleted_at is null;
+create index reservations_first_name_search_idx on reservations (lower(first_name));
+create index reservations_last_name_search_idx on reservations (lower(last_name));
+create index reservations_email_search_idx on reservations (lower(email));
@@ -0,0 +1,3 @@
+drop index reservations_guest_id_idx;
+alter table reservations drop column guest_id;
+drop table guests;
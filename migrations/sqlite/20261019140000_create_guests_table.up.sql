@@ -0,0 +1,40 @@
+create table guests
+(
+    id integer primary key autoincrement,
+    first_name varchar(50) not null,
+    last_name varchar(50) not null,
+    email varchar(100) not null,
+    phone varchar(20) not null,
+    email_key varchar(100) not null default '',
+    phone_key varchar(20) not null default '',
+    notes text not null default '',
+    tags varchar(255) not null default '',
+    create_at timestamp,
+    update_at timestamp
+);
+create index guests_email_key_idx on guests (email_key) where email_key <> '';
+create index guests_phone_key_idx on guests (phone_key) where phone_key <> '';
+create index guests_last_name_idx on guests (lower(last_name), lower(first_name), id);
+
+-- no foreign key, sqlite cannot drop a column that has one
+alter table reservations add column guest_id int;
+create index reservations_guest_id_idx on reservations (guest_id);
+
+-- one guest per email of the existing reservations, with the details of the latest one.
+-- sqlite has no regexp_replace, the phone key drops the usual separators instead.
+insert into guests (first_name, last_name, email, phone, email_key, phone_key, create_at, update_at)
+select first_name, last_name, email, phone, email_key,
+       case when length(phone_key) >= 7 then phone_key else '' end,
+       create_at, create_at
+from (
+    select first_name, last_name, email, phone, create_at, lower(trim(email)) as email_key,
+           replace(replace(replace(replace(replace(replace(phone, ' ', ''), '-', ''), '(', ''), ')', ''), '+', ''), '.', '') as phone_key,
+           row_number() over (partition by lower(trim(email)) order by create_at desc, id desc) as latest
+    from reservations
+    where trim(email) <> ''
+)
+where latest = 1
+order by email_key;
+
+update reservations set guest_id = (select g.id from guests g where g.email_key = lower(trim(reservations.email)))
+where trim(email) <> '';
@@ -0,0 +1,15 @@
+create table guest_accounts
+(
+    id integer primary key autoincrement,
+    guest_id int not null references guests (id),
+    email varchar(100) not null,
+    password varchar(60) not null,
+    verify_token varchar(64) not null default '',
+    verify_expires timestamp,
+    verified_at timestamp,
+    create_at timestamp,
+    update_at timestamp
+);
+create unique index guest_accounts_email_idx on guest_accounts (email);
+create index guest_accounts_guest_id_idx on guest_accounts (guest_id);
+create index guest_accounts_verify_token_idx on guest_accounts (verify_token) where verify_token <> '';
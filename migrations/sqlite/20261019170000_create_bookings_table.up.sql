@@ -0,0 +1,10 @@
+create table bookings
+(
+    id integer primary key autoincrement,
+    create_at timestamp,
+    update_at timestamp
+);
+
+-- no foreign key, sqlite cannot drop a column that has one
+alter table reservations add column booking_id int;
+create index reservations_booking_id_idx on reservations (booking_id);
@@ -0,0 +1 @@
+alter table audit_log add column actor varchar(100) not null default '';
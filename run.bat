@@ -1,2 +1,4 @@
-go build -o booking.exe .\cmd\web\.
+for /f %%i in ('git rev-parse --short HEAD') do set COMMIT=%%i
+for /f %%i in ('git describe --tags --always') do set VERSION=%%i
+go build -ldflags "-X github.com/ArmanurRahman/booking/internal/version.Version=%VERSION% -X github.com/ArmanurRahman/booking/internal/version.Commit=%COMMIT%" -o booking.exe .\cmd\web\.
 booking.exe
\ No newline at end of file